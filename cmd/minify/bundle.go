@@ -0,0 +1,762 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	min "github.com/tdewolff/minify/v2"
+	minifyJS "github.com/tdewolff/minify/v2/js"
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/js"
+)
+
+// bundleModule is an ES module that is part of a bundle.
+type bundleModule struct {
+	filename string
+	name     string // variable holding the module's exports
+	src      []byte
+	ast      *js.AST
+	deps     map[string]string // import specifier to variable holding the module's exports
+	edits    []bundleEdit
+	imports  []bundleImport
+	exports  []bundleExport
+	used     map[string]bool // exports imported by other modules
+	done     bool
+}
+
+// bundleImport is an imported binding of a module.
+type bundleImport struct {
+	binding    string
+	from, name string // variable holding the module's exports and the imported name, which is * for the namespace
+}
+
+// bundleExport is an exported name and the JS expression of its value.
+type bundleExport struct {
+	name, value    string
+	star           bool   // from export * which is overridden by other exports
+	from, fromName string // variable holding the module's exports and the name that is re-exported, which is * for the namespace
+}
+
+// bundleEdit replaces src[start:end] by the replacement.
+type bundleEdit struct {
+	start, end  int
+	replacement string
+}
+
+// bundler resolves the relative imports of ES modules starting from an entry file and writes all modules into a single file, each module is scoped by a function that returns an object of getters to its exports. Exports and imports that are not used by other modules are removed, as are modules without used exports that have no side effects.
+type bundler struct {
+	format    string // iife or esm
+	open      func(string) (io.ReadCloser, error)
+	modules   map[string]*bundleModule
+	order     []*bundleModule
+	stack     []*bundleModule
+	externals map[string]string // import specifier to variable holding the external module's namespace
+	imports   []string
+}
+
+// bundleModules bundles the entry ES module and all modules it imports into a single IIFE or ES module. Bare import specifiers are kept as imports for the esm format only.
+func bundleModules(entry, format string, open func(string) (io.ReadCloser, error)) ([]byte, error) {
+	if format != "iife" && format != "esm" {
+		return nil, fmt.Errorf("unknown bundle format %s, must be iife or esm", format)
+	}
+	b := &bundler{
+		format:    format,
+		open:      open,
+		modules:   map[string]*bundleModule{},
+		externals: map[string]string{},
+	}
+	if _, err := b.load(path.Clean(entry)); err != nil {
+		return nil, err
+	}
+
+	for _, mod := range b.order {
+		if err := b.rewrite(mod); err != nil {
+			return nil, fmt.Errorf("%s: %w", mod.filename, err)
+		}
+	}
+	b.markUsed()
+
+	buf := &bytes.Buffer{}
+	for _, specifier := range b.imports {
+		fmt.Fprintf(buf, "import * as %s from %s;\n", b.externals[specifier], strconv.Quote(specifier))
+	}
+	if format == "iife" {
+		buf.WriteString("\"use strict\";\n(function(){\n")
+	}
+	for i, mod := range b.order {
+		b.writeModule(buf, mod, i == len(b.order)-1)
+	}
+	if format == "iife" {
+		buf.WriteString("})();\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// load reads, parses and resolves the imports of a module and its dependencies, which are added in topological order.
+func (b *bundler) load(filename string) (*bundleModule, error) {
+	if mod, ok := b.modules[filename]; ok {
+		if !mod.done {
+			cycle := []string{}
+			for i := len(b.stack) - 1; 0 <= i; i-- {
+				cycle = append([]string{b.stack[i].filename}, cycle...)
+				if b.stack[i] == mod {
+					break
+				}
+			}
+			return nil, fmt.Errorf("circular import: %s -> %s", strings.Join(cycle, " -> "), filename)
+		}
+		return mod, nil
+	}
+
+	r, err := b.open(filename)
+	if err != nil {
+		return nil, err
+	}
+	src, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(src) == cap(src) {
+		// parse in place so that the byte slices of the AST point into src, see min.SliceOffset
+		src = append(src, 0)[:len(src)]
+	}
+	ast, err := js.Parse(parse.NewInputBytes(src))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	mod := &bundleModule{
+		filename: filename,
+		name:     "$$m" + strconv.Itoa(len(b.modules)),
+		src:      src,
+		ast:      ast,
+		deps:     map[string]string{},
+		used:     map[string]bool{},
+	}
+	b.modules[filename] = mod
+	b.stack = append(b.stack, mod)
+	for _, istmt := range ast.List {
+		var specifier []byte
+		if stmt, ok := istmt.(*js.ImportStmt); ok {
+			specifier = stmt.Module
+		} else if stmt, ok := istmt.(*js.ExportStmt); ok && stmt.Module != nil {
+			specifier = stmt.Module
+		} else {
+			continue
+		}
+		spec := string(specifier[1 : len(specifier)-1])
+		if _, ok := mod.deps[spec]; ok {
+			continue
+		}
+		if !strings.HasPrefix(spec, "./") && !strings.HasPrefix(spec, "../") && !strings.HasPrefix(spec, "/") {
+			if b.format != "esm" {
+				return nil, fmt.Errorf("%s: cannot bundle external module %s in iife format", filename, spec)
+			}
+			if _, ok := b.externals[spec]; !ok {
+				b.externals[spec] = "$$e" + strconv.Itoa(len(b.externals))
+				b.imports = append(b.imports, spec)
+			}
+			mod.deps[spec] = b.externals[spec]
+			continue
+		}
+
+		depFilename, err := b.resolve(path.Dir(filename), spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		dep, err := b.load(depFilename)
+		if err != nil {
+			return nil, err
+		}
+		mod.deps[spec] = dep.name
+	}
+	b.stack = b.stack[:len(b.stack)-1]
+	mod.done = true
+	b.order = append(b.order, mod)
+	return mod, nil
+}
+
+// resolve returns the filename of a relative import specifier, trying the .js and .mjs extensions and index.js when the file does not exist.
+func (b *bundler) resolve(dir, spec string) (string, error) {
+	filename := spec
+	if !strings.HasPrefix(spec, "/") {
+		filename = path.Join(dir, spec)
+	}
+	if _, ok := b.modules[filename]; ok {
+		return filename, nil
+	}
+	for _, candidate := range []string{filename, filename + ".js", filename + ".mjs", path.Join(filename, "index.js")} {
+		if r, err := b.open(candidate); err == nil {
+			r.Close()
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("cannot resolve module %s", spec)
+}
+
+// writeModule writes the module with its imports replaced by variables and its used exports. The entry module is not scoped, its exports are kept for the esm format.
+func (b *bundler) writeModule(w *bytes.Buffer, mod *bundleModule, entry bool) {
+	body := &bytes.Buffer{}
+	start := 0
+	for _, edit := range mod.edits {
+		body.Write(mod.src[start:edit.start])
+		body.WriteString(edit.replacement)
+		start = edit.end
+	}
+	body.Write(mod.src[start:])
+	body.WriteString("\n;\n")
+
+	if !entry && len(mod.used) == 0 {
+		// modules without used exports are kept for their side effects only
+		if !isEmptyModule(mod.ast) {
+			w.WriteString("(function(){\n")
+			w.Write(body.Bytes())
+			w.WriteString("})();\n")
+		}
+		return
+	} else if !entry {
+		fmt.Fprintf(w, "var %s=function(){\n", mod.name)
+	}
+	w.Write(body.Bytes())
+
+	if !entry {
+		getters := []string{}
+		for _, export := range mod.exports {
+			if mod.used[export.name] {
+				getters = append(getters, fmt.Sprintf("get %s(){return %s}", strconv.Quote(export.name), mod.exportValue(export)))
+			}
+		}
+		fmt.Fprintf(w, "return{%s}}();\n", strings.Join(getters, ","))
+	} else if b.format == "esm" && 0 < len(mod.exports) {
+		vars, list := []string{}, []string{}
+		for i, export := range mod.exports {
+			local := mod.exportValue(export)
+			if strings.Contains(local, ".") {
+				vars = append(vars, "$$x"+strconv.Itoa(i)+"="+local)
+				local = "$$x" + strconv.Itoa(i)
+			}
+			if local != export.name {
+				local += " as " + export.name
+			}
+			list = append(list, local)
+		}
+		if 0 < len(vars) {
+			fmt.Fprintf(w, "var %s;\n", strings.Join(vars, ","))
+		}
+		fmt.Fprintf(w, "export{%s};\n", strings.Join(list, ","))
+	}
+}
+
+// isEmptyModule returns true if the module body has no side effects, that is it only has empty statements, imports, exports, and declarations whose initializers have no side effects.
+func isEmptyModule(ast *js.AST) bool {
+	for _, istmt := range ast.List {
+		switch stmt := istmt.(type) {
+		case *js.EmptyStmt, *js.ImportStmt:
+		case *js.ExportStmt:
+			if stmt.Decl != nil && !isEmptyExpr(stmt.Decl) {
+				return false
+			}
+		case *js.VarDecl, *js.FuncDecl, *js.ClassDecl:
+			if !isEmptyExpr(stmt.(js.IExpr)) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isEmptyExpr returns true if evaluating the expression or declaration has no side effects.
+func isEmptyExpr(iexpr js.IExpr) bool {
+	switch expr := iexpr.(type) {
+	case *js.LiteralExpr, *js.FuncDecl, *js.ArrowFunc:
+		return true
+	case *js.VarDecl:
+		for _, item := range expr.List {
+			if item.Default != nil && !isEmptyExpr(item.Default) {
+				return false
+			}
+		}
+		return true
+	case *js.ClassDecl:
+		if expr.Extends != nil && !isEmptyExpr(expr.Extends) {
+			return false
+		}
+		for _, method := range expr.Methods {
+			if method.Name.IsComputed() {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// value returns the JS expression of an imported binding, which is a member of the variable holding the exporting module's exports so that it reflects later assignments in that module.
+func (imp bundleImport) value() string {
+	if imp.name == "*" {
+		return imp.from
+	}
+	return imp.from + "." + imp.name
+}
+
+// exportValue returns the JS expression of an export's value, which may be an imported binding.
+func (mod *bundleModule) exportValue(export bundleExport) string {
+	if export.from == "" {
+		for _, imp := range mod.imports {
+			if imp.binding == export.value {
+				return imp.value()
+			}
+		}
+	}
+	return export.value
+}
+
+// hasExport returns true if the module exports the name.
+func (mod *bundleModule) hasExport(name string) bool {
+	for _, export := range mod.exports {
+		if export.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// markUsed marks the exports of modules that are used by the modules importing them, in reverse topological order. The exports of the entry module are used for the esm format only.
+func (b *bundler) markUsed() {
+	entry := b.order[len(b.order)-1]
+	if b.format == "esm" {
+		for _, export := range entry.exports {
+			entry.used[export.name] = true
+		}
+	}
+	for i := len(b.order) - 1; 0 <= i; i-- {
+		mod := b.order[i]
+		for _, export := range mod.exports {
+			if mod.used[export.name] && export.from != "" {
+				b.use(export.from, export.fromName)
+			}
+		}
+		for _, imp := range mod.imports {
+			if b.isUsedBinding(mod, imp.binding) {
+				b.use(imp.from, imp.name)
+			}
+		}
+	}
+}
+
+// use marks an export as used, or all exports for the namespace.
+func (b *bundler) use(from, name string) {
+	if dep, ok := b.moduleByName(from); ok {
+		if name == "*" {
+			for _, export := range dep.exports {
+				dep.used[export.name] = true
+			}
+		} else {
+			dep.used[name] = true
+		}
+	}
+}
+
+// isUsedBinding returns true if an imported binding is used by the module or by one of its used exports.
+func (b *bundler) isUsedBinding(mod *bundleModule, binding string) bool {
+	for _, v := range mod.ast.Undeclared {
+		if 0 < v.Uses && string(v.Data) == binding {
+			return true
+		}
+	}
+	for _, export := range mod.exports {
+		if mod.used[export.name] && export.from == "" && export.value == binding {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite collects the imports and exports of a module and the edits that remove its import and export statements. Statements are located by lexing the source with the regular expressions found by the parser, they are matched in order with the import and export statements of the AST.
+func (b *bundler) rewrite(mod *bundleModule) error {
+	stmts := []js.IStmt{}
+	for _, istmt := range mod.ast.List {
+		switch stmt := istmt.(type) {
+		case *js.ImportStmt:
+			stmts = append(stmts, istmt)
+			dep := mod.deps[string(stmt.Module[1:len(stmt.Module)-1])]
+			if stmt.Default != nil {
+				mod.imports = append(mod.imports, bundleImport{binding: string(stmt.Default), from: dep, name: "default"})
+			}
+			for _, alias := range stmt.List {
+				if alias.Binding == nil {
+					continue
+				} else if alias.Name == nil {
+					mod.imports = append(mod.imports, bundleImport{binding: string(alias.Binding), from: dep, name: string(alias.Binding)})
+				} else {
+					mod.imports = append(mod.imports, bundleImport{binding: string(alias.Binding), from: dep, name: string(alias.Name)})
+				}
+			}
+		case *js.ExportStmt:
+			stmts = append(stmts, istmt)
+		}
+	}
+	for _, imp := range mod.imports {
+		if dep, ok := b.moduleByName(imp.from); ok && imp.name != "*" && !dep.hasExport(imp.name) {
+			return fmt.Errorf("%s has no export %s", dep.filename, imp.name)
+		}
+	}
+
+	regExps := map[int]bool{}
+	minifyJS.Walk(mod.ast, minifyJS.Visitor{
+		Expr: func(iexpr js.IExpr) {
+			if expr, ok := iexpr.(*js.LiteralExpr); ok && expr.TokenType == js.RegExpToken {
+				if offset, ok := min.SliceOffset(mod.src, expr.Data); ok {
+					regExps[offset] = true
+				}
+			}
+		},
+	})
+	tokens := lexTopLevel(mod.src, regExps)
+	edits := []bundleEdit{}
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i].tt != js.ImportToken && tokens[i].tt != js.ExportToken || 0 < tokens[i].depth {
+			continue
+		} else if tokens[i].tt == js.ImportToken && i+1 < len(tokens) && (tokens[i+1].tt == js.OpenParenToken || tokens[i+1].tt == js.DotToken) {
+			continue // import() or import.meta
+		} else if len(stmts) == 0 {
+			return fmt.Errorf("unexpected %s statement", tokens[i].tt)
+		}
+		istmt := stmts[0]
+		stmts = stmts[1:]
+
+		start := tokens[i].start
+		switch stmt := istmt.(type) {
+		case *js.ImportStmt:
+			j := tokenAt(tokens, i, moduleOffset(mod.src, stmt.Module))
+			edits = append(edits, bundleEdit{start, statementEnd(tokens, j), ""})
+		case *js.ExportStmt:
+			if stmt.Decl == nil {
+				j := tokenIndex(tokens, i, js.CloseBraceToken)
+				if stmt.Module != nil {
+					j = tokenAt(tokens, i, moduleOffset(mod.src, stmt.Module))
+				}
+				edits = append(edits, bundleEdit{start, statementEnd(tokens, j), ""})
+				if err := b.addExports(mod, stmt); err != nil {
+					return err
+				}
+			} else if !stmt.Default {
+				edits = append(edits, bundleEdit{start, tokens[i+1].start, ""})
+				switch decl := stmt.Decl.(type) {
+				case *js.VarDecl:
+					for _, item := range decl.List {
+						for _, name := range bindingNames(item.Binding) {
+							mod.exports = append(mod.exports, bundleExport{name: name, value: name})
+						}
+					}
+				case *js.FuncDecl:
+					mod.exports = append(mod.exports, bundleExport{name: string(decl.Name.Data), value: string(decl.Name.Data)})
+				case *js.ClassDecl:
+					mod.exports = append(mod.exports, bundleExport{name: string(decl.Name.Data), value: string(decl.Name.Data)})
+				}
+			} else {
+				// export default
+				end := tokens[i+2].start
+				local := "$$default"
+				switch decl := stmt.Decl.(type) {
+				case *js.FuncDecl:
+					edits = append(edits, bundleEdit{start, end, ""})
+					if decl.Name != nil {
+						local = string(decl.Name.Data)
+					} else {
+						j := tokenIndex(tokens, i, js.OpenParenToken)
+						edits = append(edits, bundleEdit{tokens[j].start, tokens[j].start, " " + local})
+					}
+				case *js.ClassDecl:
+					edits = append(edits, bundleEdit{start, end, ""})
+					if decl.Name != nil {
+						local = string(decl.Name.Data)
+					} else {
+						j := tokenIndex(tokens, i, js.ClassToken)
+						edits = append(edits, bundleEdit{tokens[j].end, tokens[j].end, " " + local})
+					}
+				default:
+					edits = append(edits, bundleEdit{start, end, "var " + local + "="})
+				}
+				mod.exports = append(mod.exports, bundleExport{name: "default", value: local})
+			}
+		}
+	}
+
+	// names exported explicitly take precedence over those of export *
+	exports := mod.exports[:0]
+	names := map[string]bool{}
+	for _, export := range mod.exports {
+		if !export.star {
+			names[export.name] = true
+		}
+	}
+	for _, export := range mod.exports {
+		if !export.star || !names[export.name] {
+			names[export.name] = true
+			exports = append(exports, export)
+		}
+	}
+	mod.exports = exports
+
+	liveEdits, err := liveImports(mod, tokens)
+	if err != nil {
+		return err
+	}
+	for _, edit := range liveEdits {
+		// skip the bindings in import and export statements, which are removed
+		inside := false
+		for _, stmtEdit := range edits {
+			inside = inside || stmtEdit.start <= edit.start && edit.end <= stmtEdit.end && stmtEdit.start != stmtEdit.end
+		}
+		if !inside {
+			edits = append(edits, edit)
+		}
+	}
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+	mod.edits = edits
+	return nil
+}
+
+// liveImports returns the edits that replace the uses of imported bindings by members of the variable holding the exporting module's exports, so that they reflect later assignments in that module as ES live bindings do. The uses are found by renaming the identifiers named after imported bindings to unique probes and parsing the result, a probe refers to the imported binding unless it is a declaration or a variable of the same name is declared in an enclosing scope.
+func liveImports(mod *bundleModule, tokens []bundleToken) ([]bundleEdit, error) {
+	imports := map[string]bundleImport{}
+	for _, imp := range mod.imports {
+		imports[imp.binding] = imp
+	}
+	if len(imports) == 0 {
+		return nil, nil
+	}
+
+	src := []byte{}
+	probes := map[string]bundleToken{}
+	start := 0
+	for i, token := range tokens {
+		if token.tt == js.IdentifierToken {
+			if _, ok := imports[string(mod.src[token.start:token.end])]; ok {
+				probe := "$$p" + strconv.Itoa(i)
+				src = append(append(src, mod.src[start:token.start]...), probe...)
+				start = token.end
+				probes[probe] = token
+			}
+		}
+	}
+	src = append(src, mod.src[start:]...)
+	ast, err := js.Parse(parse.NewInputBytes(src))
+	if err != nil {
+		return nil, err
+	}
+
+	// find the innermost scope of every probe
+	scopes := map[*js.Var][]*js.Scope{}
+	declared := map[*js.Var]bool{}
+	shorthands := map[*js.Var]bool{}
+	stack := []*js.Scope{}
+	minifyJS.Walk(ast, minifyJS.Visitor{
+		EnterBlock: func(block *js.BlockStmt) {
+			stack = append(stack, &block.Scope)
+			for i, list := range []js.VarArray{block.Scope.Declared, block.Scope.Undeclared} {
+				for _, v := range list {
+					if _, ok := probes[string(v.Data)]; ok {
+						declared[v] = declared[v] || i == 0
+						if len(scopes[v]) < len(stack) {
+							scopes[v] = append([]*js.Scope{}, stack...)
+						}
+					}
+				}
+			}
+		},
+		ExitBlock: func(*js.BlockStmt) {
+			stack = stack[:len(stack)-1]
+		},
+		Expr: func(iexpr js.IExpr) {
+			if expr, ok := iexpr.(*js.ObjectExpr); ok {
+				for _, item := range expr.List {
+					if v, ok := item.Value.(*js.Var); ok && item.Name != nil && !item.Name.IsComputed() && item.Name.IsIdent(v.Data) {
+						shorthands[v] = true
+					}
+				}
+			}
+		},
+	})
+
+	edits := []bundleEdit{}
+Probes:
+	for v, stack := range scopes {
+		token := probes[string(v.Data)]
+		name := string(mod.src[token.start:token.end])
+		if declared[v] {
+			continue
+		}
+		for _, scope := range stack {
+			for _, w := range scope.Declared {
+				if other, ok := probes[string(w.Data)]; ok && string(mod.src[other.start:other.end]) == name {
+					continue Probes // shadowed by a local variable
+				}
+			}
+		}
+		replacement := imports[name].value()
+		if shorthands[v] {
+			replacement = name + ":" + replacement
+		}
+		edits = append(edits, bundleEdit{token.start, token.end, replacement})
+	}
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+	return edits, nil
+}
+
+// addExports adds the exports of an export clause, which may re-export from another module.
+func (b *bundler) addExports(mod *bundleModule, stmt *js.ExportStmt) error {
+	dep := ""
+	if stmt.Module != nil {
+		dep = mod.deps[string(stmt.Module[1:len(stmt.Module)-1])]
+	}
+	for _, alias := range stmt.List {
+		if alias.Binding == nil {
+			continue
+		} else if alias.Name == nil && bytes.Equal(alias.Binding, []byte("*")) {
+			// export * from
+			depMod, ok := b.moduleByName(dep)
+			if !ok {
+				return fmt.Errorf("cannot re-export all from external module %s", stmt.Module)
+			}
+			for _, export := range depMod.exports {
+				if export.name != "default" {
+					mod.exports = append(mod.exports, bundleExport{name: export.name, value: dep + "." + export.name, star: true, from: dep, fromName: export.name})
+				}
+			}
+		} else if bytes.Equal(alias.Name, []byte("*")) {
+			mod.exports = append(mod.exports, bundleExport{name: string(alias.Binding), value: dep, from: dep, fromName: "*"})
+		} else {
+			local := alias.Name
+			if local == nil {
+				local = alias.Binding
+			}
+			if dep != "" {
+				mod.exports = append(mod.exports, bundleExport{name: string(alias.Binding), value: dep + "." + string(local), from: dep, fromName: string(local)})
+			} else {
+				mod.exports = append(mod.exports, bundleExport{name: string(alias.Binding), value: string(local)})
+			}
+		}
+	}
+	return nil
+}
+
+func (b *bundler) moduleByName(name string) (*bundleModule, bool) {
+	for _, mod := range b.order {
+		if mod.name == name {
+			return mod, true
+		}
+	}
+	return nil, false
+}
+
+// bundleToken is a significant token and its position and nesting depth in the source.
+type bundleToken struct {
+	tt         js.TokenType
+	start, end int
+	depth      int
+}
+
+// lexTopLevel returns the significant tokens of a JS source, a slash is read as a regular expression only if the parser found one at its position.
+func lexTopLevel(src []byte, regExps map[int]bool) []bundleToken {
+	tokens := []bundleToken{}
+	l := js.NewLexer(parse.NewInputBytes(src))
+	pos, depth := 0, 0
+	for {
+		tt, data := l.Next()
+		if tt == js.ErrorToken {
+			break
+		} else if (tt == js.DivToken || tt == js.DivEqToken) && regExps[pos] {
+			if tt, data = l.RegExp(); tt == js.ErrorToken {
+				break
+			}
+		}
+		start := pos
+		pos += len(data)
+		if tt == js.WhitespaceToken || tt == js.LineTerminatorToken || tt == js.CommentToken || tt == js.CommentLineTerminatorToken {
+			continue
+		}
+		if tt == js.CloseBraceToken || tt == js.CloseParenToken || tt == js.CloseBracketToken {
+			depth--
+		}
+		tokens = append(tokens, bundleToken{tt, start, pos, depth})
+		if tt == js.OpenBraceToken || tt == js.OpenParenToken || tt == js.OpenBracketToken {
+			depth++
+		}
+	}
+	return tokens
+}
+
+// tokenIndex returns the index of the first token of the given type from i, or the last index if not found.
+func tokenIndex(tokens []bundleToken, i int, tt js.TokenType) int {
+	for ; i < len(tokens)-1; i++ {
+		if tokens[i].tt == tt {
+			break
+		}
+	}
+	return i
+}
+
+// moduleOffset returns the position in src of the module specifier of an import or export statement, or -1 if unknown.
+func moduleOffset(src, module []byte) int {
+	if offset, ok := min.SliceOffset(src, module); ok {
+		return offset
+	}
+	return -1
+}
+
+// tokenAt returns the index of the token from i that starts at the given position, or the last index if not found.
+func tokenAt(tokens []bundleToken, i int, pos int) int {
+	for ; i < len(tokens)-1; i++ {
+		if tokens[i].start == pos {
+			break
+		}
+	}
+	return i
+}
+
+// statementEnd returns the end position of a statement ending at token j, including its semicolon.
+func statementEnd(tokens []bundleToken, j int) int {
+	if j+1 < len(tokens) && tokens[j+1].tt == js.SemicolonToken {
+		return tokens[j+1].end
+	}
+	return tokens[j].end
+}
+
+// bindingNames returns the names of the variables declared by a binding.
+func bindingNames(ibinding js.IBinding) []string {
+	names := []string{}
+	switch binding := ibinding.(type) {
+	case *js.Var:
+		names = append(names, string(binding.Data))
+	case *js.BindingArray:
+		for _, item := range binding.List {
+			names = append(names, bindingNames(item.Binding)...)
+		}
+		if binding.Rest != nil {
+			names = append(names, bindingNames(binding.Rest)...)
+		}
+	case *js.BindingObject:
+		for _, item := range binding.List {
+			names = append(names, bindingNames(item.Value.Binding)...)
+		}
+		if binding.Rest != nil {
+			names = append(names, string(binding.Rest.Data))
+		}
+	}
+	return names
+}
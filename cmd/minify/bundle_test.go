@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/tdewolff/minify/v2/js"
+	"github.com/tdewolff/test"
+)
+
+func bundleOpener(files map[string]string) func(string) (io.ReadCloser, error) {
+	return func(filename string) (io.ReadCloser, error) {
+		if src, ok := files[filename]; ok {
+			return ioutil.NopCloser(bytes.NewReader([]byte(src))), nil
+		}
+		return nil, os.ErrNotExist
+	}
+}
+
+func TestBundle(t *testing.T) {
+	var bundleTests = []struct {
+		format   string
+		files    map[string]string
+		expected string
+	}{
+		{"iife", map[string]string{"main.js": `import {a} from "./a"; f(a)`, "a.js": `export const a = 5`}, `"use strict";!function(){var a=function(){const a=5;return{get a(){return a}}}();f(a.a)}()`},
+		{"iife", map[string]string{"main.js": `import b, {c as d} from "./lib/index.js"; f(b, d)`, "lib/index.js": `export default function(){}; export let c = 1`}, `"use strict";!function(){var a=function(){function a(){}let b=1;return{get default(){return a},get c(){return b}}}();f(a.default,a.c)}()`},
+		{"iife", map[string]string{"main.js": `import * as ns from "./a"; f(ns.a)`, "a.js": `export default class {}; export {a}; function a(){}`}, `"use strict";!function(){var a=function(){class a{}function b(){}return{get default(){return a},get a(){return b}}}();f(a.a)}()`},
+		{"iife", map[string]string{"main.js": `import "./a"; import "./a.js"; f()`, "a.js": `g()`}, `"use strict";!function(){!function(){g()}(),f()}()`},
+		{"iife", map[string]string{"main.js": `import {a} from "./b"; f(a / 2, /re/.test(a))`, "b.js": `export * from "./c"; export var a = 1`, "c.js": `export var a = 2, b = 3`}, `"use strict";!function(){var a=function(){var a=1;return{get a(){return a}}}();f(a.a/2,/re/.test(a.a))}()`},
+		{"iife", map[string]string{"main.js": `import {a} from "./a"; import "./b"; f()`, "a.js": `export const a = 5; function g(){}`, "b.js": `export {}`}, `"use strict";!function(){f()}()`},
+		{"iife", map[string]string{"main.js": `import {a} from "./a"; import "./b"; f()`, "a.js": `export const a = g()`, "b.js": `export class b {}; export default () => 1`}, `"use strict";!function(){!function(){const a=g()}(),f()}()`},
+		{"esm", map[string]string{"main.js": `import React from "react"; import {a} from "./a"; export {a as b}; export default React`, "a.js": `export const a = 5`}, `var $$m1,$$default,$$x0;import*as $$e0 from"react";$$m1=function(){const a=5;return{get a(){return a}}}(),$$default=$$e0.default,$$x0=$$m1.a;export{$$x0 as b,$$default as default}`},
+		{"iife", map[string]string{"main.js": `import {a, b} from "./a"; f(a)`, "a.js": `export function a(){}; export function b(){}; export const c = /*#__PURE__*/ g()`}, `"use strict";!function(){var a=function(){function a(){}return{get a(){return a}}}();f(a.a)}()`},
+		{"esm", map[string]string{"main.js": `export * as ns from "./a"; export function f(){}`, "a.js": `export const a = 5`}, `var $$m1=function(){const a=5;return{get a(){return a}}}();function f(){}export{$$m1 as ns,f}`},
+		{"iife", map[string]string{"main.js": "import {a} from \"./a\";\nif (x) /\"/.test(a); {} /'/.test(a); f(a / x, \"/\")", "a.js": `export let a = "b"`}, `"use strict";!function(){var a=function(){let a="b";return{get a(){return a}}}();x&&/"/.test(a.a),/'/.test(a.a),f(a.a/x,"/")}()`},
+		{"iife", map[string]string{"main.js": `import {count, inc} from "./a"; inc(); f(count, {count}, function(count){return count})`, "a.js": `export let count = 0; export function inc(){count++}`}, `"use strict";!function(){var a=function(){let a=0;function b(){a++}return{get count(){return a},get inc(){return b}}}();a.inc(),f(a.count,{count:a.count},function(a){return a})}()`},
+	}
+
+	for _, tt := range bundleTests {
+		t.Run(tt.expected, func(t *testing.T) {
+			b, err := bundleModules("main.js", tt.format, bundleOpener(tt.files))
+			test.Error(t, err)
+
+			w := &bytes.Buffer{}
+			err = (&js.Minifier{TreeShaking: true}).Minify(nil, w, bytes.NewReader(b), nil)
+			test.Minify(t, string(b), err, w.String(), tt.expected)
+		})
+	}
+}
+
+func TestBundleErrors(t *testing.T) {
+	var bundleTests = []struct {
+		format string
+		files  map[string]string
+		err    string
+	}{
+		{"cjs", map[string]string{"main.js": ``}, "unknown bundle format cjs, must be iife or esm"},
+		{"iife", map[string]string{"main.js": `import "./a"`}, "main.js: cannot resolve module ./a"},
+		{"iife", map[string]string{"main.js": `import "react"`}, "main.js: cannot bundle external module react in iife format"},
+		{"iife", map[string]string{"main.js": `import {b} from "./a"; f(b)`, "a.js": `export const a = 1`}, "main.js: a.js has no export b"},
+		{"iife", map[string]string{"main.js": `import "./a"`, "a.js": `import "./b"`, "b.js": `import "./a"`}, "circular import: a.js -> b.js -> a.js"},
+		{"iife", map[string]string{"main.js": `import "./main"`}, "circular import: main.js -> main.js"},
+		{"esm", map[string]string{"main.js": `import "./a"`, "a.js": `export * from "react"`}, "a.js: cannot re-export all from external module \"react\""},
+	}
+
+	for _, tt := range bundleTests {
+		t.Run(tt.err, func(t *testing.T) {
+			_, err := bundleModules("main.js", tt.format, bundleOpener(tt.files))
+			test.String(t, err.Error(), tt.err)
+		})
+	}
+}
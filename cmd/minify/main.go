@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -43,17 +44,35 @@ var filetypeMime = map[string]string{
 }
 
 var (
-	help      bool
-	hidden    bool
-	list      bool
-	m         *min.M
-	pattern   *regexp.Regexp
-	recursive bool
-	verbose   bool
-	version   bool
-	watch     bool
-	sync      bool
-	bundle    bool
+	help         bool
+	hidden       bool
+	list         bool
+	m            *min.M
+	pattern      *regexp.Regexp
+	recursive    bool
+	verbose      bool
+	version      bool
+	watch        bool
+	sync         bool
+	bundle       bool
+	bundleFormat string
+	sourceMap    bool
+
+	extractLicenses bool
+	comments        *min.Comments
+
+	cssInlineImports bool
+)
+
+var (
+	cssMinifier  = &css.Minifier{}
+	htmlMinifier = &html.Minifier{}
+	jsMinifier   = &js.Minifier{}
+	jsonMinifier = &json.Minifier{}
+	svgMinifier  = &svg.Minifier{}
+	xmlMinifier  = &xml.Minifier{}
+
+	jsMimetype = regexp.MustCompile("^(application|text)/(x-)?(java|ecma)script$")
 )
 
 type Task struct {
@@ -93,13 +112,16 @@ func run() int {
 	siteurl := ""
 	cpuprofile := ""
 	memprofile := ""
-
-	cssMinifier := &css.Minifier{}
-	htmlMinifier := &html.Minifier{}
-	jsMinifier := &js.Minifier{}
-	jsonMinifier := &json.Minifier{}
-	svgMinifier := &svg.Minifier{}
-	xmlMinifier := &xml.Minifier{}
+	jsNameCache := ""
+	jsMangleProps := ""
+	maxLineLen := 0
+	jsDefines := []string{}
+	cssPurgeContent := []string{}
+	cssPurgeSafelist := []string{}
+	commentsPolicy := ""
+	cssRenameMap := ""
+	cssTargets := ""
+	jsTarget := ""
 
 	flag := flag.NewFlagSet("minify", flag.ContinueOnError)
 	flag.Usage = func() {
@@ -120,11 +142,24 @@ func run() int {
 	flag.BoolVarP(&watch, "watch", "w", false, "Watch files and minify upon changes")
 	flag.BoolVarP(&sync, "sync", "s", false, "Copy all files to destination directory and minify when filetype matches")
 	flag.BoolVarP(&bundle, "bundle", "b", false, "Bundle files by concatenation into a single file")
+	flag.StringVar(&bundleFormat, "bundle-format", "", "Bundle the JS entry file and the ES modules it imports into a single iife or esm file, implies --bundle")
 	flag.BoolVarP(&version, "version", "", false, "Version")
+	flag.BoolVar(&sourceMap, "source-map", false, "Write a source map next to each output file (.map) and link to it, supported for CSS and JS")
+	flag.StringVar(&commentsPolicy, "comments", "", "Comments to keep in CSS, HTML and JS: none, license (default) for comments starting with /*!, //! or <!--!, tagged for license comments and comments with a @license or @preserve tag, or a regular expression")
+	flag.BoolVar(&extractLicenses, "extract-licenses", false, "Move the kept comments to a license file next to each output file (.LICENSE.txt) and refer to it by a banner comment")
+	flag.IntVar(&maxLineLen, "max-line-len", 0, "Start a new line in CSS and JS at a safe position once the line is longer than the given number of bytes, 0 is unlimited")
 
 	flag.StringVar(&siteurl, "url", "", "URL of file to enable URL minification")
 	flag.StringVar(&cpuprofile, "cpuprofile", "", "Export CPU profile")
 	flag.StringVar(&memprofile, "memprofile", "", "Export memory profile")
+	flag.BoolVar(&cssMinifier.FlattenNesting, "css-flatten-nesting", false, "Move nested style rules to the top level for browsers that do not support CSS nesting")
+	flag.BoolVar(&cssInlineImports, "css-inline-imports", false, "Inline the stylesheets of local @import rules, relative to the input file")
+	flag.BoolVar(&cssMinifier.MergeRules, "css-merge-rules", false, "Merge adjacent rulesets with equal selectors or declarations and remove overridden declarations")
+	flag.BoolVar(&cssMinifier.MergeShorthands, "css-merge-shorthands", false, "Replace complete sets of longhand declarations by their shorthand when shorter")
+	flag.StringArrayVar(&cssPurgeContent, "css-purge-content", nil, "Remove CSS rulesets whose selectors match no element in the HTML files matching the pattern (eg. 'templates/**/*.html'), can be repeated")
+	flag.StringArrayVar(&cssPurgeSafelist, "css-purge-safelist", nil, "Keep CSS selectors matching the regular expression when purging (eg. ^\\.js-), can be repeated")
+	flag.StringVar(&cssRenameMap, "css-rename-map", "", "Rename CSS classes, IDs and custom properties in CSS and HTML using a JSON file of original to short names, which is created or updated; stylesheets should precede the HTML files using them")
+	flag.StringVar(&cssTargets, "css-targets", "", "Browserslist query of the browsers to support (eg. 'defaults' or 'chrome >= 80, safari >= 14'), removes vendor prefixes they do not need and enables the modern syntax they support")
 	flag.IntVar(&cssMinifier.Precision, "css-precision", 0, "Number of significant digits to preserve in numbers, 0 is all")
 	flag.BoolVar(&htmlMinifier.KeepConditionalComments, "html-keep-conditional-comments", false, "Preserve all IE conditional comments")
 	flag.BoolVar(&htmlMinifier.KeepDefaultAttrVals, "html-keep-default-attrvals", false, "Preserve default attribute values")
@@ -132,6 +167,14 @@ func run() int {
 	flag.BoolVar(&htmlMinifier.KeepEndTags, "html-keep-end-tags", false, "Preserve all end tags")
 	flag.BoolVar(&htmlMinifier.KeepWhitespace, "html-keep-whitespace", false, "Preserve whitespace characters but still collapse multiple into one")
 	flag.BoolVar(&htmlMinifier.KeepQuotes, "html-keep-quotes", false, "Preserve quotes around attribute values")
+	flag.BoolVar(&jsMinifier.ASCIIOnly, "js-ascii-only", false, "Escape non-ASCII characters in strings, template literals, regular expressions and identifiers")
+	flag.BoolVar(&jsMinifier.Beautify, "js-beautify", false, "Write each statement on its own line with indentation and spaces around operators, for debugging")
+	flag.StringArrayVar(&jsDefines, "js-define", nil, "Replace global variable or member expression by a JS expression (eg. DEBUG=false or process.env.NODE_ENV='\"production\"'), can be repeated")
+	flag.StringVar(&jsMangleProps, "js-mangle-props", "", "Rename object properties matching the regular expression (eg. ^_)")
+	flag.StringSliceVar(&jsMinifier.ReservedProps, "js-reserved-props", nil, "Comma-separated list of property names that are never renamed")
+	flag.BoolVar(&jsMinifier.TreeShaking, "js-tree-shaking", false, "Remove unused declarations without side-effects from functions and ES modules, respecting /*#__PURE__*/ annotations on calls")
+	flag.StringVar(&jsTarget, "js-target", "", "ECMAScript version to support (eg. es5, es2015 or esnext), rewrites newer syntax such as arrow functions, classes and optional chaining and enables the shorter syntax it supports")
+	flag.StringVar(&jsNameCache, "js-name-cache", "", "Rename globals using a JSON file of original to short names, which is created or updated; scripts declaring globals must precede the scripts using them")
 	flag.IntVar(&jsonMinifier.Precision, "json-precision", 0, "Number of significant digits to preserve in numbers, 0 is all")
 	flag.IntVar(&svgMinifier.Precision, "svg-precision", 0, "Number of significant digits to preserve in numbers, 0 is all")
 	flag.BoolVar(&xmlMinifier.KeepWhitespace, "xml-keep-whitespace", false, "Preserve whitespace characters but still collapse multiple into one")
@@ -257,6 +300,21 @@ func run() int {
 
 	////////////////
 
+	if bundleFormat != "" {
+		if bundleFormat != "iife" && bundleFormat != "esm" {
+			Error.Println("--bundle-format must be iife or esm")
+			return 1
+		} else if len(inputs) != 1 {
+			Error.Println("--bundle-format requires a single entry file")
+			return 1
+		} else if sourceMap {
+			Error.Println("--source-map is not supported with --bundle-format")
+			return 1
+		}
+		bundle = true
+		jsMinifier.TreeShaking = true
+	}
+
 	// set output, empty means stdout, ending in slash means a directory, otherwise a file
 	dirDst := false
 	if output != "" {
@@ -270,6 +328,13 @@ func run() int {
 			return 1
 		}
 	}
+	if output == "" && sourceMap {
+		Error.Println("--source-map requires destination to be a file or directory")
+		return 1
+	} else if output == "" && extractLicenses {
+		Error.Println("--extract-licenses requires destination to be a file or directory")
+		return 1
+	}
 	if !dirDst && (sync || watch) {
 		if sync {
 			Error.Println("--sync requires destination to be a directory")
@@ -333,7 +398,7 @@ func run() int {
 	m.Add("text/css", cssMinifier)
 	m.Add("text/html", htmlMinifier)
 	m.Add("image/svg+xml", svgMinifier)
-	m.AddRegexp(regexp.MustCompile("^(application|text)/(x-)?(java|ecma)script$"), jsMinifier)
+	m.AddRegexp(jsMimetype, jsMinifier)
 	m.AddRegexp(regexp.MustCompile("[/+]json$"), jsonMinifier)
 	m.AddRegexp(regexp.MustCompile("[/+]xml$"), xmlMinifier)
 
@@ -342,8 +407,155 @@ func run() int {
 		return 1
 	}
 
+	if 0 < len(cssPurgeContent) {
+		usage := css.NewHTMLUsage()
+		for _, pattern := range cssPurgeContent {
+			filenames, err := globFiles(pattern)
+			if err != nil {
+				Error.Println(err)
+				return 1
+			} else if len(filenames) == 0 {
+				Warning.Println("no files match", pattern)
+			}
+			for _, filename := range filenames {
+				f, err := os.Open(filename)
+				if err != nil {
+					Error.Println(err)
+					return 1
+				}
+				err = usage.Add(f)
+				f.Close()
+				if err != nil {
+					Error.Println("cannot read "+filename+":", err)
+					return 1
+				}
+			}
+		}
+		cssMinifier.SelectorUsage = usage
+		for _, safelist := range cssPurgeSafelist {
+			re, err := regexp.Compile(safelist)
+			if err != nil {
+				Error.Println(err)
+				return 1
+			}
+			cssMinifier.PurgeSafelist = append(cssMinifier.PurgeSafelist, re)
+		}
+	}
+
+	if cssTargets != "" {
+		if cssMinifier.Targets, err = css.ParseTargets(cssTargets); err != nil {
+			Error.Println(err)
+			return 1
+		}
+	}
+
+	if jsTarget != "" {
+		if jsMinifier.Target, err = js.ParseTarget(jsTarget); err != nil {
+			Error.Println(err)
+			return 1
+		}
+	}
+
+	if 0 < len(jsDefines) {
+		jsMinifier.Defines = map[string]string{}
+		for _, define := range jsDefines {
+			i := strings.IndexByte(define, '=')
+			if i <= 0 {
+				Error.Printf("invalid define %s, must be NAME=VALUE\n", define)
+				return 1
+			}
+			jsMinifier.Defines[define[:i]] = define[i+1:]
+		}
+	}
+
+	cssMinifier.MaxLineLen = maxLineLen
+	jsMinifier.MaxLineLen = maxLineLen
+
+	if commentsPolicy != "" || extractLicenses {
+		comments = &min.Comments{}
+		switch commentsPolicy {
+		case "", "license":
+			comments.Policy = min.LicenseComments
+		case "none":
+			comments.Policy = min.NoComments
+		case "tagged":
+			comments.Policy = min.TaggedComments
+		default:
+			comments.Policy = min.RegexpComments
+			if comments.Regexp, err = regexp.Compile(commentsPolicy); err != nil {
+				Error.Println(err)
+				return 1
+			}
+		}
+		cssMinifier.Comments = comments
+		htmlMinifier.Comments = comments
+		jsMinifier.Comments = comments
+	}
+
+	if jsMangleProps != "" {
+		if jsMinifier.MangleProps, err = regexp.Compile(jsMangleProps); err != nil {
+			Error.Println(err)
+			return 1
+		}
+	}
+
+	if jsNameCache != "" {
+		jsMinifier.MangleTopLevel = true
+		jsMinifier.NameCache = js.NewNameCache()
+		if f, err := os.Open(jsNameCache); err == nil {
+			_, err = jsMinifier.NameCache.ReadFrom(f)
+			f.Close()
+			if err != nil {
+				Error.Println("cannot read name cache:", err)
+				return 1
+			}
+		} else if !os.IsNotExist(err) {
+			Error.Println(err)
+			return 1
+		}
+		defer func() {
+			f, err := openOutputFile(jsNameCache)
+			if err != nil {
+				Error.Println(err)
+				return
+			}
+			if _, err = jsMinifier.NameCache.WriteTo(f); err != nil {
+				Error.Println("cannot write name cache:", err)
+			}
+			f.Close()
+		}()
+	}
+
+	if cssRenameMap != "" {
+		renameMap := min.NewRenameMap()
+		if f, err := os.Open(cssRenameMap); err == nil {
+			_, err = renameMap.ReadFrom(f)
+			f.Close()
+			if err != nil {
+				Error.Println("cannot read rename map:", err)
+				return 1
+			}
+		} else if !os.IsNotExist(err) {
+			Error.Println(err)
+			return 1
+		}
+		cssMinifier.RenameMap = renameMap
+		htmlMinifier.RenameMap = renameMap
+		defer func() {
+			f, err := openOutputFile(cssRenameMap)
+			if err != nil {
+				Error.Println(err)
+				return
+			}
+			if _, err = renameMap.WriteTo(f); err != nil {
+				Error.Println("cannot write rename map:", err)
+			}
+			f.Close()
+		}()
+	}
+
 	numWorkers := 1
-	if !verbose && len(tasks) > 1 {
+	if !verbose && len(tasks) > 1 && jsNameCache == "" && cssRenameMap == "" && !extractLicenses { // the name cache and rename map require the order of the inputs, and license files are extracted one output at a time
 		numWorkers = 4
 		if n := runtime.NumCPU(); n > numWorkers {
 			numWorkers = n
@@ -634,13 +846,24 @@ func minify(mimetype string, t Task) bool {
 		}
 	}
 
-	fr, err := NewConcatFileReader(t.srcs, openInputFile)
-	if err != nil {
-		Error.Println(err)
-		return false
-	}
-	if mimetype == filetypeMime["js"] {
-		fr.SetSeparator([]byte("\n"))
+	var fr io.ReadCloser
+	if bundleFormat != "" && jsMimetype.MatchString(mimetype) {
+		b, err := bundleModules(t.srcs[0], bundleFormat, openInputFile)
+		if err != nil {
+			Error.Println("cannot bundle "+srcName+":", err)
+			return false
+		}
+		fr = ioutil.NopCloser(bytes.NewReader(b))
+	} else {
+		cfr, err := NewConcatFileReader(t.srcs, openInputFile)
+		if err != nil {
+			Error.Println(err)
+			return false
+		}
+		if mimetype == filetypeMime["js"] {
+			cfr.SetSeparator([]byte("\n"))
+		}
+		fr = cfr
 	}
 	fw, err := openOutputFile(t.dst)
 	if err != nil {
@@ -670,11 +893,31 @@ func minify(mimetype string, t Task) bool {
 		w = NewCountingWriter(bufio.NewWriter(fw))
 	}
 
+	if extractLicenses {
+		comments.LicenseFile = path.Base(t.dst) + ".LICENSE.txt"
+		comments.Reset()
+	}
+
 	success := true
 	startTime := time.Now()
-	if err = m.Minify(mimetype, w, r); err != nil {
+	if sourceMap {
+		err = minifySourceMap(mimetype, w, r, t)
+	} else if cssInlineImports && mimetype == filetypeMime["css"] {
+		cssMinifier := *cssMinifier
+		cssMinifier.ResolveImport = importResolver(t)
+		cssMinifier.ImportSource = t.srcs[0]
+		err = cssMinifier.Minify(m, w, r, nil)
+	} else {
+		err = m.Minify(mimetype, w, r)
+	}
+	if err != nil {
 		Error.Println("cannot minify "+srcName+":", err)
 		success = false
+	} else if extractLicenses && 0 < comments.Len() {
+		if err = writeLicenseFile(t.dst + ".LICENSE.txt"); err != nil {
+			Error.Println(err)
+			success = false
+		}
 	}
 	if verbose {
 		dur := time.Since(startTime)
@@ -724,3 +967,91 @@ func minify(mimetype string, t Task) bool {
 	}
 	return success
 }
+
+// writeLicenseFile writes the comments extracted from the last output to the license file.
+func writeLicenseFile(filename string) error {
+	f, err := openOutputFile(filename)
+	if err != nil {
+		return err
+	}
+	if _, err = comments.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// importResolver returns a function that loads the stylesheets of CSS @import rules relative to the input file, or nil when bundling multiple files.
+func importResolver(t Task) func(string) ([]byte, error) {
+	if 1 < len(t.srcs) {
+		return nil
+	}
+	return func(url string) ([]byte, error) {
+		b, err := ioutil.ReadFile(path.Join(path.Dir(t.srcs[0]), url))
+		if os.IsNotExist(err) {
+			Warning.Println("cannot find import", url, "of", t.srcs[0])
+			return nil, nil
+		}
+		return b, err
+	}
+}
+
+// minifySourceMap minifies and writes a source map to the output filename appended by .map, which is linked from the output.
+func minifySourceMap(mimetype string, w io.Writer, r io.Reader, t Task) error {
+	if mimetype != filetypeMime["css"] && !jsMimetype.MatchString(mimetype) {
+		return m.Minify(mimetype, w, r)
+	} else if 1 < len(t.srcs) {
+		return fmt.Errorf("--source-map doesn't support bundling multiple files")
+	}
+
+	source := t.srcs[0]
+	if source == "" {
+		source = "stdin"
+	} else if rel, err := filepath.Rel(path.Dir(t.dst), source); err == nil {
+		source = filepath.ToSlash(rel)
+	}
+	mapFile := path.Base(t.dst) + ".map"
+
+	fm, err := openOutputFile(t.dst + ".map")
+	if err != nil {
+		return err
+	}
+	defer fm.Close()
+	bm := bufio.NewWriter(fm)
+
+	if mimetype == filetypeMime["css"] {
+		cssMinifier := *cssMinifier
+		cssMinifier.SourceMap = bm
+		cssMinifier.SourceMapSource = source
+		cssMinifier.SourceMapFile = path.Base(t.dst)
+		cssMinifier.LoadSourceMap = func(url string) ([]byte, error) {
+			if t.srcs[0] == "" || strings.Contains(url, "://") {
+				return nil, nil
+			}
+			b, err := ioutil.ReadFile(path.Join(path.Dir(t.srcs[0]), url))
+			if os.IsNotExist(err) {
+				Warning.Println("cannot find source map", url, "of", t.srcs[0])
+				return nil, nil
+			}
+			return b, err
+		}
+		if cssInlineImports {
+			cssMinifier.ResolveImport = importResolver(t)
+			cssMinifier.ImportSource = t.srcs[0]
+		}
+		err = cssMinifier.Minify(m, w, r, nil)
+	} else {
+		jsMinifier := *jsMinifier
+		jsMinifier.SourceMap = bm
+		jsMinifier.SourceMapSource = source
+		jsMinifier.SourceMapFile = path.Base(t.dst)
+		err = jsMinifier.Minify(m, w, r, nil)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append([]byte("\n"), min.SourceMappingURL(mimetype, mapFile)...)); err != nil {
+		return err
+	}
+	return bm.Flush()
+}
@@ -2,6 +2,10 @@ package main
 
 import (
 	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
 )
 
 type countingReader struct {
@@ -116,3 +120,51 @@ func (r *concatFileReader) writeSep(p []byte) int {
 func (r *concatFileReader) Close() error {
 	return r.cur.Close()
 }
+
+// matchGlob reports whether the slash-separated name matches the pattern, where ** matches zero or more directories.
+func matchGlob(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	} else if pattern[0] == "**" {
+		return matchGlob(pattern[1:], name) || 0 < len(name) && matchGlob(pattern, name[1:])
+	} else if len(name) == 0 {
+		return false
+	} else if ok, _ := path.Match(pattern[0], name[0]); !ok {
+		return false
+	}
+	return matchGlob(pattern[1:], name[1:])
+}
+
+// globFiles returns the files that match the pattern, where ** matches zero or more directories.
+func globFiles(pattern string) ([]string, error) {
+	segments := strings.Split(path.Clean(filepath.ToSlash(pattern)), "/")
+	i := 0
+	for i < len(segments) && !strings.ContainsAny(segments[i], "*?[\\") {
+		i++
+	}
+	root := path.Join(segments[:i]...)
+	if strings.HasPrefix(pattern, "/") {
+		root = "/" + root
+	}
+	if i == len(segments) {
+		if _, err := os.Stat(root); err != nil {
+			return nil, err
+		}
+		return []string{root}, nil
+	} else if root == "" {
+		root = "."
+	}
+
+	filenames := []string{}
+	err := filepath.Walk(root, func(filename string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, filename)
+		if err == nil && matchGlob(segments[i:], strings.Split(filepath.ToSlash(rel), "/")) {
+			filenames = append(filenames, filename)
+		}
+		return err
+	})
+	return filenames, err
+}
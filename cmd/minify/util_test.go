@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"io/ioutil"
+	"strings"
 	"testing"
 
 	"github.com/tdewolff/test"
@@ -150,3 +151,26 @@ func TestConcatSepEmpty(t *testing.T) {
 	test.T(t, err, io.EOF)
 	test.Bytes(t, buf, []byte("_"))
 }
+
+func TestMatchGlob(t *testing.T) {
+	var globTests = []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"*.html", "index.html", true},
+		{"*.html", "a/index.html", false},
+		{"**/*.html", "index.html", true},
+		{"**/*.html", "a/b/index.html", true},
+		{"a/**/b/*.html", "a/b/index.html", true},
+		{"a/**/b/*.html", "a/x/y/b/index.html", true},
+		{"a/**/b/*.html", "a/x/y/c/index.html", false},
+		{"**", "a/b", true},
+	}
+
+	for _, tt := range globTests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			test.T(t, matchGlob(strings.Split(tt.pattern, "/"), strings.Split(tt.name, "/")), tt.match)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package minify
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sync"
+)
+
+// CommentPolicy selects which comments are kept by the CSS, HTML, and JS minifiers.
+type CommentPolicy int
+
+// CommentPolicy values.
+const (
+	LicenseComments CommentPolicy = iota // keep license comments, which start with /*!, //!, or <!--!
+	NoComments                           // remove all comments
+	TaggedComments                       // keep license comments and comments with a @license or @preserve JSDoc tag
+	RegexpComments                       // keep comments matching Comments.Regexp
+)
+
+var (
+	licenseTagBytes  = []byte("@license")
+	preserveTagBytes = []byte("@preserve")
+)
+
+// Comments is a policy of which comments the CSS, HTML, and JS minifiers keep, so that they handle comments consistently. Kept comments are written in place, or when LicenseFile is set, they are extracted and replaced by a single banner comment that refers to the license file. The extracted comments can then be written to the license file with WriteTo. Share it between the minifiers like the RenameMap; it is safe for concurrent use.
+type Comments struct {
+	Policy      CommentPolicy
+	Regexp      *regexp.Regexp // matched against the comment including its delimiters for RegexpComments
+	LicenseFile string         // file name or URL of the license file that extracted comments are written to, the banner refers to it
+
+	mu       sync.Mutex
+	comments [][]byte
+}
+
+// commentBody returns the comment without its delimiters.
+func commentBody(comment []byte) []byte {
+	if bytes.HasPrefix(comment, []byte("<!--")) {
+		comment = bytes.TrimSuffix(comment[4:], []byte("-->"))
+	} else if bytes.HasPrefix(comment, []byte("/*")) {
+		comment = bytes.TrimSuffix(comment[2:], []byte("*/"))
+	} else if bytes.HasPrefix(comment, []byte("//")) {
+		comment = comment[2:]
+	}
+	return comment
+}
+
+// Keep returns true if the comment, including its delimiters, is kept by the policy. A nil policy keeps license comments.
+func (c *Comments) Keep(comment []byte) bool {
+	body := commentBody(comment)
+	isLicense := 1 < len(body) && body[0] == '!'
+	if c == nil {
+		return isLicense
+	}
+	switch c.Policy {
+	case LicenseComments:
+		return isLicense
+	case TaggedComments:
+		return isLicense || bytes.Contains(body, licenseTagBytes) || bytes.Contains(body, preserveTagBytes)
+	case RegexpComments:
+		return c.Regexp != nil && c.Regexp.Match(comment)
+	}
+	return false
+}
+
+// MayKeep returns false if src has no comments that are kept by the policy, so that minifiers can skip looking for comments that their parser does not report. A nil policy keeps license comments.
+func (c *Comments) MayKeep(src []byte) bool {
+	if c != nil {
+		switch c.Policy {
+		case NoComments:
+			return false
+		case TaggedComments:
+			if bytes.Contains(src, licenseTagBytes) || bytes.Contains(src, preserveTagBytes) {
+				return true
+			}
+		case RegexpComments:
+			return c.Regexp != nil
+		}
+	}
+	return bytes.Contains(src, []byte("/*!")) || bytes.Contains(src, []byte("//!")) || bytes.Contains(src, []byte("<!--!"))
+}
+
+// Extract returns true if the comment is kept by the policy and LicenseFile is set, in which case the comment is added to the extracted comments and the minifier writes the banner instead. Repeated comments are extracted once.
+func (c *Comments) Extract(comment []byte) bool {
+	if c == nil || c.LicenseFile == "" || !c.Keep(comment) {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, prev := range c.comments {
+		if bytes.Equal(prev, comment) {
+			return true
+		}
+	}
+	c.comments = append(c.comments, append([]byte{}, comment...))
+	return true
+}
+
+// Banner returns the text of the banner comment that replaces the extracted comments, without comment delimiters.
+func (c *Comments) Banner() []byte {
+	return []byte("For license information please see " + c.LicenseFile)
+}
+
+// Len returns the number of extracted comments.
+func (c *Comments) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.comments)
+}
+
+// Reset removes the extracted comments, so that the policy can be reused for another output file.
+func (c *Comments) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.comments = c.comments[:0]
+}
+
+// WriteTo writes the extracted comments to w separated by empty lines, which is the content of the license file.
+func (c *Comments) WriteTo(w io.Writer) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var n int64
+	for i, comment := range c.comments {
+		if 0 < i {
+			comment = append([]byte("\n"), comment...)
+		}
+		m, err := w.Write(append(comment, '\n'))
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,69 @@
+package minify
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func TestCommentsKeep(t *testing.T) {
+	commentTests := []struct {
+		policy   CommentPolicy
+		comment  string
+		expected bool
+	}{
+		{LicenseComments, "/*! MIT */", true},
+		{LicenseComments, "//! MIT", true},
+		{LicenseComments, "<!--! MIT -->", true},
+		{LicenseComments, "/* MIT */", false},
+		{LicenseComments, "/*!*/", false},
+		{LicenseComments, "/** @license MIT */", false},
+		{NoComments, "/*! MIT */", false},
+		{TaggedComments, "/*! MIT */", true},
+		{TaggedComments, "/** @license MIT */", true},
+		{TaggedComments, "// @preserve", true},
+		{TaggedComments, "<!-- @license MIT -->", true},
+		{TaggedComments, "/* comment */", false},
+		{RegexpComments, "/* Copyright 2020 */", true},
+		{RegexpComments, "/*! MIT */", false},
+	}
+	for _, tt := range commentTests {
+		t.Run(tt.comment, func(t *testing.T) {
+			c := &Comments{Policy: tt.policy, Regexp: regexp.MustCompile("Copyright")}
+			test.T(t, c.Keep([]byte(tt.comment)), tt.expected)
+		})
+	}
+
+	var c *Comments
+	test.T(t, c.Keep([]byte("/*! MIT */")), true)
+	test.T(t, c.Extract([]byte("/*! MIT */")), false)
+}
+
+func TestCommentsMayKeep(t *testing.T) {
+	var c *Comments
+	test.T(t, c.MayKeep([]byte("a=1/*! MIT */")), true)
+	test.T(t, c.MayKeep([]byte("a=1/* MIT */")), false)
+	test.T(t, (&Comments{Policy: NoComments}).MayKeep([]byte("/*! MIT */")), false)
+	test.T(t, (&Comments{Policy: TaggedComments}).MayKeep([]byte("/** @preserve */")), true)
+	test.T(t, (&Comments{Policy: RegexpComments}).MayKeep([]byte("/* Copyright */")), false)
+}
+
+func TestCommentsExtract(t *testing.T) {
+	c := &Comments{LicenseFile: "app.js.LICENSE.txt"}
+	test.T(t, c.Extract([]byte("/*! MIT */")), true)
+	test.T(t, c.Extract([]byte("/* comment */")), false)
+	test.T(t, c.Extract([]byte("//! BSD")), true)
+	test.T(t, c.Extract([]byte("/*! MIT */")), true)
+	test.T(t, c.Len(), 2)
+	test.String(t, string(c.Banner()), "For license information please see app.js.LICENSE.txt")
+
+	w := &bytes.Buffer{}
+	_, err := c.WriteTo(w)
+	test.Error(t, err)
+	test.String(t, w.String(), "/*! MIT */\n\n//! BSD\n")
+
+	c.Reset()
+	test.T(t, c.Len(), 0)
+}
@@ -1,7 +1,9 @@
 package minify
 
 import (
+	"bytes"
 	"encoding/base64"
+	"io"
 
 	"github.com/tdewolff/parse/v2"
 	"github.com/tdewolff/parse/v2/strconv"
@@ -12,6 +14,7 @@ var (
 	charsetAsciiBytes = []byte("charset=us-ascii")
 	dataBytes         = []byte("data:")
 	base64Bytes       = []byte(";base64")
+	newlineBytes      = []byte("\n")
 )
 
 // Epsilon is the closest number to zero that is not considered to be zero.
@@ -498,3 +501,42 @@ func Number(num []byte, prec int) []byte {
 	}
 	return num[start:end]
 }
+
+////////////////////////////////////////////////////////////////
+
+// LineWriter wraps a writer and starts a new line after a write that consists of one of the break characters, such as ; or }, once the current line is longer than MaxLineLen bytes. Minifiers write such characters separately at positions where a newline does not change the meaning of the output, lines can be longer when there is no such position.
+type LineWriter struct {
+	io.Writer
+	MaxLineLen int
+
+	breaks  []byte
+	col     int
+	newline bool // write a newline before the next write
+}
+
+// NewLineWriter returns a new LineWriter that writes to w and breaks lines after the given characters.
+func NewLineWriter(w io.Writer, maxLineLen int, breaks string) *LineWriter {
+	return &LineWriter{
+		Writer:     w,
+		MaxLineLen: maxLineLen,
+		breaks:     []byte(breaks),
+	}
+}
+
+// Write writes to the underlying writer and starts a new line when needed, which is postponed until more is written so that the output does not end in a newline.
+func (w *LineWriter) Write(b []byte) (int, error) {
+	if w.newline && 0 < len(b) {
+		if _, err := w.Writer.Write(newlineBytes); err != nil {
+			return 0, err
+		}
+		w.newline = false
+		w.col = 0
+	}
+	if i := bytes.LastIndexByte(b, '\n'); i != -1 {
+		w.col = len(b) - i - 1
+	} else {
+		w.col += len(b)
+	}
+	w.newline = len(b) == 1 && w.MaxLineLen < w.col && bytes.IndexByte(w.breaks, b[0]) != -1
+	return w.Writer.Write(b)
+}
@@ -1,6 +1,7 @@
 package minify
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -378,6 +379,15 @@ func RandNumBytes(withExp bool) []byte {
 	return b
 }
 
+func TestLineWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewLineWriter(buf, 4, ";}")
+	for _, s := range []string{"a", "{", "b:c", ";", "d:e", ";", "x\nf", ":", "gg", "}", "h;i", "}"} {
+		w.Write([]byte(s))
+	}
+	test.String(t, buf.String(), "a{b:c;\nd:e;x\nf:gg}\nh;i}")
+}
+
 func BenchmarkNumber(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		for j := 0; j < n; j++ {
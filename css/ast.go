@@ -0,0 +1,804 @@
+package css
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+)
+
+// Node is a node of a stylesheet, which is a *Stylesheet, *Rule, *AtRule, *Declaration, *Comment, or *Raw.
+type Node interface {
+	String() string
+	writeTo(*bytes.Buffer)
+}
+
+// Stylesheet is a parsed stylesheet, see Parse. It can be transformed, written back using Write, or minified using MinifyStylesheet. The arguments of function tokens are in Args, without the closing parenthesis.
+type Stylesheet struct {
+	Nodes []Node
+}
+
+// Rule is a style rule, such as a,b{color:red}. Its nodes are the declarations and the nested style rules and at-rules.
+type Rule struct {
+	Selectors []Selector
+	Nodes     []Node
+}
+
+// Selector is one of the comma-separated selectors of a style rule or a keyframe.
+type Selector []Token
+
+// AtRule is an at-rule, such as @import url(a.css); or @media screen{...}. Its nodes are the rules or declarations of its block, or Raw tokens for unknown at-rules.
+type AtRule struct {
+	Name    []byte // including the at-sign
+	Prelude []Token
+	Block   bool // set when followed by a block instead of a semicolon
+	Nodes   []Node
+}
+
+// Declaration is a property and its value. The value of a custom property is a single CustomPropertyValueToken.
+type Declaration struct {
+	Property  []byte
+	Values    []Token
+	Important bool
+}
+
+// Comment is a comment outside of declaration lists, such as a license comment.
+type Comment struct {
+	Data []byte
+}
+
+// Raw are tokens that are written verbatim, such as the contents of unknown at-rules and, when Error is set, a declaration or rule that could not be parsed.
+type Raw struct {
+	Tokens []Token
+	Error  bool
+}
+
+// Parse parses a stylesheet from r. Nested style rules are kept as child nodes of their parent rule, see Minifier.FlattenNesting. Parse errors are kept as Raw nodes, so that the stylesheet can be written back as is.
+func Parse(r io.Reader) (*Stylesheet, error) {
+	z := parse.NewInput(r)
+	defer z.Restore()
+
+	s := &Stylesheet{}
+	var err error
+	if hasNestedBlocks(z.Bytes()) {
+		if rules := parseNesting(z.Bytes()); hasNesting(rules) {
+			s.Nodes, err = parseNestedNodes(rules, false)
+			return s, err
+		}
+	}
+	s.Nodes, err = parseNodes(z, false)
+	return s, err
+}
+
+// parseNestedNodes parses the rules of a stylesheet or group rule, or the declarations and rules of a style rule when inBlock is set, where the parser parses the parts without nesting.
+func parseNestedNodes(rules []nestedRule, inBlock bool) ([]Node, error) {
+	nodes := []Node{}
+	chunk := []byte{}
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		chunkNodes, err := parseNodes(parse.NewInputBytes(chunk), inBlock)
+		nodes = append(nodes, chunkNodes...)
+		chunk = chunk[:0]
+		return err
+	}
+	for _, rule := range rules {
+		if inBlock && rule.decl {
+			chunk = append(append(chunk, rule.raw...), ';')
+			continue
+		} else if !inBlock && (rule.prelude == nil || !hasNesting([]nestedRule{rule})) {
+			chunk = append(chunk, rule.raw...)
+			continue
+		} else if err := flush(); err != nil {
+			return nodes, err
+		}
+
+		// the prelude is parsed as a rule or group rule with an empty block
+		var prelude []Node
+		var err error
+		if rule.prelude != nil {
+			if prelude, err = parseNodes(parse.NewInputBytes(append(parse.Copy(rule.prelude), "{}"...)), false); err != nil {
+				return nodes, err
+			}
+		}
+		if len(prelude) != 1 {
+			raw, err := parseNodes(parse.NewInputBytes(rule.raw), false)
+			nodes = append(nodes, raw...)
+			if err != nil {
+				return nodes, err
+			}
+			continue
+		}
+
+		children, err := parseNestedNodes(rule.rules, inBlock || !rule.at)
+		switch n := prelude[0].(type) {
+		case *Rule:
+			n.Nodes = children
+		case *AtRule:
+			n.Nodes = children
+		}
+		nodes = append(nodes, prelude[0])
+		if err != nil {
+			return nodes, err
+		}
+	}
+	return nodes, flush()
+}
+
+// parseNodes parses a stylesheet without nested style rules, or a list of declarations when inline.
+func parseNodes(input *parse.Input, isInline bool) ([]Node, error) {
+	root := []Node{}
+	p := css.NewParser(input, isInline)
+	stack := []*[]Node{&root}
+	var selectors []Selector
+	var raw []css.Token // tokens of consecutive token grammars
+	for {
+		gt, tt, data := p.Next()
+		nodes := stack[len(stack)-1]
+		if gt != css.TokenGrammar && 0 < len(raw) {
+			tokens, _ := newTokens(raw, false)
+			*nodes = append(*nodes, &Raw{Tokens: tokens})
+			raw = raw[:0]
+		}
+
+		switch gt {
+		case css.ErrorGrammar:
+			if !p.HasParseError() {
+				if err := p.Err(); err != io.EOF {
+					return root, err
+				}
+				return root, nil
+			}
+			tokens, _ := newTokens(p.Values(), false)
+			*nodes = append(*nodes, &Raw{Tokens: tokens, Error: true})
+		case css.TokenGrammar:
+			raw = append(raw, css.Token{TokenType: tt, Data: data})
+		case css.CommentGrammar:
+			*nodes = append(*nodes, &Comment{parse.Copy(data)})
+		case css.AtRuleGrammar, css.BeginAtRuleGrammar:
+			prelude, _ := newTokens(p.Values(), false)
+			atRule := &AtRule{Name: parse.Copy(data), Prelude: prelude, Block: gt == css.BeginAtRuleGrammar}
+			*nodes = append(*nodes, atRule)
+			if atRule.Block {
+				stack = append(stack, &atRule.Nodes)
+			}
+		case css.QualifiedRuleGrammar, css.BeginRulesetGrammar:
+			selector, _ := newTokens(p.Values(), false)
+			selectors = append(selectors, selector)
+			if gt == css.BeginRulesetGrammar {
+				rule := &Rule{Selectors: selectors}
+				*nodes = append(*nodes, rule)
+				stack = append(stack, &rule.Nodes)
+				selectors = nil
+			}
+		case css.EndAtRuleGrammar, css.EndRulesetGrammar:
+			if 1 < len(stack) {
+				stack = stack[:len(stack)-1]
+			}
+		case css.DeclarationGrammar:
+			values := p.Values()
+			important := isImportant(values)
+			if important {
+				values = values[:len(values)-2]
+			}
+			tokens, _ := newTokens(values, false)
+			*nodes = append(*nodes, &Declaration{parse.Copy(data), tokens, important})
+		case css.CustomPropertyGrammar:
+			value := Token{TokenType: css.CustomPropertyValueToken, Data: parse.Copy(p.Values()[0].Data)}
+			*nodes = append(*nodes, &Declaration{parse.Copy(data), []Token{value}, false})
+		}
+	}
+}
+
+// isImportant returns true if the values of a declaration end in !important.
+func isImportant(values []css.Token) bool {
+	n := len(values)
+	return 2 < n && values[n-2].TokenType == css.DelimToken && values[n-2].Data[0] == '!' && ToHash(values[n-1].Data) == Important
+}
+
+// newTokens copies the tokens and moves the arguments of functions into Args. When nested, it returns at the closing parenthesis of a function together with the number of tokens consumed.
+func newTokens(values []css.Token, nested bool) ([]Token, int) {
+	tokens := []Token{}
+	level := 0
+	for i := 0; i < len(values); i++ {
+		tt, data := values[i].TokenType, parse.Copy(values[i].Data)
+		switch tt {
+		case css.LeftParenthesisToken:
+			level++
+		case css.RightParenthesisToken:
+			if nested && level == 0 {
+				return tokens, i + 1
+			}
+			level--
+		case css.FunctionToken:
+			args, n := newTokens(values[i+1:], true)
+			fun := ToHash(parse.ToLower(parse.Copy(data[:len(data)-1])))
+			tokens = append(tokens, Token{tt, data, args, fun, 0})
+			i += n
+			continue
+		case css.IdentToken:
+			tokens = append(tokens, Token{tt, data, nil, 0, ToHash(parse.ToLower(parse.Copy(data)))})
+			continue
+		}
+		tokens = append(tokens, Token{tt, data, nil, 0, 0})
+	}
+	return tokens, len(values)
+}
+
+// flattenTokens appends copies of the tokens to dst, where functions are followed by their arguments and a closing parenthesis.
+func flattenTokens(dst []css.Token, tokens []Token) []css.Token {
+	for _, t := range tokens {
+		dst = append(dst, css.Token{TokenType: t.TokenType, Data: parse.Copy(t.Data)})
+		if t.TokenType == css.FunctionToken {
+			dst = flattenTokens(dst, t.Args)
+			dst = append(dst, css.Token{TokenType: css.RightParenthesisToken, Data: rightParenBytes})
+		}
+	}
+	return dst
+}
+
+////////////////////////////////////////////////////////////////
+
+// Write writes the stylesheet to w. The output is not minified, the whitespace within selectors, preludes, and values is kept.
+func (s *Stylesheet) Write(w io.Writer) error {
+	buf := &bytes.Buffer{}
+	s.writeTo(buf)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (s *Stylesheet) String() string {
+	buf := &bytes.Buffer{}
+	s.writeTo(buf)
+	return buf.String()
+}
+
+func (s *Stylesheet) writeTo(buf *bytes.Buffer) {
+	writeNodes(buf, s.Nodes)
+}
+
+func (r *Rule) String() string {
+	buf := &bytes.Buffer{}
+	r.writeTo(buf)
+	return buf.String()
+}
+
+func (r *Rule) writeTo(buf *bytes.Buffer) {
+	for i, selector := range r.Selectors {
+		if i != 0 {
+			buf.Write(commaBytes)
+		}
+		writeTokens(buf, selector)
+	}
+	buf.Write(leftBracketBytes)
+	writeNodes(buf, r.Nodes)
+	buf.Write(rightBracketBytes)
+}
+
+func (r *AtRule) String() string {
+	buf := &bytes.Buffer{}
+	r.writeTo(buf)
+	return buf.String()
+}
+
+func (r *AtRule) writeTo(buf *bytes.Buffer) {
+	r.writePrelude(buf)
+	if r.Block {
+		buf.Write(leftBracketBytes)
+		writeNodes(buf, r.Nodes)
+		buf.Write(rightBracketBytes)
+	}
+}
+
+// writePrelude writes the name and prelude of the at-rule.
+func (r *AtRule) writePrelude(buf *bytes.Buffer) {
+	buf.Write(r.Name)
+	if 0 < len(r.Prelude) {
+		switch r.Prelude[0].TokenType {
+		case css.IdentToken, css.FunctionToken, css.URLToken, css.NumberToken, css.PercentageToken, css.DimensionToken:
+			buf.Write(spaceBytes) // separate from the name
+		}
+	}
+	writeTokens(buf, r.Prelude)
+}
+
+func (d *Declaration) String() string {
+	buf := &bytes.Buffer{}
+	d.writeTo(buf)
+	return buf.String()
+}
+
+func (d *Declaration) writeTo(buf *bytes.Buffer) {
+	buf.Write(d.Property)
+	buf.Write(colonBytes)
+	writeTokens(buf, d.Values)
+	if d.Important {
+		buf.Write(importantBytes)
+	}
+}
+
+func (c *Comment) String() string {
+	return string(c.Data)
+}
+
+func (c *Comment) writeTo(buf *bytes.Buffer) {
+	buf.Write(c.Data)
+}
+
+func (r *Raw) String() string {
+	buf := &bytes.Buffer{}
+	r.writeTo(buf)
+	return buf.String()
+}
+
+func (r *Raw) writeTo(buf *bytes.Buffer) {
+	writeTokens(buf, r.Tokens)
+}
+
+// writeNodes writes the nodes of a block, separating declarations and at-rules without block by semicolons.
+func writeNodes(buf *bytes.Buffer, nodes []Node) {
+	for i, n := range nodes {
+		if 0 < i && isStatement(nodes[i-1]) {
+			buf.Write(semicolonBytes)
+		}
+		n.writeTo(buf)
+	}
+	if 0 < len(nodes) {
+		if atRule, ok := nodes[len(nodes)-1].(*AtRule); ok && !atRule.Block {
+			buf.Write(semicolonBytes) // at-rules such as @import require a semicolon
+		}
+	}
+}
+
+// isStatement returns true for nodes that are terminated by a semicolon.
+func isStatement(n Node) bool {
+	switch n := n.(type) {
+	case *Declaration:
+		return true
+	case *AtRule:
+		return !n.Block
+	}
+	return false
+}
+
+func writeTokens(buf *bytes.Buffer, tokens []Token) {
+	for _, t := range tokens {
+		buf.Write(t.Data)
+		if t.TokenType == css.FunctionToken {
+			writeTokens(buf, t.Args)
+			buf.Write(rightParenBytes)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////
+
+// Visitor visits the nodes of a stylesheet, see Walk. Enter is called before the child nodes are visited, which are skipped when it returns nil, and Exit is called afterwards.
+type Visitor interface {
+	Enter(n Node) Visitor
+	Exit(n Node)
+}
+
+// Walk traverses the stylesheet or node in depth-first order.
+func Walk(v Visitor, n Node) {
+	if v = v.Enter(n); v == nil {
+		return
+	}
+	var nodes []Node
+	switch n := n.(type) {
+	case *Stylesheet:
+		nodes = n.Nodes
+	case *Rule:
+		nodes = n.Nodes
+	case *AtRule:
+		nodes = n.Nodes
+	}
+	for _, child := range nodes {
+		Walk(v, child)
+	}
+	v.Exit(n)
+}
+
+////////////////////////////////////////////////////////////////
+
+// grammarParser is the grammar of a stylesheet, as returned by css.Parser.
+type grammarParser interface {
+	Next() (css.GrammarType, css.TokenType, []byte)
+	Values() []css.Token
+	HasParseError() bool
+	Err() error
+}
+
+type grammar struct {
+	gt     css.GrammarType
+	tt     css.TokenType
+	data   []byte
+	values []css.Token
+}
+
+// stylesheetParser returns the grammar of a parsed stylesheet, so that it can be minified without writing and parsing it again. The tokens are copied as the minifier may change them in place.
+type stylesheetParser struct {
+	grammars []grammar
+	i        int
+}
+
+func newStylesheetParser(s *Stylesheet) *stylesheetParser {
+	p := &stylesheetParser{i: -1}
+	p.addNodes(s.Nodes)
+	return p
+}
+
+func (p *stylesheetParser) addNodes(nodes []Node) {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *Stylesheet:
+			p.addNodes(n.Nodes)
+		case *Rule:
+			for i, selector := range n.Selectors {
+				if i+1 < len(n.Selectors) {
+					p.add(css.QualifiedRuleGrammar, css.WhitespaceToken, nil, selector)
+				} else {
+					p.add(css.BeginRulesetGrammar, css.WhitespaceToken, nil, selector)
+				}
+			}
+			if len(n.Selectors) == 0 {
+				p.add(css.BeginRulesetGrammar, css.WhitespaceToken, nil, nil)
+			}
+			p.addNodes(n.Nodes)
+			p.add(css.EndRulesetGrammar, css.RightBraceToken, rightBracketBytes, nil)
+		case *AtRule:
+			if !n.Block {
+				p.add(css.AtRuleGrammar, css.AtKeywordToken, n.Name, n.Prelude)
+				continue
+			}
+			p.add(css.BeginAtRuleGrammar, css.AtKeywordToken, n.Name, n.Prelude)
+			p.addNodes(n.Nodes)
+			p.add(css.EndAtRuleGrammar, css.RightBraceToken, rightBracketBytes, nil)
+		case *Declaration:
+			if bytes.HasPrefix(n.Property, []byte("--")) {
+				value := flattenTokens(nil, n.Values)
+				value = []css.Token{{TokenType: css.CustomPropertyValueToken, Data: joinCSSTokens(value)}}
+				p.grammars = append(p.grammars, grammar{css.CustomPropertyGrammar, css.CustomPropertyNameToken, parse.Copy(n.Property), value})
+				continue
+			}
+			values := flattenTokens(nil, n.Values)
+			if n.Important {
+				values = append(values, css.Token{TokenType: css.DelimToken, Data: []byte("!")}, css.Token{TokenType: css.IdentToken, Data: []byte("important")})
+			}
+			p.grammars = append(p.grammars, grammar{css.DeclarationGrammar, css.IdentToken, parse.Copy(n.Property), values})
+		case *Comment:
+			p.add(css.CommentGrammar, css.CommentToken, n.Data, nil)
+		case *Raw:
+			values := flattenTokens(nil, n.Tokens)
+			if n.Error {
+				p.grammars = append(p.grammars, grammar{css.ErrorGrammar, css.ErrorToken, nil, values})
+				continue
+			}
+			for _, value := range values {
+				p.grammars = append(p.grammars, grammar{css.TokenGrammar, value.TokenType, value.Data, nil})
+			}
+		}
+	}
+}
+
+func (p *stylesheetParser) add(gt css.GrammarType, tt css.TokenType, data []byte, tokens []Token) {
+	p.grammars = append(p.grammars, grammar{gt, tt, parse.Copy(data), flattenTokens(nil, tokens)})
+}
+
+func joinCSSTokens(values []css.Token) []byte {
+	b := []byte{}
+	for _, value := range values {
+		b = append(b, value.Data...)
+	}
+	return b
+}
+
+// Next returns the next grammar, or an ErrorGrammar at the end of the stylesheet.
+func (p *stylesheetParser) Next() (css.GrammarType, css.TokenType, []byte) {
+	if p.i < len(p.grammars) {
+		p.i++
+	}
+	if p.i == len(p.grammars) {
+		return css.ErrorGrammar, css.ErrorToken, nil
+	}
+	g := p.grammars[p.i]
+	return g.gt, g.tt, g.data
+}
+
+// Values returns the tokens of the current grammar.
+func (p *stylesheetParser) Values() []css.Token {
+	if p.i < 0 || p.i == len(p.grammars) {
+		return nil
+	}
+	return p.grammars[p.i].values
+}
+
+// HasParseError returns true for Raw nodes with Error set, which are written verbatim.
+func (p *stylesheetParser) HasParseError() bool {
+	return 0 <= p.i && p.i < len(p.grammars) && p.grammars[p.i].gt == css.ErrorGrammar
+}
+
+// Err returns io.EOF as the stylesheet has no errors.
+func (p *stylesheetParser) Err() error {
+	return io.EOF
+}
+
+// commentParser adds the kept comments inside blocks, which the parser does not report, as comment grammars to the grammar of the parser in source order.
+type commentParser struct {
+	grammarParser
+	src      []byte
+	comments [][]byte
+	offsets  []int // positions of the comments in the source
+	braces   []int // positions of the closing braces in the source
+
+	next     grammar // grammar of the parser that follows the comments
+	buffered bool
+	pos      int  // position of the last grammar in the source
+	comment  bool // current grammar is a comment
+}
+
+func newCommentParser(p grammarParser, src []byte, keep func([]byte) bool) *commentParser {
+	cp := &commentParser{grammarParser: p, src: src}
+	l := css.NewLexer(parse.NewInputBytes(src))
+	pos, level := 0, 0
+	for {
+		tt, data := l.Next()
+		pos += len(data)
+		if tt == css.ErrorToken {
+			break
+		} else if tt == css.LeftBraceToken {
+			level++
+		} else if tt == css.RightBraceToken {
+			level--
+			cp.braces = append(cp.braces, pos-len(data))
+		} else if tt == css.CommentToken && 0 < level && keep(data) {
+			cp.comments = append(cp.comments, data)
+			cp.offsets = append(cp.offsets, pos-len(data))
+		}
+	}
+	return cp
+}
+
+// offset returns the position in the source of a grammar, or -1 if unknown. The closing braces of blocks are not always a slice of the source, they are the first closing brace after the last grammar.
+func (p *commentParser) offset(g grammar) int {
+	if offset, ok := minify.SliceOffset(p.src, g.data); ok {
+		return offset
+	} else if g.gt == css.EndRulesetGrammar || g.gt == css.EndAtRuleGrammar {
+		for _, brace := range p.braces {
+			if p.pos < brace {
+				return brace
+			}
+		}
+	} else if 0 < len(g.values) {
+		if offset, ok := minify.SliceOffset(p.src, g.values[0].Data); ok {
+			return offset
+		}
+	}
+	return -1
+}
+
+// Next returns the next comment in front of the next grammar of the parser, or that grammar. Remaining comments are returned at the end of the stylesheet.
+func (p *commentParser) Next() (css.GrammarType, css.TokenType, []byte) {
+	if !p.buffered {
+		gt, tt, data := p.grammarParser.Next()
+		p.next = grammar{gt, tt, data, append(p.next.values[:0], p.grammarParser.Values()...)}
+		p.buffered = true
+	}
+	offset := p.offset(p.next)
+	if 0 < len(p.comments) {
+		end := p.next.gt == css.ErrorGrammar && !p.grammarParser.HasParseError()
+		if end || p.offsets[0] < offset {
+			comment := p.comments[0]
+			p.comments = p.comments[1:]
+			p.offsets = p.offsets[1:]
+			p.comment = true
+			return css.CommentGrammar, css.CommentToken, comment
+		}
+	}
+	if p.pos < offset {
+		p.pos = offset
+	}
+	p.buffered = false
+	p.comment = false
+	return p.next.gt, p.next.tt, p.next.data
+}
+
+// Values returns the tokens of the current grammar.
+func (p *commentParser) Values() []css.Token {
+	if p.comment {
+		return nil
+	}
+	return p.next.values
+}
+
+////////////////////////////////////////////////////////////////
+
+// MinifyStylesheet minifies a parsed stylesheet, see Parse, and writes to w. It applies the same options as Minify except for SourceMap, where nested style rules are flattened when FlattenNesting or the targets require it. The stylesheet is not modified. Nested style rules that are kept are minified like Minify does: the parser does not support them, so the parts of the stylesheet between them are written and parsed again, which costs about as much as parsing those parts.
+func (o *Minifier) MinifyStylesheet(m *minify.M, w io.Writer, s *Stylesheet) error {
+	if !hasNestedRules(s.Nodes) {
+		return o.minify(m, w, newStylesheetParser(s), false, nil, nil)
+	} else if o.flattensNesting() {
+		nodes, err := flattenNodes(s.Nodes, o.features())
+		if err != nil {
+			return err
+		}
+		return o.minify(m, w, newStylesheetParser(&Stylesheet{Nodes: nodes}), false, nil, nil)
+	}
+	return o.minify(m, w, nil, false, nil, nestingRules(s.Nodes, false))
+}
+
+// flattenNodes returns the nodes with the nested style rules moved to the top level, with their selectors resolved against their parents, like flattenNesting.
+func flattenNodes(nodes []Node, features feature) ([]Node, error) {
+	flat := []Node{}
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *Stylesheet:
+			children, err := flattenNodes(n.Nodes, features)
+			if err != nil {
+				return nil, err
+			}
+			flat = append(flat, children...)
+		case *Rule:
+			selectors := make([][]byte, len(n.Selectors))
+			for i, selector := range n.Selectors {
+				buf := &bytes.Buffer{}
+				writeTokens(buf, selector)
+				selectors[i] = buf.Bytes()
+			}
+			var err error
+			if flat, err = flattenRuleNodes(flat, selectors, n.Nodes, features); err != nil {
+				return nil, err
+			}
+		case *AtRule:
+			if n.Block && n.isGroup() {
+				children, err := flattenNodes(n.Nodes, features)
+				if err != nil {
+					return nil, err
+				}
+				flat = append(flat, &AtRule{Name: n.Name, Prelude: n.Prelude, Block: true, Nodes: children})
+			} else {
+				flat = append(flat, n)
+			}
+		default:
+			flat = append(flat, n)
+		}
+	}
+	return flat, nil
+}
+
+// flattenRuleNodes appends the declarations of a style rule followed by its nested rules, in order, so that declarations after nested rules are in a separate style rule, like flattenBlock.
+func flattenRuleNodes(flat []Node, selectors [][]byte, nodes []Node, features feature) ([]Node, error) {
+	decls := []Node{}
+	flush := func() {
+		if 0 < len(decls) {
+			rule := &Rule{Nodes: decls}
+			for _, selector := range selectors {
+				rule.Selectors = append(rule.Selectors, newSelector(selector))
+			}
+			flat = append(flat, rule)
+			decls = []Node{}
+		}
+	}
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *Rule:
+			flush()
+			buf := &bytes.Buffer{}
+			for i, selector := range n.Selectors {
+				if i != 0 {
+					buf.Write(commaBytes)
+				}
+				writeTokens(buf, selector)
+			}
+			nested, err := resolveSelectors(buf.Bytes(), selectors, features)
+			if err != nil {
+				return nil, err
+			}
+			if flat, err = flattenRuleNodes(flat, nested, n.Nodes, features); err != nil {
+				return nil, err
+			}
+		case *AtRule:
+			if !n.Block || !n.isGroup() {
+				decls = append(decls, n)
+				continue
+			}
+			flush()
+			children, err := flattenRuleNodes(nil, selectors, n.Nodes, features)
+			if err != nil {
+				return nil, err
+			}
+			flat = append(flat, &AtRule{Name: n.Name, Prelude: n.Prelude, Block: true, Nodes: children})
+		default:
+			decls = append(decls, n)
+		}
+	}
+	flush()
+	return flat, nil
+}
+
+// newSelector returns the tokens of a selector.
+func newSelector(b []byte) Selector {
+	values := []css.Token{}
+	l := css.NewLexer(parse.NewInputBytes(b))
+	for {
+		tt, data := l.Next()
+		if tt == css.ErrorToken {
+			break
+		}
+		values = append(values, css.Token{TokenType: tt, Data: data})
+	}
+	tokens, _ := newTokens(values, false)
+	return tokens
+}
+
+// isGroup returns true for at-rules that contain rules, such as @media.
+func (r *AtRule) isGroup() bool {
+	return 1 < len(r.Name) && groupRules[string(parse.ToLower(parse.Copy(r.Name[1:])))]
+}
+
+// nestedRules returns the rules of nodes for minifyNesting, like parseNesting does for text, where inBlock is set for the declarations and rules of a style rule.
+func nestingRules(nodes []Node, inBlock bool) []nestedRule {
+	rules := []nestedRule{}
+	for _, n := range nodes {
+		buf := &bytes.Buffer{}
+		n.writeTo(buf)
+		rule := nestedRule{raw: buf.Bytes()}
+		switch n := n.(type) {
+		case *Stylesheet:
+			rules = append(rules, nestingRules(n.Nodes, inBlock)...)
+			continue
+		case *Rule:
+			prelude := &bytes.Buffer{}
+			for i, selector := range n.Selectors {
+				if i != 0 {
+					prelude.Write(commaBytes)
+				}
+				writeTokens(prelude, selector)
+			}
+			rule.prelude = prelude.Bytes()
+			rule.rules = nestingRules(n.Nodes, true)
+		case *AtRule:
+			if n.Block && n.isGroup() {
+				prelude := &bytes.Buffer{}
+				n.writePrelude(prelude)
+				rule.prelude = prelude.Bytes()
+				rule.at = true
+				rule.rules = nestingRules(n.Nodes, inBlock)
+			} else if !n.Block {
+				rule.raw = append(rule.raw, ';')
+			}
+		case *Declaration, *Raw:
+			rule.decl = inBlock
+		case *Comment:
+			if inBlock {
+				continue // comments in blocks are not kept, like parseNesting
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// hasNestedRules returns true if a style rule contains rules or group rules.
+func hasNestedRules(nodes []Node) bool {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *Rule:
+			for _, child := range n.Nodes {
+				if _, ok := child.(*Rule); ok {
+					return true
+				} else if atRule, ok := child.(*AtRule); ok && atRule.Block {
+					return true
+				}
+			}
+		case *AtRule:
+			if hasNestedRules(n.Nodes) {
+				return true
+			}
+		}
+	}
+	return false
+}
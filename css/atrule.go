@@ -0,0 +1,165 @@
+package css
+
+import (
+	"bytes"
+
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+)
+
+var (
+	fromBytes   = []byte("from")
+	toBytes     = []byte("to")
+	n0pBytes    = []byte("0%")
+	allAndBytes = []byte("all and ")
+)
+
+// isConditionRule returns true if the prelude of the at-rule is a media query or other condition.
+func isConditionRule(name []byte) bool {
+	switch string(parse.ToLower(parse.Copy(name[1:]))) {
+	case "media", "supports", "container", "custom-media":
+		return true
+	}
+	return false
+}
+
+// isGroupRule returns true if the at-rule is a conditional group rule, whose rules apply only when the condition holds. Such rules can be merged when adjacent and removed when empty, unlike @layer or @keyframes which take effect even when empty.
+func isGroupRule(r *rule) bool {
+	if r.typ != blockRule {
+		return false
+	}
+	name := string(parse.ToLower(parse.Copy(r.data[1:])))
+	return groupRules[name] && name != "layer"
+}
+
+// descriptorRules are at-rules whose block only has descriptors, which have no effect when empty.
+var descriptorRules = map[string]bool{
+	"font-face":           true,
+	"font-feature-values": true,
+	"page":                true,
+	"viewport":            true,
+	"-ms-viewport":        true,
+}
+
+// isEmptyRule returns true if the at-rule has no effect as it is a conditional group rule or descriptor rule without rules or descriptors.
+func isEmptyRule(r *rule) bool {
+	if r.typ != blockRule || 0 < len(r.rules) {
+		return false
+	}
+	return isGroupRule(r) || descriptorRules[string(parse.ToLower(parse.Copy(r.data[1:])))]
+}
+
+// minifyNumberToken minifies the number of a number, percentage, or dimension token.
+func (c *cssMinifier) minifyNumberToken(t css.Token) css.Token {
+	n := parse.Number(t.Data)
+	if n == 0 {
+		return t
+	}
+	var num []byte
+	if c.o.KeepCSS2 {
+		num = minify.Decimal(parse.Copy(t.Data[:n]), c.o.Precision) // don't use exponents
+	} else {
+		num = minify.Number(parse.Copy(t.Data[:n]), c.o.Precision)
+	}
+	t.Data = append(num, t.Data[n:]...)
+	return t
+}
+
+// minifyCondition minifies the numbers of a media query or other condition, and removes whitespace around comparisons and ratios such as (width >= 600px) and (aspect-ratio: 16 / 9).
+func (c *cssMinifier) minifyCondition(values []css.Token) []css.Token {
+	isOperator := func(t css.Token) bool {
+		return t.TokenType == css.DelimToken && (t.Data[0] == '<' || t.Data[0] == '>' || t.Data[0] == '=' || t.Data[0] == '/')
+	}
+
+	list := make([]css.Token, 0, len(values))
+	level := 0
+	for i, val := range values {
+		switch val.TokenType {
+		case css.LeftParenthesisToken, css.FunctionToken:
+			level++
+		case css.RightParenthesisToken:
+			level--
+		case css.NumberToken, css.PercentageToken, css.DimensionToken:
+			val = c.minifyNumberToken(val)
+		case css.WhitespaceToken:
+			if 0 < level && (0 < len(list) && isOperator(list[len(list)-1]) || i+1 < len(values) && isOperator(values[i+1])) {
+				continue
+			}
+		}
+		list = append(list, val)
+	}
+	return list
+}
+
+// minifyKeyframeSelectors writes the selector of a keyframe using the shortest of from and 0%, and of to and 100%.
+func (c *cssMinifier) minifyKeyframeSelectors(values []css.Token) {
+	for _, val := range values {
+		if val.TokenType == css.IdentToken && parse.EqualFold(val.Data, fromBytes) {
+			val.Data = n0pBytes
+		} else if val.TokenType == css.IdentToken && parse.EqualFold(val.Data, toBytes) {
+			val.Data = toBytes
+		} else if val.TokenType == css.PercentageToken {
+			if val = c.minifyNumberToken(val); bytes.Equal(val.Data, n100pBytes) {
+				val.Data = toBytes
+			}
+		}
+		c.w.Write(val.Data)
+	}
+}
+
+// hasAllMedia returns the length of a leading all and in a media query list of one query, which can be removed.
+func hasAllMedia(values []css.Token) int {
+	i := 0
+	for i < len(values) && values[i].TokenType == css.WhitespaceToken {
+		i++
+	}
+	b := joinTokens(values[i:])
+	if !bytes.HasPrefix(parse.ToLower(parse.Copy(b)), allAndBytes) || bytes.IndexByte(b, ',') != -1 {
+		return 0
+	}
+	j := i + 1 // all
+	for j < len(values) && values[j].TokenType == css.WhitespaceToken {
+		j++
+	}
+	j++ // and
+	for j < len(values) && values[j].TokenType == css.WhitespaceToken {
+		j++
+	}
+	return j
+}
+
+////////////////////////////////////////////////////////////////
+
+// mergeAtRules merges adjacent conditional group rules with equal preludes, removes the empty ones as well as empty descriptor rules such as @font-face, and removes @font-face rules that are repeated later on, which take precedence.
+func mergeAtRules(rules []*rule) []*rule {
+	list := rules[:0]
+	for _, r := range rules {
+		if r.typ == blockRule && !isKeyframes(r) {
+			r.rules = mergeAtRules(r.rules)
+		}
+		if isEmptyRule(r) {
+			continue
+		} else if isGroupRule(r) && 0 < len(list) {
+			if prev := list[len(list)-1]; isGroupRule(prev) && bytes.Equal(prev.data, r.data) && bytes.Equal(joinTokens(prev.values), joinTokens(r.values)) {
+				prev.rules = mergeAtRules(append(prev.rules, r.rules...))
+				continue
+			}
+		}
+		list = append(list, r)
+	}
+
+	fontFaces := list[:0]
+Next:
+	for i, r := range list {
+		if r.typ == blockRule && parse.EqualFold(r.data[1:], []byte("font-face")) {
+			for _, later := range list[i+1:] {
+				if later.typ == blockRule && bytes.Equal(r.data, later.data) && equalRules(r.rules, later.rules) {
+					continue Next
+				}
+			}
+		}
+		fontFaces = append(fontFaces, r)
+	}
+	return fontFaces
+}
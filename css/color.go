@@ -0,0 +1,671 @@
+package css
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"strconv"
+
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+)
+
+// gamutTolerance is the tolerance of the sRGB channels for colors to be considered in gamut, which is well below the precision of 8-bit channels.
+const gamutTolerance = 0.001
+
+type matrix [3][3]float64
+
+func (m matrix) mul(v [3]float64) [3]float64 {
+	return [3]float64{
+		m[0][0]*v[0] + m[0][1]*v[1] + m[0][2]*v[2],
+		m[1][0]*v[0] + m[1][1]*v[1] + m[1][2]*v[2],
+		m[2][0]*v[0] + m[2][1]*v[1] + m[2][2]*v[2],
+	}
+}
+
+// conversion matrices from CSS Color Module Level 4, all XYZ coordinates are relative to D65 unless noted otherwise
+var (
+	linearSRGBToXYZ = matrix{
+		{0.41239079926595934, 0.357584339383878, 0.1804807884018343},
+		{0.21263900587151027, 0.715168678767756, 0.07219231536073371},
+		{0.01933081871559182, 0.11919477979462598, 0.9505321522496607},
+	}
+	xyzToLinearSRGB = matrix{
+		{3.2409699419045226, -1.537383177570094, -0.4986107602930034},
+		{-0.9692436362808796, 1.8759675015077202, 0.04155505740717559},
+		{0.05563007969699366, -0.20397695888897652, 1.0569715142428786},
+	}
+	linearP3ToXYZ = matrix{
+		{0.4865709486482162, 0.26566769316909306, 0.1982172852343625},
+		{0.2289745640697488, 0.6917385218365064, 0.079286914093745},
+		{0.0, 0.04511338185890264, 1.043944368900976},
+	}
+	linearA98ToXYZ = matrix{
+		{0.5766690429101305, 0.1855582379065463, 0.1882286462349947},
+		{0.29734497525053605, 0.6273635662554661, 0.07529145849399788},
+		{0.02703136138641234, 0.07068885253582723, 0.9913375368376388},
+	}
+	linearRec2020ToXYZ = matrix{
+		{0.6369580483012914, 0.14461690358620832, 0.1688809751641721},
+		{0.2627002120112671, 0.6779980715188708, 0.05930171646986196},
+		{0.0, 0.028072693049087428, 1.060985057710791},
+	}
+	linearProPhotoToXYZD50 = matrix{
+		{0.7977604896723027, 0.13518583717574031, 0.0313493495815248},
+		{0.2880711282292934, 0.7118432178101014, 0.00008565396060525902},
+		{0.0, 0.0, 0.8251046025104601},
+	}
+	xyzD50ToXYZ = matrix{
+		{0.9554734527042182, -0.023098536874261423, 0.0632593086610217},
+		{-0.028369706963208136, 1.0099954580058226, 0.021041398966943008},
+		{0.012314001688319899, -0.020507696433477912, 1.3303659366080753},
+	}
+	xyzToXYZD50 = matrix{
+		{1.0479298208405488, 0.022946793341019088, -0.05019222954313557},
+		{0.029627815688159344, 0.990434484573249, -0.01707382502938514},
+		{-0.009243058152591178, 0.015055144896577895, 0.7518742899580008},
+	}
+	xyzToLMS = matrix{
+		{0.8190224379967030, 0.3619062600528904, -0.1288737815209879},
+		{0.0329836539323885, 0.9292868615863434, 0.0361446663506424},
+		{0.0481771893596242, 0.2642395317527308, 0.6335478284694309},
+	}
+	lmsToXYZ = matrix{
+		{1.2268798758459243, -0.5578149944602171, 0.2813910456659647},
+		{-0.0405757452148008, 1.1122868032803170, -0.0717110580655164},
+		{-0.0763729366746601, -0.4214933324022432, 1.5869240198367816},
+	}
+	lmsToOklab = matrix{
+		{0.2104542683093140, 0.7936177747023054, -0.0040720430116193},
+		{1.9779985324311684, -2.4285922420485799, 0.4505937096174110},
+		{0.0259040424655478, 0.7827717124575296, -0.8086757548730772},
+	}
+	oklabToLMS = matrix{
+		{1.0, 0.3963377773761749, 0.2158037573099136},
+		{1.0, -0.1055613458156586, -0.0638541728258133},
+		{1.0, -0.0894841775298119, -1.2914855480194092},
+	}
+	whiteD50 = [3]float64{0.3457 / 0.3585, 1.0, (1.0 - 0.3457 - 0.3585) / 0.3585}
+)
+
+// colorNames maps the color names that are in neither ShortenColorName nor ShortenColorHex to their hexcode
+var colorNames = map[string][]byte{
+	"aqua":           []byte("#00ffff"),
+	"blue":           []byte("#0000ff"),
+	"crimson":        []byte("#dc143c"),
+	"cyan":           []byte("#00ffff"),
+	"darkgrey":       []byte("#a9a9a9"),
+	"darkred":        []byte("#8b0000"),
+	"darkslategrey":  []byte("#2f4f4f"),
+	"dimgray":        []byte("#696969"),
+	"dimgrey":        []byte("#696969"),
+	"grey":           []byte("#808080"),
+	"hotpink":        []byte("#ff69b4"),
+	"lightgrey":      []byte("#d3d3d3"),
+	"lightslategray": []byte("#778899"),
+	"lightslategrey": []byte("#778899"),
+	"lime":           []byte("#00ff00"),
+	"oldlace":        []byte("#fdf5e6"),
+	"rebeccapurple":  []byte("#663399"),
+	"skyblue":        []byte("#87ceeb"),
+	"slategrey":      []byte("#708090"),
+	"thistle":        []byte("#d8bfd8"),
+}
+
+// color is a color in the XYZ color space relative to D65, with an alpha channel.
+type color struct {
+	xyz   [3]float64
+	alpha float64
+}
+
+func mapChannels(v [3]float64, f func(float64) float64) [3]float64 {
+	return [3]float64{f(v[0]), f(v[1]), f(v[2])}
+}
+
+func signedPow(v, e float64) float64 {
+	if v < 0.0 {
+		return -math.Pow(-v, e)
+	}
+	return math.Pow(v, e)
+}
+
+func srgbToLinear(v float64) float64 {
+	if math.Abs(v) <= 0.04045 {
+		return v / 12.92
+	}
+	return signedPow((math.Abs(v)+0.055)/1.055, 2.4) * math.Copysign(1.0, v)
+}
+
+func linearToSRGB(v float64) float64 {
+	if math.Abs(v) <= 0.0031308 {
+		return v * 12.92
+	}
+	return (1.055*math.Pow(math.Abs(v), 1.0/2.4) - 0.055) * math.Copysign(1.0, v)
+}
+
+func a98ToLinear(v float64) float64 {
+	return signedPow(v, 563.0/256.0)
+}
+
+func proPhotoToLinear(v float64) float64 {
+	if math.Abs(v) <= 16.0/512.0 {
+		return v / 16.0
+	}
+	return signedPow(v, 1.8)
+}
+
+func rec2020ToLinear(v float64) float64 {
+	const alpha, beta = 1.09929682680944, 0.018053968510807
+	if math.Abs(v) < beta*4.5 {
+		return v / 4.5
+	}
+	return signedPow((math.Abs(v)+alpha-1.0)/alpha, 1.0/0.45) * math.Copysign(1.0, v)
+}
+
+func srgbToXYZ(rgb [3]float64) [3]float64 {
+	return linearSRGBToXYZ.mul(mapChannels(rgb, srgbToLinear))
+}
+
+func xyzToSRGB(xyz [3]float64) [3]float64 {
+	return mapChannels(xyzToLinearSRGB.mul(xyz), linearToSRGB)
+}
+
+func labToXYZ(lab [3]float64) [3]float64 {
+	const kappa, epsilon = 24389.0 / 27.0, 216.0 / 24389.0
+	f1 := (lab[0] + 16.0) / 116.0
+	f0 := lab[1]/500.0 + f1
+	f2 := f1 - lab[2]/200.0
+	xyz := [3]float64{(116.0*f0 - 16.0) / kappa, lab[0] / kappa, (116.0*f2 - 16.0) / kappa}
+	if epsilon < f0*f0*f0 {
+		xyz[0] = f0 * f0 * f0
+	}
+	if kappa*epsilon < lab[0] {
+		xyz[1] = f1 * f1 * f1
+	}
+	if epsilon < f2*f2*f2 {
+		xyz[2] = f2 * f2 * f2
+	}
+	return xyzD50ToXYZ.mul([3]float64{xyz[0] * whiteD50[0], xyz[1] * whiteD50[1], xyz[2] * whiteD50[2]})
+}
+
+func xyzToLab(xyz [3]float64) [3]float64 {
+	const kappa, epsilon = 24389.0 / 27.0, 216.0 / 24389.0
+	xyz = xyzToXYZD50.mul(xyz)
+	f := [3]float64{}
+	for i := range f {
+		v := xyz[i] / whiteD50[i]
+		if epsilon < v {
+			f[i] = math.Cbrt(v)
+		} else {
+			f[i] = (kappa*v + 16.0) / 116.0
+		}
+	}
+	return [3]float64{116.0*f[1] - 16.0, 500.0 * (f[0] - f[1]), 200.0 * (f[1] - f[2])}
+}
+
+func oklabToXYZ(lab [3]float64) [3]float64 {
+	lms := mapChannels(oklabToLMS.mul(lab), func(v float64) float64 { return v * v * v })
+	return lmsToXYZ.mul(lms)
+}
+
+func xyzToOklab(xyz [3]float64) [3]float64 {
+	return lmsToOklab.mul(mapChannels(xyzToLMS.mul(xyz), math.Cbrt))
+}
+
+func polarToRectangular(l, c, h float64) [3]float64 {
+	h *= math.Pi / 180.0
+	return [3]float64{l, c * math.Cos(h), c * math.Sin(h)}
+}
+
+func hwbToSRGB(h, w, b float64) [3]float64 {
+	if 1.0 <= w+b {
+		gray := w / (w + b)
+		return [3]float64{gray, gray, gray}
+	}
+	r, g, bl := css.HSL2RGB(h/360.0, 1.0, 0.5)
+	return mapChannels([3]float64{r, g, bl}, func(v float64) float64 { return v*(1.0-w-b) + w })
+}
+
+////////////////////////////////////////////////////////////////
+
+// colorComponents returns the components of a color function and its alpha, if any. Legacy colors separate the components by commas. It returns false for other arguments, such as functions and none.
+func colorComponents(args []Token, legacy bool) ([]Token, *Token, bool) {
+	components := []Token{}
+	var alpha *Token
+	slash, comma := false, false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg.TokenType {
+		case css.WhitespaceToken:
+			continue
+		case css.CommaToken:
+			if !legacy || len(components) == 0 {
+				return nil, nil, false
+			}
+			comma = true
+		case css.DelimToken:
+			if arg.Data[0] != '/' || slash || comma {
+				return nil, nil, false
+			}
+			slash = true
+		case css.NumberToken, css.PercentageToken, css.DimensionToken:
+			if alpha != nil {
+				return nil, nil, false
+			} else if slash || comma && len(components) == 3 {
+				alpha = &args[i]
+			} else {
+				components = append(components, arg)
+			}
+		case css.IdentToken:
+			if len(components) != 0 || alpha != nil {
+				return nil, nil, false
+			}
+			components = append(components, arg) // color space of color()
+		default:
+			return nil, nil, false
+		}
+	}
+	return components, alpha, true
+}
+
+// colorNumber returns the value of a number, or of a percentage relative to ref.
+func colorNumber(t Token, ref float64) (float64, bool) {
+	if t.TokenType == css.NumberToken {
+		f, err := strconv.ParseFloat(string(t.Data), 64)
+		return f, err == nil
+	} else if t.TokenType == css.PercentageToken {
+		f, err := strconv.ParseFloat(string(t.Data[:len(t.Data)-1]), 64)
+		return f / 100.0 * ref, err == nil
+	}
+	return 0.0, false
+}
+
+// colorHue returns the hue in degrees of a number or angle.
+func colorHue(t Token) (float64, bool) {
+	if t.TokenType == css.NumberToken {
+		return colorNumber(t, 0.0)
+	} else if t.TokenType != css.DimensionToken {
+		return 0.0, false
+	}
+	n := parse.Number(t.Data)
+	f, err := strconv.ParseFloat(string(t.Data[:n]), 64)
+	if err != nil {
+		return 0.0, false
+	}
+	switch string(parse.ToLower(parse.Copy(t.Data[n:]))) {
+	case "deg":
+		return f, true
+	case "grad":
+		return f * 0.9, true
+	case "rad":
+		return f * 180.0 / math.Pi, true
+	case "turn":
+		return f * 360.0, true
+	}
+	return 0.0, false
+}
+
+// colorAlpha returns the alpha of a color clamped to [0.0, 1.0], which is one if not specified.
+func colorAlpha(t *Token) (float64, bool) {
+	if t == nil {
+		return 1.0, true
+	}
+	a, ok := colorNumber(*t, 1.0)
+	return math.Max(0.0, math.Min(1.0, a)), ok
+}
+
+// colorNumbers returns the values of the numbers and percentages, with percentages relative to the references.
+func colorNumbers(components []Token, refs ...float64) ([3]float64, bool) {
+	v := [3]float64{}
+	if len(components) != 3 {
+		return v, false
+	}
+	for i := range v {
+		var ok bool
+		if v[i], ok = colorNumber(components[i], refs[i]); !ok {
+			return v, false
+		}
+	}
+	return v, true
+}
+
+// colorName returns the hexadecimal code of a named color.
+func colorName(name []byte) ([]byte, bool) {
+	name = parse.ToLower(parse.Copy(name))
+	if hexValue, ok := ShortenColorName[ToHash(name)]; ok {
+		return hexValue, true
+	} else if hexValue, ok := colorNames[string(name)]; ok {
+		return hexValue, true
+	}
+	for hexValue, shortName := range ShortenColorHex {
+		if bytes.Equal(shortName, name) {
+			return []byte(hexValue), true
+		}
+	}
+	return nil, false
+}
+
+// parseColor returns the color of a named color, hexadecimal color, or color function with literal arguments.
+func parseColor(value Token) (color, bool) {
+	switch value.TokenType {
+	case css.IdentToken:
+		if value.Ident == Transparent {
+			return color{}, true
+		}
+		if hexValue, ok := colorName(value.Data); ok {
+			return parseColor(Token{css.HashToken, hexValue, nil, 0, 0})
+		}
+	case css.HashToken:
+		data := value.Data[1:]
+		if len(data) == 3 || len(data) == 4 {
+			data = []byte{data[0], data[0], data[1], data[1], data[2], data[2], 'f', 'f'}[:len(value.Data[1:])*2]
+			if len(value.Data) == 5 {
+				data[6], data[7] = value.Data[4], value.Data[4]
+			}
+		}
+		if len(data) == 6 {
+			data = append(data[:6:6], 'f', 'f')
+		}
+		rgba := make([]byte, 4)
+		if len(data) != 8 {
+			return color{}, false
+		} else if _, err := hex.Decode(rgba, data); err != nil {
+			return color{}, false
+		}
+		rgb := [3]float64{float64(rgba[0]) / 255.0, float64(rgba[1]) / 255.0, float64(rgba[2]) / 255.0}
+		return color{srgbToXYZ(rgb), float64(rgba[3]) / 255.0}, true
+	case css.FunctionToken:
+		return parseColorFunction(value)
+	}
+	return color{}, false
+}
+
+// parseColorFunction returns the color of a color function with literal arguments.
+func parseColorFunction(value Token) (color, bool) {
+	name := string(parse.ToLower(parse.Copy(value.Data[:len(value.Data)-1])))
+	if name == "color-mix" {
+		return parseColorMix(value.Args)
+	}
+
+	legacy := name == "rgb" || name == "rgba" || name == "hsl" || name == "hsla"
+	components, alphaToken, ok := colorComponents(value.Args, legacy)
+	if !ok {
+		return color{}, false
+	}
+	alpha, ok := colorAlpha(alphaToken)
+	if !ok {
+		return color{}, false
+	}
+
+	var xyz [3]float64
+	switch name {
+	case "rgb", "rgba":
+		rgb, ok := colorNumbers(components, 255.0, 255.0, 255.0)
+		if !ok {
+			return color{}, false
+		}
+		xyz = srgbToXYZ(mapChannels(rgb, func(v float64) float64 { return math.Max(0.0, math.Min(1.0, v/255.0)) }))
+	case "hsl", "hsla", "hwb":
+		if len(components) != 3 {
+			return color{}, false
+		}
+		h, ok1 := colorHue(components[0])
+		s, ok2 := colorNumber(components[1], 100.0)
+		l, ok3 := colorNumber(components[2], 100.0)
+		if !ok1 || !ok2 || !ok3 {
+			return color{}, false
+		}
+		h = math.Mod(h, 360.0)
+		if h < 0.0 {
+			h += 360.0
+		}
+		s, l = math.Max(0.0, math.Min(1.0, s/100.0)), math.Max(0.0, math.Min(1.0, l/100.0))
+		if name == "hwb" {
+			xyz = srgbToXYZ(hwbToSRGB(h, s, l))
+		} else {
+			r, g, b := css.HSL2RGB(h/360.0, s, l)
+			xyz = srgbToXYZ([3]float64{r, g, b})
+		}
+	case "lab", "oklab":
+		ref := [3]float64{100.0, 125.0, 125.0}
+		if name == "oklab" {
+			ref = [3]float64{1.0, 0.4, 0.4}
+		}
+		lab, ok := colorNumbers(components, ref[0], ref[1], ref[2])
+		if !ok {
+			return color{}, false
+		}
+		lab[0] = math.Max(0.0, math.Min(ref[0], lab[0]))
+		if name == "lab" {
+			xyz = labToXYZ(lab)
+		} else {
+			xyz = oklabToXYZ(lab)
+		}
+	case "lch", "oklch":
+		ref := [2]float64{100.0, 150.0}
+		if name == "oklch" {
+			ref = [2]float64{1.0, 0.4}
+		}
+		if len(components) != 3 {
+			return color{}, false
+		}
+		l, ok1 := colorNumber(components[0], ref[0])
+		c, ok2 := colorNumber(components[1], ref[1])
+		h, ok3 := colorHue(components[2])
+		if !ok1 || !ok2 || !ok3 {
+			return color{}, false
+		}
+		lab := polarToRectangular(math.Max(0.0, math.Min(ref[0], l)), math.Max(0.0, c), h)
+		if name == "lch" {
+			xyz = labToXYZ(lab)
+		} else {
+			xyz = oklabToXYZ(lab)
+		}
+	case "color":
+		if len(components) != 4 || components[0].TokenType != css.IdentToken {
+			return color{}, false
+		}
+		v, ok := colorNumbers(components[1:], 1.0, 1.0, 1.0)
+		if !ok {
+			return color{}, false
+		}
+		switch string(parse.ToLower(parse.Copy(components[0].Data))) {
+		case "srgb":
+			xyz = srgbToXYZ(v)
+		case "srgb-linear":
+			xyz = linearSRGBToXYZ.mul(v)
+		case "display-p3":
+			xyz = linearP3ToXYZ.mul(mapChannels(v, srgbToLinear))
+		case "a98-rgb":
+			xyz = linearA98ToXYZ.mul(mapChannels(v, a98ToLinear))
+		case "prophoto-rgb":
+			xyz = xyzD50ToXYZ.mul(linearProPhotoToXYZD50.mul(mapChannels(v, proPhotoToLinear)))
+		case "rec2020":
+			xyz = linearRec2020ToXYZ.mul(mapChannels(v, rec2020ToLinear))
+		case "xyz", "xyz-d65":
+			xyz = v
+		case "xyz-d50":
+			xyz = xyzD50ToXYZ.mul(v)
+		default:
+			return color{}, false
+		}
+	default:
+		return color{}, false
+	}
+	return color{xyz, alpha}, true
+}
+
+// parseColorMix returns the color of color-mix() in a rectangular color space. Polar color spaces, which interpolate the hue, are not supported.
+func parseColorMix(args []Token) (color, bool) {
+	// split the arguments by commas
+	groups := [][]Token{{}}
+	for _, arg := range args {
+		if arg.TokenType == css.CommaToken {
+			groups = append(groups, []Token{})
+		} else if arg.TokenType != css.WhitespaceToken {
+			groups[len(groups)-1] = append(groups[len(groups)-1], arg)
+		}
+	}
+	if len(groups) != 3 || len(groups[0]) != 2 || groups[0][0].TokenType != css.IdentToken || !parse.EqualFold(groups[0][0].Data, []byte("in")) || groups[0][1].TokenType != css.IdentToken {
+		return color{}, false
+	}
+
+	var to func([3]float64) [3]float64
+	var from func([3]float64) [3]float64
+	switch string(parse.ToLower(parse.Copy(groups[0][1].Data))) {
+	case "srgb":
+		to, from = xyzToSRGB, srgbToXYZ
+	case "srgb-linear":
+		to, from = xyzToLinearSRGB.mul, linearSRGBToXYZ.mul
+	case "lab":
+		to, from = xyzToLab, labToXYZ
+	case "oklab":
+		to, from = xyzToOklab, oklabToXYZ
+	case "xyz", "xyz-d65":
+		to, from = func(v [3]float64) [3]float64 { return v }, func(v [3]float64) [3]float64 { return v }
+	case "xyz-d50":
+		to, from = xyzToXYZD50.mul, xyzD50ToXYZ.mul
+	default:
+		return color{}, false
+	}
+
+	colors := [2]color{}
+	percentages := [2]float64{-1.0, -1.0}
+	for i, group := range groups[1:] {
+		if len(group) == 2 && group[0].TokenType == css.PercentageToken {
+			group[0], group[1] = group[1], group[0]
+		}
+		if len(group) == 2 {
+			p, ok := colorNumber(group[1], 100.0)
+			if !ok || group[1].TokenType != css.PercentageToken || p < 0.0 || 100.0 < p {
+				return color{}, false
+			}
+			percentages[i] = p / 100.0
+		} else if len(group) != 1 {
+			return color{}, false
+		}
+		var ok bool
+		if colors[i], ok = parseColor(group[0]); !ok {
+			return color{}, false
+		}
+	}
+
+	// normalize the percentages
+	if percentages[0] < 0.0 && percentages[1] < 0.0 {
+		percentages = [2]float64{0.5, 0.5}
+	} else if percentages[0] < 0.0 {
+		percentages[0] = 1.0 - percentages[1]
+	} else if percentages[1] < 0.0 {
+		percentages[1] = 1.0 - percentages[0]
+	}
+	sum := percentages[0] + percentages[1]
+	if sum < minify.Epsilon {
+		return color{}, false
+	}
+	alphaMultiplier := math.Min(sum, 1.0)
+	percentages[0] /= sum
+	percentages[1] /= sum
+
+	// interpolate with premultiplied alpha
+	alpha := colors[0].alpha*percentages[0] + colors[1].alpha*percentages[1]
+	a, b := to(colors[0].xyz), to(colors[1].xyz)
+	mixed := [3]float64{}
+	for i := range mixed {
+		mixed[i] = a[i]*colors[0].alpha*percentages[0] + b[i]*colors[1].alpha*percentages[1]
+		if minify.Epsilon < alpha {
+			mixed[i] /= alpha
+		}
+	}
+	return color{from(mixed), alpha * alphaMultiplier}, true
+}
+
+// isColorFunction returns true if the function is a color function that is not minified otherwise.
+func isColorFunction(name []byte) bool {
+	switch string(parse.ToLower(parse.Copy(name[:len(name)-1]))) {
+	case "hwb", "lab", "lch", "oklab", "oklch", "color", "color-mix":
+		return true
+	}
+	return false
+}
+
+// isLegacyColorFunction returns true if the color function is a legacy sRGB color, which gradients interpolate in sRGB like hex and named colors.
+func isLegacyColorFunction(name []byte) bool {
+	return parse.EqualFold(name, []byte("hwb("))
+}
+
+// isGradientFunction returns true if the function is a gradient, such as linear-gradient( or -webkit-repeating-radial-gradient(.
+func isGradientFunction(name []byte) bool {
+	return bytes.HasSuffix(parse.ToLower(parse.Copy(name)), []byte("gradient("))
+}
+
+// interpolatesInSRGB returns true if the gradient arguments set the sRGB interpolation color space with "in srgb". By default, gradients with a stop color that is not a legacy sRGB color interpolate in Oklab.
+func interpolatesInSRGB(args []Token) bool {
+	for i, arg := range args {
+		if arg.TokenType == css.IdentToken && parse.EqualFold(arg.Data, []byte("in")) {
+			for _, space := range args[i+1:] {
+				if space.TokenType == css.IdentToken {
+					return parse.EqualFold(space.Data, []byte("srgb"))
+				} else if space.TokenType != css.WhitespaceToken {
+					break
+				}
+			}
+		}
+	}
+	return false
+}
+
+// minifyColorFunction evaluates a color function with literal arguments to the shortest equivalent color, if it is within the sRGB gamut.
+func (c *cssMinifier) minifyColorFunction(value Token) (Token, bool) {
+	col, ok := parseColor(value)
+	if !ok {
+		return value, false
+	}
+	rgb := xyzToSRGB(col.xyz)
+	for i := range rgb {
+		if rgb[i] < -gamutTolerance || 1.0+gamutTolerance < rgb[i] {
+			return value, false
+		}
+		rgb[i] = math.Max(0.0, math.Min(1.0, rgb[i]))
+	}
+
+	if 1.0-minify.Epsilon < col.alpha {
+		return rgbToToken(rgb[0], rgb[1], rgb[2]), true
+	} else if col.alpha < minify.Epsilon && rgb[0] < minify.Epsilon && rgb[1] < minify.Epsilon && rgb[2] < minify.Epsilon {
+		return Token{css.IdentToken, transparentBytes, nil, 0, Transparent}, true
+	} else if c.features&hexAlphaColors != 0 && 0 < len(c.o.Targets) {
+		return rgbaToToken(rgb[0], rgb[1], rgb[2], col.alpha), true
+	} else if c.o.KeepCSS2 {
+		return value, false
+	}
+
+	// use rgba() when shorter
+	args := []Token{}
+	for i, v := range append(rgb[:], col.alpha) {
+		if i != 0 {
+			args = append(args, Token{css.CommaToken, commaBytes, nil, 0, 0})
+		}
+		if i < 3 {
+			v = math.Floor(v*255.0 + 0.5)
+		}
+		num := minify.Number([]byte(strconv.FormatFloat(v, 'f', -1, 64)), c.o.Precision)
+		args = append(args, Token{css.NumberToken, num, nil, 0, 0})
+	}
+	rgba := Token{css.FunctionToken, []byte("rgba("), args, Rgba, 0}
+	if tokenLength(rgba) < tokenLength(value) {
+		return rgba, true
+	}
+	return value, false
+}
+
+// tokenLength returns the length of the token when written.
+func tokenLength(t Token) int {
+	n := len(t.Data)
+	if t.TokenType == css.FunctionToken {
+		for _, arg := range t.Args {
+			n += tokenLength(arg)
+		}
+		n++ // closing parenthesis
+	}
+	return n
+}
@@ -3,9 +3,12 @@ package css
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"math"
+	"path"
+	"regexp"
 	"strconv"
 
 	"github.com/tdewolff/minify/v2"
@@ -14,6 +17,9 @@ import (
 	strconvParse "github.com/tdewolff/parse/v2/strconv"
 )
 
+// ErrSourceMapOption is returned when an option that restructures the buffered stylesheet is combined with SourceMap.
+var ErrSourceMapOption = errors.New("option cannot be combined with a source map")
+
 var (
 	spaceBytes        = []byte(" ")
 	colonBytes        = []byte(":")
@@ -40,15 +46,35 @@ var (
 	repeatYBytes      = []byte("repeat-y")
 	importantBytes    = []byte("!important")
 	dataSchemeBytes   = []byte("data:")
+	sourceMapURLBytes = []byte("# sourceMappingURL=")
+	bannerStartBytes  = []byte("/*! ")
+	bannerEndBytes    = []byte(" */")
 )
 
 type cssMinifier struct {
 	m *minify.M
 	w io.Writer
-	p *css.Parser
+	p grammarParser
 	o *Minifier
 
 	tokenBuffer []Token
+
+	sourceMap    *minify.SourceMapWriter // set when generating a source map
+	sourceMapURL []byte                  // URL of the source map of the input
+
+	importer    *cssImporter  // set when inlining imports
+	filename    string        // URL of an inlined stylesheet relative to the input file
+	conditions  [][]css.Token // conditions of the @import rules that inlined the stylesheet
+	importsDone bool          // set after the first rule, after which @import rules are not inlined
+	err         error
+
+	inKeyframes bool // set within @keyframes, whose rulesets have keyframe selectors
+	inGradient  bool // set within gradients that do not interpolate in sRGB, whose stop colors must keep their color space
+
+	renames  renames // original names of the renamed classes and IDs
+	features feature // features supported by the targets
+
+	extracted bool // set after writing the banner of extracted comments
 }
 
 ////////////////////////////////////////////////////////////////
@@ -58,6 +84,43 @@ type Minifier struct {
 	KeepCSS2     bool
 	Precision    int // number of significant digits
 	newPrecision int // precision for new numbers
+
+	// Targets are the browsers that the output must support, see ParseTargets. When set, vendor-prefixed declarations and @keyframes rules are removed when followed by their unprefixed counterpart that all targets support, which buffers the stylesheet and is not applied when generating a source map. The targets also select the modern syntax that may be used, such as #rrggbbaa colors.
+	Targets Targets
+
+	// MergeRules merges adjacent rulesets with equal selectors, or with equal declarations when their selectors use only pseudo-classes and pseudo-elements that all browsers support, and removes declarations that are overridden within a ruleset. Adjacent conditional group rules such as @media with equal conditions are merged and empty ones are removed, as are empty descriptor rules such as @font-face and @font-face rules that are repeated later on. It buffers the stylesheet and cannot be combined with SourceMap.
+	MergeRules bool
+
+	// MergeShorthands replaces complete sets of longhand declarations in a ruleset by their shorthand when shorter, such as margin-top, margin-right, margin-bottom, and margin-left by margin. Font longhands are not merged, since the font shorthand also resets inherited properties such as font-size-adjust, font-kerning, and font-feature-settings, which differ between browsers. It buffers the stylesheet and cannot be combined with SourceMap.
+	MergeShorthands bool
+
+	// FlattenNesting moves nested style rules to the top level, replacing the nesting selector & by the selectors of the parent rule, for browsers that do not support nesting. Nesting is flattened as well when KeepCSS2 is set or when not all Targets support it. When all Targets support :is(), the parent selectors are wrapped in :is() unless repeating the nested selector for each parent selector matches the same elements with the same specificity. Otherwise, the nested selector is repeated for each combination of parent selectors, and an error is returned when that cannot be done such as for :not(&). The @nest rule of an earlier draft is treated as a nested style rule. Otherwise, nested rules are kept and their selectors and declarations are minified, where the rulesets containing nested rules are not merged. It is not applied when generating a source map.
+	FlattenNesting bool
+
+	// MaxLineLen starts a new line between declarations and after rules once the line is longer than the given number of bytes, for tools that cannot handle long lines.
+	MaxLineLen int
+
+	// SelectorUsage removes the selectors, and rulesets, that cannot match any element when set, such as by an HTMLUsage of the documents the stylesheet applies to. Selectors that match any of PurgeSafelist are kept. It buffers the stylesheet and cannot be combined with SourceMap.
+	SelectorUsage SelectorUsage
+	PurgeSafelist []*regexp.Regexp
+
+	// RenameMap renames classes and IDs in selectors, exact [href="#id"] attribute selectors, and url(#id) references, and custom properties and other dashed identifiers, to short names when set. Share it with the HTML minifier so that both use the same names. Attribute selectors that match classes or IDs partially, such as [class^=btn-], no longer match renamed names.
+	RenameMap *minify.RenameMap
+
+	// Comments selects the comments that are kept in place, except in inline styles. By default license comments starting with /*! are kept. Share it with the HTML and JS minifiers to handle comments consistently.
+	Comments *minify.Comments
+
+	// SourceMap receives a source map (revision 3) of the output when set. The minifier is not safe for concurrent use when set.
+	SourceMap       io.Writer
+	SourceMapSource string // name of the input file in the source map
+	SourceMapFile   string // name of the output file in the source map
+
+	// LoadSourceMap loads the source map referenced by a sourceMappingURL comment in the input, relative to the input file, so that it can be chained into the output source map. Source maps in data URIs are loaded directly. Returning nil skips chaining.
+	LoadSourceMap func(url string) ([]byte, error)
+
+	// ResolveImport loads the stylesheets of local @import rules, relative to the input file, so that they are inlined into the output. Imports with conditions are wrapped in @layer, @supports, and @media rules, and remaining @import rules are hoisted to the top. Relative URLs of inlined stylesheets are rewritten to be relative to the input file. Returning nil keeps the @import rule.
+	ResolveImport func(url string) ([]byte, error)
+	ImportSource  string // name of the input file, so that importing the input itself is detected as a circular import
 }
 
 // Minify minifies CSS data, it reads from r and writes to w.
@@ -118,30 +181,199 @@ func (t Token) IsLengthPercentage() bool {
 
 // Minify minifies CSS data, it reads from r and writes to w.
 func (o *Minifier) Minify(m *minify.M, w io.Writer, r io.Reader, params map[string]string) error {
-	o.newPrecision = o.Precision
-	if o.newPrecision <= 0 || 15 < o.newPrecision {
-		o.newPrecision = 15 // minimum number of digits a double can represent exactly
+	if o.SourceMap != nil {
+		if o.MergeRules {
+			return fmt.Errorf("MergeRules: %w", ErrSourceMapOption)
+		} else if o.MergeShorthands {
+			return fmt.Errorf("MergeShorthands: %w", ErrSourceMapOption)
+		} else if o.SelectorUsage != nil {
+			return fmt.Errorf("SelectorUsage: %w", ErrSourceMapOption)
+		}
 	}
 
 	z := parse.NewInput(r)
 	defer z.Restore()
 
+	var sourceMap *minify.SourceMapWriter
+	if o.SourceMap != nil {
+		sourceMap = minify.NewSourceMapWriter(w, &minify.SourceMap{File: o.SourceMapFile}, o.SourceMapSource, z.Bytes())
+		w = sourceMap
+	}
+
+	// the parser does not support nested style rules, which are either flattened or minified separately
 	isInline := params != nil && params["inline"] == "1"
+	input := z
+	var nesting []nestedRule
+	if sourceMap == nil && !isInline && hasNestedBlocks(z.Bytes()) {
+		if rules := parseNesting(z.Bytes()); hasNesting(rules) {
+			if o.flattensNesting() {
+				buf := &bytes.Buffer{}
+				if err := flattenNesting(buf, rules, o.features()); err != nil {
+					return err
+				}
+				input = parse.NewInputBytes(buf.Bytes())
+			} else {
+				nesting = rules
+			}
+		}
+	}
+
+	var p grammarParser = css.NewParser(input, isInline)
+	if nesting == nil && !isInline && o.Comments.MayKeep(input.Bytes()) {
+		p = newCommentParser(p, input.Bytes(), o.Comments.Keep)
+	}
+	return o.minify(m, w, p, isInline, sourceMap, nesting)
+}
+
+// flattensNesting returns true if nested style rules are moved to the top level, which is required when not all targets support nesting.
+func (o *Minifier) flattensNesting() bool {
+	return o.FlattenNesting || o.KeepCSS2 || 0 < len(o.Targets) && o.Targets.features()&nestedRules == 0
+}
+
+// features returns the features supported by the targets, which are none for KeepCSS2 and the default features when no targets are set.
+func (o *Minifier) features() feature {
+	if o.KeepCSS2 {
+		return 0
+	} else if 0 < len(o.Targets) {
+		return o.Targets.features()
+	}
+	return defaultFeatures
+}
+
+// minify minifies the grammar of the parser, writing to w, which is the source map writer when set.
+func (o *Minifier) minify(m *minify.M, w io.Writer, p grammarParser, isInline bool, sourceMap *minify.SourceMapWriter, nesting []nestedRule) error {
+	o.newPrecision = o.Precision
+	if o.newPrecision <= 0 || 15 < o.newPrecision {
+		o.newPrecision = 15 // minimum number of digits a double can represent exactly
+	}
+	if 0 < o.MaxLineLen {
+		if sourceMap != nil {
+			// the source map writer wraps the line writer so that it sees the inserted newlines
+			sourceMap.Writer = minify.NewLineWriter(sourceMap.Writer, o.MaxLineLen, ";}")
+		} else {
+			w = minify.NewLineWriter(w, o.MaxLineLen, ";}")
+		}
+	}
+
 	c := &cssMinifier{
 		m: m,
 		w: w,
-		p: css.NewParser(z, isInline),
+		p: p,
 		o: o,
+
+		sourceMap: sourceMap,
+	}
+	if o.RenameMap != nil {
+		c.renames = renames{map[string][]byte{}, map[string][]byte{}}
+	}
+	c.features = o.features()
+
+	if nesting != nil {
+		c.minifyNesting(nesting)
+		if _, err := w.Write(nil); err != nil {
+			return err
+		}
+		return c.err
+	}
+
+	// buffer the stylesheet for structural optimizations
+	var buffer *bytes.Buffer
+	if (o.MergeRules || o.MergeShorthands || o.SelectorUsage != nil || 0 < len(o.Targets)) && sourceMap == nil {
+		buffer = &bytes.Buffer{}
+		c.w = buffer
+	}
+	if o.ResolveImport != nil {
+		c.importer = &cssImporter{w: c.w}
+		if o.ImportSource != "" {
+			c.importer.stack = append(c.importer.stack, path.Base(o.ImportSource))
+		}
 	}
 	c.minifyGrammar()
 
+	if buffer != nil {
+		if rules, ok := parseRules(buffer.Bytes(), isInline); ok {
+			if o.SelectorUsage != nil {
+				usage := o.SelectorUsage
+				if o.RenameMap != nil {
+					usage = renamedUsage{usage, c.renames}
+				}
+				rules = purgeRules(usage, o.PurgeSafelist, rules)
+			}
+			if 0 < len(o.Targets) {
+				rules = removePrefixes(c.features, rules)
+			}
+			if o.MergeShorthands {
+				rules = c.mergeShorthands(rules)
+			}
+			if o.MergeRules {
+				rules = mergeRules(mergeAtRules(rules))
+				if isInline {
+					rules = removeOverridden(rules)
+				}
+			}
+			writeRules(w, rules)
+		} else {
+			w.Write(buffer.Bytes())
+		}
+	}
+
 	if _, err := w.Write(nil); err != nil {
 		return err
+	} else if c.err != nil {
+		return c.err
+	}
+	if c.p.Err() != io.EOF {
+		return c.p.Err()
+	}
+	if sourceMap != nil {
+		if err := c.chainSourceMap(); err != nil {
+			return err
+		}
+		if _, err := sourceMap.SourceMap.WriteTo(o.SourceMap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMapping maps the current output position to the position of b in the input when generating a source map.
+func (c *cssMinifier) addMapping(b []byte) {
+	if c.sourceMap != nil {
+		c.sourceMap.Map(b, nil)
 	}
-	if c.p.Err() == io.EOF {
+}
+
+// chainSourceMap chains the source map of the input, if any, into the output source map.
+func (c *cssMinifier) chainSourceMap() error {
+	if c.sourceMapURL == nil {
 		return nil
 	}
-	return c.p.Err()
+
+	var b []byte
+	url := string(c.sourceMapURL)
+	if bytes.HasPrefix(c.sourceMapURL, dataSchemeBytes) {
+		_, data, err := parse.DataURI(c.sourceMapURL)
+		if err != nil {
+			return err
+		}
+		b, url = data, ""
+	} else if c.o.LoadSourceMap != nil {
+		var err error
+		if b, err = c.o.LoadSourceMap(url); err != nil {
+			return err
+		}
+	}
+	if b == nil {
+		return nil
+	}
+
+	in, err := minify.ParseSourceMap(b)
+	if err != nil {
+		return err
+	}
+	in.Rebase(path.Join(path.Dir(c.o.SourceMapSource), path.Dir(url)))
+	c.sourceMap.Chain(c.sourceMap.AddSource(c.o.SourceMapSource), in)
+	return nil
 }
 
 func (c *cssMinifier) minifyGrammar() {
@@ -161,15 +393,24 @@ func (c *cssMinifier) minifyGrammar() {
 					vals = vals[:len(vals)-1]
 					semicolonQueued = true
 				}
+				if 0 < len(vals) {
+					c.addMapping(vals[0].Data)
+				}
 				for _, val := range vals {
 					c.w.Write(val.Data)
 				}
 				continue
 			}
+			if c.importer != nil {
+				c.finishImports(semicolonQueued)
+			}
 			return
 		case css.EndAtRuleGrammar, css.EndRulesetGrammar:
 			c.w.Write(rightBracketBytes)
 			semicolonQueued = false
+			if gt == css.EndAtRuleGrammar {
+				c.inKeyframes = false
+			}
 			continue
 		}
 
@@ -177,62 +418,110 @@ func (c *cssMinifier) minifyGrammar() {
 			c.w.Write(semicolonBytes)
 			semicolonQueued = false
 		}
+		if c.importer != nil && !c.importsDone {
+			if !isImportPrelude(gt, data) {
+				c.finishImports(false)
+			} else if gt == css.AtRuleGrammar && c.filename != "" && ToHash(data[1:]) == Charset {
+				continue // only the input can specify its character encoding
+			} else if gt == css.AtRuleGrammar && ToHash(data[1:]) == Import {
+				if inlined, err := c.inlineImport(data, c.p.Values()); err != nil {
+					c.err = err
+					return
+				} else if inlined {
+					continue
+				}
+			}
+		}
 
 		switch gt {
 		case css.AtRuleGrammar:
+			c.addMapping(data)
 			c.w.Write(data)
 			values := c.p.Values()
-			if ToHash(data[1:]) == Import && len(values) == 2 && values[1].TokenType == css.URLToken {
-				url := values[1].Data
-				if url[4] != '"' && url[4] != '\'' {
-					a := 4
-					for parse.IsWhitespace(url[a]) || parse.IsNewline(url[a]) {
-						a++
-					}
-					b := len(url) - 2
-					for parse.IsWhitespace(url[b]) || parse.IsNewline(url[b]) {
-						b--
-					}
-					url = url[a-1 : b+2]
-					url[0] = '"'
-					url[len(url)-1] = '"'
-				} else {
-					url = url[4 : len(url)-1]
-				}
-				values[1].Data = url
+			if ToHash(data[1:]) == Import && len(values) == 2 {
+				minifyImportURL(values)
 			}
 			for _, val := range values {
 				c.w.Write(val.Data)
 			}
 			semicolonQueued = true
 		case css.BeginAtRuleGrammar:
+			c.addMapping(data)
 			c.w.Write(data)
-			for _, val := range c.p.Values() {
+			values := c.p.Values()
+			if c.o.RenameMap != nil && isPropertyRule(data) {
+				c.renameDashedIdents(values)
+			}
+			if isConditionRule(data) {
+				values = c.minifyCondition(values)
+				if n := hasAllMedia(values); 0 < n && !c.o.KeepCSS2 && parse.EqualFold(data[1:], []byte("media")) {
+					values = values[n:]
+				}
+			}
+			c.inKeyframes = bytes.HasSuffix(parse.ToLower(parse.Copy(data)), keyframesBytes)
+			for _, val := range values {
 				c.w.Write(val.Data)
 			}
 			c.w.Write(leftBracketBytes)
 		case css.QualifiedRuleGrammar:
-			c.minifySelectors(data, c.p.Values())
+			if values := c.p.Values(); 0 < len(values) {
+				c.addMapping(values[0].Data)
+			}
+			if c.inKeyframes {
+				c.minifyKeyframeSelectors(c.p.Values())
+			} else {
+				c.minifySelectors(data, c.p.Values())
+			}
 			c.w.Write(commaBytes)
 		case css.BeginRulesetGrammar:
-			c.minifySelectors(data, c.p.Values())
+			if values := c.p.Values(); 0 < len(values) {
+				c.addMapping(values[0].Data)
+			}
+			if c.inKeyframes {
+				c.minifyKeyframeSelectors(c.p.Values())
+			} else {
+				c.minifySelectors(data, c.p.Values())
+			}
 			c.w.Write(leftBracketBytes)
 		case css.DeclarationGrammar:
+			c.addMapping(data)
+			if c.o.RenameMap != nil {
+				c.renameDashedIdents(c.p.Values())
+			}
 			c.minifyDeclaration(data, c.p.Values())
 			semicolonQueued = true
 		case css.CustomPropertyGrammar:
-			c.w.Write(data)
-			c.w.Write(colonBytes)
+			c.addMapping(data)
 			value := parse.TrimWhitespace(c.p.Values()[0].Data)
 			if len(c.p.Values()[0].Data) != 0 && len(value) == 0 {
 				value = spaceBytes
+			} else if c.o.RenameMap != nil {
+				value = c.renameCustomPropertyValue(value)
+			}
+			if c.o.RenameMap != nil {
+				data = c.o.RenameMap.CustomProperty(data)
 			}
+			c.w.Write(data)
+			c.w.Write(colonBytes)
 			c.w.Write(value)
 			semicolonQueued = true
 		case css.CommentGrammar:
-			if len(data) > 5 && data[1] == '*' && data[2] == '!' {
-				c.w.Write(data[:3])
-				comment := parse.TrimWhitespace(parse.ReplaceMultipleWhitespace(data[3 : len(data)-2]))
+			if c.sourceMap != nil && bytes.HasPrefix(data[2:], sourceMapURLBytes) {
+				c.sourceMapURL = parse.TrimWhitespace(data[2+len(sourceMapURLBytes) : len(data)-2])
+			} else if c.o.Comments.Extract(data) {
+				if !c.extracted {
+					c.w.Write(bannerStartBytes)
+					c.w.Write(c.o.Comments.Banner())
+					c.w.Write(bannerEndBytes)
+					c.extracted = true
+				}
+			} else if c.o.Comments.Keep(data) {
+				n := 2
+				if data[2] == '!' {
+					n = 3
+				}
+				c.w.Write(data[:n])
+				comment := parse.TrimWhitespace(parse.ReplaceMultipleWhitespace(data[n : len(data)-2]))
 				c.w.Write(comment)
 				c.w.Write(data[len(data)-2:])
 			}
@@ -245,19 +534,38 @@ func (c *cssMinifier) minifyGrammar() {
 func (c *cssMinifier) minifySelectors(property []byte, values []css.Token) {
 	inAttr := false
 	isClass := false
+	var attrName []byte     // name of the attribute selector
+	match := css.ErrorToken // matcher of the attribute selector
 	for _, val := range c.p.Values() {
 		if !inAttr {
 			if val.TokenType == css.IdentToken {
-				if !isClass {
+				if isClass && c.o.RenameMap != nil {
+					val.Data = c.renameClass(val.Data)
+				} else if !isClass {
 					parse.ToLower(val.Data)
 				}
 				isClass = false
 			} else if val.TokenType == css.DelimToken && val.Data[0] == '.' {
 				isClass = true
+			} else if val.TokenType == css.HashToken && c.o.RenameMap != nil {
+				c.w.Write(val.Data[:1])
+				val.Data = c.renameID(val.Data[1:])
 			} else if val.TokenType == css.LeftBracketToken {
 				inAttr = true
+				attrName, match = nil, css.ErrorToken
 			}
 		} else {
+			if c.o.RenameMap != nil && attrName != nil && match != css.ErrorToken && (val.TokenType == css.IdentToken || val.TokenType == css.StringToken) {
+				s := val.Data
+				if val.TokenType == css.StringToken {
+					s = s[1 : len(s)-1]
+				}
+				if rename := c.renameAttributeSelector(attrName, match, s); rename != nil {
+					c.w.Write(rename)
+					attrName = nil
+					continue
+				}
+			}
 			if val.TokenType == css.StringToken && len(val.Data) > 2 {
 				s := val.Data[1 : len(val.Data)-1]
 				if css.IsIdent(s) {
@@ -266,8 +574,12 @@ func (c *cssMinifier) minifySelectors(property []byte, values []css.Token) {
 				}
 			} else if val.TokenType == css.RightBracketToken {
 				inAttr = false
+			} else if val.TokenType == css.IdentToken && attrName == nil && match == css.ErrorToken {
+				attrName = val.Data
 			} else if val.TokenType == css.IdentToken && len(val.Data) == 1 && (val.Data[0] == 'i' || val.Data[0] == 'I') {
 				c.w.Write(spaceBytes)
+			} else if val.TokenType == css.DelimToken && val.Data[0] == '=' || css.IncludeMatchToken <= val.TokenType && val.TokenType <= css.SubstringMatchToken {
+				match = val.TokenType
 			}
 		}
 		c.w.Write(val.Data)
@@ -435,6 +747,16 @@ func (c *cssMinifier) writeDeclaration(values []Token, important bool) {
 
 func (c *cssMinifier) minifyTokens(prop Hash, values []Token) []Token {
 	for i, value := range values {
+		if fun := value.Fun; value.TokenType == css.FunctionToken && (fun == Calc || fun == Min || fun == Max || fun == Clamp) {
+			var ok bool
+			if value, ok = c.minifyMath(prop, value); ok {
+				values[i] = value
+				if value.TokenType == css.FunctionToken {
+					continue // arguments are minified already
+				}
+			}
+		}
+
 		tt := value.TokenType
 		switch tt {
 		case css.NumberToken:
@@ -464,7 +786,7 @@ func (c *cssMinifier) minifyTokens(prop Hash, values []Token) []Token {
 		case css.StringToken:
 			values[i].Data = removeMarkupNewlines(values[i].Data)
 		case css.URLToken:
-			if 10 < len(values[i].Data) {
+			if 10 < len(values[i].Data) || 5 < len(values[i].Data) && (c.filename != "" || c.o.RenameMap != nil) {
 				uri := parse.TrimWhitespace(values[i].Data[4 : len(values[i].Data)-1])
 				delim := byte('"')
 				if 1 < len(uri) && (uri[0] == '\'' || uri[0] == '"') {
@@ -472,6 +794,10 @@ func (c *cssMinifier) minifyTokens(prop Hash, values []Token) []Token {
 					uri = removeMarkupNewlines(uri)
 					uri = uri[1 : len(uri)-1]
 				}
+				uri = c.rebaseURL(uri)
+				if c.o.RenameMap != nil && 1 < len(uri) && uri[0] == '#' {
+					uri = append([]byte{'#'}, c.renameID(uri[1:])...)
+				}
 				if 4 < len(uri) && parse.EqualFold(uri[:5], dataSchemeBytes) {
 					uri = minify.DataURI(c.m, uri)
 				}
@@ -482,7 +808,17 @@ func (c *cssMinifier) minifyTokens(prop Hash, values []Token) []Token {
 				}
 			}
 		case css.FunctionToken:
+			inGradient := c.inGradient
+			if isGradientFunction(values[i].Data) {
+				c.inGradient = !interpolatesInSRGB(values[i].Args)
+			}
 			values[i].Args = c.minifyTokens(prop, values[i].Args)
+			c.inGradient = inGradient
+
+			if isColorFunction(values[i].Data) && (!c.inGradient || isLegacyColorFunction(values[i].Data)) {
+				values[i], _ = c.minifyColorFunction(values[i])
+				continue
+			}
 
 			fun := values[i].Fun
 			args := values[i].Args
@@ -568,6 +904,22 @@ func (c *cssMinifier) minifyTokens(prop Hash, values []Token) []Token {
 					}
 				} else if len(vals) == 4 {
 					args[6] = minifyNumberPercentage(args[6])
+
+					// only use #rrggbbaa when targeted explicitly, as it rounds the alpha channel to 8 bits
+					if c.features&hexAlphaColors != 0 && 0 < len(c.o.Targets) {
+						if r, g, b, ok := colorChannels(fun, args, vals); ok {
+							values[i] = rgbaToToken(r, g, b, a)
+							break
+						}
+					}
+					if c.features&spaceSeparatedColors != 0 {
+						if fun == Rgba || fun == Hsla {
+							values[i].Data = append(values[i].Data[:len(values[i].Data)-2:len(values[i].Data)-2], '(')
+						}
+						args[1] = Token{css.WhitespaceToken, spaceBytes, nil, 0, 0}
+						args[3] = Token{css.WhitespaceToken, spaceBytes, nil, 0, 0}
+						args[5] = Token{css.DelimToken, []byte("/"), nil, 0, 0}
+					}
 				}
 
 				if 3 <= len(vals) && (fun == Rgb || fun == Rgba) {
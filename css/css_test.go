@@ -2,8 +2,11 @@ package css
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/tdewolff/minify/v2"
@@ -38,6 +41,13 @@ func TestCSS(t *testing.T) {
 		{"@MEDIA all{}", "@media all{}"},
 		{"@media only screen and (max-width : 800px){}", "@media only screen and (max-width:800px){}"},
 		{"@media (-webkit-min-device-pixel-ratio:1.5),(min-resolution:1.5dppx){}", "@media(-webkit-min-device-pixel-ratio:1.5),(min-resolution:1.5dppx){}"},
+		{"@media screen and (min-width : 768.0px) , print and (max-width: 0.50em){}", "@media screen and (min-width:768px),print and (max-width:.5em){}"},
+		{"@media (width >= 600px) and (aspect-ratio: 16 / 9){}", "@media(width>=600px) and (aspect-ratio:16/9){}"},
+		{"@media all and (min-width: 10px){}", "@media(min-width:10px){}"},
+		{"@media all and (min-width: 10px), print{}", "@media all and (min-width:10px),print{}"},
+		{"@supports (width: 10.0px){}", "@supports(width:10px){}"},
+		{"@keyframes x{from{a:b}TO{a:c}50.00%, 100.0%{a:d}}", "@keyframes x{0%{a:b}to{a:c}50%,to{a:d}}"},
+		{"@keyframes x{0%{a:b}}a{0%{}}", "@keyframes x{0%{a:b}}a{0%{}}"},
 		{"[class^=icon-] i[class^=icon-],i[class*=\" icon-\"]{x:y}", "[class^=icon-] i[class^=icon-],i[class*=\" icon-\"]{x:y}"},
 		{"html{line-height:1;}html{line-height:1;}", "html{line-height:1}html{line-height:1}"},
 		{"a { b: 1", "a{b:1}"},
@@ -132,6 +142,25 @@ func TestCSSInline(t *testing.T) {
 		{"color: hsla(0 100% 50% / 1);", "color:red"},
 		{"color: hsla(0 100% 50% / 60%);", "color:hsla(0 100% 50%/.6)"},
 		{"color: hsla(400, 150%, 150%, 2);", "color:#fff"},
+		{"color: hwb(0 0% 0%);", "color:red"},
+		{"color: hwb(120deg 100% 100%);", "color:gray"},
+		{"color: lab(54.29% 80.82 69.88);", "color:red"},
+		{"color: lch(0% 0 0 / 0);", "color:transparent"},
+		{"color: oklab(1 0 0);", "color:#fff"},
+		{"color: oklab(0.5 0.1 0.1 / 0.5);", "color:rgba(161,66,3,.5)"},
+		{"color: oklch(62.8% 0.2577 29.23);", "color:red"},
+		{"color: color(srgb 1 0 0);", "color:red"},
+		{"color: color(xyz-d50 0.9642 1 0.8251);", "color:#fff"},
+		{"color: color(display-p3 1 0 0);", "color:color(display-p3 1 0 0)"},
+		{"color: lch(50% var(--c) 10);", "color:lch(50% var(--c) 10)"},
+		{"color: color-mix(in srgb, red, blue);", "color:purple"},
+		{"color: color-mix(in srgb, red 30%, blue 20%);", "color:rgba(153,0,102,.5)"},
+		{"color: color-mix(in oklab, white, black);", "color:#636363"},
+		{"color: color-mix(in lch, red, blue);", "color:color-mix(in lch,red,blue)"},
+		{"background: linear-gradient(oklch(62.8% 0.2577 29.23), blue);", "background:linear-gradient(oklch(62.8% .2577 29.23),blue)"},
+		{"background: radial-gradient(lab(54.29% 80.82 69.88) 10%, hwb(240 0% 0%));", "background:radial-gradient(lab(54.29% 80.82 69.88) 10%,#00f)"},
+		{"background: linear-gradient(to right in srgb, oklch(62.8% 0.2577 29.23), blue);", "background:linear-gradient(to right in srgb,red,blue)"},
+		{"background: linear-gradient(in oklab, oklch(62.8% 0.2577 29.23), blue);", "background:linear-gradient(in oklab,oklch(62.8% .2577 29.23),blue)"},
 		//{"color: hwb(0 0% 0%);", "color:red"}, TODO
 		//{"color: hwb(120 20% 20%/50%);", "color:"}, TODO
 		{"background-color:transparent", "background-color:initial"},
@@ -306,7 +335,7 @@ func TestCSSInline(t *testing.T) {
 		{"background:url(url) TOP RIGHT REPEAT-Y", "background:url(url)100% 0 REPEAT-Y"},
 		{"background:url(url)TOP RIGHT REPEAT-Y", "background:url(url)100% 0 REPEAT-Y"},
 
-		{"margin:calc(10px) calc(20px)", "margin:calc(10px)calc(20px)"},
+		{"margin:calc(10px) calc(20px)", "margin:10px 20px"},
 		{"border-left:0 none", "border-left:0"},
 		{"--custom-variable:0px;", "--custom-variable:0px"},
 		{"--foo: 0px ;", "--foo:0px"},
@@ -319,21 +348,58 @@ func TestCSSInline(t *testing.T) {
 
 		// TODO: functions
 		{"width:calc(0%-0px)", "width:calc(0%0)"}, // invalid
-		{"width:calc(0% - 0px)", "width:calc(0% - 0)"},
-		{"width:calc(calc(0% - 0px) + 1em)", "width:calc(calc(0% - 0) + 1em)"},
-		//{"width:calc(5px);", "width:5px"},
-		//{"width:calc(5px - 3px);", "width:2px"},
-		//{"width:calc(5px + -3px);", "width:2px"},
-		//{"width:calc(5px - 3%);", "width:calc(5px - 3%)"},
-		//{"width:calc(2*5px);", "width:10px"},
-		//{"width:calc(10px/2);", "width:5px"},
-		//{"width:calc(calc(5px));", "width:5px"},
-		//{"width:calc(calc(5px - 1em)*3);", "width:calc((5px - 1em)*3)"},
-		//{"width:calc(calc(5px - 1em) - 3%);", "width:calc(5px - 1em - 3%)"},
-		//{"width:calc(3% - calc(5px - 1em));", "width:calc(3% - 5px + 1em)"},
-		//{"width:calc(5px-3px);", "width:calc(5px-3px)"}, // invalid
-		//{"width:calc(5px*3px);", "width:calc(5px*3px)"}, // invalid
-		//{"width:calc(5px/3px);", "width:calc(5px/3px)"}, // invalid
+		{"width:calc(0% - 0px)", "width:0%"},
+		{"width:calc(100% - 0px)", "width:100%"},
+		{"width:calc(pi*1px - 0px)", "width:calc(pi*1px - 0px)"},
+		{"width:calc(calc(0% - 0px) + 1em)", "width:calc(0% + 1em)"},
+		{"width:calc(5px);", "width:5px"},
+		{"width:calc(5px - 3px);", "width:2px"},
+		{"width:calc(5px + -3px);", "width:2px"},
+		{"width:calc(5px - 3%);", "width:calc(5px - 3%)"},
+		{"width:calc(2*5px);", "width:10px"},
+		{"width:calc(10px/2);", "width:5px"},
+		{"width:calc(calc(5px));", "width:5px"},
+		{"width:calc(calc(5px - 1em)*3);", "width:calc((5px - 1em)*3)"},
+		{"width:calc(calc(5px - 1em) - 3%);", "width:calc(5px - 1em - 3%)"},
+		{"width:calc(3% - calc(5px - 1em));", "width:calc(3% - 5px + 1em)"},
+		{"width:calc(5px-3px);", "width:calc(5px-3px)"}, // invalid
+		{"width:calc(5px*3px);", "width:calc(5px*3px)"}, // invalid
+		{"width:calc(5px/3px);", "width:calc(5px/3px)"}, // invalid
+		{"width:calc(1in + 4px);", "width:100px"},
+		{"width:calc(2 * (10px + 5px));", "width:30px"},
+		{"width:calc( 100%  -  2 * 10px );", "width:calc(100% - 20px)"},
+		{"width:calc(100% - 10px - 10px);", "width:calc(100% - 20px)"},
+		{"width:calc(5px - 1em + 0px);", "width:calc(5px - 1em)"},
+		{"width:calc(1px + 2);", "width:calc(1px + 2)"}, // invalid
+		{"width:calc(10px/0);", "width:calc(10px/0)"},   // invalid
+		{"margin:calc(-5px);", "margin:calc(-5px)"},
+		{"width:calc(var(--x) * 2);", "width:calc(var(--x)*2)"},
+		{"width:calc(10px - calc(var(--x) + 1px));", "width:calc(10px - (var(--x) + 1px))"},
+		{"width:calc(var(--x) + 1px + 2px);", "width:calc(var(--x) + 1px + 2px)"},
+		{"width:calc(var(--x));", "width:calc(var(--x))"},
+		{"width:calc(pi * 2 * 1px);", "width:calc(pi*2px)"},
+		{"width:min(10px, 2em, 5px);", "width:min(5px,2em)"},
+		{"width:max(1in, 90px);", "width:1in"},
+		{"width:min(50%, 100px);", "width:min(50%,100px)"},
+		{"width:min(var(--x));", "width:min(var(--x))"},
+		{"width:min(var(--x), 10px, 20px);", "width:min(var(--x),10px)"},
+		{"width:clamp(10px, 5px, 20px);", "width:10px"},
+		{"width:clamp(10px, 50%, 20px);", "width:clamp(10px,50%,20px)"},
+		{"width:calc(min(10px, 20px) + 1em);", "width:calc(10px + 1em)"},
+		{"width:calc(max(10px, 1em));", "width:max(10px,1em)"},
+		{"z-index:calc(3 / 2);", "z-index:calc(1.5)"},
+		{"transition-delay:calc(1s + 500ms);", "transition-delay:1.5s"},
+		{"transition-delay:calc(1s + 100ms);", "transition-delay:1.1s"},
+		{"transition-delay:calc(1s + 1ms);", "transition-delay:1001ms"},
+		{"transform:rotate(calc(1turn + 90deg));", "transform:rotate(450deg)"},
+		{"transform:rotate(calc(1deg + .5turn));", "transform:rotate(181deg)"},
+		{"width:calc(1in + 1pt);", "width:73pt"},
+		{"aspect-ratio:calc(16/9);", "aspect-ratio:calc(16/9)"},
+		{"width:calc(1/3*10px);", "width:calc(1/3*10px)"},
+		{"width:calc(1cm + 1px);", "width:calc(1cm + 1px)"},
+		{"width:calc(0.1px + 0.2px);", "width:.3px"},
+		{"line-height:calc(1 / 8);", "line-height:calc(1/8)"},
+		{"grid-template-columns:calc(1fr + 1fr);", "grid-template-columns:calc(1fr + 1fr)"},
 
 		// TODO: dimensions
 		//{"any:0deg 0s 0ms 0dpi 0dpcm 0dppx 0hz 0khz", "any:0 0s 0s 0dpi 0dpi 0dpi 0hz 0hz"},
@@ -424,6 +490,684 @@ func TestCSSKeepCSS2(t *testing.T) {
 	}
 }
 
+func TestCSSSourceMap(t *testing.T) {
+	tests := []struct {
+		css       string
+		expected  string
+		sourceMap string
+	}{
+		{"a {\n  color: red;\n}\n@media screen {\n  b { margin: 0px }\n}", "a{color:red}@media screen{b{margin:0}}", `{"version":3,"file":"out.css","sources":["src/in.css"],"names":[],"mappings":"AAAA,EACE,UAEF,cACE,EAAI"}`},
+		{"a,b{--x: 1}@import 'c.css';", "a,b{--x:1}@import 'c.css'", `{"version":3,"file":"out.css","sources":["src/in.css"],"names":[],"mappings":"AAAA,EAAE,EAAE,MAAO"}`},
+		{"a{color:red}\n/*# sourceMappingURL=data:application/json;base64,eyJ2ZXJzaW9uIjozLCJzb3VyY2VzIjpbImEuc2NzcyJdLCJuYW1lcyI6W10sIm1hcHBpbmdzIjoiQUFBQSxFQUFFIn0= */", "a{color:red}", `{"version":3,"file":"out.css","sources":["src/a.scss"],"names":[],"mappings":"AAAA,EAAE"}`},
+		{"a{color:red}\n/*# sourceMappingURL=maps/in.css.map */", "a{color:red}", `{"version":3,"file":"out.css","sources":["src/scss/a.scss"],"names":[],"mappings":"AAAA,EAAA"}`},
+	}
+
+	m := minify.New()
+	for _, tt := range tests {
+		t.Run(tt.css, func(t *testing.T) {
+			sourceMap := &bytes.Buffer{}
+			cssMinifier := &Minifier{
+				SourceMap:       sourceMap,
+				SourceMapSource: "src/in.css",
+				SourceMapFile:   "out.css",
+				LoadSourceMap: func(url string) ([]byte, error) {
+					test.String(t, url, "maps/in.css.map")
+					return []byte(`{"version":3,"sourceRoot":"../scss","sources":["a.scss"],"names":[],"mappings":"AAAA"}`), nil
+				},
+			}
+			r := bytes.NewBufferString(tt.css)
+			w := &bytes.Buffer{}
+			err := cssMinifier.Minify(m, w, r, nil)
+			test.Minify(t, tt.css, err, w.String(), tt.expected)
+			test.String(t, sourceMap.String(), tt.sourceMap)
+		})
+	}
+}
+
+func TestCSSSourceMapMaxLineLen(t *testing.T) {
+	tests := []struct {
+		css      string
+		expected string
+	}{
+		{".alpha { color: red }\n.b { top: 0 }", ".alpha{color:red}\n.b{top:0}"},
+		{"a { color: red; margin: 0 }\nb { top: 0 }", "a{color:red;\nmargin:0}b{top:0}"},
+	}
+
+	m := minify.New()
+	for _, tt := range tests {
+		t.Run(tt.css, func(t *testing.T) {
+			sourceMap := &bytes.Buffer{}
+			o := &Minifier{MaxLineLen: 10, SourceMap: sourceMap}
+			r := bytes.NewBufferString(tt.css)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.css, err, w.String(), tt.expected)
+
+			// every mapping must point at the same token in the output and the input
+			sm, err := minify.ParseSourceMap(sourceMap.Bytes())
+			test.Error(t, err)
+			genLines := strings.Split(w.String(), "\n")
+			lines := strings.Split(tt.css, "\n")
+			for _, mapping := range sm.Mappings {
+				if mapping.GenLine < len(genLines) && mapping.GenCol < len(genLines[mapping.GenLine]) {
+					test.T(t, genLines[mapping.GenLine][mapping.GenCol], lines[mapping.Line][mapping.Col], fmt.Sprintf("mapping %v", mapping))
+				} else {
+					t.Errorf("mapping %v outside of output", mapping)
+				}
+			}
+		})
+	}
+}
+
+func TestCSSMergeRules(t *testing.T) {
+	tests := []struct {
+		css      string
+		expected string
+	}{
+		{`a{color:red}a{margin:0}`, `a{color:red;margin:0}`},
+		{`.x{color:red}.y{color:red}`, `.x,.y{color:red}`},
+		{`.x{color:red}.x,.y{color:red}`, `.x,.y{color:red}`},
+		{`.x{color:red}.y{color:blue}.x{color:red}`, `.x{color:red}.y{color:blue}.x{color:red}`},
+		{`a{color:red;color:blue}`, `a{color:blue}`},
+		{`a{color:red!important;color:blue}`, `a{color:red!important}`},
+		{`a{color:red!important;color:blue!important}`, `a{color:blue!important}`},
+		{`a{color:red}a{color:blue}`, `a{color:blue}`},
+		{`a{--x:1;--x:calc(2px)}`, `a{--x:calc(2px)}`},
+		{`a{font-size:16px;font-size:1rem}`, `a{font-size:16px;font-size:1rem}`},
+		{`a{content:"";content:none}`, `a{content:"";content:none}`},
+		{`a{display:-webkit-box;display:flex}`, `a{display:-webkit-box;display:flex}`},
+		{`a{color:red;color:rgb(0 0 0/50%)}`, `a{color:red;color:rgb(0 0 0/.5)}`},
+		{`a{width:10px;width:20px\9}`, `a{width:10px;width:20px\9}`},
+		{`.x{color:red}.y::-moz-selection{color:red}`, `.x{color:red}.y::-moz-selection{color:red}`},
+		{`a{color:red}b:focus-visible{color:red}`, `a{color:red}b:focus-visible{color:red}`},
+		{`a{color:red}b:is(.x,.y){color:red}`, `a{color:red}b:is(.x,.y){color:red}`},
+		{`a{color:red}b:not(:focus-visible){color:red}`, `a{color:red}b:not(:focus-visible){color:red}`},
+		{`a:hover{color:red}b::before{color:red}c:NTH-CHILD(2n){color:red}`, `a:hover,b::before,c:NTH-CHILD(2n){color:red}`},
+		{`a[title="x:y"]{color:red}.sm\:flex{color:red}`, `a[title="x:y"],.sm\:flex{color:red}`},
+		{`@media screen{a{color:red}a{margin:0}}`, `@media screen{a{color:red;margin:0}}`},
+		{`@keyframes x{0%{color:red}0%{margin:0}}`, `@keyframes x{0%{color:red}0%{margin:0}}`},
+		{`@font-face{src:url(a.woff);src:url(b.woff)}`, `@font-face{src:url(a.woff);src:url(b.woff)}`},
+		{`@import "a.css";a{color:red}/*! comment */a{color:blue}`, `@import "a.css";a{color:red}/*!comment*/a{color:blue}`},
+		{`@page{margin:0;@top-left{content:"x"}}a{}`, `@page{margin:0;@top-left{content:"x"}}a{}`},
+		{`@media print{a{color:red}}@media print{a{margin:0}}`, `@media print{a{color:red;margin:0}}`},
+		{`@media print{a{color:red}}b{color:red}@media print{a{margin:0}}`, `@media print{a{color:red}}b{color:red}@media print{a{margin:0}}`},
+		{`@media print{}@supports(display:grid){@media print{}}a{color:red}`, `a{color:red}`},
+		{`@layer x{}@keyframes y{}`, `@layer x{}@keyframes y{}`},
+		{`@font-face{}@page{}@media print{@font-face{}}@font-feature-values x{}a{color:red}`, `a{color:red}`},
+		{`@font-face{font-family:x}@counter-style x{}@property --x{}`, `@font-face{font-family:x}@counter-style x{}@property --x{}`},
+		{`@font-face{font-family:x;src:url(a.woff)}@font-face{font-family:y;src:url(b.woff)}@font-face{font-family:x;src:url(a.woff)}`, `@font-face{font-family:y;src:url(b.woff)}@font-face{font-family:x;src:url(a.woff)}`},
+	}
+
+	m := minify.New()
+	cssMinifier := &Minifier{MergeRules: true}
+	for _, tt := range tests {
+		t.Run(tt.css, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.css)
+			w := &bytes.Buffer{}
+			err := cssMinifier.Minify(m, w, r, nil)
+			test.Minify(t, tt.css, err, w.String(), tt.expected)
+		})
+	}
+
+	// inline style attributes
+	inline := `color:red;margin:0;color:blue`
+	w := &bytes.Buffer{}
+	err := cssMinifier.Minify(m, w, bytes.NewBufferString(inline), map[string]string{"inline": "1"})
+	test.Minify(t, inline, err, w.String(), `margin:0;color:blue`)
+
+	// source maps
+	sourceMapMinifier := &Minifier{MergeRules: true, SourceMap: &bytes.Buffer{}}
+	err = sourceMapMinifier.Minify(m, &bytes.Buffer{}, bytes.NewBufferString(`a{color:red}`), nil)
+	test.T(t, errors.Is(err, ErrSourceMapOption), true, "must give error for MergeRules with SourceMap")
+}
+
+func TestCSSMergeShorthands(t *testing.T) {
+	tests := []struct {
+		css      string
+		expected string
+	}{
+		{`a{margin-top:0;margin-right:0;margin-bottom:0;margin-left:0}`, `a{margin:0}`},
+		{`a{padding-top:1px;color:red;padding-right:2px;padding-bottom:1px;padding-left:2px}`, `a{padding:1px 2px;color:red}`},
+		{`a{padding-top:1px;padding-right:2px;padding-bottom:3px;padding-left:2px}`, `a{padding:1px 2px 3px}`},
+		{`a{padding-top:1px;padding-right:2px;padding-bottom:3px}`, `a{padding-top:1px;padding-right:2px;padding-bottom:3px}`},
+		{`a{margin-top:0;margin-right:0;margin-bottom:0;margin-left:0;margin-inline:3px}`, `a{margin-top:0;margin-right:0;margin-bottom:0;margin-left:0;margin-inline:3px}`},
+		{`a{margin-top:0;margin-right:0;margin-bottom:0;margin-left:0;margin-top:1px}`, `a{margin-top:0;margin-right:0;margin-bottom:0;margin-left:0;margin-top:1px}`},
+		{`a{margin-top:0!important;margin-right:0;margin-bottom:0;margin-left:0}`, `a{margin-top:0!important;margin-right:0;margin-bottom:0;margin-left:0}`},
+		{`a{margin-top:0!important;margin-right:0!important;margin-bottom:0!important;margin-left:0!important}`, `a{margin:0!important}`},
+		{`a{margin-top:var(--x);margin-right:0;margin-bottom:0;margin-left:0}`, `a{margin-top:var(--x);margin-right:0;margin-bottom:0;margin-left:0}`},
+		{`a{margin-top:inherit;margin-right:0;margin-bottom:0;margin-left:0}`, `a{margin-top:inherit;margin-right:0;margin-bottom:0;margin-left:0}`},
+		{`a{top:0;right:0;bottom:0;left:0}`, `a{top:0;right:0;bottom:0;left:0}`}, // inset requires targets
+		{`a{border-top-width:1px;border-right-width:1px;border-bottom-width:1px;border-left-width:1px;border-radius:2px}`, `a{border-width:1px;border-radius:2px}`},
+		{`a{border-top-style:solid;border-right-style:solid;border-bottom-style:dashed;border-left-style:solid}`, `a{border-style:solid solid dashed}`},
+		{`a{border-top-color:red;border-right-color:blue;border-bottom-color:red;border-left-color:blue}`, `a{border-color:red blue}`},
+		{`a{border-top-width:1px;border-top-style:solid;border-top-color:red}`, `a{border-top:1px solid red}`},
+		{`a{border-top-left-radius:1px 2px;border-top-right-radius:1px;border-bottom-right-radius:1px;border-bottom-left-radius:1px}`, `a{border-radius:1px/2px 1px 1px}`},
+		{`a{outline-color:red;outline-style:solid;outline-width:medium}`, `a{outline:red solid}`},
+		{`a{list-style-type:square;list-style-position:inside;list-style-image:none}`, `a{list-style:square inside none}`},
+		{`a{font-style:italic;font-variant:normal;font-weight:bold;font-stretch:normal;font-size:12px;line-height:1.5;font-family:arial,sans-serif}`, `a{font-style:italic;font-variant:normal;font-weight:700;font-stretch:normal;font-size:12px;line-height:1.5;font-family:arial,sans-serif}`}, // font resets inherited properties such as font-kerning
+		{`@media screen{a{margin-top:0;margin-right:0;margin-bottom:0;margin-left:0}}`, `@media screen{a{margin:0}}`},
+	}
+
+	m := minify.New()
+	cssMinifier := &Minifier{MergeShorthands: true}
+	for _, tt := range tests {
+		t.Run(tt.css, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.css)
+			w := &bytes.Buffer{}
+			err := cssMinifier.Minify(m, w, r, nil)
+			test.Minify(t, tt.css, err, w.String(), tt.expected)
+		})
+	}
+
+	// source maps
+	sourceMapMinifier := &Minifier{MergeShorthands: true, SourceMap: &bytes.Buffer{}}
+	err := sourceMapMinifier.Minify(m, &bytes.Buffer{}, bytes.NewBufferString(`a{margin:0}`), nil)
+	test.T(t, errors.Is(err, ErrSourceMapOption), true, "must give error for MergeShorthands with SourceMap")
+}
+
+func TestCSSPurge(t *testing.T) {
+	usage := NewHTMLUsage()
+	err := usage.Add(bytes.NewBufferString(`<!doctype html><div id="main" class="row  col-2 sm:flex"><a href="#" data-toggle>x</a><svg><circle class="icon"/></svg></div>`))
+	test.Error(t, err)
+
+	tests := []struct {
+		css      string
+		expected string
+	}{
+		{`body{margin:0}table{color:red}`, `body{margin:0}`},
+		{`.row{color:red}.unused{color:blue}`, `.row{color:red}`},
+		{`.row,.unused{color:red}`, `.row{color:red}`},
+		{`.row,.unused::-moz-selection{color:red}`, `.row,.unused::-moz-selection{color:red}`},
+		{`#main{color:red}#other{color:blue}`, `#main{color:red}`},
+		{`DIV>A[data-toggle]{color:red}a[title]{color:blue}`, `div>a[data-toggle]{color:red}`},
+		{`a[href^="#"]{color:red}`, `a[href^="#"]{color:red}`},
+		{`.sm\:flex{display:flex}.md\:flex{display:flex}`, `.sm\:flex{display:flex}`},
+		{`.col-\32{color:red}`, `.col-\32{color:red}`},
+		{`circle.icon{fill:red}rect{fill:red}`, `circle.icon{fill:red}`},
+		{`a:not(.unused){color:red}a:hover{color:blue}p::before{content:""}`, `a:not(.unused){color:red}a:hover{color:blue}`},
+		{`svg|a{color:red}`, `svg|a{color:red}`},
+		{`.js-toggle{color:red}.unused{color:blue}`, `.js-toggle{color:red}`},
+		{`@media screen{.unused{color:red}}@media print{.row{color:red}}`, `@media print{.row{color:red}}`},
+		{`@media screen{}@font-face{font-family:x}`, `@media screen{}@font-face{font-family:x}`},
+		{`@keyframes x{from{color:red}}`, `@keyframes x{0%{color:red}}`},
+	}
+
+	m := minify.New()
+	cssMinifier := &Minifier{SelectorUsage: usage, PurgeSafelist: []*regexp.Regexp{regexp.MustCompile(`^\.js-`)}}
+	for _, tt := range tests {
+		t.Run(tt.css, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.css)
+			w := &bytes.Buffer{}
+			err := cssMinifier.Minify(m, w, r, nil)
+			test.Minify(t, tt.css, err, w.String(), tt.expected)
+		})
+	}
+
+	// source maps
+	sourceMapMinifier := &Minifier{SelectorUsage: usage, SourceMap: &bytes.Buffer{}}
+	err = sourceMapMinifier.Minify(m, &bytes.Buffer{}, bytes.NewBufferString(`.row{color:red}`), nil)
+	test.T(t, errors.Is(err, ErrSourceMapOption), true, "must give error for SelectorUsage with SourceMap")
+}
+
+func TestParseTargets(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`chrome >= 80`, `chrome >= 80`},
+		{`Chrome > 80, Safari 14`, `chrome >= 81, safari >= 14`},
+		{`safari 15`, `safari >= 15`},
+		{`firefox 60-70 or ie 11`, `firefox >= 60, ie >= 11`},
+		{`last 1 safari version, ff esr`, `firefox >= 115, safari >= 18.2`},
+		{`last 2 versions and not dead`, `and_chr >= 131, and_ff >= 133, chrome >= 130, edge >= 130, firefox >= 132, ios_saf >= 18, opera >= 113, safari >= 18, samsung >= 26`},
+		{`last 2 versions, not chrome < 131`, `and_chr >= 131, and_ff >= 133, chrome >= 131, edge >= 130, firefox >= 132, ios_saf >= 18, opera >= 113, safari >= 18, samsung >= 26`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			targets, err := ParseTargets(tt.query)
+			test.Error(t, err)
+			test.String(t, targets.String(), tt.expected)
+		})
+	}
+
+	errorTests := []string{
+		`> 0.5%`,
+		`not ie 11`,
+		`netscape 4`,
+		`chrome 1`,
+		`chrome ~ 80`,
+		`last two versions`,
+		`chrome 80 and firefox 80`,
+	}
+	for _, query := range errorTests {
+		t.Run(query, func(t *testing.T) {
+			_, err := ParseTargets(query)
+			test.That(t, err != nil, "must fail")
+		})
+	}
+}
+
+func TestCSSTargets(t *testing.T) {
+	tests := []struct {
+		targets  string
+		css      string
+		expected string
+	}{
+		{`defaults`, `a{-webkit-transition:-webkit-transform 1s;transition:transform 1s}`, `a{transition:transform 1s}`},
+		{`defaults`, `a{-webkit-box-shadow:0 0 1px red;-moz-box-shadow:0 0 1px red;box-shadow:0 0 1px red}`, `a{box-shadow:0 0 1px red}`},
+		{`defaults`, `a{box-shadow:0 0 1px red;-webkit-box-shadow:0 0 2px red}`, `a{box-shadow:0 0 1px red;-webkit-box-shadow:0 0 2px red}`},
+		{`defaults`, `a{-webkit-border-radius:1px}`, `a{-webkit-border-radius:1px}`},
+		{`defaults`, `a{display:-webkit-box;display:-ms-flexbox;display:flex;-webkit-box-align:center;-ms-flex-align:center;align-items:center}`, `a{display:flex;align-items:center}`},
+		{`defaults`, `a{display:-webkit-box;-webkit-box-orient:vertical;-webkit-line-clamp:3}`, `a{display:-webkit-box;-webkit-box-orient:vertical;-webkit-line-clamp:3}`},
+		{`defaults`, `a{width:-webkit-calc(100% - 1px);width:calc(100% - 1px)}`, `a{width:calc(100% - 1px)}`},
+		{`defaults`, `a{width:-webkit-fill-available;width:100%}`, `a{width:-webkit-fill-available;width:100%}`},
+		{`defaults`, `a{background:-webkit-linear-gradient(top,red,blue);background:linear-gradient(red,blue)}`, `a{background:linear-gradient(red,blue)}`},
+		{`defaults`, `a{position:-webkit-sticky;position:sticky}`, `a{position:sticky}`},
+		{`safari 12`, `a{position:-webkit-sticky;position:sticky}`, `a{position:-webkit-sticky;position:sticky}`},
+		{`defaults`, `a{-webkit-user-select:none;user-select:none}`, `a{-webkit-user-select:none;user-select:none}`},
+		{`chrome 80`, `a{-webkit-user-select:none;user-select:none}`, `a{user-select:none}`},
+		{`defaults`, `@-webkit-keyframes x{from{color:red}}@keyframes x{from{color:red}}@-webkit-keyframes y{from{color:red}}`, `@keyframes x{0%{color:red}}@-webkit-keyframes y{0%{color:red}}`},
+		{`ie 9`, `@-webkit-keyframes x{from{color:red}}@keyframes x{from{color:red}}`, `@-webkit-keyframes x{0%{color:red}}@keyframes x{0%{color:red}}`},
+		{`defaults`, `@media print{a{-webkit-box-sizing:border-box;box-sizing:border-box}}`, `@media print{a{box-sizing:border-box}}`},
+		{`defaults`, `a{color:rgba(255,0,0,.5);background:hsla(0,0%,0%,.2)}`, `a{color:#ff000080;background:#0003}`},
+		{`ie 11`, `a{color:rgba(255,0,0,.5)}`, `a{color:rgba(255,0,0,.5)}`},
+		{`defaults`, `a{color:oklab(.5 .1 .1/.5)}`, `a{color:#a1420380}`},
+		{`chrome 90`, `a{top:0;right:0;bottom:0;left:0}`, `a{inset:0}`},
+		{`safari 13`, `a{top:0;right:0;bottom:0;left:0}`, `a{top:0;right:0;bottom:0;left:0}`},
+	}
+
+	m := minify.New()
+	for _, tt := range tests {
+		t.Run(tt.targets+" "+tt.css, func(t *testing.T) {
+			targets, err := ParseTargets(tt.targets)
+			test.Error(t, err)
+
+			r := bytes.NewBufferString(tt.css)
+			w := &bytes.Buffer{}
+			cssMinifier := &Minifier{Targets: targets, MergeShorthands: true}
+			err = cssMinifier.Minify(m, w, r, nil)
+			test.Minify(t, tt.css, err, w.String(), tt.expected)
+		})
+	}
+}
+
+func TestCSSNesting(t *testing.T) {
+	tests := []struct {
+		flatten  bool
+		targets  string
+		css      string
+		expected string
+	}{
+		{false, ``, `.a { color: red; &:hover { color: blue } }`, `.a{color:red;&:hover{color:blue}}`},
+		{false, ``, `.a { & .b { margin: 0px } & > .c { top: 0 } & + & { z-index: 1 } }`, `.a{.b{margin:0}>.c{top:0}&+&{z-index:1}}`},
+		{false, ``, `.a { a:hover { color: red } }`, `.a{a:hover{color:red}}`},
+		{false, ``, `.a { color: red; @media (min-width: 10px) { color: blue; .b { top: 0 } } padding: 0px 0px }`, `.a{color:red;@media(min-width:10px){color:blue;.b{top:0}}padding:0}`},
+		{false, ``, `.a { --x: { a: b }; .b { c: d } }`, `.a{--x:{ a: b };.b{c:d}}`},
+		{false, ``, `b { color: #ff0000 } @media print { .p { .q { color: #ff0000 } } }`, `b{color:red}@media print{.p{.q{color:red}}}`},
+		{false, ``, `@import 'x.css'; @charset "utf-8"; @layer x; .a { color: red; .b { c: d } }`, `@import 'x.css';@charset "utf-8";@layer x;.a{color:red;.b{c:d}}`},
+		{false, ``, `@media print { @layer x; .a { .b { c: d } } }`, `@media print{@layer x;.a{.b{c:d}}}`},
+		{false, ``, `.a { @nest .b & { c: d } }`, `.a{.b &{c:d}}`},
+		{true, ``, `.a { color: red; &:hover { color: blue } }`, `.a{color:red}.a:hover{color:blue}`},
+		{true, ``, `.a, .b { .c & { top: 0 } > .d { top: 0 } }`, `.c .a,.c .b{top:0}.a>.d,.b>.d{top:0}`},
+		{true, ``, `.a, .b { & + & { top: 0 } }`, `.a+.a,.a+.b,.b+.a,.b+.b{top:0}`},
+		{true, ``, `div { .e& { top: 0 } }`, `div.e{top:0}`},
+		{true, ``, `.a b { .e&:hover { top: 0 } }`, `.a b.e:hover{top:0}`},
+		{true, ``, `.a { :not(&) { top: 0 } }`, `:not(.a){top:0}`},
+		{true, ``, `.a { color: red; .b { top: 0 } padding: 0 }`, `.a{color:red}.a .b{top:0}.a{padding:0}`},
+		{true, ``, `.a { @media print { color: red; .b { top: 0 } } }`, `@media print{.a{color:red}.a .b{top:0}}`},
+		{true, ``, `@media print { .a { .b { .c { top: 0 } } } }`, `@media print{.a .b .c{top:0}}`},
+		{true, ``, `@import 'x.css'; @layer x; .a { .b { c: d } }`, `@import 'x.css';@layer x;.a .b{c:d}`},
+		{true, ``, `.a, #b { .c { top: 0 } }`, `.a .c,#b .c{top:0}`},
+		{true, ``, `.a { @nest .b & { top: 0 } }`, `.b .a{top:0}`},
+		{false, `chrome 110`, `.a { &:hover { color: blue } }`, `.a:hover{color:blue}`},
+		{false, `chrome 120`, `.a { &:hover { color: blue } }`, `.a{&:hover{color:blue}}`},
+		{false, `chrome 110`, `.a, .b { & + & { top: 0 } }`, `:is(.a,.b)+:is(.a,.b){top:0}`},
+		{false, `chrome 110`, `div { .e& { top: 0 } }`, `.e:is(div){top:0}`},
+		{false, `chrome 110`, `.a .b { .c & { top: 0 } & .d { top: 0 } }`, `.c :is(.a .b){top:0}.a .b .d{top:0}`},
+		{false, `chrome 110`, `.a, #b { .c { top: 0 } }`, `:is(.a,#b) .c{top:0}`},
+		{false, `chrome 110`, `.a, .b { .c { top: 0 } }`, `.a .c,.b .c{top:0}`},
+		{false, `chrome 110`, `.a, .b:not(#c) { .d & { top: 0 } }`, `.d :is(.a,.b:not(#c)){top:0}`},
+		{false, `chrome 110`, `.a .b { :not(&) { top: 0 } }`, `:not(.a .b){top:0}`},
+	}
+
+	m := minify.New()
+	for _, tt := range tests {
+		t.Run(tt.css, func(t *testing.T) {
+			cssMinifier := &Minifier{FlattenNesting: tt.flatten}
+			if tt.targets != "" {
+				targets, err := ParseTargets(tt.targets)
+				test.Error(t, err)
+				cssMinifier.Targets = targets
+			}
+			r := bytes.NewBufferString(tt.css)
+			w := &bytes.Buffer{}
+			err := cssMinifier.Minify(m, w, r, nil)
+			test.Minify(t, tt.css, err, w.String(), tt.expected)
+		})
+	}
+
+	// selectors that require :is()
+	errorTests := []string{
+		`.a, .b { :not(&) { top: 0 } }`,
+		`.a .b { .c > .d& { top: 0 } }`,
+		`div { span& { top: 0 } }`,
+	}
+	for _, css := range errorTests {
+		t.Run(css, func(t *testing.T) {
+			cssMinifier := &Minifier{FlattenNesting: true}
+			err := cssMinifier.Minify(m, &bytes.Buffer{}, bytes.NewBufferString(css), nil)
+			test.That(t, err != nil, "must fail")
+		})
+	}
+}
+
+func TestCSSComments(t *testing.T) {
+	cssTests := []struct {
+		policy      minify.CommentPolicy
+		licenseFile string
+		css         string
+		expected    string
+	}{
+		{minify.NoComments, "", "/*! license */a{b:c}", "a{b:c}"},
+		{minify.TaggedComments, "", "/** @license  MIT */a{b:c}/* comment */", "/** @license MIT*/a{b:c}"},
+		{minify.RegexpComments, "", "/* Copyright */a{b:c}/*! license */", "/*Copyright*/a{b:c}"},
+		{minify.LicenseComments, "a.css.LICENSE.txt", "/*! license */a{b:c}/*! other */", "/*! For license information please see a.css.LICENSE.txt */a{b:c}"},
+		{minify.LicenseComments, "a.css.LICENSE.txt", "/* comment */a{b:c}", "a{b:c}"},
+		{minify.LicenseComments, "", "a{/*! license */b:c;/* comment */}@media print{/*! other */a{b:c}}", "a{/*!license*/b:c}@media print{/*!other*/a{b:c}}"},
+		{minify.TaggedComments, "", "a{b:c/** @preserve */}", "a{b:c;/** @preserve*/}"},
+	}
+
+	m := minify.New()
+	for _, tt := range cssTests {
+		t.Run(tt.css, func(t *testing.T) {
+			comments := &minify.Comments{Policy: tt.policy, Regexp: regexp.MustCompile("Copyright"), LicenseFile: tt.licenseFile}
+			o := &Minifier{Comments: comments}
+			r := bytes.NewBufferString(tt.css)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.css, err, w.String(), tt.expected)
+		})
+	}
+
+	// a nil policy keeps license comments
+	src := "/*! license */a{/*! other */b:c}"
+	w := &bytes.Buffer{}
+	err := (&Minifier{MergeRules: true}).Minify(m, w, bytes.NewBufferString(src), nil)
+	test.Minify(t, src, err, w.String(), "/*!license*/a{/*!other*/b:c}")
+}
+
+func TestCSSMaxLineLen(t *testing.T) {
+	tests := []struct {
+		mergeRules bool
+		css        string
+		expected   string
+	}{
+		{false, `a{color:red;margin:0;padding:0}b{top:0}`, "a{color:red;\nmargin:0;padding:0}\nb{top:0}"},
+		{false, `a{content:";}";top:0}`, "a{content:\";}\";\ntop:0}"},
+		{false, `@media print{a{color:red}b{top:0}}`, "@media print{a{color:red}\nb{top:0}}"},
+		{true, `a{color:red}a{margin:0}b{top:0}`, "a{color:red;\nmargin:0}b{top:0}"},
+	}
+
+	m := minify.New()
+	for _, tt := range tests {
+		t.Run(tt.css, func(t *testing.T) {
+			o := &Minifier{MaxLineLen: 10, MergeRules: tt.mergeRules}
+			r := bytes.NewBufferString(tt.css)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.css, err, w.String(), tt.expected)
+		})
+	}
+}
+
+func TestCSSRename(t *testing.T) {
+	tests := []struct {
+		css      string
+		expected string
+	}{
+		{`.button--primary{color:red}.button--primary:hover,.card__title{color:blue}`, `.a{color:red}.a:hover,.b{color:blue}`},
+		{`#main-content>.x{color:red}`, `#a>.x{color:red}`},
+		{`.a,.long-name{color:red}`, `.a,.b{color:red}`},
+		{`.long-name,.a{color:red}`, `.a,.b{color:red}`},
+		{`.sm\:flex{display:flex}`, `.a{display:flex}`},
+		{`a:not(.long-name){color:red}`, `a:not(.a){color:red}`},
+		{`[class~="long-name"],[id='main-content'],[class^=long-]{color:red}`, `[class~=a],[id=a],[class^=long-]{color:red}`},
+		{`[class="long-name" i]{color:red}`, `[class=a i]{color:red}`},
+		{`a[href="#main-content"],[href^="#main"],#main-content{filter:url( "#main-content" );background:url(a.png)}`, `a[href="#a"],[href^="#main"],#a{filter:url(#a);background:url(a.png)}`},
+		{`a{--brand-color:red;--shadow:0 0 var(--brand-color);color:var(--brand-color,var(--fallback-color))}`, `a{--a:red;--b:0 0 var(--a);color:var(--a,var(--c))}`},
+		{`@property --brand-color{syntax:"<color>"}a{transition:--brand-color 1s}`, `@property --a{syntax:"<color>"}a{transition:--a 1s}`},
+	}
+
+	m := minify.New()
+	for _, tt := range tests {
+		t.Run(tt.css, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.css)
+			w := &bytes.Buffer{}
+			cssMinifier := &Minifier{RenameMap: minify.NewRenameMap()}
+			err := cssMinifier.Minify(m, w, r, nil)
+			test.Minify(t, tt.css, err, w.String(), tt.expected)
+		})
+	}
+
+	// purge against the original names
+	usage := NewHTMLUsage()
+	err := usage.Add(bytes.NewBufferString(`<div id="main-content" class="card__title">`))
+	test.Error(t, err)
+
+	r := bytes.NewBufferString(`.card__title{color:red}.unused{color:blue}#main-content{color:green}`)
+	w := &bytes.Buffer{}
+	cssMinifier := &Minifier{SelectorUsage: usage, RenameMap: minify.NewRenameMap()}
+	err = cssMinifier.Minify(m, w, r, nil)
+	test.Minify(t, "purge", err, w.String(), `.a{color:red}#a{color:green}`)
+}
+
+func TestCSSInlineImports(t *testing.T) {
+	files := map[string]string{
+		"a.css":         `a { color: red; }`,
+		"b.css":         `@charset "utf-8"; @import url(sub/c.css); b { margin: 0px }`,
+		"sub/c.css":     `@import "https://example.com/d.css"; @import "../a.css"; c { color: blue }`,
+		"empty.css":     `/* nothing */`,
+		"cycle.css":     `@import "sub/cycle.css";`,
+		"sub/cycle.css": `@import "../cycle.css";`,
+		"cond.css":      `@import "https://example.com/d.css" print;`,
+		"layer.css":     `@layer x; e{f:g}`,
+		"main.css":      `@import "sub/main.css"; m{n:o}`,
+		"sub/main.css":  `@import "../main.css"; p{q:r}`,
+		"sub/img.css":   `@import "missing.css"; @import "/d.css"; a{background:url(img.png)} b{background:url( "../img.png" )} c{background:url(/img.png)} d{background:url(data:image/png;base64,AA==)} e{filter:url(#f)} f{background:url(https://example.com/img.png)}`,
+	}
+	tests := []struct {
+		css      string
+		expected string
+	}{
+		{`@import "a.css";`, `a{color:red}`},
+		{`@import url( a.css ); b{c:d}`, `a{color:red}b{c:d}`},
+		{`@charset "utf-8"; @import "b.css"; @import 'https://example.com/e.css'; x{y:z}`, `@charset "utf-8";@import "https://example.com/d.css";@import 'https://example.com/e.css';a{color:red}c{color:blue}b{margin:0}x{y:z}`},
+		{`@import "a.css" screen and (min-width: 100px);`, `@media screen and (min-width:100px){a{color:red}}`},
+		{`@import "a.css" (min-width: 100px), print;`, `@media(min-width:100px),print{a{color:red}}`},
+		{`@import "a.css" supports(display: grid);`, `@supports(display:grid){a{color:red}}`},
+		{`@import "a.css" supports(not (display: grid));`, `@supports not (display:grid){a{color:red}}`},
+		{`@import "a.css" layer;`, `@layer{a{color:red}}`},
+		{`@import "a.css" layer(base.x) supports(display: grid) print;`, `@layer base.x{@supports(display:grid){@media print{a{color:red}}}}`},
+		{`@import "sub/c.css" print;`, `@import "https://example.com/d.css" print;@media print{a{color:red}c{color:blue}}`},
+		{`@import "layer.css" print;`, `@media print{@layer x;e{f:g}}`},
+		{`@import "empty.css" print; a{b:c}`, `a{b:c}`},
+		{`@import "missing.css"; a{b:c}`, `@import "missing.css";a{b:c}`},
+		{`@import "/a.css"; @import "data:text/css,a{}";`, `@import "/a.css";@import "data:text/css,a{}"`},
+		{`a{b:c} @import "a.css";`, `a{b:c}@import "a.css"`},
+		{`@import "sub/img.css";`, `@import "sub/missing.css";@import "/d.css";a{background:url(sub/img.png)}b{background:url(img.png)}c{background:url(/img.png)}d{background:url(data:image/png,%00)}e{filter:url(#f)}f{background:url(https://example.com/img.png)}`},
+	}
+
+	m := minify.New()
+	cssMinifier := &Minifier{
+		ResolveImport: func(url string) ([]byte, error) {
+			if src, ok := files[url]; ok {
+				return []byte(src), nil
+			}
+			return nil, nil
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.css, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.css)
+			w := &bytes.Buffer{}
+			err := cssMinifier.Minify(m, w, r, nil)
+			test.Minify(t, tt.css, err, w.String(), tt.expected)
+		})
+	}
+
+	errorTests := []struct {
+		css string
+		err string
+	}{
+		{`@import "cycle.css";`, "circular import: cycle.css -> sub/cycle.css -> cycle.css"},
+		{`@import "cond.css" screen;`, "cond.css: cannot hoist @import of https://example.com/d.css out of conditional @import"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.css, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.css)
+			w := &bytes.Buffer{}
+			err := cssMinifier.Minify(m, w, r, nil)
+			test.String(t, err.Error(), tt.err)
+		})
+	}
+
+	// the input is on the import stack, so that it is not inlined into itself
+	t.Run("main.css", func(t *testing.T) {
+		mainMinifier := *cssMinifier
+		mainMinifier.ImportSource = "src/main.css"
+		r := bytes.NewBufferString(files["main.css"])
+		w := &bytes.Buffer{}
+		err := mainMinifier.Minify(m, w, r, nil)
+		test.String(t, err.Error(), "circular import: main.css -> sub/main.css -> main.css")
+		test.That(t, !strings.Contains(w.String(), "m{n:o}") && !strings.Contains(w.String(), "p{q:r}"), "must not inline the input into itself")
+	})
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		css      string
+		expected string
+	}{
+		{`a , b > c { color : red ! important ; --x: { a } }`, `a,b>c{color:red!important;--x: { a } }`},
+		{`@import url(a.css) screen; @media screen and (x:1) { a { b: f(g(h), i) } }`, `@import url(a.css) screen;@media screen and (x:1){a{b:f(g(h),i)}}`},
+		{`@font-face { font-family: x } @page :first { margin: 0 }`, `@font-face{font-family:x}@page:first{margin:0}`},
+		{`@foo bar { x y{z} }`, `@foo bar{x y{z} }`},
+		{`/*! license */ a { b; c: d }`, `/*! license */a{b;c:d}`},
+		{`.a { &:hover { top: 0 } }`, `.a{&:hover{top:0}}`},
+		{`.a { color: red; &:hover, b > & { top: 0 } @media print { c: d; .e { f: g } } h: i }`, `.a{color:red;&:hover,b>&{top:0}@media print{c:d;.e{f:g}}h:i}`},
+		{`@media print { .a { .b { c: d } } } e { f: g }`, `@media print{.a{.b{c:d}}}e{f:g}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.css, func(t *testing.T) {
+			s, err := Parse(bytes.NewBufferString(tt.css))
+			test.Error(t, err)
+			w := &bytes.Buffer{}
+			test.Error(t, s.Write(w))
+			test.String(t, w.String(), tt.expected)
+		})
+	}
+}
+
+type urlRewriter struct{}
+
+func (v urlRewriter) Enter(n Node) Visitor {
+	if d, ok := n.(*Declaration); ok {
+		for i, value := range d.Values {
+			if value.TokenType == css.URLToken {
+				d.Values[i].Data = bytes.Replace(value.Data, []byte("img/"), []byte("/static/img/"), 1)
+			}
+		}
+	}
+	return v
+}
+
+func (v urlRewriter) Exit(n Node) {}
+
+func TestWalk(t *testing.T) {
+	s, err := Parse(bytes.NewBufferString(`a { background: url(img/a.png) } @media print { b { background: url( "img/b.png" ) } }`))
+	test.Error(t, err)
+	Walk(urlRewriter{}, s)
+	test.String(t, s.String(), `a{background:url(/static/img/a.png)}@media print{b{background:url( "/static/img/b.png" )}}`)
+
+	w := &bytes.Buffer{}
+	err = (&Minifier{}).MinifyStylesheet(minify.New(), w, s)
+	test.Minify(t, s.String(), err, w.String(), `a{background:url(/static/img/a.png)}@media print{b{background:url(/static/img/b.png)}}`)
+}
+
+func TestMinifyStylesheet(t *testing.T) {
+	tests := []string{
+		`/*! license */ @charset "utf-8"; a , b > c { color : #FF0000 ! important ; margin: 0px 0px }`,
+		`@media all and (min-width: 100px) { a { width: calc(10px + 20px) } } @media all and (min-width: 100px) { b { top: 0 } }`,
+		`@keyframes x { from { top: 0 } 100% { top: 1px } } @font-face { src: url("a.woff") }`,
+		`a { --x: { a } ; b; c: d } @foo bar { x y{z} } e{f:g`,
+		`a { margin-top: 1px; margin-right: 1px; margin-bottom: 1px; margin-left: 1px } a { color: red }`,
+		`.a { color: red; & .b { top: 0 } @media print { c: d } }`,
+	}
+
+	m := minify.New()
+	for _, css := range tests {
+		t.Run(css, func(t *testing.T) {
+			o := &Minifier{MergeRules: true, MergeShorthands: true}
+			s, err := Parse(bytes.NewBufferString(css))
+			test.Error(t, err)
+
+			expected := &bytes.Buffer{}
+			test.Error(t, o.Minify(m, expected, bytes.NewBufferString(css), nil))
+			w := &bytes.Buffer{}
+			err = o.MinifyStylesheet(m, w, s)
+			test.Minify(t, css, err, w.String(), expected.String())
+
+			// the stylesheet is not modified
+			w.Reset()
+			err = o.MinifyStylesheet(m, w, s)
+			test.Minify(t, css, err, w.String(), expected.String())
+		})
+	}
+
+	// nested rules are flattened when minifying
+	css := `.a { color: red; &:hover { top: 0 } }`
+	s, err := Parse(bytes.NewBufferString(css))
+	test.Error(t, err)
+	w := &bytes.Buffer{}
+	err = (&Minifier{FlattenNesting: true}).MinifyStylesheet(m, w, s)
+	test.Minify(t, css, err, w.String(), `.a{color:red}.a:hover{top:0}`)
+	test.String(t, s.String(), `.a{color:red;&:hover{top:0}}`)
+
+	// nested rules are minified from the nodes like Minify does
+	nestingTests := []string{
+		`.a { color: red; .b { top: 0 } padding: 0 }`,
+		`.a, .b { & + & { top: 0 } .c & { top: 0 } }`,
+		`.a { @media print { color: red; .b { top: 0 } } }`,
+		`@media print { @layer x; .a { .b { .c { top: 0 } } } } b { color: #ff0000 }`,
+		`/*! license */ .a { /* comment */ --x: { a: b }; .b { c: d } }`,
+		`@font-face { src: url("a.woff") } .a { @nest .b & { top: 0 } }`,
+	}
+	for _, css := range nestingTests {
+		for _, flatten := range []bool{false, true} {
+			t.Run(css, func(t *testing.T) {
+				o := &Minifier{FlattenNesting: flatten}
+				s, err := Parse(bytes.NewBufferString(css))
+				test.Error(t, err)
+
+				expected := &bytes.Buffer{}
+				test.Error(t, o.Minify(m, expected, bytes.NewBufferString(css), nil))
+				w := &bytes.Buffer{}
+				err = o.MinifyStylesheet(m, w, s)
+				test.Minify(t, css, err, w.String(), expected.String())
+			})
+		}
+	}
+
+	// statement at-rules keep their semicolon before nested rules
+	css = `@import 'x.css'; @charset "utf-8"; @layer x; .a { .b { c: d } }`
+	s, err = Parse(bytes.NewBufferString(css))
+	test.Error(t, err)
+	w.Reset()
+	err = (&Minifier{}).MinifyStylesheet(m, w, s)
+	test.Minify(t, css, err, w.String(), `@import 'x.css';@charset "utf-8";@layer x;.a{.b{c:d}}`)
+}
+
 func TestReaderErrors(t *testing.T) {
 	r := test.NewErrorReader(0)
 	w := &bytes.Buffer{}
@@ -0,0 +1,296 @@
+package css
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+)
+
+var (
+	layerBytes    = []byte("layer")
+	layerAtBytes  = []byte("@layer")
+	supportsBytes = []byte("@supports")
+	mediaBytes    = []byte("@media")
+)
+
+// cssImporter holds the state shared by the stylesheets that are inlined into the output.
+type cssImporter struct {
+	w     io.Writer    // writer for the @import rules that are not inlined, which are hoisted to the top
+	body  bytes.Buffer // inlined stylesheets, written after the @import rules of the input
+	stack []string     // stylesheets being inlined, to detect circular imports
+}
+
+// minifyImportURL rewrites the URL of an @import rule to a string.
+func minifyImportURL(values []css.Token) {
+	if len(values) < 2 || values[1].TokenType != css.URLToken {
+		return
+	}
+	url := values[1].Data
+	if url[4] != '"' && url[4] != '\'' {
+		a := 4
+		for parse.IsWhitespace(url[a]) || parse.IsNewline(url[a]) {
+			a++
+		}
+		b := len(url) - 2
+		for parse.IsWhitespace(url[b]) || parse.IsNewline(url[b]) {
+			b--
+		}
+		url = url[a-1 : b+2]
+		url[0] = '"'
+		url[len(url)-1] = '"'
+	} else {
+		url = url[4 : len(url)-1]
+	}
+	values[1].Data = url
+}
+
+// importURL returns the unquoted URL of an @import rule.
+func importURL(values []css.Token) (string, bool) {
+	if len(values) < 2 || values[1].TokenType != css.URLToken && values[1].TokenType != css.StringToken {
+		return "", false
+	}
+	url := values[1].Data
+	if values[1].TokenType == css.URLToken {
+		url = parse.TrimWhitespace(url[4 : len(url)-1])
+	}
+	if 1 < len(url) && (url[0] == '"' || url[0] == '\'') {
+		url = url[1 : len(url)-1]
+	}
+	return string(url), true
+}
+
+// isLocalURL returns true if the URL is relative to the importing stylesheet.
+func isLocalURL(url string) bool {
+	if url == "" || url[0] == '/' {
+		return false
+	}
+	colon := strings.IndexByte(url, ':')
+	slash := strings.IndexByte(url, '/')
+	return colon == -1 || slash != -1 && slash < colon
+}
+
+// rebaseURL returns a URL relative to the inlined stylesheet as relative to the input file. Absolute URLs, URLs with a scheme, and fragments are returned as is.
+func (c *cssMinifier) rebaseURL(url []byte) []byte {
+	dir := path.Dir(c.filename)
+	if dir == "." || len(url) == 0 || url[0] == '#' || !isLocalURL(string(url)) {
+		return url
+	}
+	return []byte(path.Join(dir, string(url)))
+}
+
+// isImportPrelude returns true if the grammar may precede @import rules.
+func isImportPrelude(gt css.GrammarType, data []byte) bool {
+	if gt == css.CommentGrammar {
+		return true
+	} else if gt == css.AtRuleGrammar {
+		at := data[1:]
+		return ToHash(at) == Charset || ToHash(at) == Import || parse.EqualFold(at, layerBytes)
+	}
+	return false
+}
+
+// importConditions splits the conditions of an @import rule into its layer, supports, and media query list parts.
+func importConditions(values []css.Token) (layer []css.Token, isLayer bool, supports []css.Token, media []css.Token) {
+	for 0 < len(values) {
+		if values[0].TokenType == css.WhitespaceToken {
+			values = values[1:]
+		} else if !isLayer && supports == nil && values[0].TokenType == css.IdentToken && parse.EqualFold(values[0].Data, layerBytes) {
+			isLayer = true
+			values = values[1:]
+		} else if values[0].TokenType == css.FunctionToken {
+			name := values[0].Data[:len(values[0].Data)-1]
+			i, level := 1, 0
+			for ; i < len(values); i++ {
+				if values[i].TokenType == css.FunctionToken || values[i].TokenType == css.LeftParenthesisToken {
+					level++
+				} else if values[i].TokenType == css.RightParenthesisToken {
+					if level == 0 {
+						break
+					}
+					level--
+				}
+			}
+			if !isLayer && supports == nil && parse.EqualFold(name, layerBytes) {
+				layer, isLayer = values[1:i], true
+			} else if supports == nil && ToHash(name) == Supports {
+				supports = values[1:i]
+			} else {
+				break
+			}
+			if i < len(values) {
+				i++
+			}
+			values = values[i:]
+		} else {
+			break
+		}
+	}
+	return layer, isLayer, supports, values
+}
+
+// writeAtRuleTokens writes the prelude of an at-rule followed by an opening bracket.
+func writeAtRuleTokens(w io.Writer, name []byte, values []css.Token) {
+	w.Write(name)
+	if 0 < len(values) && values[0].TokenType != css.LeftParenthesisToken {
+		w.Write(spaceBytes)
+	}
+	for _, val := range values {
+		w.Write(val.Data)
+	}
+	w.Write(leftBracketBytes)
+}
+
+// isDeclaration returns true if the tokens form a declaration, i.e. have a colon outside of parentheses.
+func isDeclaration(values []css.Token) bool {
+	level := 0
+	for _, val := range values {
+		if val.TokenType == css.FunctionToken || val.TokenType == css.LeftParenthesisToken {
+			level++
+		} else if val.TokenType == css.RightParenthesisToken {
+			level--
+		} else if level == 0 && val.TokenType == css.ColonToken {
+			return true
+		}
+	}
+	return false
+}
+
+// inlineImport inlines the stylesheet of an @import rule using ResolveImport, or hoists the @import rule of an inlined stylesheet to the top of the output. It returns false if the @import rule should be written as is.
+func (c *cssMinifier) inlineImport(data []byte, values []css.Token) (bool, error) {
+	url, ok := importURL(values)
+	if !ok {
+		return false, nil
+	}
+
+	var b []byte
+	filename := path.Join(path.Dir(c.filename), url)
+	if isLocalURL(url) {
+		for i, name := range c.importer.stack {
+			if name == filename {
+				return false, fmt.Errorf("circular import: %s -> %s", strings.Join(c.importer.stack[i:], " -> "), filename)
+			}
+		}
+
+		var err error
+		if b, err = c.o.ResolveImport(filename); err != nil {
+			if c.filename != "" {
+				return false, fmt.Errorf("%s: %w", c.filename, err)
+			}
+			return false, err
+		}
+	}
+
+	if b == nil {
+		if c.filename == "" {
+			return false, nil
+		}
+
+		// hoist the @import rule of the inlined stylesheet to the top, adding the conditions of the @import rules that inlined it
+		if 1 < len(c.conditions) || len(c.conditions) == 1 && 2 < len(values) {
+			return false, fmt.Errorf("%s: cannot hoist @import of %s out of conditional @import", c.filename, url)
+		}
+		minifyImportURL(values)
+		if rebased := c.rebaseURL([]byte(url)); string(rebased) != url {
+			delim := byte('"')
+			if bytes.IndexByte(rebased, '"') != -1 {
+				delim = '\''
+			}
+			values[1] = css.Token{TokenType: css.StringToken, Data: append(append([]byte{delim}, rebased...), delim)}
+		}
+		c.importer.w.Write(data)
+		for _, val := range values {
+			c.importer.w.Write(val.Data)
+		}
+		if len(c.conditions) == 1 {
+			for _, val := range c.conditions[0] {
+				c.importer.w.Write(val.Data)
+			}
+		}
+		c.importer.w.Write(semicolonBytes)
+		return true, nil
+	}
+
+	conditions := c.conditions
+	if 2 < len(values) {
+		conditions = append(conditions[:len(conditions):len(conditions)], values[2:])
+	}
+
+	w := &c.importer.body
+	n := w.Len()
+	wrappers := 0
+	layer, isLayer, supports, media := importConditions(values[2:])
+	if isLayer {
+		writeAtRuleTokens(w, layerAtBytes, layer)
+		wrappers++
+	}
+	if supports != nil {
+		if isDeclaration(supports) {
+			w.Write(supportsBytes)
+			w.Write([]byte("("))
+			for _, val := range supports {
+				w.Write(val.Data)
+			}
+			w.Write(rightParenBytes)
+			w.Write(leftBracketBytes)
+		} else {
+			writeAtRuleTokens(w, supportsBytes, supports)
+		}
+		wrappers++
+	}
+	if 0 < len(media) {
+		writeAtRuleTokens(w, mediaBytes, media)
+		wrappers++
+	}
+	m := w.Len()
+
+	imported := &cssMinifier{
+		m: c.m,
+		w: w,
+		p: css.NewParser(parse.NewInputBytes(b), false),
+		o: c.o,
+
+		importer:   c.importer,
+		filename:   filename,
+		conditions: conditions,
+
+		renames:  c.renames,
+		features: c.features,
+	}
+	c.importer.stack = append(c.importer.stack, filename)
+	imported.minifyGrammar()
+	c.importer.stack = c.importer.stack[:len(c.importer.stack)-1]
+	if imported.err != nil {
+		return false, imported.err
+	} else if err := imported.p.Err(); err != io.EOF {
+		return false, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if w.Len() == m {
+		w.Truncate(n) // empty stylesheet
+	} else {
+		for i := 0; i < wrappers; i++ {
+			w.Write(rightBracketBytes)
+		}
+	}
+	return true, nil
+}
+
+// finishImports writes the inlined stylesheets after the @import rules of the input.
+func (c *cssMinifier) finishImports(semicolonQueued bool) {
+	if c.filename != "" {
+		if semicolonQueued {
+			c.w.Write(semicolonBytes)
+		}
+	} else if !c.importsDone && 0 < c.importer.body.Len() {
+		if semicolonQueued {
+			c.w.Write(semicolonBytes)
+		}
+		c.w.Write(c.importer.body.Bytes())
+	}
+	c.importsDone = true
+}
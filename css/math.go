@@ -0,0 +1,592 @@
+package css
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+)
+
+// mathUnits maps the units that can be converted into each other to their canonical unit and the multiplier towards it.
+var mathUnits = map[string]struct {
+	canonical  string
+	multiplier float64
+}{
+	"px":   {"px", 1.0},
+	"in":   {"px", 96.0},
+	"cm":   {"px", 96.0 / 2.54},
+	"mm":   {"px", 96.0 / 25.4},
+	"q":    {"px", 96.0 / 101.6},
+	"pt":   {"px", 4.0 / 3.0},
+	"pc":   {"px", 16.0},
+	"ms":   {"ms", 1.0},
+	"s":    {"ms", 1000.0},
+	"deg":  {"deg", 1.0},
+	"grad": {"deg", 0.9},
+	"rad":  {"deg", 180.0 / math.Pi},
+	"turn": {"deg", 360.0},
+	"hz":   {"hz", 1.0},
+	"khz":  {"hz", 1000.0},
+	"dppx": {"dppx", 1.0},
+	"x":    {"dppx", 1.0},
+	"dpi":  {"dppx", 1.0 / 96.0},
+	"dpcm": {"dppx", 2.54 / 96.0},
+}
+
+// mathCategory returns the canonical unit of a unit, values of the same category can be added and compared.
+func mathCategory(unit string) (string, float64) {
+	if u, ok := mathUnits[unit]; ok {
+		return u.canonical, u.multiplier
+	}
+	return unit, 1.0
+}
+
+type mathOp byte
+
+const (
+	mathValue    mathOp = iota // number, percentage, or dimension
+	mathSum                    // terms are added, or subtracted when inverted
+	mathProduct                // factors are multiplied, or divided when inverted
+	mathFunction               // min(), max(), or clamp()
+	mathOpaque                 // any other token, such as pi, var() or env()
+)
+
+// mathNode is a node in the expression tree of a math function.
+type mathNode struct {
+	op      mathOp
+	num     float64
+	unit    string
+	inv     bool       // negated within a sum, or inverted within a product
+	nodes   []mathNode // terms, factors, or arguments
+	token   Token      // function name or opaque token
+	fragile bool       // a substitution function such as var() is an operand, which may expand to any tokens so that its neighbours must not change
+}
+
+type mathParser struct {
+	c    *cssMinifier
+	prop Hash
+	args []Token
+	i    int
+}
+
+func (p *mathParser) whitespace() bool {
+	ws := false
+	for p.i < len(p.args) && p.args[p.i].TokenType == css.WhitespaceToken {
+		p.i++
+		ws = true
+	}
+	return ws
+}
+
+func (p *mathParser) peekDelim() byte {
+	if p.i < len(p.args) && p.args[p.i].TokenType == css.DelimToken {
+		return p.args[p.i].Data[0]
+	}
+	return 0
+}
+
+// parseCalc parses all arguments as a single sum.
+func (p *mathParser) parseCalc() (mathNode, bool) {
+	n, ok := p.parseSum()
+	p.whitespace()
+	return n, ok && p.i == len(p.args)
+}
+
+// parseFunction parses all arguments as sums separated by commas.
+func (p *mathParser) parseFunction(t Token) (mathNode, bool) {
+	n := mathNode{op: mathFunction, token: t}
+	for {
+		arg, ok := p.parseSum()
+		if !ok {
+			return n, false
+		}
+		n.nodes = append(n.nodes, arg)
+		p.whitespace()
+		if p.i == len(p.args) {
+			break
+		} else if p.args[p.i].TokenType != css.CommaToken {
+			return n, false
+		}
+		p.i++
+	}
+	if t.Fun == Clamp && len(n.nodes) != 3 {
+		return n, false
+	}
+	return n, true
+}
+
+func (p *mathParser) parseSum() (mathNode, bool) {
+	p.whitespace()
+	term, ok := p.parseProduct()
+	if !ok {
+		return term, false
+	}
+	n := mathNode{op: mathSum, nodes: []mathNode{term}}
+	for {
+		i := p.i
+		if !p.whitespace() || p.peekDelim() != '+' && p.peekDelim() != '-' {
+			p.i = i
+			break
+		}
+		inv := p.peekDelim() == '-'
+		p.i++
+		if !p.whitespace() {
+			return n, false // + and - must be surrounded by whitespace
+		}
+		if term, ok = p.parseProduct(); !ok {
+			return n, false
+		}
+		term.inv = inv
+		n.nodes = append(n.nodes, term)
+	}
+	if len(n.nodes) == 1 {
+		return n.nodes[0], true
+	}
+	return n, true
+}
+
+func (p *mathParser) parseProduct() (mathNode, bool) {
+	factor, ok := p.parseValue()
+	if !ok {
+		return factor, false
+	}
+	n := mathNode{op: mathProduct, nodes: []mathNode{factor}}
+	for {
+		i := p.i
+		p.whitespace()
+		if p.peekDelim() != '*' && p.peekDelim() != '/' {
+			p.i = i
+			break
+		}
+		inv := p.peekDelim() == '/'
+		p.i++
+		p.whitespace()
+		if factor, ok = p.parseValue(); !ok {
+			return n, false
+		}
+		factor.inv = inv
+		n.nodes = append(n.nodes, factor)
+	}
+	if len(n.nodes) == 1 {
+		return n.nodes[0], true
+	}
+	return n, true
+}
+
+func (p *mathParser) parseValue() (mathNode, bool) {
+	if len(p.args) <= p.i {
+		return mathNode{}, false
+	}
+	t := p.args[p.i]
+	p.i++
+	switch t.TokenType {
+	case css.NumberToken, css.PercentageToken, css.DimensionToken:
+		n := parse.Number(t.Data)
+		num, err := strconv.ParseFloat(string(t.Data[:n]), 64)
+		if err != nil || n == 0 {
+			return mathNode{}, false
+		}
+		unit := parse.ToLower(parse.Copy(t.Data[n:]))
+		if string(unit) == "fr" {
+			return mathNode{op: mathOpaque, token: t}, true // flexible lengths are not allowed in math functions
+		}
+		for _, c := range unit {
+			if (c < 'a' || 'z' < c) && (c != '%' || t.TokenType != css.PercentageToken) {
+				return mathNode{}, false // such as 5px-3px
+			}
+		}
+		return mathNode{op: mathValue, num: num, unit: string(unit)}, true
+	case css.LeftParenthesisToken:
+		n, ok := p.parseSum()
+		p.whitespace()
+		if !ok || len(p.args) <= p.i || p.args[p.i].TokenType != css.RightParenthesisToken {
+			return n, false
+		}
+		p.i++
+		return n, true
+	case css.FunctionToken:
+		sub := mathParser{c: p.c, prop: p.prop, args: t.Args}
+		if t.Fun == Calc {
+			return sub.parseCalc()
+		} else if t.Fun == Min || t.Fun == Max || t.Fun == Clamp {
+			return sub.parseFunction(t)
+		}
+		t.Args = p.c.minifyTokens(p.prop, t.Args)
+		return mathNode{op: mathOpaque, token: t, fragile: t.Fun == Var || t.Fun == Env || t.Fun == Attr}, true
+	case css.IdentToken:
+		return mathNode{op: mathOpaque, token: t}, true // such as pi, e, or infinity
+	}
+	return mathNode{}, false
+}
+
+////////////////////////////////////////////////////////////////
+
+// simplify folds the values in the expression tree, it returns false if the expression is invalid.
+func (n mathNode) simplify() (mathNode, bool) {
+	switch n.op {
+	case mathSum, mathProduct, mathFunction:
+		nodes := make([]mathNode, len(n.nodes))
+		for i, node := range n.nodes {
+			inv := node.inv
+			var ok bool
+			if nodes[i], ok = node.simplify(); !ok {
+				return n, false
+			}
+			nodes[i].inv = inv
+			if nodes[i].op == mathOpaque && nodes[i].fragile && n.op != mathFunction {
+				n.fragile = true
+			}
+		}
+		n.nodes = nodes
+	}
+	if n.fragile {
+		return n, true
+	}
+
+	switch n.op {
+	case mathSum:
+		return n.simplifySum()
+	case mathProduct:
+		return n.simplifyProduct()
+	case mathFunction:
+		return n.simplifyFunction()
+	}
+	return n, true
+}
+
+func (n mathNode) simplifySum() (mathNode, bool) {
+	// flatten nested sums
+	terms := []mathNode{}
+	for _, term := range n.nodes {
+		if term.op == mathSum && !term.fragile {
+			for _, sub := range term.nodes {
+				sub.inv = sub.inv != term.inv
+				terms = append(terms, sub)
+			}
+		} else {
+			terms = append(terms, term)
+		}
+	}
+
+	// add values of the same category
+	n.nodes = n.nodes[:0:0]
+	units := map[int][]string{} // units of the values that were added in the canonical unit
+	dimensions := 0
+	for _, term := range terms {
+		if term.op != mathValue {
+			n.nodes = append(n.nodes, term)
+			continue
+		}
+		if term.inv {
+			term.num, term.inv = -term.num, false
+		}
+		category, multiplier := mathCategory(term.unit)
+		merged := false
+		for i, node := range n.nodes {
+			if node.op != mathValue {
+				continue
+			}
+			nodeCategory, nodeMultiplier := mathCategory(node.unit)
+			if (node.unit == "") != (term.unit == "") {
+				return n, false // numbers cannot be added to dimensions
+			} else if nodeCategory == category {
+				if node.unit == term.unit {
+					n.nodes[i].num += term.num
+				} else {
+					n.nodes[i].num = node.num*nodeMultiplier + term.num*multiplier
+					n.nodes[i].unit = category
+					units[i] = append(units[i], node.unit, term.unit)
+				}
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			if term.unit != "" {
+				dimensions++
+			}
+			n.nodes = append(n.nodes, term)
+		}
+	}
+	for i, us := range units {
+		n.nodes[i].num, n.nodes[i].unit = shortestUnit(n.nodes[i].num, n.nodes[i].unit, us)
+	}
+
+	// remove zero dimensions when other dimensions or percentages remain, which keeps the type of the sum
+	for i := 0; i < len(n.nodes) && 1 < dimensions; i++ {
+		if node := n.nodes[i]; node.op == mathValue && node.num == 0.0 && node.unit != "" && node.unit != "%" {
+			n.nodes = append(n.nodes[:i], n.nodes[i+1:]...)
+			dimensions--
+			i--
+		}
+	}
+	if len(n.nodes) == 1 && !n.nodes[0].inv {
+		return n.nodes[0], true
+	}
+	return n, true
+}
+
+func (n mathNode) simplifyProduct() (mathNode, bool) {
+	// flatten nested products
+	factors := []mathNode{}
+	for _, factor := range n.nodes {
+		if factor.op == mathProduct && !factor.fragile {
+			for _, sub := range factor.nodes {
+				sub.inv = sub.inv != factor.inv
+				factors = append(factors, sub)
+			}
+		} else {
+			factors = append(factors, factor)
+		}
+	}
+
+	// multiply numbers and at most one dimension
+	value := mathNode{op: mathValue, num: 1.0}
+	n.nodes = n.nodes[:0:0]
+	for _, factor := range factors {
+		if factor.op != mathValue {
+			n.nodes = append(n.nodes, factor)
+		} else if factor.inv {
+			if factor.unit != "" || factor.num == 0.0 {
+				return n, false // division by dimensions or zero
+			}
+			value.num /= factor.num
+		} else {
+			if factor.unit != "" {
+				if value.unit != "" {
+					return n, false // multiplication of dimensions
+				}
+				value.unit = factor.unit
+			}
+			value.num *= factor.num
+		}
+	}
+	if len(n.nodes) == 0 {
+		return value, true
+	} else if value.num != 1.0 || value.unit != "" || n.nodes[0].inv {
+		if n.nodes[0].inv {
+			n.nodes = append([]mathNode{value}, n.nodes...)
+		} else {
+			n.nodes = append(n.nodes, value)
+		}
+	}
+	if len(n.nodes) == 1 {
+		return n.nodes[0], true
+	}
+	return n, true
+}
+
+func (n mathNode) simplifyFunction() (mathNode, bool) {
+	if n.token.Fun == Clamp {
+		lo, val, hi := n.nodes[0], n.nodes[1], n.nodes[2]
+		if lo.op == mathValue && val.op == mathValue && hi.op == mathValue {
+			category, _ := mathCategory(lo.unit)
+			if valCategory, _ := mathCategory(val.unit); valCategory != category {
+				return n, true
+			} else if hiCategory, _ := mathCategory(hi.unit); hiCategory != category {
+				return n, true
+			}
+			if val.compare(hi) > 0 {
+				val = hi
+			}
+			if lo.compare(val) > 0 {
+				val = lo
+			}
+			return val, true
+		}
+		return n, true
+	}
+
+	// remove values that are dominated by other values of the same category
+	nodes := n.nodes[:0:0]
+	for _, node := range n.nodes {
+		merged := false
+		if node.op == mathValue {
+			category, _ := mathCategory(node.unit)
+			for i, prev := range nodes {
+				if prevCategory, _ := mathCategory(prev.unit); prev.op == mathValue && prevCategory == category {
+					if cmp := node.compare(prev); n.token.Fun == Min && cmp < 0 || n.token.Fun == Max && cmp > 0 {
+						nodes[i] = node
+					}
+					merged = true
+					break
+				}
+			}
+		}
+		if !merged {
+			nodes = append(nodes, node)
+		}
+	}
+	n.nodes = nodes
+	if len(n.nodes) == 1 && !(n.nodes[0].op == mathOpaque && n.nodes[0].fragile) {
+		return n.nodes[0], true
+	}
+	return n, true
+}
+
+// shortestUnit returns a value in the canonical unit converted to the unit with the shortest exact representation, which is either the canonical unit or one of the given units, such as 1.1s instead of 1100ms.
+func shortestUnit(num float64, canonical string, units []string) (float64, string) {
+	length := func(num float64, unit string) int {
+		return len(minify.Number([]byte(strconv.FormatFloat(num, 'f', -1, 64)), 0)) + len(unit)
+	}
+	bestNum, bestUnit := num, canonical
+	bestLength := length(num, canonical)
+	for _, unit := range units {
+		_, multiplier := mathCategory(unit)
+		v := num / multiplier
+		if exact := strconv.FormatFloat(v, 'g', 12, 64); exact == strconv.FormatFloat(v, 'g', 15, 64) {
+			v, _ = strconv.ParseFloat(exact, 64) // remove rounding errors
+			if n := length(v, unit); n < bestLength {
+				bestNum, bestUnit, bestLength = v, unit, n
+			}
+		}
+	}
+	return bestNum, bestUnit
+}
+
+// compare returns the sign of the difference between two values of the same category.
+func (n mathNode) compare(b mathNode) int {
+	_, multiplier := mathCategory(n.unit)
+	_, bMultiplier := mathCategory(b.unit)
+	if d := n.num*multiplier - b.num*bMultiplier; d < 0.0 {
+		return -1
+	} else if 0.0 < d {
+		return 1
+	}
+	return 0
+}
+
+////////////////////////////////////////////////////////////////
+
+func (c *cssMinifier) mathValueToken(n mathNode) Token {
+	num := n.num
+	if num == 0.0 {
+		num = 0.0 // remove negative zero
+	}
+	num, _ = strconv.ParseFloat(strconv.FormatFloat(num, 'g', c.o.newPrecision, 64), 64) // remove rounding errors
+	data := []byte(strconv.FormatFloat(num, 'f', -1, 64))
+	if c.o.KeepCSS2 {
+		data = minify.Decimal(data, c.o.Precision)
+	} else {
+		data = minify.Number(data, c.o.Precision)
+	}
+	if n.unit == "" {
+		return Token{css.NumberToken, data, nil, 0, 0}
+	} else if n.unit == "%" {
+		return Token{css.PercentageToken, append(data, '%'), nil, 0, 0}
+	}
+	return Token{css.DimensionToken, append(data, n.unit...), nil, 0, 0}
+}
+
+// mathTokens returns the tokens of a node, where nested sums and products are enclosed in parentheses.
+func (c *cssMinifier) mathTokens(n mathNode, nested bool) []Token {
+	space := Token{css.WhitespaceToken, spaceBytes, nil, 0, 0}
+	switch n.op {
+	case mathValue:
+		return []Token{c.mathValueToken(n)}
+	case mathOpaque:
+		return []Token{n.token}
+	case mathFunction:
+		args := []Token{}
+		for i, node := range n.nodes {
+			if i != 0 {
+				args = append(args, Token{css.CommaToken, commaBytes, nil, 0, 0})
+			}
+			args = append(args, c.mathTokens(node, false)...)
+		}
+		n.token.Args = args
+		return []Token{n.token}
+	}
+
+	tokens := []Token{}
+	if nested {
+		tokens = append(tokens, Token{css.LeftParenthesisToken, []byte("("), nil, 0, 0})
+	}
+	for i, node := range n.nodes {
+		if n.op == mathSum {
+			if node.op == mathValue && math.Signbit(node.num) && i != 0 {
+				node.num, node.inv = -node.num, !node.inv
+			}
+			if i == 0 && node.inv {
+				tokens = append(tokens, Token{css.NumberToken, []byte("-1"), nil, 0, 0}, Token{css.DelimToken, []byte("*"), nil, 0, 0})
+			} else if i != 0 && node.inv {
+				tokens = append(tokens, space, Token{css.DelimToken, []byte("-"), nil, 0, 0}, space)
+			} else if i != 0 {
+				tokens = append(tokens, space, Token{css.DelimToken, []byte("+"), nil, 0, 0}, space)
+			}
+			tokens = append(tokens, c.mathTokens(node, node.op == mathSum)...)
+		} else {
+			if node.inv {
+				tokens = append(tokens, Token{css.DelimToken, []byte("/"), nil, 0, 0})
+			} else if i != 0 {
+				tokens = append(tokens, Token{css.DelimToken, []byte("*"), nil, 0, 0})
+			}
+			tokens = append(tokens, c.mathTokens(node, node.op == mathSum || node.op == mathProduct)...)
+		}
+	}
+	if nested {
+		tokens = append(tokens, Token{css.RightParenthesisToken, rightParenBytes, nil, 0, 0})
+	}
+	return tokens
+}
+
+// isExact returns true if the values of the expression tree have no more significant digits than the precision, apart from rounding errors of floating-point arithmetic, so that folding them loses nothing.
+func (c *cssMinifier) isExact(n mathNode) bool {
+	if n.op == mathValue {
+		precision := c.o.newPrecision
+		if 12 < precision {
+			precision = 12 // leave room for rounding errors such as in 0.1+0.2
+		}
+		return strconv.FormatFloat(n.num, 'g', precision, 64) == strconv.FormatFloat(n.num, 'g', 15, 64)
+	}
+	for _, node := range n.nodes {
+		if !c.isExact(node) {
+			return false
+		}
+	}
+	return true
+}
+
+// mathToken returns the token of an expression tree of the math function t.
+func (c *cssMinifier) mathToken(n mathNode, t Token) Token {
+	if n.op == mathValue && 0.0 <= n.num && (n.unit != "" || n.num == math.Trunc(n.num)) {
+		// negative values are clamped by calc() but may be invalid otherwise, and numbers may need to be integers
+		return c.mathValueToken(n)
+	} else if n.op == mathFunction {
+		return c.mathTokens(n, false)[0]
+	}
+	calc := Token{css.FunctionToken, t.Data, c.mathTokens(n, false), Calc, 0}
+	if t.Fun != Calc {
+		calc.Data = []byte("calc(")
+	}
+	return calc
+}
+
+// minifyMath simplifies calc(), min(), max(), and clamp(), it returns false if the expression could not be parsed. Values are only folded when the result is exact and not longer.
+func (c *cssMinifier) minifyMath(prop Hash, t Token) (Token, bool) {
+	p := mathParser{c: c, prop: prop, args: t.Args}
+	var n mathNode
+	var ok bool
+	if t.Fun == Calc {
+		n, ok = p.parseCalc()
+	} else {
+		n, ok = p.parseFunction(t)
+	}
+	if !ok {
+		return t, false
+	}
+	simplified, ok := n.simplify()
+	if !ok {
+		return t, false
+	}
+
+	original := c.mathToken(n, t)
+	if !c.isExact(simplified) {
+		return original, true
+	} else if token := c.mathToken(simplified, t); tokenLength(token) <= tokenLength(original) {
+		return token, true
+	}
+	return original, true
+}
@@ -0,0 +1,336 @@
+package css
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+)
+
+var keyframesBytes = []byte("keyframes")
+
+// mergeablePseudos are the pseudo-classes and pseudo-elements of CSS2 and Selectors Level 3, which all browsers support.
+var mergeablePseudos = map[string]bool{
+	"link":             true,
+	"visited":          true,
+	"hover":            true,
+	"active":           true,
+	"focus":            true,
+	"target":           true,
+	"lang":             true,
+	"enabled":          true,
+	"disabled":         true,
+	"checked":          true,
+	"root":             true,
+	"nth-child":        true,
+	"nth-last-child":   true,
+	"nth-of-type":      true,
+	"nth-last-of-type": true,
+	"first-child":      true,
+	"last-child":       true,
+	"first-of-type":    true,
+	"last-of-type":     true,
+	"only-child":       true,
+	"only-of-type":     true,
+	"empty":            true,
+	"not":              true,
+	"first-line":       true,
+	"first-letter":     true,
+	"before":           true,
+	"after":            true,
+}
+
+// ruleType is the type of a node of a buffered stylesheet.
+type ruleType int
+
+const (
+	declarationRule ruleType = iota // property and value
+	rulesetRule                     // selectors and declarations
+	blockRule                       // at-rule with a block of declarations or rules
+	statementRule                   // at-rule without a block, or a comment
+	tokenRule                       // token in a block that is not parsed
+)
+
+// rule is a node of a buffered stylesheet, which holds the minified data.
+type rule struct {
+	typ       ruleType
+	data      []byte      // property, at-rule name, or the at-rule statement or comment
+	values    []css.Token // value of a declaration or prelude of an at-rule
+	selectors [][]byte    // selectors of a ruleset
+	rules     []*rule     // declarations and rules in the block of rulesets and at-rules
+}
+
+// parseRules parses the minified stylesheet into a tree of rules. It returns false if there are parse errors.
+func parseRules(b []byte, isInline bool) ([]*rule, bool) {
+	input := parse.NewInputBytes(b)
+	var p grammarParser = css.NewParser(input, isInline)
+	if !isInline && bytes.Contains(b, []byte("/*")) {
+		// the minified stylesheet only has kept comments
+		p = newCommentParser(p, input.Bytes(), func([]byte) bool { return true })
+	}
+	root := &rule{}
+	stack := []*rule{root}
+	var selectors [][]byte
+	for {
+		gt, _, data := p.Next()
+		parent := stack[len(stack)-1]
+		switch gt {
+		case css.ErrorGrammar:
+			return root.rules, p.Err() == io.EOF && !p.HasParseError() && len(stack) == 1
+		case css.EndAtRuleGrammar, css.EndRulesetGrammar:
+			if len(stack) == 1 {
+				return nil, false
+			}
+			stack = stack[:len(stack)-1]
+		case css.AtRuleGrammar, css.CommentGrammar:
+			if gt == css.AtRuleGrammar {
+				data = append(data[:len(data):len(data)], joinTokens(p.Values())...)
+			}
+			parent.rules = append(parent.rules, &rule{typ: statementRule, data: data})
+		case css.BeginAtRuleGrammar:
+			r := &rule{typ: blockRule, data: data, values: copyTokens(p.Values())}
+			parent.rules = append(parent.rules, r)
+			stack = append(stack, r)
+		case css.QualifiedRuleGrammar:
+			selectors = append(selectors, joinTokens(p.Values()))
+		case css.BeginRulesetGrammar:
+			r := &rule{typ: rulesetRule, selectors: append(selectors, joinTokens(p.Values()))}
+			parent.rules = append(parent.rules, r)
+			stack = append(stack, r)
+			selectors = nil
+		case css.DeclarationGrammar, css.CustomPropertyGrammar:
+			parent.rules = append(parent.rules, &rule{typ: declarationRule, data: data, values: copyTokens(p.Values())})
+		case css.TokenGrammar:
+			parent.rules = append(parent.rules, &rule{typ: tokenRule, data: data})
+		default:
+			return nil, false
+		}
+	}
+}
+
+func copyTokens(values []css.Token) []css.Token {
+	return append([]css.Token{}, values...)
+}
+
+func joinTokens(values []css.Token) []byte {
+	b := []byte{}
+	for _, val := range values {
+		b = append(b, val.Data...)
+	}
+	return b
+}
+
+// writeRules writes the tree of rules in minified form.
+func writeRules(w io.Writer, rules []*rule) {
+	for i, r := range rules {
+		switch r.typ {
+		case declarationRule:
+			w.Write(r.data)
+			w.Write(colonBytes)
+			for _, val := range r.values {
+				w.Write(val.Data)
+			}
+		case rulesetRule:
+			for j, selector := range r.selectors {
+				if j != 0 {
+					w.Write(commaBytes)
+				}
+				w.Write(selector)
+			}
+			w.Write(leftBracketBytes)
+			writeRules(w, r.rules)
+			w.Write(rightBracketBytes)
+		case blockRule:
+			w.Write(r.data)
+			for _, val := range r.values {
+				w.Write(val.Data)
+			}
+			w.Write(leftBracketBytes)
+			writeRules(w, r.rules)
+			w.Write(rightBracketBytes)
+		case statementRule, tokenRule:
+			w.Write(r.data)
+		}
+		if (r.typ == declarationRule || r.typ == statementRule && r.data[1] != '*') && i+1 < len(rules) {
+			w.Write(semicolonBytes)
+		}
+	}
+}
+
+// value returns the value of the declaration without !important.
+func (r *rule) value() ([]css.Token, bool) {
+	n := len(r.values)
+	if 1 < n && r.values[n-2].TokenType == css.DelimToken && r.values[n-2].Data[0] == '!' && ToHash(r.values[n-1].Data) == Important {
+		return r.values[:n-2], true
+	}
+	return r.values, false
+}
+
+// dimensionUnit returns the unit of a dimension.
+func dimensionUnit(data []byte) []byte {
+	i := 0
+	for i < len(data) && (data[i] == '+' || data[i] == '-' || data[i] == '.' || '0' <= data[i] && data[i] <= '9') {
+		i++
+	}
+	if i+1 < len(data) && (data[i] == 'e' || data[i] == 'E') && (data[i+1] == '+' || data[i+1] == '-' || '0' <= data[i+1] && data[i+1] <= '9') {
+		i += 2
+		for i < len(data) && '0' <= data[i] && data[i] <= '9' {
+			i++
+		}
+	}
+	return data[i:]
+}
+
+// overrides returns true if the value of the declaration is understood by every browser that understands the value of the other declaration of the same property, so that the other declaration cannot be a fallback. Values are compared by their type and units, and values with functions, vendor prefixes or hacks may always be fallbacks.
+func (r *rule) overrides(other *rule) bool {
+	if r.data[0] == '-' && r.data[1] == '-' {
+		return true // custom property
+	}
+	a, _ := r.value()
+	b, _ := other.value()
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].TokenType != b[i].TokenType || a[i].TokenType == css.FunctionToken || a[i].TokenType == css.IdentToken && (a[i].Data[0] == '-' || b[i].Data[0] == '-') {
+			return false
+		} else if bytes.IndexByte(a[i].Data, '\\') != -1 || bytes.IndexByte(b[i].Data, '\\') != -1 {
+			return false
+		} else if a[i].TokenType == css.DimensionToken && !parse.EqualFold(dimensionUnit(a[i].Data), dimensionUnit(b[i].Data)) {
+			return false
+		}
+	}
+	return true
+}
+
+// removeOverridden removes declarations that are overridden by another declaration of the same property in the block, respecting !important.
+func removeOverridden(rules []*rule) []*rule {
+	removed := make([]bool, len(rules))
+	for i, r := range rules {
+		if r.typ != declarationRule {
+			continue
+		}
+		_, important := r.value()
+		for j := i + 1; j < len(rules); j++ {
+			s := rules[j]
+			if removed[j] || s.typ != declarationRule || !bytes.Equal(r.data, s.data) {
+				continue
+			}
+			if _, laterImportant := s.value(); important && !laterImportant {
+				// the earlier declaration wins
+				if r.overrides(s) {
+					removed[j] = true
+				}
+			} else if s.overrides(r) {
+				removed[i] = true
+				break
+			}
+		}
+	}
+
+	list := rules[:0]
+	for i, r := range rules {
+		if !removed[i] {
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+// isMergeableSelector returns true if the selectors have only pseudo-classes and pseudo-elements that all browsers support. An unsupported selector, such as :focus-visible or a vendor-prefixed one, invalidates the whole selector list in browsers that do not support it.
+func isMergeableSelector(selectors [][]byte) bool {
+	for _, selector := range selectors {
+		var quote byte
+		for i := 0; i < len(selector); i++ {
+			if c := selector[i]; c == '\\' {
+				i++
+			} else if quote != 0 {
+				if c == quote {
+					quote = 0
+				}
+			} else if c == '"' || c == '\'' {
+				quote = c
+			} else if c == ':' {
+				i++
+				if i < len(selector) && selector[i] == ':' {
+					i++
+				}
+				j := i
+				for j < len(selector) && (selector[j] == '-' || selector[j] == '_' || 'a' <= selector[j] && selector[j] <= 'z' || 'A' <= selector[j] && selector[j] <= 'Z' || '0' <= selector[j] && selector[j] <= '9' || 0x80 <= selector[j]) {
+					j++
+				}
+				if !mergeablePseudos[string(parse.ToLower(parse.Copy(selector[i:j])))] {
+					return false
+				}
+				i = j - 1
+			}
+		}
+	}
+	return true
+}
+
+// equalRules returns true if both lists of declarations are equal.
+func equalRules(a, b []*rule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].typ != declarationRule || b[i].typ != declarationRule || !bytes.Equal(a[i].data, b[i].data) || !bytes.Equal(joinTokens(a[i].values), joinTokens(b[i].values)) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalSelectors returns true if both selector lists are equal.
+func equalSelectors(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendSelectors appends the selectors that are not yet in the list.
+func appendSelectors(selectors, others [][]byte) [][]byte {
+Next:
+	for _, other := range others {
+		for _, selector := range selectors {
+			if bytes.Equal(selector, other) {
+				continue Next
+			}
+		}
+		selectors = append(selectors, other)
+	}
+	return selectors
+}
+
+// mergeRules merges adjacent rulesets with equal selectors or equal declarations, and removes overridden declarations in rulesets. Rulesets are never reordered so that the cascade is retained.
+func mergeRules(rules []*rule) []*rule {
+	list := rules[:0]
+	for _, r := range rules {
+		if r.typ == rulesetRule {
+			r.rules = removeOverridden(r.rules)
+		} else if r.typ == blockRule && !isKeyframes(r) {
+			r.rules = mergeRules(r.rules)
+		}
+
+		if 0 < len(list) && r.typ == rulesetRule && list[len(list)-1].typ == rulesetRule {
+			prev := list[len(list)-1]
+			if equalSelectors(prev.selectors, r.selectors) {
+				prev.rules = removeOverridden(append(prev.rules, r.rules...))
+				continue
+			} else if equalRules(prev.rules, r.rules) && isMergeableSelector(prev.selectors) && isMergeableSelector(r.selectors) {
+				prev.selectors = appendSelectors(prev.selectors, r.selectors)
+				continue
+			}
+		}
+		list = append(list, r)
+	}
+	return list
+}
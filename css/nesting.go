@@ -0,0 +1,741 @@
+package css
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+)
+
+// groupRules are the at-rules that contain rules, and that contain declarations as well when nested in a style rule.
+var groupRules = map[string]bool{
+	"media":          true,
+	"supports":       true,
+	"container":      true,
+	"layer":          true,
+	"scope":          true,
+	"starting-style": true,
+	"document":       true,
+	"-moz-document":  true,
+}
+
+// nestedRule is a rule or declaration of a stylesheet that may contain nested style rules, which the parser does not support.
+type nestedRule struct {
+	raw     []byte       // original text
+	prelude []byte       // selectors, or at-rule keyword and prelude, nil for declarations and other text
+	decl    bool         // declaration within a style rule
+	at      bool         // group rule such as @media
+	rules   []nestedRule // contents of the block
+}
+
+type nestingParser struct {
+	tokens []css.Token
+	i      int
+}
+
+// hasNestedBlocks returns true if the stylesheet contains blocks within blocks, which is necessary for nested style rules.
+func hasNestedBlocks(b []byte) bool {
+	depth := 0
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '{':
+			if depth++; 1 < depth {
+				return true
+			}
+		case '}':
+			depth--
+		case '"', '\'':
+			for quote := b[i]; i+1 < len(b) && b[i+1] != quote; i++ {
+				if b[i+1] == '\\' {
+					i++
+				}
+			}
+			i++
+		case '/':
+			if i+1 < len(b) && b[i+1] == '*' {
+				if end := bytes.Index(b[i+2:], []byte("*/")); end != -1 {
+					i += end + 3
+				} else {
+					i = len(b)
+				}
+			}
+		}
+	}
+	return false
+}
+
+// parseNesting parses a stylesheet into rules and the declarations and rules nested in them.
+func parseNesting(b []byte) []nestedRule {
+	l := css.NewLexer(parse.NewInputBytes(b))
+	p := &nestingParser{}
+	for {
+		tt, data := l.Next()
+		if tt == css.ErrorToken {
+			break
+		}
+		p.tokens = append(p.tokens, css.Token{TokenType: tt, Data: data})
+	}
+	return p.parseRules()
+}
+
+func (p *nestingParser) text(start, end int) []byte {
+	b := []byte{}
+	for _, t := range p.tokens[start:end] {
+		b = append(b, t.Data...)
+	}
+	return b
+}
+
+// scanPrelude returns the position of the first {, ;, or } that is not enclosed in parentheses or brackets.
+func (p *nestingParser) scanPrelude(i int) int {
+	level := 0
+	for ; i < len(p.tokens); i++ {
+		switch p.tokens[i].TokenType {
+		case css.FunctionToken, css.LeftParenthesisToken, css.LeftBracketToken:
+			level++
+		case css.RightParenthesisToken, css.RightBracketToken:
+			if 0 < level {
+				level--
+			}
+		case css.LeftBraceToken, css.SemicolonToken, css.RightBraceToken:
+			if level == 0 {
+				return i
+			}
+		}
+	}
+	return i
+}
+
+// scanDeclaration returns the position of the first ; or } that ends a custom property, which may contain blocks.
+func (p *nestingParser) scanDeclaration(i int) int {
+	level := 0
+	for ; i < len(p.tokens); i++ {
+		switch p.tokens[i].TokenType {
+		case css.FunctionToken, css.LeftParenthesisToken, css.LeftBracketToken, css.LeftBraceToken:
+			level++
+		case css.RightParenthesisToken, css.RightBracketToken:
+			if 0 < level {
+				level--
+			}
+		case css.RightBraceToken:
+			if level == 0 {
+				return i
+			}
+			level--
+		case css.SemicolonToken:
+			if level == 0 {
+				return i
+			}
+		}
+	}
+	return i
+}
+
+// skipBlock skips the block or semicolon at the current position.
+func (p *nestingParser) skipBlock() {
+	if len(p.tokens) <= p.i {
+		return
+	} else if p.tokens[p.i].TokenType != css.LeftBraceToken {
+		if p.tokens[p.i].TokenType == css.SemicolonToken {
+			p.i++
+		}
+		return
+	}
+	level := 0
+	for ; p.i < len(p.tokens); p.i++ {
+		if tt := p.tokens[p.i].TokenType; tt == css.LeftBraceToken {
+			level++
+		} else if tt == css.RightBraceToken {
+			if level--; level == 0 {
+				p.i++
+				return
+			}
+		}
+	}
+}
+
+func (p *nestingParser) isGroupRule(i int) bool {
+	return p.tokens[i].TokenType == css.AtKeywordToken && groupRules[string(parse.ToLower(parse.Copy(p.tokens[i].Data[1:])))]
+}
+
+// parseRules parses the rules of a stylesheet or group rule, until the closing brace.
+func (p *nestingParser) parseRules() []nestedRule {
+	rules := []nestedRule{}
+	for p.i < len(p.tokens) {
+		start := p.i
+		tt := p.tokens[p.i].TokenType
+		if tt == css.RightBraceToken {
+			break
+		} else if tt == css.WhitespaceToken || tt == css.CommentToken || tt == css.CDOToken || tt == css.CDCToken {
+			p.i++
+			rules = append(rules, nestedRule{raw: p.text(start, p.i)})
+			continue
+		}
+
+		end := p.scanPrelude(p.i)
+		if end == len(p.tokens) || p.tokens[end].TokenType != css.LeftBraceToken || tt == css.AtKeywordToken && !p.isGroupRule(start) {
+			p.i = end
+			p.skipBlock()
+			if p.i == start {
+				p.i++ // stray closing brace or semicolon
+			}
+			rules = append(rules, nestedRule{raw: p.text(start, p.i)})
+			continue
+		}
+
+		p.i = end + 1
+		rule := nestedRule{prelude: p.text(start, end), at: tt == css.AtKeywordToken}
+		if rule.at {
+			rule.rules = p.parseRules()
+		} else {
+			rule.rules = p.parseBlock()
+		}
+		if p.i < len(p.tokens) {
+			p.i++ // closing brace
+		}
+		rule.raw = p.text(start, p.i)
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// parseBlock parses the declarations and nested rules of a style rule, until the closing brace.
+func (p *nestingParser) parseBlock() []nestedRule {
+	rules := []nestedRule{}
+	for p.i < len(p.tokens) {
+		start := p.i
+		tt := p.tokens[p.i].TokenType
+		if tt == css.RightBraceToken {
+			break
+		} else if tt == css.WhitespaceToken || tt == css.CommentToken || tt == css.SemicolonToken {
+			p.i++
+			continue
+		}
+
+		// custom properties may contain blocks, other declarations end before any block, which starts a nested rule such as a:hover{}
+		j := p.i + 1
+		for j < len(p.tokens) && p.tokens[j].TokenType == css.WhitespaceToken {
+			j++
+		}
+		isCustomProperty := tt == css.CustomPropertyNameToken && j < len(p.tokens) && p.tokens[j].TokenType == css.ColonToken
+
+		var end int
+		if isCustomProperty {
+			end = p.scanDeclaration(p.i)
+		} else {
+			end = p.scanPrelude(p.i)
+		}
+		if end == len(p.tokens) || p.tokens[end].TokenType != css.LeftBraceToken {
+			p.i = end
+			rules = append(rules, nestedRule{raw: p.text(start, end), decl: true})
+			continue
+		} else if tt == css.AtKeywordToken && parse.EqualFold(p.tokens[start].Data[1:], []byte("nest")) {
+			// @nest .b &{} of an earlier draft equals .b &{}
+			start++
+			for start < end && p.tokens[start].TokenType == css.WhitespaceToken {
+				start++
+			}
+			tt = p.tokens[start].TokenType
+		} else if tt == css.AtKeywordToken && !p.isGroupRule(start) {
+			p.i = end
+			p.skipBlock()
+			rules = append(rules, nestedRule{raw: p.text(start, p.i)})
+			continue
+		}
+
+		p.i = end + 1
+		rule := nestedRule{prelude: p.text(start, end), at: tt == css.AtKeywordToken}
+		rule.rules = p.parseBlock()
+		if p.i < len(p.tokens) {
+			p.i++ // closing brace
+		}
+		rule.raw = p.text(start, p.i)
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// hasNesting returns true if any style rule contains nested rules.
+func hasNesting(rules []nestedRule) bool {
+	for _, rule := range rules {
+		if rule.at && hasNesting(rule.rules) {
+			return true
+		} else if !rule.at && rule.prelude != nil {
+			for _, item := range rule.rules {
+				if !item.decl {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////
+
+// splitSelectors returns the complex selectors of a selector list, without surrounding whitespace.
+func splitSelectors(prelude []byte) [][]css.Token {
+	l := css.NewLexer(parse.NewInputBytes(prelude))
+	selectors := [][]css.Token{{}}
+	level := 0
+	for {
+		tt, data := l.Next()
+		if tt == css.ErrorToken {
+			break
+		} else if tt == css.FunctionToken || tt == css.LeftParenthesisToken {
+			level++
+		} else if tt == css.RightParenthesisToken {
+			level--
+		} else if tt == css.CommaToken && level == 0 {
+			selectors = append(selectors, []css.Token{})
+			continue
+		} else if tt == css.CommentToken {
+			continue
+		}
+		selectors[len(selectors)-1] = append(selectors[len(selectors)-1], css.Token{TokenType: tt, Data: data})
+	}
+	for i, selector := range selectors {
+		for 0 < len(selector) && selector[0].TokenType == css.WhitespaceToken {
+			selector = selector[1:]
+		}
+		for 0 < len(selector) && selector[len(selector)-1].TokenType == css.WhitespaceToken {
+			selector = selector[:len(selector)-1]
+		}
+		selectors[i] = selector
+	}
+	return selectors
+}
+
+func isNestingSelector(t css.Token) bool {
+	return t.TokenType == css.DelimToken && t.Data[0] == '&'
+}
+
+// resolveSelectors replaces the nesting selector & of nested selectors by their parent selectors, or prepends the parent selectors when absent. When the targets support :is(), the parents are expanded only when that keeps the matched elements and the specificity, such as for a compound parent or a complex parent at the start of the selector, and are wrapped in :is() otherwise. When the targets do not support :is(), the selectors are expanded for each combination of parents.
+func resolveSelectors(prelude []byte, parents [][]byte, features feature) ([][]byte, error) {
+	selectors := [][]byte{}
+	for _, selector := range splitSelectors(prelude) {
+		if parents == nil {
+			b := []byte{}
+			for _, t := range selector {
+				b = append(b, t.Data...)
+			}
+			selectors = append(selectors, b)
+			continue
+		}
+
+		n, level, nested := 0, 0, false
+		for _, t := range selector {
+			if t.TokenType == css.FunctionToken || t.TokenType == css.LeftParenthesisToken {
+				level++
+			} else if t.TokenType == css.RightParenthesisToken {
+				level--
+			} else if isNestingSelector(t) {
+				n++
+				nested = nested || 0 < level
+			}
+		}
+		is := append(append([]byte(":is("), bytes.Join(parents, commaBytes)...), ')')
+		if n == 0 {
+			if features&isSelector != 0 && !sameSpecificity(parents) {
+				selectors = append(selectors, append(append(is, ' '), tokensText(selector)...))
+				continue
+			}
+			for _, parent := range parents {
+				b := append(append(parse.Copy(parent), ' '), tokensText(selector)...)
+				selectors = append(selectors, b)
+			}
+			continue
+		} else if features&isSelector == 0 {
+			expanded, err := expandNestingSelector(selector, parents, n)
+			if err != nil {
+				return nil, err
+			}
+			selectors = append(selectors, expanded...)
+			continue
+		}
+
+		if 1 < len(parents) && (1 < n || nested || !sameSpecificity(parents)) {
+			selectors = append(selectors, replaceNestingSelector(selector, func(bool, bool) []byte { return is }))
+			continue
+		}
+		for _, parent := range parents {
+			complex := isComplexSelector(parent)
+			typed := isTypeSelector(parent)
+			selectors = append(selectors, replaceNestingSelector(selector, func(leading, compound bool) []byte {
+				if compound && (complex || typed) || complex && !leading {
+					// .c & for .a .b is not .c .a .b, which requires .c to be an ancestor of .a
+					return append(append([]byte(":is("), parent...), ')')
+				}
+				return parent
+			}))
+		}
+	}
+	return selectors, nil
+}
+
+// expandNestingSelector replaces the n nesting selectors & of a nested selector by each combination of parent selectors, for targets that do not support :is(). A parent is merged into the compound selector of &, such as div for .b& resulting in div.b. It returns an error when the selector cannot be written without :is(), such as & within :not() for several parents.
+func expandNestingSelector(selector []css.Token, parents [][]byte, n int) ([][]byte, error) {
+	selectors := [][]byte{}
+	combination := make([]int, n)
+	for {
+		b := []byte{}
+		k, level, start, first := 0, 0, 0, true
+		for _, t := range selector {
+			if t.TokenType == css.FunctionToken || t.TokenType == css.LeftParenthesisToken || t.TokenType == css.LeftBracketToken {
+				level++
+			} else if t.TokenType == css.RightParenthesisToken || t.TokenType == css.RightBracketToken {
+				level--
+			}
+			if !isNestingSelector(t) {
+				b = append(b, t.Data...)
+				if level == 0 && (t.TokenType == css.WhitespaceToken || t.TokenType == css.DelimToken && (t.Data[0] == '>' || t.Data[0] == '+' || t.Data[0] == '~')) {
+					start, first = len(b), false
+				}
+				continue
+			}
+
+			parent := parents[combination[k]]
+			k++
+			if 0 < level {
+				if 1 < len(parents) || isComplexSelector(parent) {
+					return nil, fmt.Errorf("cannot flatten nesting selector without :is(): %s", tokensText(selector))
+				}
+				b = append(b, parent...)
+			} else if start < len(b) {
+				// & follows other simple selectors in its compound selector
+				compound := parse.Copy(b[start:])
+				b = b[:start]
+				if isComplexSelector(parent) {
+					if !first {
+						return nil, fmt.Errorf("cannot flatten nesting selector without :is(): %s", tokensText(selector))
+					}
+					i := bytes.LastIndexAny(parent, " >+~") + 1
+					b = append(b, parent[:i]...)
+					parent = parent[i:]
+				}
+				if !isTypeSelector(parent) {
+					b = append(append(b, compound...), parent...)
+				} else if !isTypeSelector(compound) {
+					b = append(append(b, parent...), compound...)
+				} else {
+					return nil, fmt.Errorf("cannot flatten nesting selector without :is(): %s", tokensText(selector))
+				}
+			} else {
+				b = append(b, parent...)
+			}
+		}
+		selectors = append(selectors, b)
+
+		i := n - 1
+		for ; 0 <= i; i-- {
+			if combination[i]++; combination[i] < len(parents) {
+				break
+			}
+			combination[i] = 0
+		}
+		if i < 0 {
+			return selectors, nil
+		}
+	}
+}
+
+// isComplexSelector returns true if the selector contains combinators.
+func isComplexSelector(selector []byte) bool {
+	return bytes.ContainsAny(selector, " >+~")
+}
+
+// isTypeSelector returns true if the selector starts with a type or universal selector.
+func isTypeSelector(selector []byte) bool {
+	return 0 < len(selector) && selector[0] != '.' && selector[0] != '#' && selector[0] != '[' && selector[0] != ':'
+}
+
+// replaceNestingSelector replaces each & by the given replacement, which is told whether & starts the selector or an argument of a functional pseudo-class, and whether & follows other simple selectors in its compound selector.
+func replaceNestingSelector(selector []css.Token, replacement func(bool, bool) []byte) []byte {
+	b := []byte{}
+	for i, t := range selector {
+		if isNestingSelector(t) {
+			leading, compound := true, false
+			if 0 < i {
+				prev := selector[i-1]
+				leading = prev.TokenType == css.CommaToken || prev.TokenType == css.FunctionToken || prev.TokenType == css.LeftParenthesisToken
+				compound = !leading && prev.TokenType != css.WhitespaceToken && (prev.TokenType != css.DelimToken || prev.Data[0] != '>' && prev.Data[0] != '+' && prev.Data[0] != '~')
+			}
+			b = append(b, replacement(leading, compound)...)
+		} else {
+			b = append(b, t.Data...)
+		}
+	}
+	return b
+}
+
+// sameSpecificity returns true if the selectors have the same specificity, so that :is() of the selectors can be expanded into a selector list.
+func sameSpecificity(selectors [][]byte) bool {
+	first, ok := specificity(splitSelectors(selectors[0])[0])
+	if !ok {
+		return false
+	}
+	for _, selector := range selectors[1:] {
+		if spec, ok := specificity(splitSelectors(selector)[0]); !ok || spec != first {
+			return false
+		}
+	}
+	return true
+}
+
+// specificity returns the number of ID selectors, of class, attribute, and pseudo-class selectors, and of type and pseudo-element selectors of a complex selector. It returns false for functional pseudo-classes other than :is(), :not(), :has(), and :where().
+func specificity(selector []css.Token) ([3]int, bool) {
+	spec := [3]int{}
+	for i := 0; i < len(selector); i++ {
+		switch t := selector[i]; t.TokenType {
+		case css.HashToken:
+			spec[0]++
+		case css.IdentToken:
+			spec[2]++
+		case css.DelimToken:
+			if t.Data[0] == '.' {
+				spec[1]++
+				i++ // class name
+			}
+		case css.LeftBracketToken:
+			spec[1]++
+			for i < len(selector) && selector[i].TokenType != css.RightBracketToken {
+				i++
+			}
+		case css.ColonToken:
+			if i+1 < len(selector) && selector[i+1].TokenType == css.ColonToken {
+				spec[2]++
+				i += 2 // pseudo-element name
+			} else if i+1 < len(selector) && selector[i+1].TokenType == css.IdentToken {
+				i++
+				switch string(parse.ToLower(parse.Copy(selector[i].Data))) {
+				case "before", "after", "first-line", "first-letter":
+					spec[2]++
+				default:
+					spec[1]++
+				}
+			} else if i+1 < len(selector) && selector[i+1].TokenType == css.FunctionToken {
+				i++
+				name := string(parse.ToLower(parse.Copy(selector[i].Data)))
+				start, level := i+1, 1
+				for i++; i < len(selector); i++ {
+					if selector[i].TokenType == css.FunctionToken || selector[i].TokenType == css.LeftParenthesisToken {
+						level++
+					} else if selector[i].TokenType == css.RightParenthesisToken {
+						if level--; level == 0 {
+							break
+						}
+					}
+				}
+				if name == "where(" {
+					continue
+				} else if name != "is(" && name != "not(" && name != "has(" || len(selector) <= i {
+					return spec, false
+				}
+				max := [3]int{}
+				for _, arg := range splitSelectors(tokensText(selector[start:i])) {
+					argSpec, ok := specificity(arg)
+					if !ok {
+						return spec, false
+					} else if max[0] < argSpec[0] || max[0] == argSpec[0] && (max[1] < argSpec[1] || max[1] == argSpec[1] && max[2] < argSpec[2]) {
+						max = argSpec
+					}
+				}
+				spec[0] += max[0]
+				spec[1] += max[1]
+				spec[2] += max[2]
+			}
+		case css.FunctionToken:
+			return spec, false
+		}
+	}
+	return spec, true
+}
+
+func tokensText(tokens []css.Token) []byte {
+	b := []byte{}
+	for _, t := range tokens {
+		b = append(b, t.Data...)
+	}
+	return b
+}
+
+// flattenNesting writes the stylesheet with the nested rules moved to the top level, with their selectors resolved against their parents.
+func flattenNesting(w *bytes.Buffer, rules []nestedRule, features feature) error {
+	for _, rule := range rules {
+		if rule.at {
+			w.Write(rule.prelude)
+			w.Write(leftBracketBytes)
+			if err := flattenNesting(w, rule.rules, features); err != nil {
+				return err
+			}
+			w.Write(rightBracketBytes)
+		} else if rule.prelude != nil {
+			selectors, _ := resolveSelectors(rule.prelude, nil, features)
+			if err := flattenBlock(w, selectors, rule.rules, features); err != nil {
+				return err
+			}
+		} else {
+			w.Write(rule.raw)
+		}
+	}
+	return nil
+}
+
+// flattenBlock writes the declarations of a style rule followed by its nested rules, in order, so that declarations after nested rules are written in a separate style rule.
+func flattenBlock(w *bytes.Buffer, selectors [][]byte, rules []nestedRule, features feature) error {
+	decls := [][]byte{}
+	flush := func() {
+		if 0 < len(decls) {
+			w.Write(bytes.Join(selectors, commaBytes))
+			w.Write(leftBracketBytes)
+			w.Write(bytes.Join(decls, semicolonBytes))
+			w.Write(rightBracketBytes)
+			decls = decls[:0]
+		}
+	}
+	for _, rule := range rules {
+		if rule.decl {
+			decls = append(decls, rule.raw)
+			continue
+		}
+		flush()
+		if rule.at {
+			w.Write(rule.prelude)
+			w.Write(leftBracketBytes)
+			if err := flattenBlock(w, selectors, rule.rules, features); err != nil {
+				return err
+			}
+			w.Write(rightBracketBytes)
+		} else if rule.prelude != nil {
+			nested, err := resolveSelectors(rule.prelude, selectors, features)
+			if err != nil {
+				return err
+			}
+			if err := flattenBlock(w, nested, rule.rules, features); err != nil {
+				return err
+			}
+		} else {
+			w.Write(rule.raw)
+		}
+	}
+	flush()
+	return nil
+}
+
+////////////////////////////////////////////////////////////////
+
+// minifyFragment minifies part of a stylesheet, or a list of declarations when inline.
+func (c *cssMinifier) minifyFragment(b []byte, isInline bool) []byte {
+	buf := &bytes.Buffer{}
+	if len(parse.TrimWhitespace(b)) == 0 {
+		return nil
+	}
+	sub := &cssMinifier{
+		m: c.m,
+		w: buf,
+		p: css.NewParser(parse.NewInputBytes(b), isInline),
+		o: c.o,
+
+		renames:  c.renames,
+		features: c.features,
+	}
+	sub.minifyGrammar()
+	if sub.err != nil && c.err == nil {
+		c.err = sub.err
+	}
+	return buf.Bytes()
+}
+
+// minifyPrelude minifies the selectors or at-rule prelude of a rule with nested rules.
+func (c *cssMinifier) minifyPrelude(prelude []byte) []byte {
+	b := c.minifyFragment(append(parse.Copy(prelude), "{}"...), false)
+	return bytes.TrimSuffix(b, []byte("{}"))
+}
+
+// trimNestingSelectors removes a leading & from nested selectors, since relative selectors are relative to & by default (& .b equals .b).
+func trimNestingSelectors(prelude []byte) []byte {
+	selectors := [][]byte{}
+	for _, selector := range splitSelectors(prelude) {
+		n := 0
+		for _, t := range selector {
+			if isNestingSelector(t) {
+				n++
+			}
+		}
+		if n == 1 && 2 < len(selector) && isNestingSelector(selector[0]) && selector[1].TokenType == css.WhitespaceToken {
+			selector = selector[2:]
+		}
+		selectors = append(selectors, tokensText(selector))
+	}
+	return bytes.Join(selectors, commaBytes)
+}
+
+// minifyNesting writes a stylesheet or group rule that contains nested rules, where the rules without nesting are minified as usual.
+func (c *cssMinifier) minifyNesting(rules []nestedRule) {
+	chunk := []byte{}
+	for _, rule := range rules {
+		if !hasNesting([]nestedRule{rule}) {
+			chunk = append(chunk, rule.raw...)
+			continue
+		}
+		if b := c.minifyFragment(chunk, false); 0 < len(b) {
+			c.w.Write(b)
+			if b[len(b)-1] != '}' && b[len(b)-1] != ';' && !bytes.HasSuffix(b, []byte("*/")) {
+				// the fragment ends in a statement at-rule such as @import, whose semicolon is omitted at the end of a stylesheet
+				c.w.Write(semicolonBytes)
+			}
+		}
+		chunk = chunk[:0]
+
+		c.w.Write(c.minifyPrelude(rule.prelude))
+		c.w.Write(leftBracketBytes)
+		if rule.at {
+			c.minifyNesting(rule.rules)
+		} else {
+			c.minifyNestedBlock(rule.rules)
+		}
+		c.w.Write(rightBracketBytes)
+	}
+	c.w.Write(c.minifyFragment(chunk, false))
+}
+
+// minifyNestedBlock writes the declarations and nested rules of a style rule.
+func (c *cssMinifier) minifyNestedBlock(rules []nestedRule) {
+	decls := [][]byte{}
+	semicolon := false
+	flush := func() {
+		if 0 < len(decls) {
+			if b := c.minifyFragment(bytes.Join(decls, semicolonBytes), true); 0 < len(b) {
+				c.w.Write(b)
+				semicolon = true
+			}
+			decls = decls[:0]
+		}
+	}
+	for _, rule := range rules {
+		if rule.decl {
+			decls = append(decls, rule.raw)
+			continue
+		}
+		flush()
+		if semicolon {
+			c.w.Write(semicolonBytes)
+			semicolon = false
+		}
+		if rule.prelude == nil {
+			c.w.Write(c.minifyFragment(rule.raw, false))
+			continue
+		}
+
+		prelude := rule.prelude
+		if !rule.at {
+			prelude = trimNestingSelectors(prelude)
+		}
+		c.w.Write(c.minifyPrelude(prelude))
+		c.w.Write(leftBracketBytes)
+		c.minifyNestedBlock(rule.rules)
+		c.w.Write(rightBracketBytes)
+	}
+	flush()
+}
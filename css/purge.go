@@ -0,0 +1,252 @@
+package css
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+	"github.com/tdewolff/parse/v2/html"
+	"github.com/tdewolff/parse/v2/xml"
+)
+
+// SelectorUsage is an oracle of the element names, classes, IDs, and attribute names that are used by the documents a stylesheet applies to. Element and attribute names are passed in lower case.
+type SelectorUsage interface {
+	HasElement(name []byte) bool
+	HasClass(name []byte) bool
+	HasID(name []byte) bool
+	HasAttribute(name []byte) bool
+}
+
+// HTMLUsage is a SelectorUsage of the elements, classes, IDs, and attributes found in HTML documents.
+type HTMLUsage struct {
+	elements   map[string]bool
+	classes    map[string]bool
+	ids        map[string]bool
+	attributes map[string]bool
+}
+
+// NewHTMLUsage returns a new HTMLUsage, which always uses the html, head, and body elements as they are implied by HTML documents.
+func NewHTMLUsage() *HTMLUsage {
+	return &HTMLUsage{
+		elements:   map[string]bool{"html": true, "head": true, "body": true},
+		classes:    map[string]bool{},
+		ids:        map[string]bool{},
+		attributes: map[string]bool{},
+	}
+}
+
+// Add adds the elements, classes, IDs, and attributes of an HTML document, including those of inline SVG and MathML.
+func (u *HTMLUsage) Add(r io.Reader) error {
+	z := parse.NewInput(r)
+	defer z.Restore()
+
+	l := html.NewLexer(z)
+	for {
+		tt, data := l.Next()
+		switch tt {
+		case html.ErrorToken:
+			if l.Err() != io.EOF {
+				return l.Err()
+			}
+			return nil
+		case html.StartTagToken:
+			u.elements[string(parse.ToLower(parse.Copy(l.Text())))] = true
+		case html.AttributeToken:
+			u.addAttribute(l.Text(), l.AttrVal())
+		case html.SvgToken, html.MathToken:
+			if err := u.addXML(data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (u *HTMLUsage) addXML(b []byte) error {
+	l := xml.NewLexer(parse.NewInputBytes(b))
+	for {
+		tt, _ := l.Next()
+		switch tt {
+		case xml.ErrorToken:
+			if l.Err() != io.EOF {
+				return l.Err()
+			}
+			return nil
+		case xml.StartTagToken:
+			u.elements[string(parse.ToLower(parse.Copy(l.Text())))] = true
+		case xml.AttributeToken:
+			u.addAttribute(l.Text(), l.AttrVal())
+		}
+	}
+}
+
+func (u *HTMLUsage) addAttribute(name, val []byte) {
+	name = parse.ToLower(parse.Copy(name))
+	u.attributes[string(name)] = true
+	if 1 < len(val) && (val[0] == '"' || val[0] == '\'') && val[0] == val[len(val)-1] {
+		val = val[1 : len(val)-1]
+	}
+	if bytes.Equal(name, []byte("class")) {
+		for _, class := range bytes.Fields(val) {
+			u.classes[string(class)] = true
+		}
+	} else if bytes.Equal(name, []byte("id")) {
+		u.ids[string(parse.TrimWhitespace(val))] = true
+	}
+}
+
+// HasElement returns true if an element with the lower case name was found.
+func (u *HTMLUsage) HasElement(name []byte) bool {
+	return u.elements[string(name)]
+}
+
+// HasClass returns true if an element with the class was found.
+func (u *HTMLUsage) HasClass(name []byte) bool {
+	return u.classes[string(name)]
+}
+
+// HasID returns true if an element with the ID was found.
+func (u *HTMLUsage) HasID(name []byte) bool {
+	return u.ids[string(name)]
+}
+
+// HasAttribute returns true if an element with the lower case attribute name was found.
+func (u *HTMLUsage) HasAttribute(name []byte) bool {
+	return u.attributes[string(name)]
+}
+
+////////////////////////////////////////////////////////////////
+
+// unescapeIdent replaces escape sequences in an identifier by the characters they represent.
+func unescapeIdent(b []byte) []byte {
+	if bytes.IndexByte(b, '\\') == -1 {
+		return b
+	}
+
+	ident := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] != '\\' || i+1 == len(b) {
+			ident = append(ident, b[i])
+			continue
+		}
+
+		i++
+		n, j := 0, i
+		for ; j < len(b) && j < i+6; j++ {
+			if c := b[j]; '0' <= c && c <= '9' {
+				n = n*16 + int(c-'0')
+			} else if 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F' {
+				n = n*16 + int(c|0x20-'a'+10)
+			} else {
+				break
+			}
+		}
+		if j == i {
+			ident = append(ident, b[i])
+			continue
+		}
+		if j < len(b) && parse.IsWhitespace(b[j]) {
+			j++
+		}
+		if n == 0 || 0x10FFFF < n {
+			n = 0xFFFD
+		}
+		ident = append(ident, string(rune(n))...)
+		i = j - 1
+	}
+	return ident
+}
+
+// selectorMayMatch returns false if the selector requires an element, class, ID, or attribute that is not used. Arguments of pseudo-classes, such as :not() and :is(), are not considered.
+func selectorMayMatch(usage SelectorUsage, selector []byte) bool {
+	if bytes.IndexByte(selector, '|') != -1 {
+		return true // namespaces
+	}
+
+	l := css.NewLexer(parse.NewInputBytes(selector))
+	compound := true // at the start of a compound selector
+	for {
+		tt, data := l.Next()
+		switch tt {
+		case css.ErrorToken:
+			return true
+		case css.IdentToken:
+			if compound && !usage.HasElement(parse.ToLower(unescapeIdent(parse.Copy(data)))) {
+				return false
+			}
+		case css.HashToken:
+			if !usage.HasID(unescapeIdent(data[1:])) {
+				return false
+			}
+		case css.DelimToken:
+			if data[0] == '.' {
+				if tt, data = l.Next(); tt == css.IdentToken && !usage.HasClass(unescapeIdent(data)) {
+					return false
+				}
+			}
+		case css.LeftBracketToken:
+			for tt, data = l.Next(); tt == css.WhitespaceToken; tt, data = l.Next() {
+			}
+			if tt == css.IdentToken && !usage.HasAttribute(parse.ToLower(unescapeIdent(parse.Copy(data)))) {
+				return false
+			}
+			for tt != css.RightBracketToken && tt != css.ErrorToken {
+				tt, _ = l.Next()
+			}
+		case css.ColonToken:
+			if tt, _ = l.Next(); tt == css.ColonToken {
+				tt, _ = l.Next()
+			}
+			if tt == css.FunctionToken {
+				for level := 1; 0 < level && tt != css.ErrorToken; {
+					if tt, _ = l.Next(); tt == css.FunctionToken || tt == css.LeftParenthesisToken {
+						level++
+					} else if tt == css.RightParenthesisToken {
+						level--
+					}
+				}
+			}
+		}
+		compound = tt == css.WhitespaceToken || tt == css.DelimToken && (data[0] == '>' || data[0] == '+' || data[0] == '~')
+	}
+}
+
+// isKeyframes returns true if the rule is a @keyframes at-rule, including vendor-prefixed ones.
+func isKeyframes(r *rule) bool {
+	return r.typ == blockRule && bytes.HasSuffix(parse.ToLower(parse.Copy(r.data)), keyframesBytes)
+}
+
+// purgeRules removes the selectors that cannot match any of the used elements, classes, IDs, and attributes, except for the safelisted selectors. Rulesets without selectors and at-rules that become empty are removed.
+func purgeRules(usage SelectorUsage, safelist []*regexp.Regexp, rules []*rule) []*rule {
+	list := rules[:0]
+	for _, r := range rules {
+		if r.typ == rulesetRule {
+			selectors := [][]byte{}
+		Selectors:
+			for _, selector := range r.selectors {
+				for _, re := range safelist {
+					if re.Match(selector) {
+						selectors = append(selectors, selector)
+						continue Selectors
+					}
+				}
+				if selectorMayMatch(usage, selector) {
+					selectors = append(selectors, selector)
+				}
+			}
+			if len(selectors) == 0 {
+				continue
+			} else if isMergeableSelector(r.selectors) {
+				// an unsupported selector invalidates the whole list, so keep lists with such selectors as is
+				r.selectors = selectors
+			}
+		} else if r.typ == blockRule && !isKeyframes(r) && 0 < len(r.rules) {
+			if r.rules = purgeRules(usage, safelist, r.rules); len(r.rules) == 0 {
+				continue
+			}
+		}
+		list = append(list, r)
+	}
+	return list
+}
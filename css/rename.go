@@ -0,0 +1,108 @@
+package css
+
+import (
+	"io"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+)
+
+var (
+	classBytes    = []byte("class")
+	idBytes       = []byte("id")
+	hrefBytes     = []byte("href")
+	propertyBytes = []byte("property")
+)
+
+// renames holds the original names of the classes and IDs that were renamed in the stylesheet, so that the selectors can be purged against the original names.
+type renames struct {
+	classes map[string][]byte
+	ids     map[string][]byte
+}
+
+// isDashedIdent returns true if the token is an identifier starting with two hyphens, such as a custom property name.
+func isDashedIdent(tt css.TokenType, data []byte) bool {
+	return (tt == css.IdentToken || tt == css.CustomPropertyNameToken) && 2 < len(data) && data[0] == '-' && data[1] == '-'
+}
+
+// renameClass returns the short name of a class in a selector.
+func (c *cssMinifier) renameClass(name []byte) []byte {
+	name = unescapeIdent(name)
+	rename := c.o.RenameMap.Class(name)
+	c.renames.classes[string(rename)] = parse.Copy(name)
+	return rename
+}
+
+// renameID returns the short name of an ID in a selector.
+func (c *cssMinifier) renameID(name []byte) []byte {
+	name = unescapeIdent(name)
+	rename := c.o.RenameMap.ID(name)
+	c.renames.ids[string(rename)] = parse.Copy(name)
+	return rename
+}
+
+// renameDashedIdents renames the custom properties referenced by the values.
+func (c *cssMinifier) renameDashedIdents(values []css.Token) {
+	for i, val := range values {
+		if isDashedIdent(val.TokenType, val.Data) {
+			values[i].Data = c.o.RenameMap.CustomProperty(val.Data)
+		}
+	}
+}
+
+// renameCustomPropertyValue renames the custom properties referenced by the raw value of a custom property.
+func (c *cssMinifier) renameCustomPropertyValue(value []byte) []byte {
+	b := []byte{}
+	l := css.NewLexer(parse.NewInputBytes(value))
+	for {
+		tt, data := l.Next()
+		if tt == css.ErrorToken {
+			if l.Err() != io.EOF {
+				return value
+			}
+			return b
+		} else if isDashedIdent(tt, data) {
+			data = c.o.RenameMap.CustomProperty(data)
+		}
+		b = append(b, data...)
+	}
+}
+
+// renameAttributeSelector returns the short name of the value of a class or ID attribute selector, or of the ID of a URL fragment of an href attribute selector, or nil if it cannot be renamed. Only exact matches of a single class or ID can be renamed.
+func (c *cssMinifier) renameAttributeSelector(name []byte, match css.TokenType, value []byte) []byte {
+	if parse.EqualFold(name, hrefBytes) && match == css.DelimToken && 1 < len(value) && value[0] == '#' && css.IsIdent(value[1:]) {
+		return append(append([]byte(`"#`), c.renameID(value[1:])...), '"')
+	} else if !css.IsIdent(value) {
+		return nil
+	} else if parse.EqualFold(name, classBytes) && (match == css.IncludeMatchToken || match == css.DelimToken) {
+		return c.renameClass(value)
+	} else if parse.EqualFold(name, idBytes) && match == css.DelimToken {
+		return c.renameID(value)
+	}
+	return nil
+}
+
+// renamedUsage is a SelectorUsage for a stylesheet with renamed classes and IDs, which looks up their original names.
+type renamedUsage struct {
+	SelectorUsage
+	renames renames
+}
+
+func (u renamedUsage) HasClass(name []byte) bool {
+	if original, ok := u.renames.classes[string(name)]; ok {
+		name = original
+	}
+	return u.SelectorUsage.HasClass(name)
+}
+
+func (u renamedUsage) HasID(name []byte) bool {
+	if original, ok := u.renames.ids[string(name)]; ok {
+		name = original
+	}
+	return u.SelectorUsage.HasID(name)
+}
+
+// isPropertyRule returns true if the at-rule is a @property rule.
+func isPropertyRule(data []byte) bool {
+	return parse.EqualFold(data[1:], propertyBytes)
+}
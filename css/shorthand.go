@@ -0,0 +1,263 @@
+package css
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+)
+
+// shorthand is a shorthand property that can be composed from its longhand properties.
+type shorthand struct {
+	name      string
+	longhands []string                           // longhands in the order passed to value
+	value     func(components [][][]byte) []byte // composes the shorthand value, or returns nil if not possible
+	related   func(prop string) bool             // properties that interact with the shorthand
+	feature   feature                            // feature required by the shorthand, if any
+}
+
+// shorthands are composed in order, so that shorthands of shorthands could come later. The font shorthand is left out, as it resets inherited properties besides its longhands, such as font-kerning, which would change the computed style when merging.
+var shorthands = []shorthand{
+	{"margin", sides("margin-", ""), sidesValue, prefixRelated("margin"), 0},
+	{"padding", sides("padding-", ""), sidesValue, prefixRelated("padding"), 0},
+	{"inset", sides("", ""), sidesValue, insetRelated, insetProperty},
+	{"border-width", sides("border-", "-width"), sidesValue, borderRelated, 0},
+	{"border-style", sides("border-", "-style"), sidesValue, borderRelated, 0},
+	{"border-color", sides("border-", "-color"), sidesValue, borderRelated, 0},
+	{"border-top", []string{"border-top-width", "border-top-style", "border-top-color"}, listValue, borderRelated, 0},
+	{"border-right", []string{"border-right-width", "border-right-style", "border-right-color"}, listValue, borderRelated, 0},
+	{"border-bottom", []string{"border-bottom-width", "border-bottom-style", "border-bottom-color"}, listValue, borderRelated, 0},
+	{"border-left", []string{"border-left-width", "border-left-style", "border-left-color"}, listValue, borderRelated, 0},
+	{"border-radius", []string{"border-top-left-radius", "border-top-right-radius", "border-bottom-right-radius", "border-bottom-left-radius"}, radiusValue, radiusRelated, unprefixedBorderRadius},
+	{"outline", []string{"outline-color", "outline-style", "outline-width"}, listValue, outlineRelated, 0},
+	{"list-style", []string{"list-style-type", "list-style-position", "list-style-image"}, listValue, prefixRelated("list-style"), 0},
+}
+
+// sides returns the longhands of the top, right, bottom, and left sides.
+func sides(prefix, suffix string) []string {
+	return []string{prefix + "top" + suffix, prefix + "right" + suffix, prefix + "bottom" + suffix, prefix + "left" + suffix}
+}
+
+func prefixRelated(name string) func(string) bool {
+	return func(prop string) bool {
+		return prop == name || strings.HasPrefix(prop, name+"-")
+	}
+}
+
+func insetRelated(prop string) bool {
+	return prop == "top" || prop == "right" || prop == "bottom" || prop == "left" || prefixRelated("inset")(prop)
+}
+
+func borderRelated(prop string) bool {
+	return prefixRelated("border")(prop) && !strings.HasSuffix(prop, "-radius") && !strings.HasPrefix(prop, "border-image") && prop != "border-collapse" && prop != "border-spacing"
+}
+
+func radiusRelated(prop string) bool {
+	return prop == "border-radius" || strings.HasPrefix(prop, "border-") && strings.HasSuffix(prop, "-radius")
+}
+
+func outlineRelated(prop string) bool {
+	return prefixRelated("outline")(prop) && prop != "outline-offset"
+}
+
+// collapseSides returns the shortest list of values for the top, right, bottom, and left sides.
+func collapseSides(values [][]byte) [][]byte {
+	if bytes.Equal(values[1], values[3]) {
+		values = values[:3]
+		if bytes.Equal(values[0], values[2]) {
+			values = values[:2]
+			if bytes.Equal(values[0], values[1]) {
+				values = values[:1]
+			}
+		}
+	}
+	return values
+}
+
+// sidesValue composes the value of the top, right, bottom, and left sides.
+func sidesValue(components [][][]byte) []byte {
+	values := [][]byte{}
+	for _, component := range components {
+		if len(component) != 1 {
+			return nil
+		}
+		values = append(values, component[0])
+	}
+	return bytes.Join(collapseSides(values), spaceBytes)
+}
+
+// listValue composes the value of the longhands separated by spaces.
+func listValue(components [][][]byte) []byte {
+	values := [][]byte{}
+	for _, component := range components {
+		if len(component) != 1 {
+			return nil
+		}
+		values = append(values, component[0])
+	}
+	return bytes.Join(values, spaceBytes)
+}
+
+// radiusValue composes the horizontal and vertical radii of the four corners.
+func radiusValue(components [][][]byte) []byte {
+	horizontal, vertical := [][]byte{}, [][]byte{}
+	for _, component := range components {
+		if len(component) == 1 {
+			horizontal = append(horizontal, component[0])
+			vertical = append(vertical, component[0])
+		} else if len(component) == 2 {
+			horizontal = append(horizontal, component[0])
+			vertical = append(vertical, component[1])
+		} else {
+			return nil
+		}
+	}
+	value := bytes.Join(collapseSides(horizontal), spaceBytes)
+	if h, v := bytes.Join(horizontal, spaceBytes), bytes.Join(vertical, spaceBytes); !bytes.Equal(h, v) {
+		value = append(append(value, '/'), bytes.Join(collapseSides(vertical), spaceBytes)...)
+	}
+	return value
+}
+
+// valueComponents splits the value of a declaration into components separated by whitespace. It returns nil if the value cannot be part of a shorthand, such as for CSS-wide keywords, variables, and hacks.
+func valueComponents(values []css.Token) [][]byte {
+	components := [][]byte{}
+	level := 0
+	var component []byte
+	for _, val := range values {
+		if bytes.IndexByte(val.Data, '\\') != -1 {
+			return nil
+		} else if val.TokenType == css.FunctionToken {
+			if fun := ToHash(parse.ToLower(parse.Copy(val.Data[:len(val.Data)-1]))); fun == Var || fun == Env || fun == Attr {
+				return nil
+			}
+			level++
+		} else if val.TokenType == css.LeftParenthesisToken {
+			level++
+		} else if val.TokenType == css.RightParenthesisToken {
+			level--
+		} else if val.TokenType == css.IdentToken {
+			ident := parse.ToLower(parse.Copy(val.Data))
+			if h := ToHash(ident); h == Inherit || h == Initial || h == Unset || bytes.HasPrefix(ident, []byte("revert")) {
+				return nil
+			}
+		}
+
+		if level == 0 && val.TokenType == css.WhitespaceToken {
+			components = append(components, component)
+			component = nil
+		} else {
+			component = append(component, val.Data...)
+		}
+	}
+	if component != nil {
+		components = append(components, component)
+	}
+	return components
+}
+
+// mergeShorthands replaces complete sets of longhand declarations by their shorthand, when no other declaration interacts with them and when it is shorter.
+func (c *cssMinifier) mergeShorthands(rules []*rule) []*rule {
+	for _, r := range rules {
+		if r.typ == rulesetRule || r.typ == blockRule {
+			r.rules = c.mergeShorthands(r.rules)
+		}
+	}
+
+Shorthands:
+	for _, s := range shorthands {
+		if c.features&s.feature != s.feature {
+			continue
+		}
+
+		// find the longhands and make sure no other declaration interacts with them
+		indices := make([]int, len(s.longhands))
+		for i := range indices {
+			indices[i] = -1
+		}
+		n, length := 0, 0 // number and length of the declarations
+		important := false
+		for i, r := range rules {
+			if r.typ != declarationRule {
+				continue
+			}
+			prop := string(r.data)
+			if !s.related(prop) {
+				continue
+			}
+
+			_, isImportant := r.value()
+			if n != 0 && isImportant != important {
+				continue Shorthands
+			}
+			important = isImportant
+			length += len(r.data) + 1 + len(joinTokens(r.values))
+			n++
+
+			j := 0
+			for j < len(s.longhands) && prop != s.longhands[j] {
+				j++
+			}
+			if j == len(s.longhands) || indices[j] != -1 {
+				continue Shorthands
+			}
+			indices[j] = i
+		}
+
+		// compose the shorthand value
+		components := make([][][]byte, len(s.longhands))
+		first := len(rules)
+		for j, i := range indices {
+			if i == -1 {
+				continue Shorthands
+			}
+			values, _ := rules[i].value()
+			if components[j] = valueComponents(values); len(components[j]) == 0 {
+				continue Shorthands
+			}
+			if i < first {
+				first = i
+			}
+		}
+		value := s.value(components)
+		if value == nil {
+			continue
+		}
+		if important {
+			value = append(value, importantBytes...)
+		}
+
+		// minify the shorthand declaration
+		decl := c.minifyShorthand(s.name, value)
+		if decl == nil || length+n-1 <= len(s.name)+1+len(joinTokens(decl.values)) {
+			continue
+		}
+
+		list := rules[:0]
+		for i, r := range rules {
+			if i == first {
+				list = append(list, decl)
+			} else if r.typ != declarationRule || !s.related(string(r.data)) {
+				list = append(list, r)
+			}
+		}
+		rules = list
+	}
+	return rules
+}
+
+// minifyShorthand minifies a shorthand declaration and parses it into a rule.
+func (c *cssMinifier) minifyShorthand(name string, value []byte) *rule {
+	b := append(append([]byte(name), ':'), value...)
+	buffer := &bytes.Buffer{}
+	o := &Minifier{KeepCSS2: c.o.KeepCSS2, Precision: c.o.Precision, Targets: c.o.Targets}
+	if err := o.Minify(c.m, buffer, bytes.NewReader(b), map[string]string{"inline": "1"}); err != nil {
+		return nil
+	}
+	rules, ok := parseRules(buffer.Bytes(), true)
+	if !ok || len(rules) != 1 || rules[0].typ != declarationRule {
+		return nil
+	}
+	return rules[0]
+}
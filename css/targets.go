@@ -0,0 +1,505 @@
+package css
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+)
+
+// Targets are the oldest versions of the browsers that the output must support, by browser name. Browsers that are not listed need not be supported.
+type Targets map[string]float64
+
+// feature is a set of CSS features that are supported by the targets.
+type feature uint32
+
+const (
+	hexAlphaColors         feature = 1 << iota // #rrggbbaa and #rgba
+	spaceSeparatedColors                       // rgb(0 0 0/.5)
+	insetProperty                              // inset shorthand
+	nestedRules                                // nested style rules and the & selector
+	isSelector                                 // :is() selector
+	unprefixedAnimations                       // @keyframes and animation
+	unprefixedTransitions                      // transition
+	unprefixedTransforms                       // transform and perspective
+	unprefixedBorderRadius                     // border-radius
+	unprefixedBoxShadow                        // box-shadow
+	unprefixedBoxSizing                        // box-sizing
+	unprefixedFlexbox                          // display:flex and flex properties
+	unprefixedGradients                        // linear-gradient() and others
+	unprefixedCalc                             // calc()
+	unprefixedSticky                           // position:sticky
+	unprefixedColumns                          // columns and column properties
+	unprefixedUserSelect                       // user-select
+	unprefixedAppearance                       // appearance
+
+	allFeatures = unprefixedAppearance<<1 - 1
+)
+
+// defaultFeatures are the features that are used when no targets are set, which excludes syntax that was not used before targets existed, and :is() since flattened nesting is meant for browsers that lack it.
+const defaultFeatures = allFeatures &^ spaceSeparatedColors &^ insetProperty &^ isSelector
+
+// browserVersions are the released versions of each browser, in ascending order. Mobile browsers only list their recent versions.
+var browserVersions = map[string][]float64{
+	"chrome":  versionRange(4, 131),
+	"edge":    append(versionRange(12, 18), versionRange(79, 131)...),
+	"firefox": append([]float64{2, 3, 3.5, 3.6}, versionRange(4, 133)...),
+	"ie":      {5.5, 6, 7, 8, 9, 10, 11},
+	"ios_saf": {3.2, 4, 4.2, 5, 6, 7, 8, 9, 9.3, 10, 10.3, 11, 11.3, 12, 12.2, 13, 13.4, 14, 14.5, 15, 15.4, 16, 16.4, 17, 17.4, 18, 18.2},
+	"opera":   append([]float64{9, 9.5, 10, 10.5, 10.6, 11, 11.1, 11.5, 11.6, 12, 12.1}, versionRange(15, 114)...),
+	"safari":  {3.1, 3.2, 4, 5, 5.1, 6, 6.1, 7, 7.1, 8, 9, 9.1, 10, 10.1, 11, 11.1, 12, 12.1, 13, 13.1, 14, 14.1, 15, 15.4, 16, 16.4, 17, 17.4, 18, 18.2},
+	"samsung": {4, 5, 6.2, 7.2, 8.2, 9.2, 10.1, 11.1, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27},
+	"and_chr": {131},
+	"and_ff":  {133},
+}
+
+// browserAliases are alternative names of browsers in queries.
+var browserAliases = map[string]string{
+	"chromeandroid":   "and_chr",
+	"firefoxandroid":  "and_ff",
+	"ff":              "firefox",
+	"fx":              "firefox",
+	"explorer":        "ie",
+	"ios":             "ios_saf",
+	"samsunginternet": "samsung",
+}
+
+// deadBrowsers are the browsers that are no longer maintained.
+var deadBrowsers = []string{"ie"}
+
+// firefoxESR is the version of the Firefox Extended Support Release.
+const firefoxESR = 115
+
+// featureVersions are the oldest versions of each browser that support a feature, or zero if no version supports it.
+var featureVersions = map[feature]map[string]float64{
+	hexAlphaColors:         {"chrome": 62, "edge": 79, "firefox": 49, "ios_saf": 10, "opera": 49, "safari": 10, "samsung": 8.2, "and_chr": 62, "and_ff": 49},
+	spaceSeparatedColors:   {"chrome": 65, "edge": 79, "firefox": 52, "ios_saf": 12.2, "opera": 52, "safari": 12.1, "samsung": 9.2, "and_chr": 65, "and_ff": 52},
+	insetProperty:          {"chrome": 87, "edge": 87, "firefox": 66, "ios_saf": 14.5, "opera": 73, "safari": 14.1, "samsung": 14, "and_chr": 87, "and_ff": 66},
+	nestedRules:            {"chrome": 120, "edge": 120, "firefox": 117, "ios_saf": 17.2, "opera": 106, "safari": 17.2, "samsung": 25, "and_chr": 120, "and_ff": 117},
+	isSelector:             {"chrome": 88, "edge": 88, "firefox": 78, "ios_saf": 14, "opera": 74, "safari": 14, "samsung": 15, "and_chr": 88, "and_ff": 78},
+	unprefixedAnimations:   {"chrome": 43, "edge": 12, "firefox": 16, "ie": 10, "ios_saf": 9, "opera": 30, "safari": 9, "samsung": 4, "and_chr": 43, "and_ff": 16},
+	unprefixedTransitions:  {"chrome": 26, "edge": 12, "firefox": 16, "ie": 10, "ios_saf": 7, "opera": 12.1, "safari": 6.1, "samsung": 4, "and_chr": 26, "and_ff": 16},
+	unprefixedTransforms:   {"chrome": 36, "edge": 12, "firefox": 16, "ie": 10, "ios_saf": 9, "opera": 23, "safari": 9, "samsung": 4, "and_chr": 36, "and_ff": 16},
+	unprefixedBorderRadius: {"chrome": 5, "edge": 12, "firefox": 4, "ie": 9, "ios_saf": 4.2, "opera": 10.5, "safari": 5, "samsung": 4, "and_chr": 5, "and_ff": 4},
+	unprefixedBoxShadow:    {"chrome": 10, "edge": 12, "firefox": 4, "ie": 9, "ios_saf": 5, "opera": 10.5, "safari": 5.1, "samsung": 4, "and_chr": 10, "and_ff": 4},
+	unprefixedBoxSizing:    {"chrome": 10, "edge": 12, "firefox": 29, "ie": 8, "ios_saf": 6, "opera": 9.5, "safari": 5.1, "samsung": 4, "and_chr": 10, "and_ff": 29},
+	unprefixedFlexbox:      {"chrome": 29, "edge": 12, "firefox": 28, "ie": 11, "ios_saf": 9, "opera": 17, "safari": 9, "samsung": 4, "and_chr": 29, "and_ff": 28},
+	unprefixedGradients:    {"chrome": 26, "edge": 12, "firefox": 16, "ie": 10, "ios_saf": 7, "opera": 12.1, "safari": 7, "samsung": 4, "and_chr": 26, "and_ff": 16},
+	unprefixedCalc:         {"chrome": 26, "edge": 12, "firefox": 16, "ie": 9, "ios_saf": 7, "opera": 15, "safari": 7, "samsung": 4, "and_chr": 26, "and_ff": 16},
+	unprefixedSticky:       {"chrome": 56, "edge": 16, "firefox": 32, "ios_saf": 13, "opera": 43, "safari": 13, "samsung": 6.2, "and_chr": 56, "and_ff": 32},
+	unprefixedColumns:      {"chrome": 50, "edge": 12, "firefox": 52, "ie": 10, "ios_saf": 9, "opera": 37, "safari": 9, "samsung": 5, "and_chr": 50, "and_ff": 52},
+	unprefixedUserSelect:   {"chrome": 54, "edge": 79, "firefox": 69, "opera": 41, "samsung": 6.2, "and_chr": 54, "and_ff": 69},
+	unprefixedAppearance:   {"chrome": 84, "edge": 84, "firefox": 80, "ios_saf": 15.4, "opera": 70, "safari": 15.4, "samsung": 14, "and_chr": 84, "and_ff": 80},
+}
+
+func versionRange(from, to int) []float64 {
+	versions := []float64{}
+	for v := from; v <= to; v++ {
+		versions = append(versions, float64(v))
+	}
+	return versions
+}
+
+// ParseTargets resolves a browserslist query against the embedded compatibility table and returns the oldest version of each browser. Queries are separated by commas or or, can be intersected by and, and can be excluded by not. Supported queries are defaults, last 2 versions, last 2 chrome versions, chrome >= 80, chrome 80, chrome 80-90, firefox esr, dead, and not dead. Usage statistics such as > 0.5% are not supported.
+func ParseTargets(query string) (Targets, error) {
+	selected := map[string]map[float64]bool{}
+	for i, or := range strings.Split(strings.ReplaceAll(strings.ToLower(query), " or ", ","), ",") {
+		for j, and := range strings.Split(or, " and ") {
+			and = strings.TrimSpace(and)
+			not := strings.HasPrefix(and, "not ")
+			if not {
+				and = strings.TrimSpace(and[4:])
+				if i == 0 && j == 0 {
+					return nil, fmt.Errorf("query cannot start with not: %s", query)
+				}
+			}
+			versions, err := resolveQuery(and)
+			if err != nil {
+				return nil, err
+			}
+
+			if not {
+				for name, vs := range versions {
+					for v := range vs {
+						delete(selected[name], v)
+					}
+				}
+			} else if 0 < j {
+				// intersect with the previous query
+				for name, vs := range selected {
+					for v := range vs {
+						if !versions[name][v] {
+							delete(vs, v)
+						}
+					}
+				}
+			} else {
+				for name, vs := range versions {
+					if selected[name] == nil {
+						selected[name] = map[float64]bool{}
+					}
+					for v := range vs {
+						selected[name][v] = true
+					}
+				}
+			}
+		}
+	}
+
+	targets := Targets{}
+	for name, vs := range selected {
+		for v := range vs {
+			if oldest, ok := targets[name]; !ok || v < oldest {
+				targets[name] = v
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("query selects no browsers: %s", query)
+	}
+	return targets, nil
+}
+
+// resolveQuery returns the browser versions selected by a single query.
+func resolveQuery(query string) (map[string]map[float64]bool, error) {
+	versions := map[string]map[float64]bool{}
+	add := func(name string, filter func(float64) bool) {
+		for _, v := range browserVersions[name] {
+			if filter(v) {
+				if versions[name] == nil {
+					versions[name] = map[float64]bool{}
+				}
+				versions[name][v] = true
+			}
+		}
+	}
+	last := func(name string, n int) {
+		vs := browserVersions[name]
+		if n < len(vs) {
+			vs = vs[len(vs)-n:]
+		}
+		add(name, func(v float64) bool { return vs[0] <= v })
+	}
+
+	fields := strings.Fields(query)
+	if strings.HasSuffix(query, "%") {
+		return nil, fmt.Errorf("usage statistics are not supported in query: %s", query)
+	}
+	switch {
+	case query == "defaults":
+		for name := range browserVersions {
+			if !isDeadBrowser(name) {
+				last(name, 2)
+			}
+		}
+		add("firefox", func(v float64) bool { return v == firefoxESR })
+		return versions, nil
+	case query == "dead":
+		for _, name := range deadBrowsers {
+			add(name, func(float64) bool { return true })
+		}
+		return versions, nil
+	case query == "firefox esr" || query == "ff esr" || query == "fx esr":
+		add("firefox", func(v float64) bool { return v == firefoxESR })
+		return versions, nil
+	case len(fields) == 3 && fields[0] == "last" && (fields[2] == "versions" || fields[2] == "version"):
+		if n, err := strconv.Atoi(fields[1]); err == nil && 0 < n {
+			for name := range browserVersions {
+				if !isDeadBrowser(name) {
+					last(name, n)
+				}
+			}
+			return versions, nil
+		}
+	case len(fields) == 4 && fields[0] == "last" && (fields[3] == "versions" || fields[3] == "version"):
+		name, ok := browserName(fields[2])
+		if n, err := strconv.Atoi(fields[1]); err == nil && 0 < n && ok {
+			last(name, n)
+			return versions, nil
+		}
+	case len(fields) == 2 || len(fields) == 3:
+		name, ok := browserName(fields[0])
+		if !ok {
+			return nil, fmt.Errorf("unknown browser %s in query: %s", fields[0], query)
+		}
+		op, version := "=", fields[1]
+		if len(fields) == 3 {
+			op, version = fields[1], fields[2]
+		} else if i := strings.IndexByte(version, '-'); i != -1 {
+			from, err1 := strconv.ParseFloat(version[:i], 64)
+			to, err2 := strconv.ParseFloat(version[i+1:], 64)
+			if err1 == nil && err2 == nil {
+				add(name, func(v float64) bool { return from <= v && v <= to })
+				return versions, nil
+			}
+			break
+		}
+		if v, err := strconv.ParseFloat(version, 64); err == nil {
+			switch op {
+			case "=":
+				add(name, func(w float64) bool { return w == v || math.Floor(w) == v })
+				if versions[name] == nil {
+					return nil, fmt.Errorf("unknown version %s of %s in query: %s", version, name, query)
+				}
+			case ">=":
+				add(name, func(w float64) bool { return v <= w })
+			case ">":
+				add(name, func(w float64) bool { return v < w })
+			case "<=":
+				add(name, func(w float64) bool { return w <= v })
+			case "<":
+				add(name, func(w float64) bool { return w < v })
+			default:
+				return nil, fmt.Errorf("unknown operator %s in query: %s", op, query)
+			}
+			return versions, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported query: %s", query)
+}
+
+func browserName(name string) (string, bool) {
+	if alias, ok := browserAliases[name]; ok {
+		name = alias
+	}
+	_, ok := browserVersions[name]
+	return name, ok
+}
+
+func isDeadBrowser(name string) bool {
+	for _, dead := range deadBrowsers {
+		if name == dead {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the targets as a browserslist query.
+func (t Targets) String() string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	queries := make([]string, 0, len(t))
+	for _, name := range names {
+		queries = append(queries, name+" >= "+strconv.FormatFloat(t[name], 'f', -1, 64))
+	}
+	return strings.Join(queries, ", ")
+}
+
+// features returns the features supported by all targets.
+func (t Targets) features() feature {
+	features := feature(0)
+	for f, min := range featureVersions {
+		supported := true
+		for name, v := range t {
+			if version, ok := min[name]; !ok || v < version {
+				supported = false
+				break
+			}
+		}
+		if supported {
+			features |= f
+		}
+	}
+	return features
+}
+
+////////////////////////////////////////////////////////////////
+
+// prefixedProperties are the features that make vendor-prefixed properties superfluous, by the unprefixed property name.
+var prefixedProperties = map[string]feature{
+	"animation":                  unprefixedAnimations,
+	"animation-delay":            unprefixedAnimations,
+	"animation-direction":        unprefixedAnimations,
+	"animation-duration":         unprefixedAnimations,
+	"animation-fill-mode":        unprefixedAnimations,
+	"animation-iteration-count":  unprefixedAnimations,
+	"animation-name":             unprefixedAnimations,
+	"animation-play-state":       unprefixedAnimations,
+	"animation-timing-function":  unprefixedAnimations,
+	"transition":                 unprefixedTransitions,
+	"transition-delay":           unprefixedTransitions,
+	"transition-duration":        unprefixedTransitions,
+	"transition-property":        unprefixedTransitions,
+	"transition-timing-function": unprefixedTransitions,
+	"transform":                  unprefixedTransforms,
+	"transform-origin":           unprefixedTransforms,
+	"transform-style":            unprefixedTransforms,
+	"perspective":                unprefixedTransforms,
+	"perspective-origin":         unprefixedTransforms,
+	"border-radius":              unprefixedBorderRadius,
+	"border-top-left-radius":     unprefixedBorderRadius,
+	"border-top-right-radius":    unprefixedBorderRadius,
+	"border-bottom-right-radius": unprefixedBorderRadius,
+	"border-bottom-left-radius":  unprefixedBorderRadius,
+	"box-shadow":                 unprefixedBoxShadow,
+	"box-sizing":                 unprefixedBoxSizing,
+	"flex":                       unprefixedFlexbox,
+	"flex-basis":                 unprefixedFlexbox,
+	"flex-direction":             unprefixedFlexbox,
+	"flex-flow":                  unprefixedFlexbox,
+	"flex-grow":                  unprefixedFlexbox,
+	"flex-shrink":                unprefixedFlexbox,
+	"flex-wrap":                  unprefixedFlexbox,
+	"order":                      unprefixedFlexbox,
+	"align-content":              unprefixedFlexbox,
+	"align-items":                unprefixedFlexbox,
+	"align-self":                 unprefixedFlexbox,
+	"justify-content":            unprefixedFlexbox,
+	"columns":                    unprefixedColumns,
+	"column-count":               unprefixedColumns,
+	"column-fill":                unprefixedColumns,
+	"column-gap":                 unprefixedColumns,
+	"column-rule":                unprefixedColumns,
+	"column-rule-color":          unprefixedColumns,
+	"column-rule-style":          unprefixedColumns,
+	"column-rule-width":          unprefixedColumns,
+	"column-span":                unprefixedColumns,
+	"column-width":               unprefixedColumns,
+	"user-select":                unprefixedUserSelect,
+	"appearance":                 unprefixedAppearance,
+}
+
+// legacyFlexboxProperties are the properties of the 2009 and 2012 flexbox drafts, by the property that replaces them.
+var legacyFlexboxProperties = map[string]string{
+	"-webkit-box-align":         "align-items",
+	"-ms-flex-align":            "align-items",
+	"-ms-flex-item-align":       "align-self",
+	"-ms-flex-line-pack":        "align-content",
+	"-webkit-box-pack":          "justify-content",
+	"-ms-flex-pack":             "justify-content",
+	"-webkit-box-ordinal-group": "order",
+	"-ms-flex-order":            "order",
+	"-webkit-box-flex":          "flex",
+	"-ms-flex-positive":         "flex-grow",
+	"-ms-flex-negative":         "flex-shrink",
+	"-ms-flex-preferred-size":   "flex-basis",
+	"-webkit-box-orient":        "flex-direction",
+	"-webkit-box-direction":     "flex-direction",
+}
+
+// prefixedValues are the features that make vendor-prefixed keywords and functions superfluous, by their name without the vendor prefix.
+var prefixedValues = map[string]feature{
+	"box":                        unprefixedFlexbox,
+	"flexbox":                    unprefixedFlexbox,
+	"flex":                       unprefixedFlexbox,
+	"inline-box":                 unprefixedFlexbox,
+	"inline-flexbox":             unprefixedFlexbox,
+	"inline-flex":                unprefixedFlexbox,
+	"sticky":                     unprefixedSticky,
+	"calc(":                      unprefixedCalc,
+	"gradient(":                  unprefixedGradients,
+	"linear-gradient(":           unprefixedGradients,
+	"radial-gradient(":           unprefixedGradients,
+	"repeating-linear-gradient(": unprefixedGradients,
+	"repeating-radial-gradient(": unprefixedGradients,
+}
+
+// unprefix returns the name without its vendor prefix, or nil if it has no vendor prefix.
+func unprefix(name []byte) []byte {
+	if 3 < len(name) && name[0] == '-' && name[1] != '-' {
+		if i := bytes.IndexByte(name[1:], '-'); i != -1 {
+			return name[i+2:]
+		}
+	}
+	return nil
+}
+
+// prefixedFeature returns the feature that makes the vendor-prefixed property superfluous, and the property that replaces it.
+func prefixedFeature(prop []byte) (feature, string) {
+	if replacement, ok := legacyFlexboxProperties[string(prop)]; ok {
+		return unprefixedFlexbox, replacement
+	} else if name := unprefix(prop); name != nil {
+		if f, ok := prefixedProperties[string(name)]; ok {
+			return f, string(name)
+		}
+	}
+	return 0, ""
+}
+
+// prefixedValueFeatures returns the features that make the vendor-prefixed keywords and functions of the value superfluous. It returns false if the value has no vendor-prefixed keywords or functions, or if they are not known.
+func prefixedValueFeatures(values []css.Token) (feature, bool) {
+	features := feature(0)
+	for _, val := range values {
+		if val.TokenType != css.IdentToken && val.TokenType != css.FunctionToken {
+			continue
+		} else if name := unprefix(parse.ToLower(parse.Copy(val.Data))); name != nil {
+			if f, ok := prefixedValues[string(name)]; ok {
+				features |= f
+			} else if f, ok := prefixedProperties[string(name)]; ok {
+				features |= f // such as transition:-webkit-transform 1s
+			} else {
+				return 0, false
+			}
+		}
+	}
+	return features, features != 0
+}
+
+// removePrefixes removes vendor-prefixed declarations and @keyframes rules that are followed by their unprefixed counterpart, when the targets support the unprefixed counterpart.
+func removePrefixes(features feature, rules []*rule) []*rule {
+	list := rules[:0]
+	for i, r := range rules {
+		if r.typ == rulesetRule || r.typ == blockRule && !isKeyframes(r) {
+			r.rules = removePrefixes(features, r.rules)
+		} else if r.typ == blockRule {
+			// the keyframe selectors of @keyframes rules are kept as is
+			if unprefix(parse.ToLower(parse.Copy(r.data[1:]))) != nil && features&unprefixedAnimations != 0 && hasUnprefixedKeyframes(r, rules[i+1:]) {
+				continue
+			}
+		} else if r.typ == declarationRule {
+			values, _ := r.value()
+			valueFeatures, hasPrefixedValue := prefixedValueFeatures(values)
+			if f, replacement := prefixedFeature(r.data); f != 0 && (!hasVendorValue(values) || hasPrefixedValue) {
+				if f |= valueFeatures; features&f == f && hasDeclaration(rules[i+1:], replacement, false) {
+					continue
+				}
+			} else if hasPrefixedValue && features&valueFeatures == valueFeatures && hasDeclaration(rules[i+1:], string(r.data), true) {
+				continue
+			}
+		}
+		list = append(list, r)
+	}
+	return list
+}
+
+// hasDeclaration returns true if the rules have a declaration of the property, optionally without vendor-prefixed keywords or functions.
+func hasDeclaration(rules []*rule, prop string, unprefixedValue bool) bool {
+	for _, r := range rules {
+		if r.typ == declarationRule && string(r.data) == prop {
+			if !unprefixedValue || !hasVendorValue(r.values) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasVendorValue returns true if the value has vendor-prefixed keywords or functions.
+func hasVendorValue(values []css.Token) bool {
+	for _, val := range values {
+		if (val.TokenType == css.IdentToken || val.TokenType == css.FunctionToken) && unprefix(val.Data) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUnprefixedKeyframes returns true if the rules have an unprefixed @keyframes rule with the same name as the prefixed one.
+func hasUnprefixedKeyframes(r *rule, rules []*rule) bool {
+	name := parse.TrimWhitespace(joinTokens(r.values))
+	for _, s := range rules {
+		if s.typ == blockRule && parse.EqualFold(s.data, []byte("@keyframes")) && string(parse.TrimWhitespace(joinTokens(s.values))) == string(name) {
+			return true
+		}
+	}
+	return false
+}
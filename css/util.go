@@ -2,6 +2,7 @@ package css
 
 import (
 	"encoding/hex"
+	"math"
 
 	"github.com/tdewolff/parse/v2"
 	"github.com/tdewolff/parse/v2/css"
@@ -53,3 +54,45 @@ func rgbToToken(r, g, b float64) Token {
 	}
 	return Token{css.HashToken, val, nil, 0, 0}
 }
+
+// colorChannels returns the red, green, and blue channels in the interval [0.0, 1.0] of an rgb() or hsl() color.
+func colorChannels(fun Hash, args []Token, vals []float64) (float64, float64, float64, bool) {
+	if fun == Rgb || fun == Rgba {
+		rgb := [3]float64{}
+		for j := 0; j < 3; j++ {
+			rgb[j] = vals[j]
+			if args[j*2].TokenType == css.NumberToken {
+				rgb[j] /= 255.0
+			}
+		}
+		return rgb[0], rgb[1], rgb[2], true
+	} else if args[0].TokenType == css.NumberToken && args[2].TokenType == css.PercentageToken && args[4].TokenType == css.PercentageToken {
+		h := vals[0] / 360.0
+		_, h = math.Modf(h)
+		if h < 0.0 {
+			h = 1.0 + h
+		}
+		r, g, b := css.HSL2RGB(h, vals[1], vals[2])
+		return r, g, b, true
+	}
+	return 0.0, 0.0, 0.0, false
+}
+
+// rgbaToToken returns the shortest hexadecimal color with an alpha channel.
+func rgbaToToken(r, g, b, a float64) Token {
+	rgba := make([]byte, 4)
+	for i, v := range []float64{r, g, b, a} {
+		rgba[i] = byte(math.Max(0.0, math.Min(1.0, v))*255.0 + 0.5)
+	}
+
+	val := make([]byte, 9)
+	val[0] = '#'
+	hex.Encode(val[1:], rgba)
+	if val[1] == val[2] && val[3] == val[4] && val[5] == val[6] && val[7] == val[8] {
+		val[2] = val[3]
+		val[3] = val[5]
+		val[4] = val[7]
+		val = val[:5]
+	}
+	return Token{css.HashToken, val, nil, 0, 0}
+}
@@ -36,7 +36,11 @@ var (
 	getBytes        = []byte("get")
 	autoBytes       = []byte("auto")
 	oneBytes        = []byte("one")
+	idBytes         = []byte("id")
 	inlineParams    = map[string]string{"inline": "1"}
+
+	bannerStartBytes = []byte("<!--! ")
+	bannerEndBytes   = []byte(" -->")
 )
 
 ////////////////////////////////////////////////////////////////
@@ -52,6 +56,12 @@ type Minifier struct {
 	KeepEndTags             bool
 	KeepQuotes              bool
 	KeepWhitespace          bool
+
+	// RenameMap renames the classes and IDs in class and id attributes, and in attributes that reference IDs, to short names when set. Classes and IDs, including URL fragments and other references to IDs, are renamed only when used by the document's style elements or renamed before by a stylesheet, so that the names used only by scripts, other stylesheets or links from other documents are kept, and the kept names are reserved so that no other name is renamed to them. Inline SVG is renamed as well, including xlink:href and url(#id) references. Share it with the CSS minifier so that both use the same names.
+	RenameMap *minify.RenameMap
+
+	// Comments selects the comments that are kept, in addition to conditional comments when KeepConditionalComments is set. By default license comments starting with <!--! are kept. Share it with the CSS and JS minifiers to handle comments consistently.
+	Comments *minify.Comments
 }
 
 // Minify minifies HTML data, it reads from r and writes to w.
@@ -66,6 +76,7 @@ func (o *Minifier) Minify(m *minify.M, w io.Writer, r io.Reader, _ map[string]st
 
 	omitSpace := true // if true the next leading space is omitted
 	inPre := false
+	extracted := false // set after writing the banner of extracted comments
 
 	attrMinifyBuffer := buffer.NewWriter(make([]byte, 0, 64))
 	attrByteBuffer := make([]byte, 0, 64)
@@ -73,6 +84,11 @@ func (o *Minifier) Minify(m *minify.M, w io.Writer, r io.Reader, _ map[string]st
 	z := parse.NewInput(r)
 	defer z.Restore()
 
+	var names docNames
+	if o.RenameMap != nil {
+		names = collectNames(o.RenameMap, z.Bytes())
+	}
+
 	l := html.NewLexer(z)
 	tb := NewTokenBuffer(l)
 	for {
@@ -103,8 +119,20 @@ func (o *Minifier) Minify(m *minify.M, w io.Writer, r io.Reader, _ map[string]st
 				} else {
 					w.Write(t.Data) // downlevel-revealed or short downlevel-hidden
 				}
+			} else if o.Comments.Extract(t.Data) {
+				if !extracted {
+					w.Write(bannerStartBytes)
+					w.Write(o.Comments.Banner())
+					w.Write(bannerEndBytes)
+					extracted = true
+				}
+			} else if o.Comments.Keep(t.Data) {
+				w.Write(t.Data)
 			}
 		case html.SvgToken:
+			if o.RenameMap != nil {
+				t.Data = renameSVG(o.RenameMap, names, t.Data)
+			}
 			if err := m.MinifyMimetype(svgMimeBytes, w, buffer.NewReader(t.Data), nil); err != nil {
 				if err != minify.ErrNotExist {
 					return err
@@ -466,6 +494,9 @@ func (o *Minifier) Minify(m *minify.M, w io.Writer, r io.Reader, _ map[string]st
 							}
 						}
 					}
+					if o.RenameMap != nil {
+						val = renameAttrVal(o.RenameMap, names, attr, val)
+					}
 
 					if !prevQuote {
 						w.Write(spaceBytes)
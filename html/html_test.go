@@ -244,6 +244,39 @@ func TestHTMLKeepConditionalComments(t *testing.T) {
 	}
 }
 
+func TestHTMLComments(t *testing.T) {
+	htmlTests := []struct {
+		policy      minify.CommentPolicy
+		licenseFile string
+		html        string
+		expected    string
+	}{
+		{minify.LicenseComments, "", `<!--! license --><p>text<!-- comment --></p>`, `<!--! license --><p>text`},
+		{minify.NoComments, "", `<!--! license --><p>text`, `<p>text`},
+		{minify.TaggedComments, "", `<!-- @license MIT --><p>text`, `<!-- @license MIT --><p>text`},
+		{minify.RegexpComments, "", `<!-- Copyright --><p>text<!--! license -->`, `<!-- Copyright --><p>text`},
+		{minify.LicenseComments, "a.html.LICENSE.txt", `<!--! license --><p>text<!--! other -->`, `<!--! For license information please see a.html.LICENSE.txt --><p>text`},
+	}
+
+	m := minify.New()
+	for _, tt := range htmlTests {
+		t.Run(tt.html, func(t *testing.T) {
+			comments := &minify.Comments{Policy: tt.policy, Regexp: regexp.MustCompile("Copyright"), LicenseFile: tt.licenseFile}
+			htmlMinifier := &Minifier{Comments: comments}
+			r := bytes.NewBufferString(tt.html)
+			w := &bytes.Buffer{}
+			err := htmlMinifier.Minify(m, w, r, nil)
+			test.Minify(t, tt.html, err, w.String(), tt.expected)
+		})
+	}
+
+	// a nil policy keeps license comments
+	src := `<p>text<!--! license --><!-- comment -->`
+	w := &bytes.Buffer{}
+	err := (&Minifier{}).Minify(m, w, bytes.NewBufferString(src), nil)
+	test.Minify(t, src, err, w.String(), `<p>text<!--! license -->`)
+}
+
 func TestHTMLKeepWhitespace(t *testing.T) {
 	htmlTests := []struct {
 		html     string
@@ -301,6 +334,68 @@ func TestHTMLKeepQuotes(t *testing.T) {
 	}
 }
 
+func TestHTMLRename(t *testing.T) {
+	htmlTests := []struct {
+		html     string
+		expected string
+	}{
+		{`<style>.card__title,.button--primary{color:red}</style><div class=" card__title  button--primary x ">`, `<style>.a,.b{color:red}</style><div class="a b x">`},
+		{`<html class="dark-theme"><style>.dark-theme{color:red}</style><p class="dark-theme unknown-class">`, `<html class=a><style>.a{color:red}</style><p class="a unknown-class">`},
+		{`<my-element class="card__title" id="main-content">`, `<my-element class=card__title id=main-content>`},
+		{`<style>#main-content,#main-help{color:red}</style><label for="main-content"></label><input id="main-content" aria-describedby="main-help other-help">`, `<style>#a,#b{color:red}</style><label for=a></label><input id=a aria-describedby="b other-help">`},
+		{`<h2 id="installation"><a href="#installation">x</a></h2><label for="installation">`, `<h2 id=installation><a href=#installation>x</a></h2><label for=installation>`},
+		{`<style>#main-content{color:red}</style><p id="main-content"><a href="#main-content">x</a><a href="#unknown-fragment">y</a>`, `<style>#a{color:red}</style><p id=a><a href=#a>x</a><a href=#unknown-fragment>y</a>`},
+		{`<a href="#section-main">x</a><a href="#unknown-fragment">y</a><div id="section-main"><style>#section-main{color:red}</style>`, `<a href=#a>x</a><a href=#unknown-fragment>y</a><div id=a><style>#a{color:red}</style>`},
+		{`<style>#section{color:red}</style><a href="#s&#101;ction">x</a><div ID=' s&#101;ction '>`, `<style>#a{color:red}</style><a href=#a>x</a><div id=a>`},
+		{`<div style="--brand-color:red;color:var(--brand-color)" class="">`, `<div style=--a:red;color:var(--a)>`},
+		{`<style>.block__element--modifier,#main-content{fill:red}</style><svg><rect class="block__element--modifier" id="main-content" fill="url(#main-content)" /><use href="#main-content"/><use xlink:href='#main-content'/></svg>`, `<style>.a,#a{fill:red}</style><svg><rect class="a" id="a" fill="url(#a)"/><use href="#a"/><use xlink:href='#a'/></svg>`},
+		{`<style>#main-icon{color:red}</style><a href="#main-icon">x</a><svg><use href="#main-icon"/><symbol id="main-icon"/></svg>`, `<style>#a{color:red}</style><a href=#a>x</a><svg><use href="#a"/><symbol id="a"/></svg>`},
+		{`<style>.x{filter:url(#blur-filter)}</style><svg><filter id="blur-filter"/></svg>`, `<style>.x{filter:url(#a)}</style><svg><filter id="a"/></svg>`},
+		{`<style>.very-long-name{color:red}</style><p class="very-long-name a js-toggle" id="b">`, `<style>.b{color:red}</style><p class="b a js-toggle"id=b>`},
+		{`<style>#very-long-id{color:red}</style><p id="very-long-id"><a id="a">`, `<style>#b{color:red}</style><p id=b><a id=a>`},
+		{`<svg><style>.very-long-name{fill:red}</style><path class="a very-long-name"/></svg>`, `<svg><style>.very-long-name{fill:red}</style><path class="a b"/></svg>`},
+		{`<svg><use href="#unknown-fragment"/><rect style="filter:url(#main-filter)"/></svg>`, `<svg><use href="#unknown-fragment"/><rect style="filter:url(#main-filter)"/></svg>`},
+	}
+
+	m := minify.New()
+	for _, tt := range htmlTests {
+		t.Run(tt.html, func(t *testing.T) {
+			renameMap := minify.NewRenameMap()
+			m.Add("text/css", &css.Minifier{RenameMap: renameMap})
+			r := bytes.NewBufferString(tt.html)
+			w := &bytes.Buffer{}
+			htmlMinifier := &Minifier{RenameMap: renameMap}
+			err := htmlMinifier.Minify(m, w, r, nil)
+			test.Minify(t, tt.html, err, w.String(), tt.expected)
+		})
+	}
+}
+
+func TestCollectNames(t *testing.T) {
+	names := collectNames(minify.NewRenameMap(), []byte(`<p id="main" class="card"><STYLE>.card>a.link:hover,.btn,#main{width:.5em;background:url(a.png);filter:url( "#blur" )}</STYLE><svg><style><![CDATA[.icon-part{fill:#fff}]]></style><path id="icon"/></svg>`))
+	test.T(t, len(names.ids), 2)
+	test.T(t, names.ids["main"], true)
+	test.T(t, names.ids["blur"], true)
+	test.T(t, len(names.classes), 4)
+	for _, class := range []string{"card", "link", "btn", "icon-part"} {
+		test.T(t, names.classes[class], true, class)
+	}
+}
+
+func TestHTMLRenameDefinedNames(t *testing.T) {
+	// classes and IDs that were renamed by a stylesheet minified before the document are renamed as well
+	renameMap := minify.NewRenameMap()
+	renameMap.Class([]byte("card__title"))
+	renameMap.ID([]byte("main-content"))
+
+	m := minify.New()
+	input := `<div class="card__title unknown-class" id="main-content"><a href="#main-content">x</a><a href="#installation">y</a></div>`
+	w := &bytes.Buffer{}
+	htmlMinifier := &Minifier{RenameMap: renameMap}
+	err := htmlMinifier.Minify(m, w, bytes.NewBufferString(input), nil)
+	test.Minify(t, input, err, w.String(), `<div class="a unknown-class"id=a><a href=#a>x</a><a href=#installation>y</a></div>`)
+}
+
 func TestHTMLURL(t *testing.T) {
 	htmlTests := []struct {
 		url      string
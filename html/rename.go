@@ -0,0 +1,279 @@
+package html
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+	"github.com/tdewolff/parse/v2/html"
+	"github.com/tdewolff/parse/v2/xml"
+)
+
+var (
+	urlFragmentBytes = []byte("url(#")
+	styleBytes       = []byte("style")
+	classBytes       = []byte("class")
+)
+
+// idRefsAttrs are the attributes that reference IDs, separated by whitespace.
+var idRefsAttrs = map[string]bool{
+	"for":                   true,
+	"form":                  true,
+	"list":                  true,
+	"headers":               true,
+	"itemref":               true,
+	"popovertarget":         true,
+	"aria-activedescendant": true,
+	"aria-controls":         true,
+	"aria-describedby":      true,
+	"aria-details":          true,
+	"aria-errormessage":     true,
+	"aria-flowto":           true,
+	"aria-labelledby":       true,
+	"aria-owns":             true,
+}
+
+// docNames holds the classes and IDs defined by the style elements of the document, including those of inline SVG, so that the classes and IDs that precede their style element are renamed as well.
+type docNames struct {
+	classes map[string]bool
+	ids     map[string]bool
+}
+
+// isClass returns true if the class is defined by the document or has been renamed before, and is thus renamed.
+func (names docNames) isClass(renames *minify.RenameMap, class []byte) bool {
+	return names.classes[string(class)] || renames.HasClass(class)
+}
+
+// isID returns true if the ID is used by the document's style elements or has been renamed before, and is thus renamed.
+func (names docNames) isID(renames *minify.RenameMap, id []byte) bool {
+	return names.ids[string(id)] || renames.HasID(id)
+}
+
+// renameAttrVal renames the classes and IDs in an attribute value, as well as the IDs referenced by the value. Classes and IDs are renamed only when the document's style elements use them or when a stylesheet has renamed them before, so that the classes and IDs used only by scripts, other stylesheets or links from other documents are kept.
+func renameAttrVal(renames *minify.RenameMap, names docNames, attr Token, val []byte) []byte {
+	if len(val) == 0 {
+		return val
+	} else if attr.Hash == Class {
+		return renameClasses(renames, names, val)
+	} else if attr.Hash == Id {
+		return renameID(renames, names, val)
+	} else if attr.Hash == Href {
+		return renameFragment(renames, names, val)
+	} else if idRefsAttrs[string(parse.ToLower(parse.Copy(attr.Text)))] {
+		return renameFields(val, func(id []byte) []byte {
+			return renameID(renames, names, id)
+		})
+	}
+	return val
+}
+
+// renameClasses renames the classes in a list separated by whitespace that are defined in the document or that have been renamed before, and keeps the classes that are used only in HTML.
+func renameClasses(renames *minify.RenameMap, names docNames, val []byte) []byte {
+	return renameFields(val, func(class []byte) []byte {
+		if names.isClass(renames, class) {
+			return renames.Class(class)
+		}
+		return class
+	})
+}
+
+// renameID renames an ID that is used by the document's style elements or that has been renamed before.
+func renameID(renames *minify.RenameMap, names docNames, id []byte) []byte {
+	if names.isID(renames, id) {
+		return renames.ID(id)
+	}
+	return id
+}
+
+// renameFragment renames the ID of a URL fragment that is used by the document's style elements or that has been renamed before.
+func renameFragment(renames *minify.RenameMap, names docNames, val []byte) []byte {
+	if 1 < len(val) && val[0] == '#' && names.isID(renames, val[1:]) {
+		return append([]byte{'#'}, renames.ID(val[1:])...)
+	}
+	return val
+}
+
+// renameURLRefs renames the IDs of the url(#id) references in an attribute value, such as fill="url(#gradient)".
+func renameURLRefs(renames *minify.RenameMap, names docNames, val []byte) []byte {
+	var b []byte
+	for {
+		i := bytes.Index(val, urlFragmentBytes)
+		if i == -1 {
+			break
+		}
+		i += len(urlFragmentBytes)
+		j := bytes.IndexByte(val[i:], ')')
+		if j == -1 {
+			break
+		}
+		b = append(b, val[:i]...)
+		b = append(b, renameID(renames, names, val[i:i+j])...)
+		val = val[i+j:]
+	}
+	if b == nil {
+		return val
+	}
+	return append(b, val...)
+}
+
+// renameSVG renames the classes and IDs in the attributes of inline SVG, as well as the URL fragments of href and xlink:href and the url(#id) references of presentation attributes, before it is minified as SVG. Style attributes are left to the CSS minifier.
+func renameSVG(renames *minify.RenameMap, names docNames, b []byte) []byte {
+	w := make([]byte, 0, len(b))
+	l := xml.NewLexer(parse.NewInputBytes(parse.Copy(b)))
+	for {
+		tt, data := l.Next()
+		if tt == xml.ErrorToken {
+			if l.Err() != io.EOF {
+				return b
+			}
+			return w
+		} else if tt == xml.AttributeToken && l.AttrVal() != nil {
+			name, val := l.Text(), l.AttrVal()
+			quote := byte('"')
+			if 1 < len(val) && (val[0] == '"' || val[0] == '\'') {
+				quote, val = val[0], val[1:len(val)-1]
+			}
+			if rename := renameSVGAttrVal(renames, names, name, val); !bytes.Equal(rename, val) {
+				data = append(append(append(append([]byte{' '}, name...), '=', quote), rename...), quote)
+			}
+		}
+		w = append(w, data...)
+	}
+}
+
+// renameSVGAttrVal renames the classes, IDs, and ID references in an attribute value of inline SVG.
+func renameSVGAttrVal(renames *minify.RenameMap, names docNames, name, val []byte) []byte {
+	switch string(name) {
+	case "class":
+		return renameClasses(renames, names, val)
+	case "id":
+		if val = parse.TrimWhitespace(val); len(val) != 0 {
+			return renameID(renames, names, val)
+		}
+	case "href", "xlink:href":
+		return renameFragment(renames, names, parse.TrimWhitespace(val))
+	case "style":
+	default:
+		return renameURLRefs(renames, names, val)
+	}
+	return val
+}
+
+// collectNames returns the classes and IDs used by the style elements of the document, including those of inline SVG. The classes and IDs of the document that are kept as is are reserved in the rename map, so that no other name is renamed to them.
+func collectNames(renames *minify.RenameMap, b []byte) docNames {
+	names := docNames{map[string]bool{}, map[string]bool{}}
+	classes, ids := [][]byte{}, [][]byte{} // classes and IDs of the elements
+	inStyle := false
+	l := html.NewLexer(parse.NewInputBytes(b))
+	for {
+		tt, data := l.Next()
+		if tt == html.ErrorToken {
+			break
+		} else if tt == html.StartTagToken || tt == html.EndTagToken {
+			inStyle = tt == html.StartTagToken && parse.EqualFold(l.Text(), styleBytes)
+		} else if tt == html.TextToken && inStyle {
+			collectSelectorNames(names, data)
+		} else if tt == html.AttributeToken && (parse.EqualFold(l.Text(), idBytes) || parse.EqualFold(l.Text(), classBytes)) {
+			val := l.AttrVal()
+			if 1 < len(val) && (val[0] == '"' || val[0] == '\'') {
+				val = val[1 : len(val)-1]
+			}
+			val = parse.ReplaceMultipleWhitespaceAndEntities(parse.Copy(val), EntitiesMap, nil)
+			if parse.EqualFold(l.Text(), idBytes) {
+				if val = parse.TrimWhitespace(val); len(val) != 0 {
+					ids = append(ids, val)
+				}
+			} else {
+				classes = append(classes, bytes.Fields(val)...)
+			}
+		} else if tt == html.SvgToken {
+			svg := xml.NewLexer(parse.NewInputBytes(parse.Copy(data)))
+			inSVGStyle := false
+			for {
+				tt, data := svg.Next()
+				if tt == xml.ErrorToken {
+					break
+				} else if tt == xml.StartTagToken || tt == xml.EndTagToken {
+					inSVGStyle = tt == xml.StartTagToken && bytes.Equal(svg.Text(), styleBytes)
+				} else if tt == xml.TextToken && inSVGStyle {
+					collectSelectorNames(names, data)
+				} else if tt == xml.CDATAToken && inSVGStyle {
+					collectSelectorNames(names, bytes.TrimSuffix(svg.Text(), []byte("]]>")))
+				} else if tt == xml.AttributeToken && (bytes.Equal(svg.Text(), idBytes) || bytes.Equal(svg.Text(), classBytes)) {
+					val := svg.AttrVal()
+					if 1 < len(val) && (val[0] == '"' || val[0] == '\'') {
+						val = val[1 : len(val)-1]
+					}
+					if bytes.Equal(svg.Text(), idBytes) {
+						if val = parse.TrimWhitespace(val); len(val) != 0 {
+							ids = append(ids, parse.Copy(val))
+						}
+					} else {
+						classes = append(classes, bytes.Fields(parse.Copy(val))...)
+					}
+				}
+			}
+		}
+	}
+
+	for _, class := range classes {
+		if !names.isClass(renames, class) {
+			renames.ReserveClass(class)
+		}
+	}
+	for _, id := range ids {
+		if !names.isID(renames, id) {
+			renames.ReserveID(id)
+		}
+	}
+	return names
+}
+
+// collectSelectorNames adds the classes and IDs of the selectors in a stylesheet, as well as the IDs of its url(#id) references, which the CSS minifier renames.
+func collectSelectorNames(names docNames, b []byte) {
+	p := css.NewParser(parse.NewInputBytes(parse.Copy(b)), false)
+	for {
+		gt, _, _ := p.Next()
+		if gt == css.ErrorGrammar {
+			return
+		}
+		dot := false
+		for _, val := range p.Values() {
+			if gt == css.BeginRulesetGrammar || gt == css.QualifiedRuleGrammar {
+				if val.TokenType == css.IdentToken && dot {
+					names.classes[string(val.Data)] = true
+				} else if val.TokenType == css.HashToken {
+					names.ids[string(val.Data[1:])] = true
+				}
+				dot = val.TokenType == css.DelimToken && val.Data[0] == '.'
+			} else if val.TokenType == css.URLToken {
+				if uri := urlFragment(val.Data); uri != nil {
+					names.ids[string(uri)] = true
+				}
+			}
+		}
+	}
+}
+
+// urlFragment returns the ID of a url(#id) token, or nil.
+func urlFragment(b []byte) []byte {
+	b = parse.TrimWhitespace(b[4 : len(b)-1])
+	if 0 < len(b) && (b[0] == '"' || b[0] == '\'') {
+		b = b[1 : len(b)-1]
+	}
+	if 1 < len(b) && b[0] == '#' {
+		return b[1:]
+	}
+	return nil
+}
+
+// renameFields renames the names in a list separated by whitespace.
+func renameFields(val []byte, rename func([]byte) []byte) []byte {
+	fields := bytes.Fields(val)
+	for i, field := range fields {
+		fields[i] = rename(field)
+	}
+	return bytes.Join(fields, spaceBytes)
+}
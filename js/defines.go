@@ -0,0 +1,132 @@
+package js
+
+import (
+	"fmt"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/js"
+)
+
+// replaceDefines replaces undeclared global variables and member expressions on them, such as DEBUG or process.env.NODE_ENV, by the JS expressions of the defines. Assignment targets, including destructuring patterns and the variables of for-in and for-of loops, are not replaced, nor is anything in with statements where names may resolve to properties of the object.
+func replaceDefines(ast *js.AST, defines map[string]string) error {
+	for name, value := range defines {
+		_, undeclared, err := parseDefine(value)
+		if err != nil {
+			return fmt.Errorf("define %s: %w", name, err)
+		}
+		ast.Undeclared = append(ast.Undeclared, undeclared...) // reserve names used by the define
+	}
+
+	// assignment targets and with bodies are removed from the AST while walking, since the uses of a global are the same *js.Var, and are put back afterwards
+	skip := map[js.IExpr]bool{}
+	var hiddenExprs []*js.IExpr
+	var hiddenStmts []*js.IStmt
+	exprs, stmts := []js.IExpr{}, []js.IStmt{}
+	var w *walker
+	var walkTarget func(js.IExpr)
+	walkTarget = func(iexpr js.IExpr) {
+		// replace in the default values and computed property names of destructuring patterns
+		switch expr := iexpr.(type) {
+		case *js.GroupExpr:
+			walkTarget(expr.X)
+		case *js.ArrayExpr:
+			for _, item := range expr.List {
+				walkTarget(item.Value)
+			}
+		case *js.ObjectExpr:
+			for _, item := range expr.List {
+				if item.Name != nil && item.Name.IsComputed() {
+					item.Name.Computed = w.walkExpr(item.Name.Computed)
+				}
+				walkTarget(item.Value)
+				if item.Init != nil {
+					item.Init = w.walkExpr(item.Init)
+				}
+			}
+		case *js.BinaryExpr:
+			if expr.Op == js.EqToken {
+				walkTarget(expr.X)
+				expr.Y = w.walkExpr(expr.Y)
+			}
+		case *js.DotExpr:
+			expr.X = w.walkExpr(expr.X)
+		case *js.IndexExpr:
+			expr.X = w.walkExpr(expr.X)
+			expr.Index = w.walkExpr(expr.Index)
+		}
+	}
+	hideTarget := func(p *js.IExpr) {
+		if _, ok := (*p).(*js.VarDecl); ok {
+			return
+		}
+		walkTarget(*p)
+		hiddenExprs = append(hiddenExprs, p)
+		exprs = append(exprs, *p)
+		*p = nil
+	}
+	w = &walker{
+		stmt: func(istmt js.IStmt) js.IStmt {
+			switch stmt := istmt.(type) {
+			case *js.ForInStmt:
+				hideTarget(&stmt.Init)
+			case *js.ForOfStmt:
+				hideTarget(&stmt.Init)
+			case *js.WithStmt:
+				// names may resolve to properties of the object
+				hiddenStmts = append(hiddenStmts, &stmt.Body)
+				stmts = append(stmts, stmt.Body)
+				stmt.Body = nil
+			}
+			return istmt
+		},
+		expr: func(iexpr js.IExpr) js.IExpr {
+			if skip[iexpr] {
+				return iexpr
+			}
+			switch expr := iexpr.(type) {
+			case *js.BinaryExpr:
+				if binaryOpPrecMap[expr.Op] == js.OpAssign {
+					hideTarget(&expr.X)
+				}
+			case *js.UnaryExpr:
+				if unaryOpPrecMap[expr.Op] == js.OpUpdate || expr.Op == js.DeleteToken {
+					hideTarget(&expr.X)
+				}
+			}
+			if name := memberName(iexpr); name != nil {
+				if value, ok := defines[string(name)]; ok {
+					// parse for every replacement so that they are distinct expressions
+					expr, undeclared, _ := parseDefine(value)
+					for _, v := range undeclared {
+						skip[v] = true // do not replace recursively
+					}
+					return expr
+				}
+			}
+			return iexpr
+		},
+	}
+	w.walkAST(ast)
+	for i, p := range hiddenExprs {
+		*p = exprs[i]
+	}
+	for i, p := range hiddenStmts {
+		*p = stmts[i]
+	}
+	return nil
+}
+
+// parseDefine parses the JS expression of a define and returns its undeclared variables.
+func parseDefine(value string) (js.IExpr, js.VarArray, error) {
+	ast, err := js.Parse(parse.NewInputString("(" + value + ")"))
+	if err != nil {
+		return nil, nil, err
+	} else if len(ast.List) != 1 {
+		return nil, nil, fmt.Errorf("must be a single expression")
+	}
+	exprStmt, ok := ast.List[0].(*js.ExprStmt)
+	if !ok {
+		return nil, nil, fmt.Errorf("must be a single expression")
+	}
+	return exprStmt.Value, ast.Undeclared, nil
+}
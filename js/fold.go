@@ -0,0 +1,375 @@
+package js
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/js"
+)
+
+var (
+	trueBytes      = []byte("true")
+	falseBytes     = []byte("false")
+	nullBytes      = []byte("null")
+	stringBytes    = []byte(`"string"`)
+	numberBytes    = []byte(`"number"`)
+	booleanBytes   = []byte(`"boolean"`)
+	objectBytes    = []byte(`"object"`)
+	undefinedQuote = []byte(`"undefined"`)
+)
+
+// foldConstants evaluates expressions of literals throughout the AST.
+func (m *jsMinifier) foldConstants(ast *js.AST) {
+	w := &walker{
+		expr: m.foldExpr,
+	}
+	w.walkAST(ast)
+}
+
+// foldExpr evaluates unary, binary and conditional expressions of literals, it returns the replacement expression. Operands with side-effects are never removed.
+func (m *jsMinifier) foldExpr(i js.IExpr) js.IExpr {
+	switch expr := i.(type) {
+	case *js.GroupExpr:
+		expr.X = m.foldExpr(expr.X)
+		if lit, ok := expr.X.(*js.LiteralExpr); ok {
+			return lit
+		}
+	case *js.CallExpr:
+		expr.X = m.foldRef(expr.X, false)
+	case *js.OptChainExpr:
+		if _, ok := expr.Y.(*js.CallExpr); ok {
+			expr.X = m.foldRef(expr.X, false)
+		}
+	case *js.TemplateExpr:
+		if expr.Tag != nil {
+			expr.Tag = m.foldRef(expr.Tag, false)
+		}
+	case *js.UnaryExpr:
+		if expr.Op == js.DeleteToken {
+			expr.X = m.foldRef(expr.X, true)
+			break
+		}
+		expr.X = m.foldExpr(expr.X)
+		if expr.Op == js.NotToken {
+			if truthy, ok := m.isTruthy(expr.X); ok && !m.hasSideEffects(expr.X) {
+				return booleanExpr(!truthy)
+			}
+		} else if expr.Op == js.TypeofToken {
+			if lit, ok := expr.X.(*js.LiteralExpr); ok {
+				switch lit.TokenType {
+				case js.StringToken:
+					return &js.LiteralExpr{TokenType: js.StringToken, Data: stringBytes}
+				case js.DecimalToken, js.BinaryToken, js.OctalToken, js.HexadecimalToken:
+					return &js.LiteralExpr{TokenType: js.StringToken, Data: numberBytes}
+				case js.TrueToken, js.FalseToken:
+					return &js.LiteralExpr{TokenType: js.StringToken, Data: booleanBytes}
+				case js.NullToken:
+					return &js.LiteralExpr{TokenType: js.StringToken, Data: objectBytes}
+				}
+			} else if m.isUndefined(expr.X) && !m.hasSideEffects(expr.X) {
+				return &js.LiteralExpr{TokenType: js.StringToken, Data: undefinedQuote}
+			}
+		}
+	case *js.BinaryExpr:
+		expr.X = m.foldExpr(expr.X)
+		expr.Y = m.foldExpr(expr.Y)
+		if expr.Op == js.AndToken || expr.Op == js.OrToken {
+			// true&&a  =>  a  and  false&&a  =>  false
+			if truthy, ok := m.isTruthy(expr.X); ok && !m.hasSideEffects(expr.X) {
+				if truthy == (expr.Op == js.AndToken) {
+					return groupExpr(expr.Y, binaryOpPrecMap[expr.Op])
+				}
+				return expr.X
+			}
+		} else if expr.Op == js.NullishToken {
+			// null??a  =>  a  and  1??a  =>  1
+			if lit, ok := expr.X.(*js.LiteralExpr); ok {
+				if lit.TokenType == js.NullToken {
+					return groupExpr(expr.Y, js.OpCoalesce)
+				} else if _, ok := m.isTruthy(lit); ok {
+					return lit
+				}
+			} else if m.isUndefined(expr.X) && !m.hasSideEffects(expr.X) {
+				return groupExpr(expr.Y, js.OpCoalesce)
+			}
+		} else if fold := m.foldBinaryExpr(expr); fold != nil {
+			return fold
+		}
+	case *js.CondExpr:
+		expr.Cond = m.foldExpr(expr.Cond)
+		expr.X = m.foldExpr(expr.X)
+		expr.Y = m.foldExpr(expr.Y)
+		if truthy, ok := m.isTruthy(expr.Cond); ok && !m.hasSideEffects(expr.Cond) {
+			if truthy {
+				return groupExpr(expr.X, js.OpAssign)
+			}
+			return groupExpr(expr.Y, js.OpAssign)
+		}
+	}
+	return i
+}
+
+// foldRef folds the callee of a call, the tag of a template, or the operand of delete. When the result is a reference, such as a member expression that would be called with its object as this, or eval that would become a direct eval, it is kept as (0,x) to retain the value semantics. For delete, every variable is a reference as well.
+func (m *jsMinifier) foldRef(i js.IExpr, isDelete bool) js.IExpr {
+	group, ok := i.(*js.GroupExpr)
+	if !ok {
+		return i
+	} else if _, ok := group.X.(*js.GroupExpr); ok {
+		group.X = m.foldRef(group.X, isDelete)
+		return i
+	}
+	x := m.foldExpr(group.X)
+	if x != group.X && isRefExpr(x, isDelete) {
+		x = &js.BinaryExpr{Op: js.CommaToken, X: &js.LiteralExpr{TokenType: js.DecimalToken, Data: zeroBytes}, Y: x}
+	}
+	group.X = x
+	if lit, ok := x.(*js.LiteralExpr); ok {
+		return lit
+	}
+	return i
+}
+
+// isRefExpr returns true if the expression is a member expression or eval, or any variable when isVar is set, whose reference semantics differ from its value.
+func isRefExpr(i js.IExpr, isVar bool) bool {
+	for {
+		group, ok := i.(*js.GroupExpr)
+		if !ok {
+			break
+		}
+		i = group.X
+	}
+	switch expr := i.(type) {
+	case *js.DotExpr, *js.IndexExpr, *js.OptChainExpr:
+		return true
+	case *js.Var:
+		return isVar || isGlobalVar(expr, evalBytes)
+	}
+	return false
+}
+
+// foldBinaryExpr evaluates arithmetic, bitwise, comparison and string concatenation operations of literals, or returns nil when it cannot be evaluated or is not shorter.
+func (m *jsMinifier) foldBinaryExpr(expr *js.BinaryExpr) js.IExpr {
+	if expr.Op == js.AddToken {
+		// "a"+"b"  =>  "ab"  and  a+"b"+"c"  =>  a+"bc"
+		left, isStringX := expr.X.(*js.LiteralExpr)
+		right, isStringY := expr.Y.(*js.LiteralExpr)
+		isStringX = isStringX && left.TokenType == js.StringToken
+		isStringY = isStringY && right.TokenType == js.StringToken
+		if isStringX {
+			if y, ok := stringValue(expr.Y); ok {
+				return &js.LiteralExpr{TokenType: js.StringToken, Data: concatStrings(left.Data, y)}
+			}
+		} else if isStringY {
+			if x, ok := stringValue(expr.X); ok {
+				x = append(append([]byte{'"'}, x...), '"')
+				return &js.LiteralExpr{TokenType: js.StringToken, Data: concatStrings(x, right.Data)}
+			} else if binary, ok := expr.X.(*js.BinaryExpr); ok && binary.Op == js.AddToken {
+				if lit, ok := binary.Y.(*js.LiteralExpr); ok && lit.TokenType == js.StringToken {
+					binary.Y = &js.LiteralExpr{TokenType: js.StringToken, Data: concatStrings(lit.Data, right.Data)}
+					return binary
+				}
+			}
+		}
+	}
+
+	if expr.Op == js.EqEqEqToken || expr.Op == js.NotEqEqToken || expr.Op == js.EqEqToken || expr.Op == js.NotEqToken {
+		left, okLeft := expr.X.(*js.LiteralExpr)
+		right, okRight := expr.Y.(*js.LiteralExpr)
+		if okLeft && okRight && left.TokenType == js.StringToken && right.TokenType == js.StringToken && bytes.IndexByte(left.Data, '\\') == -1 && bytes.IndexByte(right.Data, '\\') == -1 {
+			equal := bytes.Equal(left.Data[1:len(left.Data)-1], right.Data[1:len(right.Data)-1])
+			return booleanExpr(equal == (expr.Op == js.EqEqEqToken || expr.Op == js.EqEqToken))
+		}
+	}
+
+	x, okX := numberValue(expr.X)
+	y, okY := numberValue(expr.Y)
+	if !okX || !okY {
+		return nil
+	}
+
+	var z float64
+	switch expr.Op {
+	case js.AddToken:
+		z = x + y
+	case js.SubToken:
+		z = x - y
+	case js.MulToken:
+		z = x * y
+	case js.DivToken:
+		z = x / y
+	case js.ModToken:
+		z = math.Mod(x, y)
+	case js.ExpToken:
+		z = math.Pow(x, y)
+	case js.BitAndToken:
+		z = float64(toInt32(x) & toInt32(y))
+	case js.BitOrToken:
+		z = float64(toInt32(x) | toInt32(y))
+	case js.BitXorToken:
+		z = float64(toInt32(x) ^ toInt32(y))
+	case js.LtLtToken:
+		z = float64(toInt32(x) << (toUint32(y) & 0x1F))
+	case js.GtGtToken:
+		z = float64(toInt32(x) >> (toUint32(y) & 0x1F))
+	case js.GtGtGtToken:
+		z = float64(toUint32(x) >> (toUint32(y) & 0x1F))
+	case js.LtToken:
+		return booleanExpr(x < y)
+	case js.LtEqToken:
+		return booleanExpr(x <= y)
+	case js.GtToken:
+		return booleanExpr(x > y)
+	case js.GtEqToken:
+		return booleanExpr(x >= y)
+	case js.EqEqToken, js.EqEqEqToken:
+		return booleanExpr(x == y)
+	case js.NotEqToken, js.NotEqEqToken:
+		return booleanExpr(x != y)
+	default:
+		return nil
+	}
+	if math.IsNaN(z) || math.IsInf(z, 0) {
+		return nil
+	}
+
+	// only fold when not longer than the original expression
+	num := minify.Number([]byte(strconv.FormatFloat(math.Abs(z), 'g', -1, 64)), 0)
+	n := len(expr.Op.Bytes()) + numberLength(expr.X) + numberLength(expr.Y)
+	if math.Signbit(z) {
+		if n < len(num)+1 {
+			return nil
+		}
+		return &js.GroupExpr{X: &js.UnaryExpr{Op: js.NegToken, X: &js.LiteralExpr{TokenType: js.DecimalToken, Data: num}}}
+	} else if n < len(num) {
+		return nil
+	}
+	return &js.LiteralExpr{TokenType: js.DecimalToken, Data: num}
+}
+
+func booleanExpr(b bool) *js.LiteralExpr {
+	if b {
+		return &js.LiteralExpr{TokenType: js.TrueToken, Data: trueBytes}
+	}
+	return &js.LiteralExpr{TokenType: js.FalseToken, Data: falseBytes}
+}
+
+// numberValue returns the value of a (negated) numeric literal.
+func numberValue(i js.IExpr) (float64, bool) {
+	negate := false
+	if group, ok := i.(*js.GroupExpr); ok {
+		i = group.X
+	}
+	if unary, ok := i.(*js.UnaryExpr); ok && unary.Op == js.NegToken {
+		negate = true
+		i = unary.X
+	}
+	lit, ok := i.(*js.LiteralExpr)
+	if !ok {
+		return 0, false
+	}
+	var data []byte
+	switch lit.TokenType {
+	case js.DecimalToken:
+		data = lit.Data
+	case js.BinaryToken:
+		data = binaryNumber(parse.Copy(lit.Data))
+	case js.OctalToken:
+		data = octalNumber(parse.Copy(lit.Data))
+	case js.HexadecimalToken:
+		data = hexadecimalNumber(parse.Copy(lit.Data))
+	default:
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(string(data), 64)
+	if err != nil || math.IsInf(f, 0) {
+		return 0, false
+	} else if negate {
+		f = -f
+	}
+	return f, true
+}
+
+// numberLength returns the length of the minified (negated) numeric literal.
+func numberLength(i js.IExpr) int {
+	n := 0
+	if group, ok := i.(*js.GroupExpr); ok {
+		i = group.X
+		n += 2
+	}
+	if unary, ok := i.(*js.UnaryExpr); ok {
+		i = unary.X
+		n++
+	}
+	if lit, ok := i.(*js.LiteralExpr); ok && lit.TokenType == js.DecimalToken {
+		n += len(minify.Number(lit.Data, 0))
+	} else if ok {
+		n += len(lit.Data)
+	}
+	return n
+}
+
+// stringValue returns the string representation of a string, number, boolean or null literal, which is quoted only for string literals.
+func stringValue(i js.IExpr) ([]byte, bool) {
+	if lit, ok := i.(*js.LiteralExpr); ok {
+		switch lit.TokenType {
+		case js.StringToken:
+			return lit.Data, true
+		case js.TrueToken:
+			return trueBytes, true
+		case js.FalseToken:
+			return falseBytes, true
+		case js.NullToken:
+			return nullBytes, true
+		}
+	}
+	if f, ok := numberValue(i); ok && (f == 0 || 1e-6 <= math.Abs(f) && math.Abs(f) < 1e21) {
+		if f == 0 {
+			f = 0 // -0 is "0"
+		}
+		return []byte(strconv.FormatFloat(f, 'f', -1, 64)), true
+	}
+	return nil, false
+}
+
+// concatStrings concatenates a string literal and a quoted or unquoted string using the quotes of the former.
+func concatStrings(a, b []byte) []byte {
+	quote := a[0]
+	c := make([]byte, 0, len(a)+len(b))
+	c = append(c, a[:len(a)-1]...)
+	if b[0] == quote {
+		c = append(c, b[1:len(b)-1]...)
+	} else {
+		if b[0] == '"' || b[0] == '\'' {
+			b = b[1 : len(b)-1]
+		}
+		for i := 0; i < len(b); i++ {
+			if b[i] == '\\' && i+1 < len(b) {
+				c = append(c, b[i], b[i+1])
+				i++
+				continue
+			} else if b[i] == quote {
+				c = append(c, '\\')
+			}
+			c = append(c, b[i])
+		}
+	}
+	return append(c, quote)
+}
+
+func toInt32(f float64) int32 {
+	return int32(toUint32(f))
+}
+
+func toUint32(f float64) uint32 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	f = math.Mod(math.Trunc(f), 4294967296.0)
+	if f < 0 {
+		f += 4294967296.0
+	}
+	return uint32(f)
+}
@@ -1,9 +1,12 @@
-// Package js minifies ECMAScript5.1 following the specifications at http://www.ecma-international.org/ecma-262/5.1/.
+// Package js minifies ECMAScript 2020 following the specifications at https://tc39.es/ecma262/, and can rewrite the output to older versions down to ECMAScript 5.1 (see Minifier.Target).
 package js
 
 import (
 	"bytes"
 	"io"
+	"math"
+	"regexp"
+	"sort"
 
 	"github.com/tdewolff/minify/v2"
 	"github.com/tdewolff/parse/v2"
@@ -25,6 +28,44 @@ var DefaultMinifier = &Minifier{}
 type Minifier struct {
 	Precision    int // number of significant digits
 	KeepVarNames bool
+
+	// MangleTopLevel renames variables in the global scope as well, which is unsafe when other scripts use them unless they share a NameCache.
+	MangleTopLevel bool
+	NameCache      *NameCache // original to short names of globals, which is loaded and updated when renaming the global scope
+
+	MangleProps   *regexp.Regexp // rename property names that match, such as ^_ for private members
+	ReservedProps []string       // property names that are never renamed
+
+	KeepDebugger bool     // keep debugger statements, which are removed by default
+	PureFuncs    []string // (dotted) names of functions without side-effects, such as console.log, whose calls are removed when the result is unused
+
+	// TreeShaking removes unused declarations without side-effects in functions and at the top-level of ES modules. Calls annotated by /*#__PURE__*/ are considered without side-effects.
+	TreeShaking bool
+
+	Defines map[string]string // replace undeclared globals and member expressions on them (eg. DEBUG or process.env.NODE_ENV) by JS expressions
+
+	// ModuleExports are the exported names of imported modules by import specifier. Namespace imports of these modules whose members are only read, such as import*as ns from"x";f(ns.a), are replaced by named imports when shorter.
+	ModuleExports map[string][]string
+
+	// Beautify writes each statement on its own line, indents blocks by two spaces, and puts spaces around operators and after commas, which is useful for debugging. The output is minified otherwise.
+	Beautify bool
+
+	// MaxLineLen starts a new line after a semicolon, closing brace, or comma once the line is longer than the given number of bytes, for tools that cannot handle long lines. These are positions where automatic semicolon insertion does not apply.
+	MaxLineLen int
+
+	// Comments selects the comments that are kept, which are written at the top of the output in their original order. By default license comments starting with /*! or //! are kept. Share it with the CSS and HTML minifiers to handle comments consistently.
+	Comments *minify.Comments
+
+	// ASCIIOnly escapes non-ASCII characters in strings, template literals, regular expressions, and identifiers, for scripts served without a character encoding. The raw strings of tagged templates are kept as they would change otherwise.
+	ASCIIOnly bool
+
+	// Target is the ECMAScript version that the output must support (see ParseTarget). Syntax that it does not support, such as arrow functions, template literals, let and const, classes, spread, default parameters, optional chaining, and nullish coalescing, is rewritten to older syntax, and shorter syntax such as arrow functions, ?? and ?. is only introduced when it is supported. Spread of iterables other than array literals and arguments, and object spread for ES5, use helper functions. For ES5, tagged templates are called with an array of strings with a raw property, for-of loops iterate over an array of the elements, and the body of a loop whose let or const variables are captured by closures is wrapped in a function that is called in every iteration. The variables added by the rewrite are declared at the top of their function, and a script is wrapped in a function when they would otherwise be its only globals. Syntax that cannot be rewritten, such as destructuring, generators, the regular expression flags u and y, or closures capturing let or const in a loop body that breaks, continues, returns, or declares var variables for ES5, returns an error. The zero value is the latest version.
+	Target Target
+
+	// SourceMap receives a source map (revision 3) of the output when set. The minifier is not safe for concurrent use when set.
+	SourceMap       io.Writer
+	SourceMapSource string // name of the input file in the source map
+	SourceMapFile   string // name of the output file in the source map
 }
 
 // Minify minifies JS data, it reads from r and writes to w.
@@ -35,36 +76,137 @@ func Minify(m *minify.M, w io.Writer, r io.Reader, params map[string]string) err
 // Minify minifies JS data, it reads from r and writes to w.
 func (o *Minifier) Minify(_ *minify.M, w io.Writer, r io.Reader, _ map[string]string) error {
 	z := parse.NewInput(r)
-	ast, err := js.Parse(z)
-	if err != nil {
-		return err
+	src := z.Bytes()
+	var ast *js.AST
+	var err error
+	if o.TreeShaking {
+		if marked := markPureAnnotations(src); marked != nil {
+			// fall back to the original when an annotation is not in front of an expression
+			markedInput := parse.NewInputBytes(marked)
+			if ast, err = js.Parse(markedInput); err != nil {
+				ast = nil
+			} else {
+				src = markedInput.Bytes()
+			}
+		}
+	}
+	if ast == nil {
+		if ast, err = js.Parse(z); err != nil {
+			return err
+		}
 	}
 
-	// license comments
-	for _, comment := range ast.Comments {
-		if 3 < len(comment) && comment[2] == '!' {
-			w.Write(comment)
-			if comment[1] == '/' {
-				w.Write(newlineBytes)
-			}
+	// comments after the first statement are found before the AST is changed
+	comments := ast.Comments
+	if o.Comments.MayKeep(src) {
+		comments = append(append([][]byte{}, comments...), sourceComments(src, ast)...)
+	}
+
+	// the positions of the nodes are recorded before the AST is changed
+	var positions *sourcePositions
+	if o.SourceMap != nil {
+		positions = newSourcePositions(src, ast)
+	}
+
+	if 0 < len(o.Defines) {
+		if err := replaceDefines(ast, o.Defines); err != nil {
+			return err
 		}
 	}
+	if o.Target != 0 && o.Target < ES2020 {
+		if err := lowerAST(ast, o.Target); err != nil {
+			return err
+		}
+	}
+
+	// the source map writer wraps the line writer so that it sees the inserted newlines
+	if 0 < o.MaxLineLen {
+		w = minify.NewLineWriter(w, o.MaxLineLen, ";},")
+	}
+	var sourceMap *minify.SourceMapWriter
+	if o.SourceMap != nil {
+		sourceMap = minify.NewSourceMapWriter(w, &minify.SourceMap{File: o.SourceMapFile}, o.SourceMapSource, src)
+		w = sourceMap
+	}
 
 	m := &jsMinifier{
 		o:       o,
 		w:       w,
 		renamer: newRenamer(ast, ast.Undeclared, !o.KeepVarNames),
+
+		pureCalls: map[js.IExpr]bool{},
+
+		sourceMap: sourceMap,
+	}
+	if sourceMap != nil {
+		m.renamer.names = map[*js.Var][]byte{}
+		m.positions = positions
+		m.mapRange = [2]int{0, len(src)}
+	}
+	if o.TreeShaking {
+		m.unwrapPureAnnotations(ast)
+	}
+	if !o.KeepVarNames && o.ModuleExports != nil && isModule(ast) {
+		shortenNamespaceImports(ast, o.ModuleExports)
 	}
+	if o.MangleProps != nil {
+		m.renamer.renameProperties(ast, o.MangleProps, o.ReservedProps)
+	}
+	if o.MangleTopLevel {
+		if err := m.renamer.renameTopLevel(o.NameCache); err != nil {
+			return err
+		}
+	}
+
+	// license comments
+	extracted := false
+	for _, comment := range comments {
+		if o.Comments.Extract(comment) {
+			if extracted {
+				continue
+			}
+			comment = append(append([]byte("/*! "), o.Comments.Banner()...), " */"...)
+			extracted = true
+		} else if !o.Comments.Keep(comment) {
+			continue
+		}
+		w.Write(comment)
+		if comment[1] == '/' || o.Beautify {
+			w.Write(newlineBytes)
+		}
+	}
+	m.foldConstants(ast)
 	m.hoistVars(&ast.BlockStmt)
+
+	// the top-level of scripts declares globals, which may be used by other scripts
+	treeShaking := o.TreeShaking && !hasEval(ast)
+	if treeShaking && isModule(ast) {
+		m.exported = exportedNames(ast)
+		m.removeUnused = true
+	}
+	m.strict = isModule(ast) || isStrict(ast.List)
 	ast.List = m.optimizeStmtList(ast.List, functionBlock)
-	for _, item := range ast.List {
+	m.removeUnused = treeShaking
+	for i, item := range ast.List {
 		m.writeSemicolon()
+		if i != 0 {
+			m.writeNewline()
+		}
 		m.minifyStmt(item)
 	}
+	if o.Beautify && 0 < len(ast.List) {
+		m.writeSemicolon()
+		w.Write(newlineBytes)
+	}
 
 	if _, err := w.Write(nil); err != nil {
 		return err
 	}
+	if sourceMap != nil {
+		if _, err := sourceMap.SourceMap.WriteTo(o.SourceMap); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -87,25 +229,333 @@ type jsMinifier struct {
 	groupedStmt    bool       // avoid ambiguous syntax by grouping the expression statement
 	inFor          bool
 	spaceBefore    byte
+	indent         int         // indentation level in beautify mode
+	keepUnicode    bool        // set while writing the raw strings of tagged templates
 	varsHoisted    *js.VarDecl // set when variables are hoisted to this declaration
+	strict         bool        // in strict mode code, where function declarations in blocks are scoped to the block
 
 	renamer *renamer
+
+	pureCalls    map[js.IExpr]bool // call and new expressions annotated by /*#__PURE__*/
+	removeUnused bool              // remove unused declarations in the current statement list
+	exported     map[string]bool   // names exported by export clauses
+
+	sourceMap *minify.SourceMapWriter // set when generating a source map
+	positions *sourcePositions        // positions in the input of the parsed AST
+	mapRange  [2]int                  // offsets in the input of the innermost parsed statement or expression being written
+	mapOffset int                     // offset in the input plus one that the next write is mapped to, or zero
+	mapName   []byte                  // original name for mapOffset
 }
 
 func (m *jsMinifier) write(b []byte) {
+	m.writeMapped(b, b, nil)
+}
+
+// writeMapped writes b and, when generating a source map, maps it to the position of src in the input with the original symbol name.
+func (m *jsMinifier) writeMapped(b, src, name []byte) {
 	// 0 < len(b)
-	if m.needsSpace && js.IsIdentifierContinue(b) || m.spaceBefore == b[0] {
+	if m.needsSpace && (js.IsIdentifierContinue(b) || m.o.Beautify && bytes.IndexByte(noSpaceBefore, b[0]) == -1) || m.spaceBefore == b[0] {
 		m.w.Write(spaceBytes)
 	}
-	m.w.Write(b)
+	if m.sourceMap != nil {
+		if 0 < m.mapOffset {
+			m.sourceMap.MapOffset(m.mapOffset-1, m.mapName)
+			m.mapOffset = 0
+		}
+		if src != nil {
+			m.sourceMap.Map(src, name)
+		}
+	}
+	if m.o.ASCIIOnly && !m.keepUnicode {
+		m.w.Write(escapeNonASCII(b, bytes.IndexByte(quoteBytes, b[0]) == -1))
+	} else {
+		m.w.Write(b)
+	}
 	m.prev = b
 	m.needsSpace = false
 	m.expectExpr = expectAny
 	m.spaceBefore = 0
 }
 
+// writeDeclVar writes the name of a declared variable, which is mapped to its declaration in the input with its original name for source maps. Declarations that were moved out of the statement being written, such as hoisted variables, are mapped to the first declaration that has not been written before.
+func (m *jsMinifier) writeDeclVar(v *js.Var) {
+	if m.sourceMap != nil {
+		if offset, ok := m.positions.offset(m.positions.decls[v], m.mapRange); ok {
+			m.mapVar(v, offset)
+		} else if offset, ok := m.positions.offset(m.positions.decls[v], [2]int{0, math.MaxInt32}); ok {
+			m.mapVar(v, offset)
+		}
+	}
+	m.writeMapped(v.Data, nil, nil)
+}
+
+// writeUseVar writes the name of a used variable, which is mapped to its use in the input with its original name for source maps. Declarations that were turned into assignments are mapped to their declaration.
+func (m *jsMinifier) writeUseVar(v *js.Var) {
+	if m.sourceMap != nil {
+		if offset, ok := m.positions.offset(m.positions.uses[v], m.mapRange); ok {
+			m.mapVar(v, offset)
+		} else if offset, ok := m.positions.offset(m.positions.decls[v], m.mapRange); ok {
+			m.mapVar(v, offset)
+		}
+	}
+	m.writeMapped(v.Data, nil, nil)
+}
+
+// mapVar maps the next write to the occurrence of a variable in the input.
+func (m *jsMinifier) mapVar(v *js.Var, offset int) {
+	m.mapOffset = offset + 1
+	m.mapName = m.renamer.names[v]
+}
+
+// mapNode maps the next write to the keyword of a parsed statement or declaration, and sets the range in the input in which its variables are found. It returns the previous range, which is restored after writing the node.
+func (m *jsMinifier) mapNode(node interface{}) [2]int {
+	mapRange := m.mapRange
+	if r, ok := m.positions.ranges[node]; ok {
+		m.mapRange = r
+	}
+	if offset, ok := m.positions.keywords[node]; ok {
+		m.mapOffset = offset + 1
+		m.mapName = nil
+	}
+	return mapRange
+}
+
+// stmtKeyword returns the keyword or label that a statement starts with, or nil.
+func stmtKeyword(istmt js.IStmt) []byte {
+	switch stmt := istmt.(type) {
+	case *js.VarDecl:
+		return stmt.TokenType.Bytes()
+	case *js.IfStmt:
+		return js.IfToken.Bytes()
+	case *js.WithStmt:
+		return js.WithToken.Bytes()
+	case *js.DoWhileStmt:
+		return js.DoToken.Bytes()
+	case *js.WhileStmt:
+		return js.WhileToken.Bytes()
+	case *js.ForStmt, *js.ForInStmt, *js.ForOfStmt:
+		return js.ForToken.Bytes()
+	case *js.SwitchStmt:
+		return js.SwitchToken.Bytes()
+	case *js.BranchStmt:
+		return stmt.Type.Bytes()
+	case *js.LabelledStmt:
+		return stmt.Label
+	case *js.ReturnStmt:
+		return js.ReturnToken.Bytes()
+	case *js.ThrowStmt:
+		return js.ThrowToken.Bytes()
+	case *js.TryStmt:
+		return js.TryToken.Bytes()
+	case *js.DebuggerStmt:
+		return js.DebuggerToken.Bytes()
+	case *js.FuncDecl:
+		if stmt.Async {
+			return asyncBytes
+		}
+		return functionBytes
+	case *js.ClassDecl:
+		return classBytes
+	case *js.ImportStmt:
+		return importBytes
+	case *js.ExportStmt:
+		return exportBytes
+	}
+	return nil
+}
+
+// sourcePositions holds the positions in the input of the parsed AST, which are recorded before the AST is changed so that the output is mapped to the input as it is written.
+type sourcePositions struct {
+	keywords map[interface{}]int    // offsets of the keywords of statements and declarations
+	ranges   map[interface{}][2]int // offsets of the start and end of statements and expressions, except variables
+	decls    map[*js.Var][]int      // offsets of the declarations of variables in source order
+	uses     map[*js.Var][]int      // offsets of the uses of variables in source order
+	written  map[int]bool           // offsets of the variables that have been written
+}
+
+// newSourcePositions records the positions in the input of the parsed AST. The AST is walked in source order alongside the tokens of the input. Literals, property names, and labels are found at the slices of the input that they hold. Keywords and variables, which hold no slice at their position, are found at the next token with their name, where names after a dot are skipped since they are property names. A slash is read as a regular expression only if the parser found one at its position.
+func newSourcePositions(src []byte, ast *js.AST) *sourcePositions {
+	p := &sourcePositions{
+		keywords: map[interface{}]int{},
+		ranges:   map[interface{}][2]int{},
+		decls:    map[*js.Var][]int{},
+		uses:     map[*js.Var][]int{},
+		written:  map[int]bool{},
+	}
+
+	type token struct {
+		data   []byte
+		offset int
+		member bool // name after a dot, which is a property name
+	}
+	tokens := []token{}
+	indices := map[int]int{} // token indices by offset
+	regExps := regExpOffsets(src, ast)
+	l := js.NewLexer(parse.NewInputBytes(src))
+	offset := 0
+	member := false
+	for {
+		tt, data := l.Next()
+		if tt == js.ErrorToken {
+			break
+		} else if (tt == js.DivToken || tt == js.DivEqToken) && regExps[offset] {
+			if tt, data = l.RegExp(); tt == js.ErrorToken {
+				break
+			}
+		}
+		if tt != js.WhitespaceToken && tt != js.LineTerminatorToken && tt != js.CommentToken && tt != js.CommentLineTerminatorToken {
+			indices[offset] = len(tokens)
+			tokens = append(tokens, token{data, offset, member})
+			member = tt == js.DotToken || tt == js.OptChainToken
+		}
+		offset += len(data)
+	}
+
+	type node struct {
+		node  interface{}
+		start int
+	}
+	nodes := []node{} // statements and expressions being walked
+	next := 0         // index of the token after the last one found
+	end := 0          // offset after the last token found
+	found := func(i int) int {
+		if next <= i {
+			next = i + 1
+		}
+		end = tokens[i].offset + len(tokens[i].data)
+		for j := len(nodes) - 1; 0 <= j && nodes[j].start == -1; j-- {
+			nodes[j].start = tokens[i].offset
+		}
+		return tokens[i].offset
+	}
+	findSlice := func(b []byte) (int, bool) {
+		if offset, ok := minify.SliceOffset(src, b); ok {
+			if i, ok := indices[offset]; ok {
+				return found(i), true
+			}
+		}
+		return 0, false
+	}
+	findName := func(name []byte) (int, bool) {
+		for i := next; i < len(tokens); i++ {
+			if !tokens[i].member && bytes.Equal(tokens[i].data, name) {
+				return found(i), true
+			}
+		}
+		return 0, false
+	}
+	findVar := func(vars map[*js.Var][]int, v *js.Var) {
+		for v.Link != nil {
+			v = v.Link
+		}
+		if offset, ok := findName(v.Data); ok {
+			vars[v] = append(vars[v], offset)
+		}
+	}
+	exit := func(n interface{}) {
+		if top := nodes[len(nodes)-1]; top.start != -1 {
+			p.ranges[n] = [2]int{top.start, end}
+		}
+		nodes = nodes[:len(nodes)-1]
+	}
+
+	w := &walker{
+		stmt: func(istmt js.IStmt) js.IStmt {
+			nodes = append(nodes, node{istmt, -1})
+			switch stmt := istmt.(type) {
+			case *js.LabelledStmt:
+				if offset, ok := findSlice(stmt.Label); ok {
+					p.keywords[istmt] = offset
+				}
+				return istmt
+			case *js.ImportStmt:
+				if offset, ok := findName(importBytes); ok {
+					p.keywords[istmt] = offset
+				}
+				findSlice(stmt.Default)
+				for _, alias := range stmt.List {
+					findSlice(alias.Name)
+					findSlice(alias.Binding)
+				}
+				findSlice(stmt.Module)
+				return istmt
+			case *js.ExportStmt:
+				if offset, ok := findName(exportBytes); ok {
+					p.keywords[istmt] = offset
+				}
+				for _, alias := range stmt.List {
+					findSlice(alias.Name)
+					findSlice(alias.Binding)
+				}
+				findSlice(stmt.Module)
+				return istmt
+			}
+			if keyword := stmtKeyword(istmt); keyword != nil {
+				if offset, ok := findName(keyword); ok {
+					p.keywords[istmt] = offset
+				}
+			}
+			if stmt, ok := istmt.(*js.BranchStmt); ok && stmt.Label != nil {
+				findSlice(stmt.Label)
+			}
+			return istmt
+		},
+		exitStmt: func(istmt js.IStmt) {
+			exit(istmt)
+		},
+		expr: func(iexpr js.IExpr) js.IExpr {
+			nodes = append(nodes, node{iexpr, -1})
+			switch expr := iexpr.(type) {
+			case *js.Var:
+				findVar(p.uses, expr)
+			case *js.LiteralExpr:
+				findSlice(expr.Data)
+			case *js.FuncDecl, *js.ClassDecl, *js.VarDecl:
+				if offset, ok := findName(stmtKeyword(expr.(js.IStmt))); ok {
+					p.keywords[iexpr] = offset
+				}
+			}
+			return iexpr
+		},
+		exitExpr: func(iexpr js.IExpr) {
+			if _, ok := iexpr.(*js.Var); ok {
+				// variables are shared by their occurrences
+				nodes = nodes[:len(nodes)-1]
+				return
+			}
+			exit(iexpr)
+		},
+		propertyName: func(name *js.PropertyName) {
+			if !name.IsComputed() {
+				findSlice(name.Literal.Data)
+			}
+		},
+		binding: func(v *js.Var) {
+			findVar(p.decls, v)
+		},
+	}
+	w.walkAST(ast)
+	return p
+}
+
+// offset returns the first offset of the occurrences of a variable within the range of the input that has not been written before, or the first offset within the range if all have been written. The parser keeps one variable for all occurrences, so this is how an occurrence in the output is matched to the input.
+func (p *sourcePositions) offset(offsets []int, r [2]int) (int, bool) {
+	first := -1
+	for i := sort.SearchInts(offsets, r[0]); i < len(offsets) && offsets[i] < r[1]; i++ {
+		if !p.written[offsets[i]] {
+			p.written[offsets[i]] = true
+			return offsets[i], true
+		} else if first == -1 {
+			first = offsets[i]
+		}
+	}
+	return first, first != -1
+}
+
 func (m *jsMinifier) writeSpaceAfterIdent() {
-	if js.IsIdentifierEnd(m.prev) || 1 < len(m.prev) && m.prev[0] == '/' {
+	if m.o.Beautify {
+		m.writeSpace()
+	} else if js.IsIdentifierEnd(m.prev) || 1 < len(m.prev) && m.prev[0] == '/' {
 		m.w.Write(spaceBytes)
 	}
 }
@@ -130,7 +580,87 @@ func (m *jsMinifier) writeSemicolon() {
 	}
 }
 
+// writeSpace writes a space in beautify mode, unless at the start of a line.
+func (m *jsMinifier) writeSpace() {
+	if m.o.Beautify && m.prev != nil && m.prev[0] != ' ' && m.prev[0] != '\n' {
+		m.w.Write(spaceBytes)
+		m.prev = spaceBytes
+		m.needsSpace = false
+		m.spaceBefore = 0
+	}
+}
+
+// writeNewline starts a new line at the current indentation in beautify mode.
+func (m *jsMinifier) writeNewline() {
+	if m.o.Beautify {
+		m.w.Write(newlineBytes)
+		for i := 0; i < m.indent; i++ {
+			m.w.Write(indentBytes)
+		}
+		m.prev = newlineBytes
+		m.needsSpace = false
+		m.spaceBefore = 0
+	}
+}
+
+// writeOperator writes a binary operator, surrounded by spaces in beautify mode.
+func (m *jsMinifier) writeOperator(b []byte) {
+	m.writeSpace()
+	m.write(b)
+	m.writeSpace()
+}
+
+// writeComma writes a comma, followed by a space in beautify mode.
+func (m *jsMinifier) writeComma() {
+	m.write(commaBytes)
+	m.writeSpace()
+}
+
+// writeOpenBrace opens a block, which is indented in beautify mode.
+func (m *jsMinifier) writeOpenBrace() {
+	m.writeSpace()
+	m.write(openBraceBytes)
+	m.indent++
+}
+
+// writeCloseBrace closes a block, on a new line in beautify mode unless it is empty.
+func (m *jsMinifier) writeCloseBrace(empty bool) {
+	m.indent--
+	if m.o.Beautify && !empty {
+		m.writeSemicolon()
+		m.writeNewline()
+	}
+	m.write(closeBraceBytes)
+	m.needsSemicolon = false
+}
+
+// writeOpen writes a keyword and an opening parenthesis such as if(, separated by a space in beautify mode.
+func (m *jsMinifier) writeOpen(b []byte) {
+	if m.o.Beautify {
+		m.write(b[:len(b)-1])
+		m.writeSpace()
+		m.write(openParenBytes)
+	} else {
+		m.write(b)
+	}
+}
+
+// writeCloseOpen writes a closing parenthesis and an opening brace, separated by a space in beautify mode.
+func (m *jsMinifier) writeCloseOpen() {
+	if m.o.Beautify {
+		m.write(closeParenBytes)
+		m.writeSpace()
+		m.write(openBraceBytes)
+	} else {
+		m.write(closeParenOpenBracketBytes)
+	}
+}
+
 func (m *jsMinifier) minifyStmt(i js.IStmt) {
+	if m.sourceMap != nil {
+		mapRange := m.mapNode(i)
+		defer func() { m.mapRange = mapRange }()
+	}
 	switch stmt := i.(type) {
 	case *js.ExprStmt:
 		m.expectExpr = expectExprStmt
@@ -147,24 +677,26 @@ func (m *jsMinifier) minifyStmt(i js.IStmt) {
 		hasIf := !m.isEmptyStmt(stmt.Body)
 		hasElse := !m.isEmptyStmt(stmt.Else)
 
-		m.write(ifOpenBytes)
+		m.writeOpen(ifOpenBytes)
 		m.minifyExpr(stmt.Cond, js.OpExpr)
 		m.write(closeParenBytes)
 
 		if !hasIf && hasElse {
 			m.requireSemicolon()
 		} else if hasIf {
+			m.writeSpace()
 			if ifStmt, ok := stmt.Body.(*js.IfStmt); ok && m.isEmptyStmt(ifStmt.Else) {
-				m.write(openBraceBytes)
+				m.writeOpenBrace()
+				m.writeNewline()
 				m.minifyStmt(stmt.Body)
-				m.write(closeBraceBytes)
-				m.needsSemicolon = false
+				m.writeCloseBrace(false)
 			} else {
 				m.minifyStmt(stmt.Body)
 			}
 		}
 		if hasElse {
 			m.writeSemicolon()
+			m.writeSpace()
 			m.write(elseBytes)
 			m.writeSpaceBeforeIdent()
 			m.minifyStmt(stmt.Else)
@@ -180,7 +712,8 @@ func (m *jsMinifier) minifyStmt(i js.IStmt) {
 	case *js.LabelledStmt:
 		m.write(stmt.Label)
 		m.write(colonBytes)
-		m.minifyStmt(stmt.Value)
+		m.writeSpace()
+		m.minifyStmtOrBlock(stmt.Value, defaultBlock)
 	case *js.BranchStmt:
 		m.write(stmt.Type.Bytes())
 		if stmt.Label != nil {
@@ -189,26 +722,29 @@ func (m *jsMinifier) minifyStmt(i js.IStmt) {
 		}
 		m.requireSemicolon()
 	case *js.WithStmt:
-		m.write(withOpenBytes)
+		m.writeOpen(withOpenBytes)
 		m.minifyExpr(stmt.Cond, js.OpExpr)
 		m.write(closeParenBytes)
+		m.writeSpace()
 		m.minifyStmtOrBlock(stmt.Body, defaultBlock)
 	case *js.DoWhileStmt:
 		m.write(doBytes)
 		m.writeSpaceBeforeIdent()
 		m.minifyStmtOrBlock(stmt.Body, iterationBlock)
 		m.writeSemicolon()
-		m.write(whileOpenBytes)
+		m.writeSpace()
+		m.writeOpen(whileOpenBytes)
 		m.minifyExpr(stmt.Cond, js.OpExpr)
 		m.write(closeParenBytes)
 	case *js.WhileStmt:
-		m.write(whileOpenBytes)
+		m.writeOpen(whileOpenBytes)
 		m.minifyExpr(stmt.Cond, js.OpExpr)
 		m.write(closeParenBytes)
+		m.writeSpace()
 		m.minifyStmtOrBlock(stmt.Body, iterationBlock)
 	case *js.ForStmt:
 		m.renamer.renameScope(stmt.Body.Scope)
-		m.write(forOpenBytes)
+		m.writeOpen(forOpenBytes)
 		m.inFor = true
 		if decl, ok := stmt.Init.(*js.VarDecl); ok {
 			m.minifyVarDecl(decl, false)
@@ -217,14 +753,21 @@ func (m *jsMinifier) minifyStmt(i js.IStmt) {
 		}
 		m.inFor = false
 		m.write(semicolonBytes)
+		if stmt.Cond != nil {
+			m.writeSpace()
+		}
 		m.minifyExpr(stmt.Cond, js.OpExpr)
 		m.write(semicolonBytes)
+		if stmt.Post != nil {
+			m.writeSpace()
+		}
 		m.minifyExpr(stmt.Post, js.OpExpr)
 		m.write(closeParenBytes)
+		m.writeSpace()
 		m.minifyBlockAsStmt(&stmt.Body, iterationBlock)
 	case *js.ForInStmt:
 		m.renamer.renameScope(stmt.Body.Scope)
-		m.write(forOpenBytes)
+		m.writeOpen(forOpenBytes)
 		m.inFor = true
 		if decl, ok := stmt.Init.(*js.VarDecl); ok {
 			m.minifyVarDecl(decl, false)
@@ -237,13 +780,14 @@ func (m *jsMinifier) minifyStmt(i js.IStmt) {
 		m.writeSpaceBeforeIdent()
 		m.minifyExpr(stmt.Value, js.OpExpr)
 		m.write(closeParenBytes)
+		m.writeSpace()
 		m.minifyBlockAsStmt(&stmt.Body, iterationBlock)
 	case *js.ForOfStmt:
 		m.renamer.renameScope(stmt.Body.Scope)
 		if stmt.Await {
-			m.write(forAwaitOpenBytes)
+			m.writeOpen(forAwaitOpenBytes)
 		} else {
-			m.write(forOpenBytes)
+			m.writeOpen(forOpenBytes)
 		}
 		m.inFor = true
 		if decl, ok := stmt.Init.(*js.VarDecl); ok {
@@ -257,16 +801,17 @@ func (m *jsMinifier) minifyStmt(i js.IStmt) {
 		m.writeSpaceBeforeIdent()
 		m.minifyExpr(stmt.Value, js.OpAssign)
 		m.write(closeParenBytes)
-		if len(stmt.Body.List) == 1 {
-		}
+		m.writeSpace()
 		m.minifyBlockAsStmt(&stmt.Body, iterationBlock)
 	case *js.SwitchStmt:
-		m.write(switchOpenBytes)
+		m.writeOpen(switchOpenBytes)
 		m.minifyExpr(stmt.Init, js.OpExpr)
-		m.write(closeParenOpenBracketBytes)
+		m.writeCloseOpen()
+		m.indent++
 		m.needsSemicolon = false
 		for _, clause := range stmt.List {
 			m.writeSemicolon()
+			m.writeNewline()
 			m.write(clause.TokenType.Bytes())
 			if clause.Cond != nil {
 				m.writeSpaceBeforeIdent()
@@ -274,13 +819,15 @@ func (m *jsMinifier) minifyStmt(i js.IStmt) {
 			}
 			m.write(colonBytes)
 			clause.List = m.optimizeStmtList(clause.List, defaultBlock)
+			m.indent++
 			for _, item := range clause.List {
 				m.writeSemicolon()
+				m.writeNewline()
 				m.minifyStmt(item)
 			}
+			m.indent--
 		}
-		m.write(closeBraceBytes)
-		m.needsSemicolon = false
+		m.writeCloseBrace(len(stmt.List) == 0)
 	case *js.ThrowStmt:
 		m.write(throwBytes)
 		m.writeSpaceBeforeIdent()
@@ -292,7 +839,9 @@ func (m *jsMinifier) minifyStmt(i js.IStmt) {
 		stmt.Body.List = m.optimizeStmtList(stmt.Body.List, defaultBlock)
 		m.minifyBlockStmt(stmt.Body)
 		if stmt.Catch != nil {
+			m.writeSpace()
 			m.write(catchBytes)
+			m.writeSpace()
 			m.renamer.renameScope(stmt.Catch.Scope)
 			if stmt.Binding != nil {
 				m.write(openParenBytes)
@@ -303,6 +852,7 @@ func (m *jsMinifier) minifyStmt(i js.IStmt) {
 			m.minifyBlockStmt(*stmt.Catch)
 		}
 		if stmt.Finally != nil {
+			m.writeSpace()
 			m.write(finallyBytes)
 			m.renamer.renameScope(stmt.Finally.Scope)
 			stmt.Finally.List = m.optimizeStmtList(stmt.Finally.List, defaultBlock)
@@ -313,6 +863,8 @@ func (m *jsMinifier) minifyStmt(i js.IStmt) {
 	case *js.ClassDecl:
 		m.minifyClassDecl(*stmt)
 	case *js.DebuggerStmt:
+		m.write(debuggerBytes)
+		m.requireSemicolon()
 	case *js.EmptyStmt:
 	case *js.ImportStmt:
 		m.write(importBytes)
@@ -320,28 +872,24 @@ func (m *jsMinifier) minifyStmt(i js.IStmt) {
 			m.write(spaceBytes)
 			m.write(stmt.Default)
 			if len(stmt.List) != 0 {
-				m.write(commaBytes)
+				m.writeComma()
 			}
 		}
-		if len(stmt.List) == 1 {
+		if isStarAlias(stmt.List) {
 			m.writeSpaceBeforeIdent()
 			m.minifyAlias(stmt.List[0])
-		} else if 1 < len(stmt.List) {
-			m.write(openBraceBytes)
-			for i, item := range stmt.List {
-				if i != 0 {
-					m.write(commaBytes)
-				}
-				m.minifyAlias(item)
-			}
-			m.write(closeBraceBytes)
+		} else if len(stmt.List) != 0 {
+			m.writeSpace()
+			m.minifyAliasList(stmt.List)
 		}
 		if stmt.Default != nil || len(stmt.List) != 0 {
-			if len(stmt.List) < 2 {
+			if isStarAlias(stmt.List) || len(stmt.List) == 0 {
 				m.write(spaceBytes)
 			}
+			m.writeSpace()
 			m.write(fromBytes)
 		}
+		m.writeSpace()
 		m.write(stmt.Module)
 		m.requireSemicolon()
 	case *js.ExportStmt:
@@ -351,31 +899,31 @@ func (m *jsMinifier) minifyStmt(i js.IStmt) {
 				m.write(spaceDefaultBytes)
 			}
 			m.writeSpaceBeforeIdent()
-			m.minifyExpr(stmt.Decl, js.OpAssign)
+			if decl, ok := stmt.Decl.(*js.FuncDecl); ok && !stmt.Default {
+				m.minifyFuncDecl(*decl, false) // keep the name of the exported declaration
+			} else {
+				m.minifyExpr(stmt.Decl, js.OpAssign)
+			}
 			_, isHoistable := stmt.Decl.(*js.FuncDecl)
 			_, isClass := stmt.Decl.(*js.ClassDecl)
 			if !isHoistable && !isClass {
 				m.requireSemicolon()
 			}
 		} else {
-			if len(stmt.List) == 1 {
+			if isStarAlias(stmt.List) {
 				m.writeSpaceBeforeIdent()
 				m.minifyAlias(stmt.List[0])
-			} else if 1 < len(stmt.List) {
-				m.write(openBraceBytes)
-				for i, item := range stmt.List {
-					if i != 0 {
-						m.write(commaBytes)
-					}
-					m.minifyAlias(item)
-				}
-				m.write(closeBraceBytes)
+			} else {
+				m.writeSpace()
+				m.minifyAliasList(stmt.List)
 			}
 			if stmt.Module != nil {
-				if len(stmt.List) < 2 && (len(stmt.List) != 1 || !bytes.Equal(stmt.List[0].Binding, starBytes)) {
+				if isStarAlias(stmt.List) && !bytes.Equal(stmt.List[0].Binding, starBytes) {
 					m.write(spaceBytes)
 				}
+				m.writeSpace()
 				m.write(fromBytes)
+				m.writeSpace()
 				m.write(stmt.Module)
 			}
 			m.requireSemicolon()
@@ -384,15 +932,14 @@ func (m *jsMinifier) minifyStmt(i js.IStmt) {
 }
 
 func (m *jsMinifier) minifyBlockStmt(stmt js.BlockStmt) {
-	m.write(openBraceBytes)
+	m.writeOpenBrace()
 	m.needsSemicolon = false
 	for _, item := range stmt.List {
 		m.writeSemicolon()
+		m.writeNewline()
 		m.minifyStmt(item)
 	}
-
-	m.write(closeBraceBytes)
-	m.needsSemicolon = false
+	m.writeCloseBrace(len(stmt.List) == 0)
 }
 
 func (m *jsMinifier) minifyBlockAsStmt(blockStmt *js.BlockStmt, blockType blockType) {
@@ -440,6 +987,8 @@ func (m *jsMinifier) minifyAlias(alias js.Alias) {
 		m.write(alias.Name)
 		if !bytes.Equal(alias.Name, starBytes) {
 			m.write(spaceBytes)
+		} else {
+			m.writeSpace()
 		}
 		m.write(asSpaceBytes)
 	}
@@ -448,17 +997,31 @@ func (m *jsMinifier) minifyAlias(alias js.Alias) {
 	}
 }
 
+// minifyAliasList writes the braced list of an import or export clause, skipping the empty alias of a trailing comma.
+func (m *jsMinifier) minifyAliasList(list []js.Alias) {
+	m.write(openBraceBytes)
+	for i, item := range list {
+		if item.Binding == nil {
+			continue
+		} else if i != 0 {
+			m.writeComma()
+		}
+		m.minifyAlias(item)
+	}
+	m.write(closeBraceBytes)
+}
+
 func (m *jsMinifier) minifyParams(params js.Params) {
 	m.write(openParenBytes)
 	for i, item := range params.List {
 		if i != 0 {
-			m.write(commaBytes)
+			m.writeComma()
 		}
 		m.minifyBindingElement(item)
 	}
 	if params.Rest != nil {
 		if len(params.List) != 0 {
-			m.write(commaBytes)
+			m.writeComma()
 		}
 		m.write(ellipsisBytes)
 		m.minifyBinding(params.Rest)
@@ -470,13 +1033,13 @@ func (m *jsMinifier) minifyArguments(args js.Arguments) {
 	m.write(openParenBytes)
 	for i, item := range args.List {
 		if i != 0 {
-			m.write(commaBytes)
+			m.writeComma()
 		}
 		m.minifyExpr(item, js.OpAssign)
 	}
 	if args.Rest != nil {
 		if len(args.List) != 0 {
-			m.write(commaBytes)
+			m.writeComma()
 		}
 		m.write(ellipsisBytes)
 		m.minifyExpr(args.Rest, js.OpAssign)
@@ -491,7 +1054,7 @@ func (m *jsMinifier) minifyVarDecl(decl *js.VarDecl, onlyDefines bool) {
 		for _, item := range decl.List {
 			if item.Default != nil || !onlyDefines {
 				if !first {
-					m.write(commaBytes)
+					m.writeComma()
 				}
 				m.minifyBindingElement(item)
 				first = false
@@ -502,7 +1065,7 @@ func (m *jsMinifier) minifyVarDecl(decl *js.VarDecl, onlyDefines bool) {
 		m.writeSpaceBeforeIdent()
 		for i, item := range decl.List {
 			if i != 0 {
-				m.write(commaBytes)
+				m.writeComma()
 			}
 			m.minifyBindingElement(item)
 		}
@@ -513,6 +1076,9 @@ func (m *jsMinifier) minifyFuncDecl(decl js.FuncDecl, inExpr bool) {
 	parentRename := m.renamer.rename
 	m.renamer.rename = !decl.Body.Scope.HasWith && !m.o.KeepVarNames
 	parentVarsHoisted := m.hoistVars(&decl.Body)
+	parentStrict := m.strict
+	m.strict = m.strict || isStrict(decl.Body.List)
+	m.keepBlockFuncs(decl.Body.List)
 
 	if decl.Async {
 		m.write(asyncSpaceBytes)
@@ -528,7 +1094,7 @@ func (m *jsMinifier) minifyFuncDecl(decl js.FuncDecl, inExpr bool) {
 		if !decl.Generator {
 			m.write(spaceBytes)
 		}
-		m.write(decl.Name.Data)
+		m.writeDeclVar(decl.Name)
 	}
 	if !inExpr {
 		m.renamer.renameScope(decl.Body.Scope)
@@ -539,6 +1105,7 @@ func (m *jsMinifier) minifyFuncDecl(decl js.FuncDecl, inExpr bool) {
 	m.minifyBlockStmt(decl.Body)
 
 	m.varsHoisted = parentVarsHoisted
+	m.strict = parentStrict
 	m.renamer.rename = parentRename
 }
 
@@ -546,6 +1113,9 @@ func (m *jsMinifier) minifyMethodDecl(decl js.MethodDecl) {
 	parentRename := m.renamer.rename
 	m.renamer.rename = !decl.Body.Scope.HasWith && !m.o.KeepVarNames
 	parentVarsHoisted := m.hoistVars(&decl.Body)
+	parentStrict := m.strict
+	m.strict = m.strict || isStrict(decl.Body.List)
+	m.keepBlockFuncs(decl.Body.List)
 
 	if decl.Static {
 		m.write(staticBytes)
@@ -575,6 +1145,7 @@ func (m *jsMinifier) minifyMethodDecl(decl js.MethodDecl) {
 	m.minifyBlockStmt(decl.Body)
 
 	m.varsHoisted = parentVarsHoisted
+	m.strict = parentStrict
 	m.renamer.rename = parentRename
 }
 
@@ -582,6 +1153,9 @@ func (m *jsMinifier) minifyArrowFunc(decl js.ArrowFunc) {
 	parentRename := m.renamer.rename
 	m.renamer.rename = !decl.Body.Scope.HasWith && !m.o.KeepVarNames
 	parentVarsHoisted := m.hoistVars(&decl.Body)
+	parentStrict := m.strict
+	m.strict = m.strict || isStrict(decl.Body.List)
+	m.keepBlockFuncs(decl.Body.List)
 
 	m.renamer.renameScope(decl.Body.Scope)
 	if decl.Async {
@@ -601,7 +1175,7 @@ func (m *jsMinifier) minifyArrowFunc(decl js.ArrowFunc) {
 		m.minifyParams(decl.Params)
 		m.inFor = parentInFor
 	}
-	m.write(arrowBytes)
+	m.writeOperator(arrowBytes)
 	removeBraces := false
 	if 0 < len(decl.Body.List) {
 		returnStmt, isReturn := decl.Body.List[len(decl.Body.List)-1].(*js.ReturnStmt)
@@ -640,26 +1214,32 @@ func (m *jsMinifier) minifyArrowFunc(decl js.ArrowFunc) {
 	}
 
 	m.varsHoisted = parentVarsHoisted
+	m.strict = parentStrict
 	m.renamer.rename = parentRename
 }
 
 func (m *jsMinifier) minifyClassDecl(decl js.ClassDecl) {
+	// class bodies are strict mode code
+	parentStrict := m.strict
+	m.strict = true
+
 	m.write(classBytes)
 	if decl.Name != nil {
 		m.write(spaceBytes)
-		m.write(decl.Name.Data)
+		m.writeDeclVar(decl.Name)
 	}
 	if decl.Extends != nil {
 		m.write(spaceExtendsBytes)
 		m.writeSpaceBeforeIdent()
 		m.minifyExpr(decl.Extends, js.OpLHS)
 	}
-	m.write(openBraceBytes)
+	m.writeOpenBrace()
 	for _, item := range decl.Methods {
+		m.writeNewline()
 		m.minifyMethodDecl(item)
 	}
-	m.write(closeBraceBytes)
-	m.needsSemicolon = false
+	m.writeCloseBrace(len(decl.Methods) == 0)
+	m.strict = parentStrict
 }
 
 func (m *jsMinifier) minifyPropertyName(name js.PropertyName) {
@@ -676,14 +1256,22 @@ func (m *jsMinifier) minifyProperty(property js.Property) {
 	// property.Name is always set in ObjectLiteral
 	if property.Spread {
 		m.write(ellipsisBytes)
-	} else if v, ok := property.Value.(*js.Var); property.Name != nil && (!ok || !property.Name.IsIdent(v.Name())) {
+	} else if v, ok := property.Value.(*js.Var); property.Name != nil && (!ok || !property.Name.IsIdent(v.Name()) || !m.o.Target.supports(ES2015)) {
 		// add 'old-name:' before BindingName as the latter will be renamed
 		m.minifyPropertyName(*property.Name)
 		m.write(colonBytes)
+		m.writeSpace()
+	} else if method, ok := property.Value.(*js.MethodDecl); ok && m.o.Target.supports(ES2015) && isArrowMethod(method) {
+		// convert method to arrow function:  a(){return b}  =>  a:()=>b
+		m.minifyPropertyName(method.Name)
+		m.write(colonBytes)
+		m.writeSpace()
+		m.minifyArrowFunc(js.ArrowFunc{Async: method.Async, Params: method.Params, Body: method.Body})
+		return
 	}
 	m.minifyExpr(property.Value, js.OpAssign)
 	if property.Init != nil {
-		m.write(equalBytes)
+		m.writeOperator(equalBytes)
 		m.minifyExpr(property.Init, js.OpAssign)
 	}
 }
@@ -695,7 +1283,7 @@ func (m *jsMinifier) minifyBindingElement(element js.BindingElement) {
 		m.minifyBinding(element.Binding)
 		m.inFor = parentInFor
 		if element.Default != nil {
-			m.write(equalBytes)
+			m.writeOperator(equalBytes)
 			m.minifyExpr(element.Default, js.OpAssign)
 		}
 	}
@@ -704,48 +1292,50 @@ func (m *jsMinifier) minifyBindingElement(element js.BindingElement) {
 func (m *jsMinifier) minifyBinding(ibinding js.IBinding) {
 	switch binding := ibinding.(type) {
 	case *js.Var:
-		m.write(binding.Data)
+		m.writeDeclVar(binding)
 	case *js.BindingArray:
 		m.write(openBracketBytes)
 		for i, item := range binding.List {
 			if i != 0 {
-				m.write(commaBytes)
+				m.writeComma()
 			}
 			m.minifyBindingElement(item)
 		}
 		if binding.Rest != nil {
 			if 0 < len(binding.List) {
-				m.write(commaBytes)
+				m.writeComma()
 			}
 			m.write(ellipsisBytes)
 			m.minifyBinding(binding.Rest)
 		} else if 0 < len(binding.List) && binding.List[len(binding.List)-1].Binding == nil {
-			m.write(commaBytes)
+			m.writeComma()
 		}
 		m.write(closeBracketBytes)
 	case *js.BindingObject:
 		m.write(openBraceBytes)
 		for i, item := range binding.List {
 			if i != 0 {
-				m.write(commaBytes)
+				m.writeComma()
 			}
 			// item.Key is always set
 			if item.Key.IsComputed() {
 				m.minifyPropertyName(*item.Key)
 				m.write(colonBytes)
+				m.writeSpace()
 			} else if v, ok := item.Value.Binding.(*js.Var); !ok || !item.Key.IsIdent(v.Data) {
 				// add 'old-name:' before BindingName as the latter will be renamed
 				m.minifyPropertyName(*item.Key)
 				m.write(colonBytes)
+				m.writeSpace()
 			}
 			m.minifyBindingElement(item.Value)
 		}
 		if binding.Rest != nil {
 			if 0 < len(binding.List) {
-				m.write(commaBytes)
+				m.writeComma()
 			}
 			m.write(ellipsisBytes)
-			m.write(binding.Rest.Data)
+			m.writeDeclVar(binding.Rest)
 		}
 		m.write(closeBraceBytes)
 	}
@@ -788,26 +1378,29 @@ func (m *jsMinifier) minifyBinaryExpr(expr *js.BinaryExpr) bool {
 }
 
 func (m *jsMinifier) minifyExpr(i js.IExpr, prec js.OpPrec) {
+	if m.sourceMap != nil {
+		mapRange := m.mapNode(i)
+		defer func() { m.mapRange = mapRange }()
+	}
 	switch expr := i.(type) {
 	case *js.Var:
 		for expr.Link != nil {
 			expr = expr.Link
 		}
-		data := expr.Data
-		if bytes.Equal(data, undefinedBytes) { // TODO: only if not defined
+		if isGlobalVar(expr, undefinedBytes) {
 			if js.OpUnary < prec {
 				m.write(groupedVoidZeroBytes)
 			} else {
 				m.write(voidZeroBytes)
 			}
-		} else if bytes.Equal(data, infinityBytes) { // TODO: only if not defined
+		} else if isGlobalVar(expr, infinityBytes) {
 			if js.OpMul < prec {
 				m.write(groupedOneDivZeroBytes)
 			} else {
 				m.write(oneDivZeroBytes)
 			}
 		} else {
-			m.write(data)
+			m.writeUseVar(expr)
 		}
 	case *js.LiteralExpr:
 		if expr.TokenType == js.DecimalToken {
@@ -836,6 +1429,14 @@ func (m *jsMinifier) minifyExpr(i js.IExpr, prec js.OpPrec) {
 			m.write(expr.Data)
 		}
 	case *js.BinaryExpr:
+		if _, ok := expr.X.(*js.ObjectExpr); ok && expr.Op == js.EqToken && m.expectExpr != expectAny {
+			// group the assignment instead of the object, since ({a})=b is not a destructuring assignment
+			m.write(openParenBytes)
+			m.expectExpr = expectAny
+			m.minifyExpr(expr, js.OpExpr)
+			m.write(closeParenBytes)
+			break
+		}
 		if m.minifyBinaryExpr(expr) {
 			break
 		}
@@ -865,6 +1466,9 @@ func (m *jsMinifier) minifyExpr(i js.IExpr, prec js.OpPrec) {
 			}
 		} else {
 			m.minifyExpr(expr.X, precLeft)
+			if expr.Op != js.CommaToken {
+				m.writeSpace()
+			}
 			if expr.Op == js.GtToken && m.prev[len(m.prev)-1] == '-' {
 				m.write(spaceBytes)
 			} else if expr.Op == js.EqEqEqToken || expr.Op == js.NotEqEqToken {
@@ -897,6 +1501,7 @@ func (m *jsMinifier) minifyExpr(i js.IExpr, prec js.OpPrec) {
 				// //  =>  / /
 				m.writeSpaceBefore('/')
 			}
+			m.writeSpace()
 			m.minifyExpr(expr.Y, binaryRightPrecMap[expr.Op])
 		}
 	case *js.UnaryExpr:
@@ -992,7 +1597,7 @@ func (m *jsMinifier) minifyExpr(i js.IExpr, prec js.OpPrec) {
 		m.write(openBracketBytes)
 		for i, item := range expr.List {
 			if i != 0 {
-				m.write(commaBytes)
+				m.writeComma()
 			}
 			if item.Spread {
 				m.write(ellipsisBytes)
@@ -1000,7 +1605,7 @@ func (m *jsMinifier) minifyExpr(i js.IExpr, prec js.OpPrec) {
 			m.minifyExpr(item.Value, js.OpAssign)
 		}
 		if 0 < len(expr.List) && expr.List[len(expr.List)-1].Value == nil {
-			m.write(commaBytes)
+			m.writeComma()
 		}
 		m.write(closeBracketBytes)
 		m.inFor = parentInFor
@@ -1015,7 +1620,7 @@ func (m *jsMinifier) minifyExpr(i js.IExpr, prec js.OpPrec) {
 		}
 		for i, item := range expr.List {
 			if i != 0 {
-				m.write(commaBytes)
+				m.writeComma()
 			}
 			m.minifyProperty(item)
 		}
@@ -1036,10 +1641,14 @@ func (m *jsMinifier) minifyExpr(i js.IExpr, prec js.OpPrec) {
 		parentInFor := m.inFor
 		m.inFor = false
 		for _, item := range expr.List {
+			m.keepUnicode = expr.Tag != nil
 			m.write(item.Value)
+			m.keepUnicode = false
 			m.minifyExpr(item.Expr, js.OpExpr)
 		}
+		m.keepUnicode = expr.Tag != nil
 		m.write(expr.Tail)
+		m.keepUnicode = false
 		m.inFor = parentInFor
 	case *js.NewExpr:
 		if expr.Args == nil && js.OpLHS < prec && prec != js.OpNew {
@@ -1074,7 +1683,7 @@ func (m *jsMinifier) minifyExpr(i js.IExpr, prec js.OpPrec) {
 			if expr.Generator {
 				m.write(starBytes)
 				m.minifyExpr(expr.X, js.OpAssign)
-			} else if v, ok := expr.X.(*js.Var); !ok || !bytes.Equal(v.Name(), undefinedBytes) { // TODO: only if not defined
+			} else if v, ok := expr.X.(*js.Var); !ok || !isGlobalVar(v, undefinedBytes) {
 				m.minifyExpr(expr.X, js.OpAssign)
 			}
 		}
@@ -1136,32 +1745,34 @@ func (m *jsMinifier) minifyExpr(i js.IExpr, prec js.OpPrec) {
 			// if condition is equal to true body
 			// for higher prec we need to add group parenthesis, and for lower prec we have parenthesis anyways. This only is shorter if len(expr.X) >= 3. isEqualExpr only checks for literal variables, which is a name will be minified to a one or two character name.
 			m.minifyExpr(expr.X, binaryLeftPrecMap[js.OrToken])
-			m.write(orBytes)
+			m.writeOperator(orBytes)
 			m.minifyExpr(expr.Y, binaryRightPrecMap[js.OrToken])
 		} else if m.isEqualExpr(expr.Cond, expr.Y) && (exprPrec(expr.Y) < js.OpAssign || binaryLeftPrecMap[js.AndToken] <= exprPrec(expr.Y)) && (exprPrec(expr.X) < js.OpAssign || binaryRightPrecMap[js.AndToken] <= exprPrec(expr.X)) {
 			// if condition is equal to false body
 			// for higher prec we need to add group parenthesis, and for lower prec we have parenthesis anyways. This only is shorter if len(expr.X) >= 3. isEqualExpr only checks for literal variables, which is a name will be minified to a one or two character name.
 			m.minifyExpr(expr.Y, binaryLeftPrecMap[js.AndToken])
-			m.write(andBytes)
+			m.writeOperator(andBytes)
 			m.minifyExpr(expr.X, binaryRightPrecMap[js.AndToken])
 		} else if m.isEqualExpr(expr.X, expr.Y) {
 			// if true and false bodies are equal
 			if prec <= js.OpExpr {
 				m.minifyExpr(expr.Cond, binaryLeftPrecMap[js.CommaToken])
-				m.write(commaBytes)
+				m.writeComma()
 				m.minifyExpr(expr.X, binaryRightPrecMap[js.CommaToken])
 			} else {
 				m.write(openParenBytes)
 				m.minifyExpr(expr.Cond, binaryLeftPrecMap[js.CommaToken])
-				m.write(commaBytes)
+				m.writeComma()
 				m.minifyExpr(expr.X, binaryRightPrecMap[js.CommaToken])
 				m.write(closeParenBytes)
 			}
 		} else if left, right, ok := m.toNullishExpr(expr); ok {
 			// no need to check whether left/right need to add groups, as the space saving is always more
 			m.minifyExpr(groupExpr(left, binaryLeftPrecMap[js.NullishToken]), binaryLeftPrecMap[js.NullishToken])
-			m.write(nullishBytes)
+			m.writeOperator(nullishBytes)
 			m.minifyExpr(groupExpr(right, binaryRightPrecMap[js.NullishToken]), binaryRightPrecMap[js.NullishToken])
+		} else if chain, ok := m.toOptChainExpr(expr); ok {
+			m.minifyExpr(groupExpr(chain, prec), prec)
 		} else {
 			// shorten when true and false bodies are true and false
 			trueX, falseX := m.isTrue(expr.X), m.isFalse(expr.X)
@@ -1171,7 +1782,7 @@ func (m *jsMinifier) minifyExpr(i js.IExpr, prec js.OpPrec) {
 			} else if trueX || trueY {
 				// trueX != trueY
 				m.minifyBooleanExpr(expr.Cond, trueY, binaryLeftPrecMap[js.OrToken])
-				m.write(orBytes)
+				m.writeOperator(orBytes)
 				if trueY {
 					m.minifyExpr(&js.GroupExpr{expr.X}, binaryRightPrecMap[js.OrToken])
 				} else {
@@ -1180,7 +1791,7 @@ func (m *jsMinifier) minifyExpr(i js.IExpr, prec js.OpPrec) {
 			} else if falseX || falseY {
 				// falseX != falseY
 				m.minifyBooleanExpr(expr.Cond, falseX, binaryLeftPrecMap[js.AndToken])
-				m.write(andBytes)
+				m.writeOperator(andBytes)
 				if falseX {
 					m.minifyExpr(&js.GroupExpr{expr.Y}, binaryRightPrecMap[js.AndToken])
 				} else {
@@ -1189,18 +1800,18 @@ func (m *jsMinifier) minifyExpr(i js.IExpr, prec js.OpPrec) {
 			} else if condExpr, ok := expr.X.(*js.CondExpr); ok && m.isEqualExpr(expr.Y, condExpr.Y) {
 				// nested conditional expression with same false bodies
 				m.minifyExpr(&js.GroupExpr{expr.Cond}, binaryLeftPrecMap[js.AndToken])
-				m.write(andBytes)
+				m.writeOperator(andBytes)
 				m.minifyExpr(&js.GroupExpr{condExpr.Cond}, binaryRightPrecMap[js.AndToken])
-				m.write(questionBytes)
+				m.writeOperator(questionBytes)
 				m.minifyExpr(condExpr.X, js.OpAssign)
-				m.write(colonBytes)
+				m.writeOperator(colonBytes)
 				m.minifyExpr(expr.Y, js.OpAssign)
 			} else {
 				// regular conditional expression
 				m.minifyExpr(expr.Cond, js.OpCoalesce)
-				m.write(questionBytes)
+				m.writeOperator(questionBytes)
 				m.minifyExpr(expr.X, js.OpAssign)
-				m.write(colonBytes)
+				m.writeOperator(colonBytes)
 				m.minifyExpr(expr.Y, js.OpAssign)
 			}
 		}
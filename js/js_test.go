@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"regexp"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/tdewolff/minify/v2"
@@ -23,7 +26,12 @@ func TestJS(t *testing.T) {
 		{`/*!comment*/a`, `/*!comment*/a`},
 		{"//!comment1\n\n//!comment2\na", "//!comment1\n//!comment2\na"},
 		{`debugger`, ``},
+		{`a();debugger;b()`, `a(),b()`},
+		{`if(a)debugger;b()`, `a,b()`},
+		{`if(a){debugger}else b()`, `a||b()`},
+		{`label:debugger`, `label:;`},
 		{`"use strict"`, `"use strict"`},
+		{`"use strict";a()`, `"use strict";a()`},
 		{`1.0`, `1`},
 		{`1000`, `1e3`},
 		{`0b1001`, `9`},
@@ -87,10 +95,33 @@ func TestJS(t *testing.T) {
 		{`export * as ns from 'path'`, `export*as ns from'path'`},
 		{`export {a as b, c} from 'path'`, `export{a as b,c}from'path'`},
 		{`export {a as b, c}`, `export{a as b,c}`},
+		{`import {a} from 'path'`, `import{a}from'path'`},
+		{`import x, {a} from 'path'`, `import x,{a}from'path'`},
+		{`export {a}`, `export{a}`},
+		{`export {a} from 'path'`, `export{a}from'path'`},
+		{`export {a, b,}`, `export{a,b}`},
 		{`export var a = b`, `export var a=b`},
 		{`export default a = b`, `export default a=b`},
 		{`export default a = b;c=d`, `export default a=b;c=d`},
 		{`export default function a(){};c=d`, `export default function(){}c=d`},
+		{`export function a(){};c=d`, `export function a(){}c=d`},
+		{`import {a} from 'path';import {b as c} from "path"`, `import{a,b as c}from'path'`},
+		{`import 'path';import x from 'path';import {a} from 'path'`, `import x,{a}from'path'`},
+		{`import x from 'path';import * as ns from 'path';import 'path'`, `import x,*as ns from'path'`},
+		{`import x from 'path';import y from 'path'`, `import x from'path';import y from'path'`},
+		{`import {a} from 'path';import * as ns from 'path'`, `import{a}from'path';import*as ns from'path'`},
+		{`import {a} from 'x';import {b} from 'y';import {c} from 'x'`, `import{a,c}from'x';import{b}from'y'`},
+		{`import {default as x, a as a} from 'path'`, `import x,{a}from'path'`},
+		{`export {a};b();export {c as d, a}`, `export{a,c as d};b()`},
+		{`export {a} from 'path';export {b} from 'path';export * from 'path'`, `export{a,b}from'path';export*from'path'`},
+		{`function foo(){}export {foo as default}`, `export default function(){}`},
+		{`function foo(){}export {foo as default, foo}`, `function foo(){}export{foo as default,foo}`},
+		{`function fooo(){}export {fooo as default, fooo}`, `export default function fooo(){}export{fooo}`},
+		{`class Foo{}export {Foo as default}`, `export default class Foo{}`},
+		{`function foo(){}export default foo`, `export default function(){}`},
+		{`function foo(){}foo();export default foo`, `function foo(){}foo();export default foo`},
+		{`class Foo{}export default Foo`, `export default class Foo{}`},
+		{`export default Foo;class Foo{}`, `export default Foo;class Foo{}`},
 		{`!class {}`, `!class{}`},
 		{`class a {}`, `class a{}`},
 		{`class a extends b {}`, `class a extends b{}`},
@@ -278,7 +309,7 @@ func TestJS(t *testing.T) {
 		{`function a(){}var a`, `function a(){}var a`},
 		{`var a;function a(){}`, `var a;function a(){}`},
 		{`var [a,b=5,,...c]=[d,e,...f];var z;z`, `var[a,b=5,,...c]=[d,e,...f],z;z`},
-		{`var {a,b=5,[5+8]:c,...d}={d,e,...f};var z;z`, `var{a,b=5,[5+8]:c,...d}={d,e,...f},z;z`},
+		{`var {a,b=5,[5+8]:c,...d}={d,e,...f};var z;z`, `var{a,b=5,[13]:c,...d}={d,e,...f},z;z`},
 		{`var a=5;var b=6;a,b`, `var a=5,b=6;a,b`},
 		{`var a;var b=6;a=7;b`, `var b=6,a=7;b`}, // swap declaration order to maintain definition order
 		{`var a=5;var b=6;a=7,b`, `var a=5,b=6;a=7,b`},
@@ -289,14 +320,14 @@ func TestJS(t *testing.T) {
 		{`var a=1,b=2;while(c);var d=3,e=4;a,b,d,e`, `for(var a=1,b=2,d,e;c;);d=3,e=4,a,b,d,e`},
 		//{`var a=1;a;var b=1`, `var a=1;a`}, // TODO
 		{`var z;var [a,b=5,,...c]=[d,e,...f];z`, `var[a,b=5,,...c]=[d,e,...f],z;z`},
-		{`var z;var {a,b=5,[5+8]:c,...d}={d,e,...f};z`, `var{a,b=5,[5+8]:c,...d}={d,e,...f},z;z`},
+		{`var z;var {a,b=5,[5+8]:c,...d}={d,e,...f};z`, `var{a,b=5,[13]:c,...d}={d,e,...f},z;z`},
 		{`var z;z;var [a,b=5,,...c]=[d,e,...f];a`, `var z,a,b,c;z,[a,b=5,,...c]=[d,e,...f],a`},
 		// TODO
 		//{`var z;z;var {a,b=5,[5+8]:c,...d}={e,f,...g};a`, `var z,a;z,{a}={e,f,...g},a`},
 		//{`var z;z;var {a,b=5,[5+8]:c,...d}={e,f,...g};d`, `var z,a,b,c,d;z,{a,b,[5+8]:c,...d}={e,f,...g},d`},
 		//{`var {a,b=5,[5+8]:c,d:e}=z;b`, `var{b=5}=z;b`},
 		//{`var {a,b=5,[5+8]:c,d:e,...f}=z;b`, `var{b=5}=z;b`},
-		{`var {a,b=5,[5+8]:c,d:e,...f}=z;f`, `var{a,b=5,[5+8]:c,d:e,...f}=z;f`},
+		{`var {a,b=5,[5+8]:c,d:e,...f}=z;f`, `var{a,b=5,[13]:c,d:e,...f}=z;f`},
 
 		// function and method declarations
 		{`function g(){return}`, `function g(){}`},
@@ -322,31 +353,88 @@ func TestJS(t *testing.T) {
 		{`class a{f(){};g(){}}`, `class a{f(){}g(){}}`},
 
 		// dead code
-		//{`return;a`, `return`},
-		//{`break;a`, `break`},
-		//{`if(a){return;a=5;b=6}`, `if(a)return`},
-		//{`if(a){throw a;a=5}`, `if(a)throw a`},
-		//{`if(a){break;a=5}`, `if(a)break`},
-		//{`if(a){continue;a=5}`, `if(a)continue`},
-		//{`if(a){return a;a=5}return b`, `return a||b`},
-		//{`if(a){throw a;a=5}throw b`, `throw a||b`},
-		//{`if(a){return;var b}return`, `a;var b`},
-		//{`if(a){return;function b(){}}`, `a;var b`},
-		//{`for (var a of b){continue;var c}`, `for(var a of b){}var c`},
-		//{`if(false)a++;else b`, `b`},
-		//{`if(false){var a}`, `var a`},
-		//{`if(false){var a;a++}else b`, `var a;b`},
-		//{`if(false){function a(c){return d};a++}else b`, `var a;b`},
-		//{`if(!1)a++;else b`, `b`},
-		//{`if(null)a++;else b`, `b`},
-		//{`var a;if(false)var b`, `var a,b`},
-		//{`var a;if(false)var b=5`, `var a,b`},
-		//{`var a;if(false){const b}`, `var a`},
-		//{`var a;if(false){function b(){}}`, `var a;if(!1)function b(){}`},
+		{`return;a`, ``},
+		{`break;a`, `break`},
+		{`if(a){return;a=5;b=6}`, `if(a)return`},
+		{`if(a){throw a;a=5}`, `if(a)throw a`},
+		{`if(a){break;a=5}`, `if(a)break`},
+		{`if(a){continue;a=5}`, `if(a)continue`},
+		{`if(a){return a;a=5}return b`, `return a||b`},
+		{`if(a){throw a;a=5}throw b`, `throw a||b`},
+		{`if(a){return;var b}return`, `if(a){var b;return}`},
+		{`if(a){return;function b(){}}`, `if(a){function b(){}return}`},
+		{`for (var a of b){continue;var c}`, `var a,c;for(a of b);`},
+		{`if(false)a++;else b`, `b`},
+		{`if(false){var a}`, `var a`},
+		{`if(false){var a;a++}else b`, `var a;b`},
+		{`if(false){function a(c){return d};a++}else b`, `if(!1){function a(c){return d}a++}else b`},
+		{`if(!1)a++;else b`, `b`},
+		{`if(null)a++;else b`, `b`},
+		{`var a;if(false)var b`, `var a,b`},
+		{`var a;if(false)var b=5`, `var a,b`},
+		{`var a;if(false){const b}`, `var a`},
+		{`var a;if(false){function b(){}}`, `var a;if(!1)function b(){}`},
+		{`if(0){function g(){}}typeof g`, `if(0)function g(){}typeof g`},                        // Annex B declares g as a variable,
+		{`"use strict";if(1){function g(){}}typeof g`, `"use strict";{function g(){}}typeof g`}, // but not in strict mode code
+		{`class A{m(){if(1){function f(){}}return f}}`, `class A{m(){{function f(){}}return f}}`},
 		//{`function f(){if(a){a=5;return}a=6;return a}`, `function f(){if(!a){a=6;return a}a=5}`},
-		//{`function g(){return;var a;a=b}`, `function g(){var a;}`},
-		//{`function g(){return 5;function f(){}}`, `function g(){return 5;function f(){}}`},
-		//{`function g(){if(a)return a;else return b;var c;c=d}`, `function g(){var c;return a||b}`},
+		{`function g(){return;var a;a=b}`, `function g(){var a}`},
+		{`function g(){return 5;function f(){}}`, `function g(){function f(){}return 5}`},
+		{`function g(){if(a)return a;else return b;var c;c=d}`, `function g(){var c;return a||b}`},
+		{`throw a;b`, `throw a`},
+		{`function f(){return 1;var a=2}`, `function f(){var a;return 1}`},
+		{`function f(){return x;let x}`, `function f(){return x;let x}`},
+		{`function f(){return x;const x=1,y=2}`, `function f(){return x;let x}`},
+		{`function f(){return new A;class A{}}`, `function f(){return new A;let A}`},
+		{`function f(){return 1;let x;class A{}}`, `function f(){return 1}`},
+		{`function f(){return x;var a;let x=1}`, `function f(){var a;return x;let x}`},
+		{`while(a){break;b()}`, `while(a)break`},
+		{`if(0)a();else b()`, `b()`},
+		{`if("")a()`, ``},
+		{`if(1+1===2)a()`, `a()`},
+		{`if(void 0){let x=a;x()}else{let x=b;x()}`, `{let x=b;x()}`},
+		{`if(a()||1)b()`, `(a()||1)&&b()`},
+		{`if(x){}`, `x`},
+
+		// constant folding
+		{`x=1+2`, `x=3`},
+		{`x=2*3-10`, `x=-4`},
+		{`x=y-(2-3)`, `x=y- -1`},
+		{`x=0.1+0.2`, `x=.1+.2`},
+		{`x=1/3`, `x=1/3`},
+		{`x=1/0`, `x=1/0`},
+		{`x=5|3`, `x=7`},
+		{`x=-1>>>28`, `x=15`},
+		{`x=1<2`, `x=!0`},
+		{`x="a"+"b"`, `x="ab"`},
+		{`x="a"+'"'`, `x='a"'`},
+		{`x='"'+"'"`, `x='"\''`},
+		{`x=y+"a"+"b"`, `x=y+"ab"`},
+		{`x="a"+1+true`, `x="a1true"`},
+		{`x="a"==="a"`, `x=!0`},
+		{`x=typeof 1`, `x="number"`},
+		{`x=!0?y:z`, `x=y`},
+		{`x=true&&y`, `x=y`},
+		{`x=0||y`, `x=y`},
+		{`x=null??y`, `x=y`},
+		{`x=typeof undefined`, `x="undefined"`},
+		{`x=undefined??y`, `x=y`},
+		{`function f(undefined){return typeof undefined}`, `function f(undefined){return typeof undefined}`},
+		{`function f(undefined){return undefined??b}`, `function f(undefined){return undefined??b}`},
+		{`function f(NaN){return NaN?b:c}`, `function f(NaN){return NaN?b:c}`},
+		{`function f(Infinity){return Infinity}`, `function f(Infinity){return Infinity}`},
+		{`x=y()||1`, `x=y()||1`},
+		{`x=(a,1)?b:c`, `x=(a,1)?b:c`},
+		{`x=(1&&a.b)`, `x=a.b`},
+		{`(1&&a.b)()`, `(0,a.b)()`},
+		{`(0||a.b)()`, `(0,a.b)()`},
+		{`(null??a[b])()`, `(0,a[b])()`},
+		{`(1&&a.b)?.()`, `(0,a.b)?.()`},
+		{`(1&&eval)("x")`, `(0,eval)("x")`},
+		{`(1&&f)()`, `f()`},
+		{`(1&&a.b)` + "`t`", `(0,a.b)` + "`t`"},
+		{`delete(1?a.b:c)`, `delete(0,a.b)`},
+		{`delete(1&&a)`, `delete(0,a)`},
 
 		// arrow functions
 		{`() => {}`, `()=>{}`},
@@ -369,8 +457,8 @@ func TestJS(t *testing.T) {
 		//{`var a=async function(b){b=6;return 5}`, `var a=async b=>(b=6,5)`},
 		//{`(function(){return 5})()`, `(()=>5)()`},
 		//{`class c{a(){return 5}}`, `class c{a:()=>5}`},
-		//{`export default{a(){return 5}}`, `export default{a:()=>5}`},
-		//{`var v={async [[1]](a){return a}}`, `var v={[[1]]:async a=>a}`},
+		{`export default{a(){return 5}}`, `export default{a:()=>5}`},
+		{`var v={async [[1]](a){return a}}`, `var v={[[1]]:async a=>a}`},
 		//{`var a={b:()=>c=5}`, `var a={b(){c=5}}`},
 		//{`var a={b:function(){c=5}}`, `var a={b(){c=5}}`},
 		//{`var a={b:async function(){c=5}}`, `var a={async b(){c=5}}`},
@@ -407,17 +495,20 @@ func TestJS(t *testing.T) {
 		{`a=b?c:c`, `a=(b,c)`},
 		{`a=b?b:c=f`, `a=b?b:c=f`}, // don't write as a=b||(c=f)
 		{`a=b||(c=f)`, `a=b||(c=f)`},
-		{`a=(-5)**3`, `a=(-5)**3`},
-		{`a=5**(-3)`, `a=5**-3`},
+		{`a=(-5)**3`, `a=-125`},
+		{`a=(-b)**3`, `a=(-b)**3`},
+		{`a=5**(-3)`, `a=.008`},
+		{`a=5**(-b)`, `a=5**-b`},
 		{`a=(-(+5))**3`, `a=(-+5)**3`}, // could remove +
 		{`a=(b,c)+3`, `a=(b,c)+3`},
 		{`(a,b)&&c`, `a,b&&c`},
 		{`function*x(){a=(yield b)}`, `function*x(){a=yield b}`},
 		{`function*x(){a=yield (yield b)}`, `function*x(){a=yield yield b}`},
 		{`if((a))while((b));`, `if(a)while(b);`},
-		{`({a}=5)`, `({a})=5`},
-		{`({a:a}=5)`, `({a})=5`},
-		{`({a:"a"}=5)`, `({a:"a"})=5`},
+		{`({a}=5)`, `({a}=5)`},
+		{`({a:a}=5)`, `({a}=5)`},
+		{`({a:"a"}=5)`, `({a:"a"}=5)`},
+		{`f=()=>({a}=b)`, `f=()=>({a}=b)`},
 		{`(function(){})`, `!function(){}`},
 		{`(function(){}())`, `!function(){}()`},
 		{`(function(){})()`, `!function(){}()`},
@@ -433,7 +524,8 @@ func TestJS(t *testing.T) {
 		{`async function g(){await(x+y)}`, `async function g(){await(x+y)}`},
 		{`await(fun()())`, `await(fun()())`},
 		{`async function g(){await(fun()())}`, `async function g(){await fun()()}`},
-		{`a=1+"2"+(3+4)`, `a=1+"2"+(3+4)`},
+		{`a=1+"2"+(3+4)`, `a="127"`},
+		{`a=1+"2"+(3+b)`, `a="12"+(3+b)`},
 		{`(-1)()`, `(-1)()`},
 		{`(-1)(-2)`, `(-1)(-2)`},
 		{`(+new Date).toString(32)`, `(+new Date).toString(32)`},
@@ -442,7 +534,8 @@ func TestJS(t *testing.T) {
 		{`(2e-8).toFixed(0)`, `2e-8.toFixed(0)`},
 		{`(-2).toFixed(0)`, `(-2).toFixed(0)`},
 		{`(a)=>((b)=>c)`, `a=>b=>c`},
-		{`function f(a=(3+2)){}`, `function f(a=3+2){}`},
+		{`function f(a=(3+2)){}`, `function f(a=5){}`},
+		{`function f(a=(b+2)){}`, `function f(a=b+2){}`},
 		{`function*a(){yield a.b}`, `function*a(){yield a.b}`},
 		{`function*a(){(yield a).b}`, `function*a(){(yield a).b}`},
 		{`function*a(){yield a["-"]}`, `function*a(){yield a["-"]}`},
@@ -599,7 +692,7 @@ func TestJS(t *testing.T) {
 		{`a[""]`, `a[""]`},                                                    // go-fuzz
 		{`function f(){;}`, `function f(){}`},                                 // go-fuzz
 		{`0xeb00000000`, `0xeb00000000`},                                      // go-fuzz
-		{`export{a,}`, `export{a,}`},                                          // go-fuzz
+		{`export{a,}`, `export{a}`},                                           // go-fuzz
 		{`var D;var{U,W,W}=y`, `var{U,W,W}=y,D`},                              // go-fuzz
 	}
 
@@ -621,11 +714,16 @@ func TestJSVarRenaming(t *testing.T) {
 		expected string
 	}{
 		{`x=function(){var name;name}`, `x=function(){var a;a}`},
+		{`x=function(){return x;let x}`, `x=function(){return a;let a}`},
+		{`x=function(){if(a){function f(){}}return f}`, `x=function(){if(a)function b(){}return b}`},
+		{`x=function(){"use strict";var a=1,b=2,c=3,d=4,e=5,g=6;if(x){function f(){}}return f(a,b,c,d,e,g)}`, `x=function(){"use strict";var a=1,b=2,c=3,d=4,e=5,g=6;if(x){function f(){}}return f(a,b,c,d,e,g)}`}, // f in the block is not the f that is returned
 		{`x=function(){var once,twice;once,twice++}`, `x=function(){var a,b;a,b++}`},
 		{`x=function(){try{var x;x}catch(y){x}}`, `x=function(){try{var a;a}catch(b){a}}`},
 		{`x=function(){try{var x;x}catch(x){x}}`, `x=function(){try{var a;a}catch(a){a}}`},
 		{`x=function(){function name(){}}`, `x=function(){function a(){}}`},
 		{`x=function name(){}`, `x=function(){}`},
+		{`x=function(undefined){return typeof undefined}`, `x=function(a){return typeof a}`},
+		{`x=function(undefined){return undefined??b}`, `x=function(a){return a??b}`},
 		{`x=function(){let a;{let b;b,a}}`, `x=function(){let a;{let b;b,a}}`},
 		//{`x=function(){let a;{let b;a}}`, `x=function(){let a;a}`}, // TODO: b unused
 		{`x=function({foo, bar}){}`, `x=function({foo:a,bar:b}){}`},
@@ -637,8 +735,8 @@ func TestJSVarRenaming(t *testing.T) {
 		{`function a(){var b;b}`, `function a(){var a;a}`},
 		{`!function(){x=function(){return fun()};var fun=function(){return 0}}`, `!function(){x=function(){return a()};var a=function(){return 0}}`},
 		{`!function(){var x=function(){return y};const y=5;x,y}`, `!function(){var b=function(){return a};const a=5;b,a}`},
-		{`!function(){if(1){const x=5;x;5}var y=function(){return x};y}`, `!function(){if(1){const a=5;a,5}var a=function(){return x};a}`},
-		{`!function(){var x=function(){return y};x;if(1){const y=5;y;5}}`, `!function(){var a=function(){return y};if(a,1){const a=5;a,5}}`},
+		{`!function(){if(1){const x=5;x;5}var y=function(){return x};y}`, `!function(){{const a=5;a,5}var a=function(){return x};a}`},
+		{`!function(){var x=function(){return y};x;if(1){const y=5;y;5}}`, `!function(){var a=function(){return y};a;{const a=5;a,5}}`},
 		{`!function(){var x=function(){return y};x;if(z)var y=5}`, `!function(){var a=function(){return b},b;a,z&&(b=5)}`},
 		{`!function(){var x=function(){return y};x;if(z){var y=5;5}}`, `!function(){var a=function(){return b},b;a,z&&(b=5,5)}`},
 		{`!function(){var x,y,z=(x,y)=>x+y;x,y,z}`, `!function(){var a,b,c=(a,b)=>a+b;a,b,c}`},
@@ -655,6 +753,7 @@ func TestJSVarRenaming(t *testing.T) {
 		{`!function(){let a=b,b=c,c=d,d=e,e=f,f=g,g=h,h=a,j;for(let i=0;;)j=4}`, `!function(){let a=b,b=c,c=d,d=e,e=f,f=g,g=h,h=a,i;for(let a=0;;)i=4}`},
 		{`function a(){var name;with(z){name}} function b(){var name;name}`, `function a(){var name;with(z)name}function b(){var a;a}`},
 		{`!function(){var name;{name;!function(){name;var other;other}}}`, `!function(){var a;a,!function(){a;var b;b}}`},
+		{`import * as ns from "x";f(ns.a,ns.b,ns.a)`, `import*as ns from"x";f(ns.a,ns.b,ns.a)`}, // unknown exports
 		{`name=function(){var a001,a002,a003,a004,a005,a006,a007,a008,a009,a010,a011,a012,a013,a014,a015,a016,a017,a018,a019,a020,a021,a022,a023,a024,a025,a026,a027,a028,a029,a030,a031,a032,a033,a034,a035,a036,a037,a038,a039,a040,a041,a042,a043,a044,a045,a046,a047,a048,a049,a050,a051,a052,a053,a054,a055,a056,a057,a058,a059,a060,a061,a062,a063,a064,a065,a066,a067,a068,a069,a070,a071,a072,a073,a074,a075,a076,a077,a078,a079,a080,a081,a082,a083,a084,a085,a086,a087,a088,a089,a090,a091,a092,a093,a094,a095,a096,a097,a098,a099,a100,a101,a102,a103,a104,a105,a106,a107,a108,a109;a001,a002,a003,a004,a005,a006,a007,a008,a009,a010,a011,a012,a013,a014,a015,a016,a017,a018,a019,a020,a021,a022,a023,a024,a025,a026,a027,a028,a029,a030,a031,a032,a033,a034,a035,a036,a037,a038,a039,a040,a041,a042,a043,a044,a045,a046,a047,a048,a049,a050,a051,a052,a053,a054,a055,a056,a057,a058,a059,a060,a061,a062,a063,a064,a065,a066,a067,a068,a069,a070,a071,a072,a073,a074,a075,a076,a077,a078,a079,a080,a081,a082,a083,a084,a085,a086,a087,a088,a089,a090,a091,a092,a093,a094,a095,a096,a097,a098,a099,a100,a101,a102,a103,a104,a105,a106,a107,a108,a109}`,
 			`name=function(){var a,b,c,d,e,f,g,h,i,j,k,l,m,n,o,p,q,r,s,t,u,v,w,x,y,z,A,B,C,D,E,F,G,H,I,J,K,L,M,N,O,P,Q,R,S,T,U,V,W,X,Y,Z,_,$,aa,ab,ac,ad,ae,af,ag,ah,ai,aj,ak,al,am,an,ao,ap,aq,ar,at,au,av,aw,ax,ay,az,aA,aB,aC,aD,aE,aF,aG,aH,aI,aJ,aK,aL,aM,aN,aO,aP,aQ,aR,aS,aT,aU,aV,aW,aX,aY,aZ,a_,a$,ba,bb;a,b,c,d,e,f,g,h,i,j,k,l,m,n,o,p,q,r,s,t,u,v,w,x,y,z,A,B,C,D,E,F,G,H,I,J,K,L,M,N,O,P,Q,R,S,T,U,V,W,X,Y,Z,_,$,aa,ab,ac,ad,ae,af,ag,ah,ai,aj,ak,al,am,an,ao,ap,aq,ar,at,au,av,aw,ax,ay,az,aA,aB,aC,aD,aE,aF,aG,aH,aI,aJ,aK,aL,aM,aN,aO,aP,aQ,aR,aS,aT,aU,aV,aW,aX,aY,aZ,a_,a$,ba,bb}`}, // 'as' is a keyword
 	}
@@ -671,6 +770,653 @@ func TestJSVarRenaming(t *testing.T) {
 	}
 }
 
+func TestJSModuleExports(t *testing.T) {
+	jsTests := []struct {
+		js       string
+		expected string
+	}{
+		{`import * as ns from "x";f(ns.a);f(ns.b,ns.a)`, `import{a,b}from"x";f(a),f(b,a)`},
+		{`import d, * as utils from "x";f(utils.format,d)`, `import d,{format}from"x";f(format,d)`},
+		{`import * as ns from "x";function f(p,q){return ns.a+p+ns.a+q}`, `import{a}from"x";function f(b,c){return a+b+a+c}`},
+		{`import * as ns from "x";f(ns.alpha,ns.beta,ns.gamma)`, `import*as ns from"x";f(ns.alpha,ns.beta,ns.gamma)`},
+		{`import * as ns from "x";let a=1;f(ns.a,ns.a)`, `import*as ns from"x";let a=1;f(ns.a,ns.a)`},
+		{`import * as ns from "x";f(ns.a,ns.a,ns)`, `import*as ns from"x";f(ns.a,ns.a,ns)`},
+		{`import * as ns from "x";f(ns.a,ns.a,ns?.b)`, `import*as ns from"x";f(ns.a,ns.a,ns?.b)`},
+		{`import * as ns from "x";f(ns.a,ns.a),delete ns.a`, `import*as ns from"x";f(ns.a,ns.a),delete ns.a`},
+		{`import * as ns from "x";f(ns.default,ns.default)`, `import*as ns from"x";f(ns.default,ns.default)`},
+		{`import * as ns from "x";export {ns};f(ns.a,ns.a)`, `import*as ns from"x";export{ns};f(ns.a,ns.a)`},
+		{`import * as ns from "x";if(ns.maybe)f(ns.maybe,ns.maybe)`, `import*as ns from"x";ns.maybe&&f(ns.maybe,ns.maybe)`}, // not exported
+		{`import * as ns from "x";ns.a();ns.a()`, `import*as ns from"x";ns.a(),ns.a()`},                                     // called with ns as this
+		{`import * as ns from "x";ns.a?.();ns.a?.()`, `import*as ns from"x";ns.a?.(),ns.a?.()`},
+		{"import * as ns from \"x\";ns.a``;ns.a``", "import*as ns from\"x\";ns.a``,ns.a``"},
+		{`import * as ns from "y";f(ns.a,ns.a)`, `import*as ns from"y";f(ns.a,ns.a)`}, // unknown module
+	}
+
+	m := minify.New()
+	o := Minifier{ModuleExports: map[string][]string{"x": {"a", "b", "format", "alpha", "beta", "gamma", "default"}}}
+	for _, tt := range jsTests {
+		t.Run(tt.js, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.js, err, w.String(), tt.expected)
+		})
+	}
+}
+
+func TestJSMangleTopLevel(t *testing.T) {
+	jsTests := []struct {
+		js       string
+		expected string
+	}{
+		{`var config={};function helper(x){return x+window.y}let counter=0`, `var a={};function b(a){return a+window.y}let c=0`},
+		{`helper(config);var other=1;function g(a){var b=other;return helper(b)}`, `b(a);var d=1;function e(c){var a=d;return b(a)}`},
+		{`var config=2;export let other=3;import helper from "x";helper()`, `var a=2;export let other=3;import helper from"x";helper()`},
+		{`var x=5;eval("x")`, `var x=5;eval("x")`},
+	}
+
+	m := minify.New()
+	o := Minifier{MangleTopLevel: true, NameCache: NewNameCache()}
+	for _, tt := range jsTests {
+		t.Run(tt.js, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.js, err, w.String(), tt.expected)
+		})
+	}
+
+	w := &bytes.Buffer{}
+	_, err := o.NameCache.WriteTo(w)
+	test.Error(t, err)
+	test.String(t, w.String(), "{\n\t\"config\": \"a\",\n\t\"counter\": \"c\",\n\t\"g\": \"e\",\n\t\"helper\": \"b\",\n\t\"other\": \"d\"\n}")
+
+	// reuse the name cache
+	cache := NewNameCache()
+	_, err = cache.ReadFrom(w)
+	test.Error(t, err)
+	o = Minifier{MangleTopLevel: true, NameCache: cache}
+	w.Reset()
+	err = o.Minify(m, w, bytes.NewBufferString(`helper(counter,unknown)`), nil)
+	test.Error(t, err)
+	test.String(t, w.String(), `b(c,unknown)`)
+
+	// conflict between a global and a renamed global
+	err = o.Minify(m, w, bytes.NewBufferString(`e()`), nil)
+	test.That(t, err != nil, "must give error for conflicting global")
+
+	_, err = cache.ReadFrom(bytes.NewBufferString(`{"a":"1b"}`))
+	test.That(t, err != nil, "must give error for invalid name")
+}
+
+func TestJSMangleProps(t *testing.T) {
+	jsTests := []struct {
+		js       string
+		expected string
+	}{
+		{`a._x=1;a._x+=a._y`, `a.a=1,a.a+=a.b`},
+		{`var o={_x:1,"_y":2,z:3,_w};o._x`, `var o={a:1,c:2,z:3,b:_w};o.a`},
+		{`a["_x"];a?._x;a?.["_x"];"_x"in a`, `a.a,a?.a,a?.["a"],"a"in a`},
+		{`class A{_f(){}get _g(){}static _h(){}}new A()._f()`, `class A{a(){}get b(){}static c(){}}(new A).a()`},
+		{`var{_x:b,_y=2}=a`, `var{a:b,b:_y=2}=a`},
+		{`a._keep;a._x;a[_x];a[1]`, `a._keep,a.a,a[_x],a[1]`},
+		{`a._x;a.a;a.b`, `a.c,a.a,a.b`},
+		{`a._if;a._x`, `a.a,a.b`},
+		{`a["\x5f"];a._x`, `a.a,a.b`},
+		{`x=a["\x61"];y=a._x`, `x=a["a"],y=a.b`},
+		{`o={["_a"]:1};f(o._a)`, `o={["a"]:1},f(o.a)`},
+		{`o={["\x5fa"]:1,"\u005fb":2};f(o._a,o.\u005fb,a["_\u{62}"])`, `o={["b"]:1,a:2},f(o.b,o.a,a.a)`},
+		{`o={[_a]:1,[` + "`_b`" + `]:2};o._c;a[` + "`a`" + `]`, `o={[_a]:1,[` + "`b`" + `]:2},o.c,a[` + "`a`" + `]`},
+	}
+
+	m := minify.New()
+	o := Minifier{KeepVarNames: true, MangleProps: regexp.MustCompile("^_"), ReservedProps: []string{"_keep"}}
+	for _, tt := range jsTests {
+		t.Run(tt.js, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.js, err, w.String(), tt.expected)
+		})
+	}
+}
+
+func TestJSPureFuncs(t *testing.T) {
+	jsTests := []struct {
+		js       string
+		expected string
+	}{
+		{`console.log("x");a()`, `a()`},
+		{`if(a)console.log(b.c);else d()`, `a?b.c:d()`},
+		{`a(),console.log(b=1,"x",...c),d()`, `a(),b=1,[...c],d()`},
+		{`x=console.log(a)`, `x=console.log(a)`},
+		{`function f(a){console.warn(a);assert(a);debugger}`, `function f(a){console.warn(a);debugger}`},
+		{`debugger;x()`, `debugger;x()`},
+		{`if(a)debugger;else b()`, `if(a)debugger;else b()`},
+		{`function f(){debugger;return 1}`, `function f(){debugger;return 1}`},
+		{`function f(console){console.log(1)}`, `function f(a){a.log(1)}`},
+		{`function f(assert){assert(1)}`, `function f(a){a(1)}`},
+		{`var console={log(){x()}};console.log(1)`, `var console={log(){x()}};console.log(1)`},
+		{`function f(){var assert=g;assert(1)}`, `function f(){var a=g;a(1)}`},
+	}
+
+	m := minify.New()
+	o := Minifier{KeepDebugger: true, PureFuncs: []string{"console.log", "assert"}}
+	for _, tt := range jsTests {
+		t.Run(tt.js, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.js, err, w.String(), tt.expected)
+		})
+	}
+}
+
+func TestJSTreeShaking(t *testing.T) {
+	jsTests := []struct {
+		js       string
+		expected string
+	}{
+		{`function f(){}var a=5`, `function f(){}var a=5`},
+		{`function g(){function f(){}let a=5,b=c(),d=6;return d}`, `function g(){let e=c(),a=6;return a}`},
+		{`function g(){class A{}class B extends C{}const x=/*#__PURE__*/f(1),y=/*#__PURE__*/f(a)}`, `function g(){class c extends C{}const e=f(a)}`},
+		{`function g(){const x=/*#__PURE__*/f(a()),y=/*@__PURE__*/new A}`, `function g(){const b=f(a())}`},
+		{`function g(){/*#__PURE__*/f();/*#__PURE__*/a.b(c=1,...d)}`, `function g(){c=1,[...d]}`},
+		{`x=/*#__PURE__*/f()+/* #__PURE__ */g()`, `x=f()+g()`},
+		{`x=a/2+/re/.test(/*#__PURE__*/f())`, `x=a/2+/re/.test(f())`},
+		{`function g(){eval("f");function f(){}}`, `function g(){eval("f");function a(){}}`},
+		{`function f(){}function g(){}export{g};export function h(){}`, `function g(){}export{g};export function h(){}`},
+		{`import a from"x";const b=1,c=2;export default c`, `import a from"x";const c=2;export default c`},
+	}
+
+	m := minify.New()
+	o := Minifier{TreeShaking: true}
+	for _, tt := range jsTests {
+		t.Run(tt.js, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.js, err, w.String(), tt.expected)
+		})
+	}
+}
+
+func TestJSDefines(t *testing.T) {
+	jsTests := []struct {
+		js       string
+		expected string
+	}{
+		{`if(DEBUG){console.log("debug")}x()`, `x()`},
+		{`if(process.env.NODE_ENV!=="production")check();run()`, `run()`},
+		{`x=process.env.OTHER;y=process.env`, `x=process.env.OTHER,y=process.env`},
+		{`function f(DEBUG){return DEBUG}`, `function f(a){return a}`},
+		{`DEBUG=true;DEBUG++`, `DEBUG=!0,DEBUG++`},
+		{`for(DEBUG in a);`, `for(DEBUG in a);`},
+		{`for(DEBUG of a);`, `for(DEBUG of a);`},
+		{`[DEBUG,[x=DEBUG]]=a`, `[DEBUG,[x=!1]]=a`},
+		{`({a:DEBUG,DEBUG}=b)`, `({a:DEBUG,DEBUG}=b)`},
+		{`(DEBUG)=1`, `DEBUG=1`},
+		{`with(o){DEBUG}DEBUG`, `with(o)DEBUG;!1`},
+		{`x=VERSION+"-"+BUILD`, `x="1.2-"+window.build`},
+		{`x=LOOP`, `x=f(LOOP)`},
+		{`x=CONFIG.a`, `x={a:1}.a`},
+	}
+
+	m := minify.New()
+	o := Minifier{Defines: map[string]string{
+		"DEBUG":                "false",
+		"process.env.NODE_ENV": `"production"`,
+		"VERSION":              `"1.2"`,
+		"BUILD":                "window.build",
+		"LOOP":                 "f(LOOP)",
+		"CONFIG":               "{a:1}",
+	}}
+	for _, tt := range jsTests {
+		t.Run(tt.js, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.js, err, w.String(), tt.expected)
+		})
+	}
+
+	o = Minifier{Defines: map[string]string{"DEBUG": "if(a)b"}}
+	err := o.Minify(m, &bytes.Buffer{}, bytes.NewBufferString(`DEBUG`), nil)
+	test.That(t, err != nil, "must give error for invalid define")
+}
+
+func TestJSBeautify(t *testing.T) {
+	jsTests := []struct {
+		js       string
+		expected string
+	}{
+		{`a = b + c`, "a = b + c;\n"},
+		{`var a = 1, b = [1, , 2]; f(a, b)`, "var a = 1, b = [1, , 2];\nf(a, b);\n"},
+		{`function f(x, y = 2) { if (x) { g(); h() } return x ? y : -x }`, "function f(x, y = 2) {\n  return x && (g(), h()), x ? y : -x;\n}\n"},
+		{`for (var i = 0; i < 10; i++) { f(i); if (i) break } for (;;) {}`, "for (var i = 0; i < 10; i++) if (f(i), i) break;\nfor (;;) ;\n"},
+		{`switch (x) { case 1: a(); break; default: b() }`, "switch (x) {\n  case 1:\n    a();\n    break;\n  default:\n    b();\n}\n"},
+		{`try { a() } catch (e) { b(e) } finally { c() }`, "try {\n  a();\n} catch (e) {\n  b(e);\n} finally {\n  c();\n}\n"},
+		{`class A extends B { get x() { return 1 } static y() {} }`, "class A extends B {\n  get x() {\n    return 1;\n  }\n  static y() {}\n}\n"},
+		{`x = { a: 1, b, [c]: d }; y = async a => { await a; b() }`, "x = {a: 1, b, [c]: d}, y = async a => {\n  await a, b();\n};\n"},
+		{`import x, {a, b as c} from "x"; import * as ns from "y"; export {a as b}`, "import x, {a, b as c} from \"x\";\nimport * as ns from \"y\";\nexport {a as b};\n"},
+		{`/*! license */ if (typeof a === "b" && c in d) delete e[f]; else return`, "/*! license */\nif (typeof a == \"b\" && c in d) delete e[f]; else return;\n"},
+	}
+
+	m := minify.New()
+	o := Minifier{Beautify: true, KeepVarNames: true}
+	for _, tt := range jsTests {
+		t.Run(tt.js, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.js, err, w.String(), tt.expected)
+		})
+	}
+}
+
+func TestJSMaxLineLen(t *testing.T) {
+	jsTests := []struct {
+		js       string
+		expected string
+	}{
+		{`a=1;b=2;c=3`, "a=1,b=2,\nc=3"},
+		{`var alpha=1,beta=2;function f(){return{a:alpha}}f(beta)`, "var alpha=1,\nbeta=2;\nfunction f(){return{a:alpha}\n}f(beta)"},
+		{"x=`a;b,c}${d}`;y=1", "x=`a;b,c}${d}`,\ny=1"},
+		{`for(;;){a();if(b)break}`, "for(;;\n)if(a(),\nb)break"},
+	}
+
+	m := minify.New()
+	o := Minifier{MaxLineLen: 5, KeepVarNames: true}
+	for _, tt := range jsTests {
+		t.Run(tt.js, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.js, err, w.String(), tt.expected)
+		})
+	}
+}
+
+func TestJSASCIIOnly(t *testing.T) {
+	jsTests := []struct {
+		js       string
+		expected string
+	}{
+		{`x="caf\u00e9"`, `x="caf\u00e9"`},
+		{`x="café"`, `x="caf\u00e9"`},
+		{`x='\é😀'`, `x='\u00e9\ud83d\ude00'`},
+		{"x=`€${a}€`", "x=`\\u20ac${a}\\u20ac`"},
+		{"x=String.raw`€`", "x=String.raw`€`"},
+		{`x=/ü+/g`, `x=/\u00fc+/g`},
+		{`var café=1;x.ñ=café`, `var caf\u00e9=1;x.\u00f1=caf\u00e9`},
+	}
+
+	m := minify.New()
+	o := Minifier{ASCIIOnly: true, KeepVarNames: true}
+	for _, tt := range jsTests {
+		t.Run(tt.js, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.js, err, w.String(), tt.expected)
+		})
+	}
+
+	test.String(t, string(escapeNonASCII([]byte("a\U0001F600"), true)), `a\u{1f600}`)
+	test.String(t, string(escapeNonASCII([]byte("'a\\\u2028b'"), false)), `'ab'`)
+}
+
+func TestJSComments(t *testing.T) {
+	jsTests := []struct {
+		policy      minify.CommentPolicy
+		licenseFile string
+		js          string
+		expected    string
+	}{
+		{minify.NoComments, "", "/*! license */a=1", "a=1"},
+		{minify.TaggedComments, "", "/** @preserve */\n// comment\na=1", "/** @preserve */a=1"},
+		{minify.RegexpComments, "", "/* Copyright */\n/*! license */a=1", "/* Copyright */a=1"},
+		{minify.LicenseComments, "a.js.LICENSE.txt", "/*! license */\n//! other\na=1", "/*! For license information please see a.js.LICENSE.txt */a=1"},
+		{minify.LicenseComments, "a.js.LICENSE.txt", "/* comment */a=1", "a=1"},
+		{minify.LicenseComments, "", "a=1;/*! license */\nb=/[/*!]/;//! other\nc=a/b/*! last */", "/*! license *///! other\n/*! last */a=1,b=/[/*!]/,c=a/b"},
+		{minify.TaggedComments, "", "a=1\nfunction f(){/** @preserve */}\n/* comment */f()", "/** @preserve */a=1;function f(){}f()"},
+	}
+
+	m := minify.New()
+	for _, tt := range jsTests {
+		t.Run(tt.js, func(t *testing.T) {
+			comments := &minify.Comments{Policy: tt.policy, Regexp: regexp.MustCompile("Copyright"), LicenseFile: tt.licenseFile}
+			o := Minifier{Comments: comments}
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.js, err, w.String(), tt.expected)
+		})
+	}
+
+	// a nil policy keeps license comments
+	src := "a=1;/*! license */b=2"
+	w := &bytes.Buffer{}
+	err := (&Minifier{}).Minify(m, w, bytes.NewBufferString(src), nil)
+	test.Minify(t, src, err, w.String(), "/*! license */a=1,b=2")
+}
+
+func TestJSSourceMap(t *testing.T) {
+	jsTests := []struct {
+		js        string
+		expected  string
+		sourceMap string
+	}{
+		{"a = 'b'", "a='b'", `{"version":3,"file":"out.js","sources":["in.js"],"names":[],"mappings":"AAAA,EAAI"}`},
+		{"x.y\n\n.z", "x.y.z", `{"version":3,"file":"out.js","sources":["in.js"],"names":[],"mappings":"AAAA,EAAE,EAED"}`},
+		{"function f(bar){return bar}", "function f(a){return a}", `{"version":3,"file":"out.js","sources":["in.js"],"names":["bar"],"mappings":"AAAA,SAAS,EAAEA,GAAK,OAAOA"}`},
+		{"/*! license */\nvar c='\u00e9'", "/*! license */var c='\u00e9'", `{"version":3,"file":"out.js","sources":["in.js"],"names":[],"mappings":"cACA,IAAI,EAAE"}`},
+		{"function add(first, second) {\n  var total = first + second;\n  if (total > 10) {\n    log(total);\n  }\n  return total;\n}\nadd(1, 2);", "function add(b,c){var a=b+c;return a>10&&log(a),a}add(1,2)", `{"version":3,"file":"out.js","sources":["in.js"],"names":["first","second","total"],"mappings":"AAAA,SAAS,IAAIA,EAAOC,GAClB,IAAIC,EAAQF,EAAQC,EAIpB,OAHIC,EAAQ,IACV,IAAIA,GAECA,EAET,IAAI,EAAG"}`},
+	}
+
+	m := minify.New()
+	for _, tt := range jsTests {
+		t.Run(tt.js, func(t *testing.T) {
+			sourceMap := &bytes.Buffer{}
+			o := Minifier{SourceMap: sourceMap, SourceMapSource: "in.js", SourceMapFile: "out.js"}
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.js, err, w.String(), tt.expected)
+			test.String(t, sourceMap.String(), tt.sourceMap)
+
+			// every input line with code must have a mapping
+			sm, err := minify.ParseSourceMap(sourceMap.Bytes())
+			test.Error(t, err)
+			mapped := map[int]bool{}
+			for _, mapping := range sm.Mappings {
+				mapped[mapping.Line] = true
+			}
+			for i, line := range strings.Split(tt.js, "\n") {
+				if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "/") && strings.Trim(line, "{}();") != "" {
+					test.That(t, mapped[i], fmt.Sprintf("line %d has no mapping: %s", i+1, line))
+				}
+			}
+		})
+	}
+}
+
+func TestJSSourceMapReordered(t *testing.T) {
+	jsTests := []struct {
+		js        string
+		expected  string
+		positions map[int][2]int // generated column to original line and column, starting at zero
+	}{
+		{"function compute(a, b) {\n  var result = a * b;\n  if (result > 100) {\n    console.log(\"big\");\n  }\n  console.log(a, b);\n  return result;\n}", "function compute(a,b){var c=a*b;return c>100&&console.log(\"big\"),console.log(a,b),c}", map[int][2]int{
+			39: {2, 6},  // result > 100
+			46: {3, 4},  // console.log("big")
+			65: {5, 2},  // console.log(a, b)
+			77: {5, 14}, // a
+			79: {5, 17}, // b
+			82: {6, 9},  // return result
+		}},
+		{"var x = 1;\nif (!ready) {\n  start(x);\n} else {\n  stop(x);\n}\nvar y = x;", "var x=1,y;ready?stop(x):start(x),y=x", map[int][2]int{
+			10: {1, 5}, // ready
+			16: {4, 2}, // stop
+			21: {4, 7}, // x
+			24: {2, 2}, // start
+			30: {2, 8}, // x
+			33: {6, 4}, // y = x
+			35: {6, 8}, // x
+		}},
+		{"function f(count) {\n  obj.count = count;\n  if (!count) {\n    return {count: count};\n  }\n  log(count);\n  return count;\n}", "function f(a){return(obj.count=a,!a)?{count:a}:(log(a),a)}", map[int][2]int{
+			11: {0, 11}, // count
+			21: {1, 2},  // obj
+			31: {1, 14}, // count
+			34: {2, 7},  // count
+			38: {3, 12}, // count:
+			44: {3, 19}, // count
+			48: {5, 2},  // log
+			52: {5, 6},  // count
+			55: {6, 9},  // count
+		}},
+		{"var total = 0;\nfor (var i = 0; i < n; i++) {\n  total += i;\n}\nreport(total);\nreport(total.total);", "for(var total=0,i=0;i<n;i++)total+=i;report(total),report(total.total)", map[int][2]int{
+			8:  {0, 4},  // total = 0
+			28: {2, 2},  // total += i
+			44: {4, 7},  // total
+			58: {5, 7},  // total
+			64: {5, 13}, // .total
+		}},
+	}
+
+	m := minify.New()
+	for _, tt := range jsTests {
+		t.Run(tt.js, func(t *testing.T) {
+			sourceMap := &bytes.Buffer{}
+			o := Minifier{SourceMap: sourceMap}
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.js, err, w.String(), tt.expected)
+
+			sm, err := minify.ParseSourceMap(sourceMap.Bytes())
+			test.Error(t, err)
+			positions := map[int][2]int{}
+			for _, mapping := range sm.Mappings {
+				positions[mapping.GenCol] = [2]int{mapping.Line, mapping.Col}
+			}
+			for col, position := range tt.positions {
+				test.T(t, positions[col], position, fmt.Sprintf("column %d", col))
+			}
+		})
+	}
+}
+
+func TestJSSourceMapMaxLineLen(t *testing.T) {
+	jsTests := []struct {
+		js       string
+		expected string
+	}{
+		{"var alpha = 1;\nvar beta = 2", "var alpha=1,\nbeta=2"},
+		{"var alpha = 1;\nfunction f(){}\nvar beta = 2", "var alpha=1,\nbeta;function f(){}\nbeta=2"},
+	}
+
+	m := minify.New()
+	for _, tt := range jsTests {
+		t.Run(tt.js, func(t *testing.T) {
+			sourceMap := &bytes.Buffer{}
+			o := Minifier{MaxLineLen: 5, KeepVarNames: true, SourceMap: sourceMap}
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.js, err, w.String(), tt.expected)
+
+			// every mapping must point at the same token in the output and the input
+			sm, err := minify.ParseSourceMap(sourceMap.Bytes())
+			test.Error(t, err)
+			genLines := strings.Split(w.String(), "\n")
+			lines := strings.Split(tt.js, "\n")
+			for _, mapping := range sm.Mappings {
+				if mapping.GenLine < len(genLines) && mapping.GenCol < len(genLines[mapping.GenLine]) {
+					test.T(t, genLines[mapping.GenLine][mapping.GenCol], lines[mapping.Line][mapping.Col], fmt.Sprintf("mapping %v", mapping))
+				} else {
+					t.Errorf("mapping %v outside of output", mapping)
+				}
+			}
+		})
+	}
+}
+
+func TestJSTarget(t *testing.T) {
+	jsTests := []struct {
+		target   Target
+		js       string
+		expected string
+	}{
+		{ES5, `var f=a=>a+1`, `var f=function(a){return a+1}`},
+		{ES5, `function f(){return()=>this.a+arguments[0]}`, `function f(){var a=this,b=arguments;return function(){return a.a+b[0]}}`},
+		{ES5, "var s=`a${b}c\n${d}`", `var s="a"+b+"c\n"+d`},
+		{ES5, "var s=`${a}`", `var s=""+a`},
+		{ES5, `let a=1;const b=2`, `var a=1,b=2`},
+		{ES5, `function f(){let a=1;{let a=2;g(a)}return a}`, `function f(){var a=1,b;return b=2,g(b),a}`},
+		{ES5, `for(let i=0;i<3;i++){let j;f(j)}`, `for(var i=0,j;i<3;i++)j=void 0,f(j)`},
+		{ES5, `var x=a??b`, `var x=a!=null?a:b`},
+		{ES5, `function f(){return a.b??c}`, `function f(){var b;return(b=a.b)!=null?b:c}`},
+		{ES5, `var x=a?.b.c()`, `var x=a==null?void 0:a.b.c()`},
+		{ES5, `var x=a?.[b]`, `var x=a==null?void 0:a[b]`},
+		{ES5, `function f(){return a.b?.()}`, `function f(){var b;return(b=a.b)==null?void 0:b.call(a)}`},
+		{ES5, `function f(){return a?.b.c?.()}`, `function f(){var b,c;return a==null?void 0:(c=(b=a.b).c)==null?void 0:c.call(b)}`},
+		{ES5, `function f(){return(a?.b)()}`, `function f(){return(a==null?void 0:a.b).call(a)}`},
+		{ES5, `delete a?.b`, `a==null||delete a.b`},
+		{ES5, `x=a.b?.c`, `!function(){var b;x=(b=a.b)==null?void 0:b.c}.call(this)`},
+		{ES5, `var y;x=a.b?.c`, `var _a,y;x=(_a=a.b)==null?void 0:_a.c`},
+		{ES5, `function f(){return g(...arguments)}`, `function f(){return g.apply(void 0,arguments)}`},
+		{ES5, `function f(){return o.g(1,...arguments)}`, `function f(){return o.g.apply(o,[1].concat([].slice.call(arguments)))}`},
+		{ES5, `function f(){return[1,...arguments,2]}`, `function f(){return[1].concat([].slice.call(arguments),[2])}`},
+		{ES5, `var x=[1,...[a,b]]`, `var x=[1].concat([a,b])`},
+		{ES5, `var x=f(...[a,b])`, `var x=f.apply(void 0,[a,b])`},
+		{ES5, `function f(a){return g(1,...a)}`, `var _toArray=function(a){if(typeof Symbol=="function"&&a!=null&&typeof a[Symbol.iterator]=="function"&&!Array.isArray(a)){for(var b=[],d=a[Symbol.iterator](),c;!(c=d.next()).done;)b.push(c.value);return b}return[].slice.call(a)};function f(a){return g.apply(void 0,[1].concat(_toArray(a)))}`},
+		{ES5, `function f(){return new F(...[1])}`, `function f(){return new(Function.prototype.bind.apply(F,[null].concat([1])))}`},
+		{ES5, `var s="\u{1F600}\\u{41}"`, `var s="\ud83d\ude00\\u{41}"`},
+		{ES5, `var r=/a.[.]\./gs`, `var r=/a[^][.]\./g`},
+		{ES5, `function f(a=1,...b){return a+b}`, `function f(a){a===void 0&&(a=1);var b=[].slice.call(arguments,1);return a+b}`},
+		{ES5, `var o={a,b(){return 1}}`, `var o={a:a,b:function(){return 1}}`},
+		{ES5, `var x=a**b`, `var x=Math.pow(a,b)`},
+		{ES5, `try{a}catch{b}`, `try{a}catch(a){b}`},
+		{ES5, `class A{constructor(a){this.a=a}m(){return 1}static s(){}get g(){return 1}}`, `var A=function(){function A(a){this.a=a}return A.prototype.m=function(){return 1},A.s=function(){},Object.defineProperty(A.prototype,"g",{get:function(){return 1},configurable:!0}),A}()`},
+		{ES5, `class B extends A{m(){return super.m()+1}}`, `var B=function(a){function B(){a.apply(this,arguments)}return B.prototype=Object.create(a.prototype),B.prototype.constructor=B,B.__proto__=a,B.prototype.m=function(){return a.prototype.m.call(this)+1},B}(A)`},
+		{ES5, `var x=a==null?void 0:a.b`, `var x=a==null?void 0:a.b`},
+		{ES5, `var o={a,...b}`, `var _assign=function(d){for(var c=1,a,b;c<arguments.length;c++)if((a=arguments[c])!=null)for(b in a)Object.prototype.hasOwnProperty.call(a,b)&&(d[b]=a[b]);return d},o=_assign({a:a},b)`},
+		{ES5, "var s=f`a${b}\\u`", `var s=f(_templateObject||(_templateObject=["a",void 0],_templateObject.raw=["a","\\u"],_templateObject),b),_templateObject`},
+		{ES5, `function f(a){for(var b of a)g(b)}`, `var _toArray=function(a){if(typeof Symbol=="function"&&a!=null&&typeof a[Symbol.iterator]=="function"&&!Array.isArray(a)){for(var b=[],d=a[Symbol.iterator](),c;!(c=d.next()).done;)b.push(c.value);return b}return[].slice.call(a)};function f(c){var a,b,d;for(a=0,b=_toArray(c);a<b.length;a++)d=b[a],g(d)}`},
+		{ES5, `for(x of[1,2])f(x)`, `!function(){var a,b;for(a=0,b=[1,2];a<b.length;a++)x=b[a],f(x)}.call(this)`},
+		{ES5, `for(let i=0;i<3;i++)f(()=>i)`, `for(var i=0;i<3;i++)!function(a){f(function(){return a})}(i)`},
+		{ES5, `for(let k in o)f(()=>k)`, `for(var k in o)!function(a){f(function(){return a})}(k)`},
+		{ES5, `function f(){while(a){let b=a--;g(()=>b)}}`, `function f(){while(a)!function(){var b=a--;g(function(){return b})}()}`},
+		{ES5, `function f(){for(let i=0;i<3;i++)this.g(()=>i)}`, `function f(){for(var a=0;a<3;a++)!function(a){this.g(function(){return a})}.call(this,a)}`},
+		{ES2015, `var o={...a,b}`, `var o=Object.assign({},a,{b})`},
+		{ES2015, `var x=a**b`, `var x=Math.pow(a,b)`},
+		{ES2015, `var f=a=>a;let b`, `var f=a=>a;let b`},
+		{ES2016, `a**=2`, `a**=2`},
+		{ES2018, `try{a}catch{b}`, `try{a}catch(a){b}`},
+		{ES2019, `var x=a!=null?a:b`, `var x=a!=null?a:b`},
+		{ES2020, `var x=a!=null?a:b`, `var x=a??b`},
+		{0, `var x=a==null?void 0:a.b.c()`, `var x=a?.b.c()`},
+		{0, `var x=null!=a?a[0]:void 0`, `var x=a?.[0]`},
+		{0, `var o={a(){return 5},b(){return this}}`, `var o={a:()=>5,b(){return this}}`},
+		{0, `var o={__proto__(){return 5}}`, `var o={__proto__(){return 5}}`},
+	}
+
+	m := minify.New()
+	for _, tt := range jsTests {
+		t.Run(tt.js, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			o := Minifier{Target: tt.target}
+			err := o.Minify(m, w, r, nil)
+			test.Minify(t, tt.js, err, w.String(), tt.expected)
+		})
+	}
+
+	errorTests := []struct {
+		target Target
+		js     string
+		err    string
+	}{
+		{ES5, `var [a,b]=c`, "cannot lower destructuring to ES5"},
+		{ES5, `var x=/a/u`, "cannot lower regular expression flag u to ES5"},
+		{ES5, `var x=/a/y`, "cannot lower regular expression flag y to ES5"},
+		{ES5, `for(let i=0;i<3;i++){if(i)continue;f(()=>i)}`, "cannot lower let or const captured by a closure in a loop to ES5"},
+		{ES5, `function g(){for(let i=0;i<3;i++){f(()=>i);return}}`, "cannot lower let or const captured by a closure in a loop to ES5"},
+		{ES5, `for(let i=0;i<3;i++){var j=i;f(()=>i)}`, "cannot lower let or const captured by a closure in a loop to ES5"},
+		{ES5, `for(let i=0;i<3;i++)f(()=>i++)`, "cannot lower let or const captured by a closure in a loop to ES5"},
+		{ES5, `function*f(){}`, "cannot lower generators to ES5"},
+		{ES2017, `async function f(){for await(var a of b);}`, "cannot lower for-await loops to ES2017"},
+		{ES2015, `async function f(){}`, "cannot lower async functions to ES2015"},
+		{ES2017, `var {a,...b}=c`, "cannot lower object rest properties to ES2017"},
+		{ES2019, `var a=10n`, "cannot lower BigInt literals to ES2019"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.js, func(t *testing.T) {
+			r := bytes.NewBufferString(tt.js)
+			w := &bytes.Buffer{}
+			o := Minifier{Target: tt.target}
+			err := o.Minify(m, w, r, nil)
+			if err == nil {
+				test.Fail(t, "expected error")
+			} else {
+				test.String(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
+
+// runNode runs a script in a new context of node and returns what it logs, followed by its exception and its globals.
+func runNode(t *testing.T, src string) string {
+	cmd := exec.Command("node", "-e", `const vm=require("vm");const out=[];const ctx={console:{log:(...a)=>out.push(a.map(String).join(" "))}};try{vm.runInNewContext(require("fs").readFileSync(0,"utf8"),ctx)}catch(e){out.push(e.name)}out.push(Object.keys(ctx).join(","));console.log(out.join("\n"))`)
+	cmd.Stdin = strings.NewReader(src)
+	out, err := cmd.Output()
+	test.Error(t, err)
+	return string(out)
+}
+
+func TestJSTargetRun(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node not found")
+	}
+
+	// the lowered scripts must have the same output and globals as the original
+	jsTests := []string{
+		`var f=function(a){return a?.b.c?.()};console.log(f(null),f({b:{}}),f({b:{c:{d:1,e(){return this.d}}}}))`,
+		`var f=function(a){return a?.b.e?.()};console.log(f(null),f({b:{d:2,e(){return this.d}}}))`,
+		`var f=function(o){return o?.b?.()};console.log(f(null),f({}),f({d:3,b(){return this.d}}))`,
+		`var f=function(a){return(a?.b)()};console.log(f({d:4,b(){return this.d}}));f(null)`,
+		`var f=function(a){return delete a?.b?.c};console.log(f(null),f({}),f({b:{c:1}}))`,
+		`this.o={b:{c:1}};console.log(o.b?.c,o.d?.c,o.b.c??2)`,
+		`console.log([...new Set([1,2,2])].length,Math.max(...[1,3,2]),[..."ab"].join("-"))`,
+		`console.log(function(){return[].slice.call(arguments).join()}(...new Set([1,2])),function(){return[].slice.call(arguments).join()}(0,..."ab"))`,
+		`console.log(new Date(...[2020,1,1]).getFullYear(),new Array(...new Set([1,2])).length)`,
+		`console.log((()=>this===globalThis)(),` + "`${1}\\u{1F600}`" + `.length,"\u{1F600}".length,/a.b/s.test("a\nb"))`,
+		`console.log(JSON.stringify({a:1,...{b:2,c:3},...null,d:4}))`,
+		"!function(){function f(s,...v){return s.join('|')+s.raw.join('|')+v.join()}console.log(f`a${1}b\\n${2}\\u0041`,String.raw`C:\\unicode\\x`)}()",
+		"!function(){var r=[];function t(s){r.push(s)}for(var i=0;i<2;i++)t`x`;console.log(r[0]===r[1],r[0].raw[0])}()",
+		`!function(){var r=[];for(let x of new Set([1,2]))r.push(x);for(var c of "ab")r.push(c);for(const x of[3,4])r.push(()=>x);console.log(r.map(f=>f.call?f():f).join())}()`,
+		`!function(){var r=[];for(let i=0;i<3;i++)r.push(()=>i);for(let k in {a:1,b:2})r.push(()=>k);console.log(r.map(f=>f()).join())}()`,
+		`!function(){var r=[],o={v:5,g(){for(let i=0;i<2;i++)r.push(()=>this.v+i)}};o.g();console.log(r.map(f=>f()).join())}()`,
+		`!function(){var r=[],a=3;while(a){let x=a--;r.push(()=>x)}console.log(r.map(f=>f()).join())}()`,
+	}
+	m := minify.New()
+	for _, src := range jsTests {
+		t.Run(src, func(t *testing.T) {
+			w := &bytes.Buffer{}
+			o := Minifier{Target: ES5}
+			err := o.Minify(m, w, bytes.NewBufferString(src), nil)
+			test.Error(t, err)
+			test.String(t, runNode(t, w.String()), runNode(t, src), w.String())
+		})
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	var targetTests = []struct {
+		s      string
+		target Target
+	}{
+		{"", 0},
+		{"esnext", 0},
+		{"ES5", ES5},
+		{"es6", ES2015},
+		{"es11", ES2020},
+		{"es2017", ES2017},
+	}
+	for _, tt := range targetTests {
+		t.Run(tt.s, func(t *testing.T) {
+			target, err := ParseTarget(tt.s)
+			test.Error(t, err)
+			test.T(t, target, tt.target)
+		})
+	}
+
+	_, err := ParseTarget("es3")
+	test.T(t, err != nil, true)
+	test.String(t, ES2015.String(), "ES2015")
+}
+
 func TestReaderError(t *testing.T) {
 	r := test.NewErrorReader(0)
 	w := &bytes.Buffer{}
@@ -0,0 +1,1634 @@
+package js
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/js"
+)
+
+// Target is an ECMAScript version that the output must conform to, see ParseTarget. The zero value is the latest version.
+type Target int
+
+// Target values.
+const (
+	ES5    Target = 5
+	ES2015 Target = 2015
+	ES2016 Target = 2016
+	ES2017 Target = 2017
+	ES2018 Target = 2018
+	ES2019 Target = 2019
+	ES2020 Target = 2020
+)
+
+// ParseTarget parses an ECMAScript version such as es5, es2015, or es6, where esnext is the latest version.
+func ParseTarget(s string) (Target, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == "esnext" {
+		return 0, nil
+	} else if strings.HasPrefix(s, "es") {
+		if n, err := strconv.Atoi(s[2:]); err == nil {
+			if 6 <= n && n < 100 {
+				n += 2009 // es6 is es2015
+			}
+			if n == int(ES5) || int(ES2015) <= n {
+				return Target(n), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid ECMAScript version %s", s)
+}
+
+func (t Target) String() string {
+	if t == 0 {
+		return "ESNext"
+	}
+	return "ES" + strconv.Itoa(int(t))
+}
+
+// supports returns true if the target supports the syntax introduced in the given version.
+func (t Target) supports(version Target) bool {
+	return t == 0 || version <= t
+}
+
+var (
+	prototypeBytes   = []byte("prototype")
+	objectVarBytes   = []byte("Object")
+	mathBytes        = []byte("Math")
+	functionVarBytes = []byte("Function")
+	constructBytes   = []byte("constructor")
+)
+
+// superClass is the super class of a lowered class, as used by the bodies of its methods.
+type superClass struct {
+	v      *js.Var   // nil for classes without extends
+	scope  *js.Scope // scope of the function that declares v
+	static bool
+}
+
+// lowerer rewrites syntax that the target does not support into older syntax, see lowerAST.
+type lowerer struct {
+	target Target
+	ast    *js.AST
+	names  map[string]bool   // names of all variables, so that new variables have unique names
+	keep   map[js.IExpr]bool // expressions introduced by lowering that must not be lowered again, such as arguments for rest parameters
+
+	blocks []*js.BlockStmt                   // blocks that are being walked, the innermost last
+	funcs  map[*js.Scope]bool                // bodies of functions
+	decls  map[*js.Scope][]js.BindingElement // variables to declare at the top of function bodies
+
+	arrows        map[*js.Scope]bool        // bodies of lowered arrow functions
+	thisVars      map[*js.Scope]*js.Var     // variables holding this of functions for their lowered arrow functions
+	argumentsVars map[*js.Scope]*js.Var     // variables holding arguments of functions for their lowered arrow functions
+	supers        map[*js.Scope]*superClass // bodies of the methods of lowered classes
+	loops         map[*js.Scope]bool        // bodies of loops
+	loopVars      map[*js.Var]bool          // lexical variables declared in loops that were made function-scoped
+	temps         map[*js.Var]bool          // variables declared by lowering
+	toArray       *js.Var                   // helper function that converts an iterable to an array, see toArrayHelper
+	assign        *js.Var                   // helper function that copies the properties of objects, see assignHelper
+
+	err error
+}
+
+// lowerAST rewrites the syntax that the target does not support into equivalent syntax that it does support. For ES5 these are arrow functions, template literals including tagged templates, let and const, classes, spread, default and rest parameters, object literal methods, for-of loops, \\u{X} escapes, and the regular expression flag s, and for later versions exponentiation, object spread, optional catch bindings, optional chaining, and nullish coalescing. Loop bodies whose let and const variables are captured by closures are wrapped in a function for ES5, see wrapLoopBody. The lowering is loose: class methods are enumerable, for-of loops iterate over a copy of arrays, and the strings of tagged templates are not frozen. Syntax that cannot be lowered, such as destructuring, generators, the regular expression flags u and y, or loop bodies that capture let and const but cannot be wrapped for ES5, returns an error. Variables introduced by lowering are declared at the top of their function, and a script is wrapped in a function when they would be its only globals.
+func lowerAST(ast *js.AST, target Target) error {
+	l := &lowerer{
+		target: target,
+		ast:    ast,
+		names:  map[string]bool{},
+		keep:   map[js.IExpr]bool{},
+
+		funcs: map[*js.Scope]bool{},
+		decls: map[*js.Scope][]js.BindingElement{},
+
+		arrows:        map[*js.Scope]bool{},
+		thisVars:      map[*js.Scope]*js.Var{},
+		argumentsVars: map[*js.Scope]*js.Var{},
+		supers:        map[*js.Scope]*superClass{},
+		loops:         map[*js.Scope]bool{},
+		loopVars:      map[*js.Var]bool{},
+		temps:         map[*js.Var]bool{},
+	}
+
+	w := &walker{
+		enterBlock: func(block *js.BlockStmt) {
+			for _, v := range block.Scope.Declared {
+				l.names[string(v.Data)] = true
+			}
+			for _, v := range block.Scope.Undeclared {
+				l.names[string(v.Data)] = true
+			}
+		},
+	}
+	w.walkAST(ast)
+
+	w = &walker{
+		stmt:       l.lowerStmt,
+		expr:       l.lowerExpr,
+		enterBlock: l.enterBlock,
+		exitBlock:  l.exitBlock,
+	}
+	w.walkAST(ast)
+	l.scopeTemporaries()
+	return l.err
+}
+
+// scopeTemporaries wraps a script in a function when the only variables that it declares at the top level are those introduced by lowering, which would otherwise become globals. The variables of scripts that declare globals themselves are kept at the top level, since wrapping would hide their globals.
+func (l *lowerer) scopeTemporaries() {
+	scope := &l.ast.BlockStmt.Scope
+	if len(scope.Declared) == 0 || isModule(l.ast) {
+		return
+	}
+	for _, v := range scope.Declared {
+		if !l.temps[v] {
+			return
+		}
+	}
+
+	// (function(){...}).call(this)
+	fn := &js.FuncDecl{Body: js.BlockStmt{List: l.ast.List, Scope: *scope}}
+	fn.Body.Scope.Parent = scope
+	fn.Body.Scope.Func = &fn.Body.Scope
+	fn.Body.Scope.IsGlobalOrFunc = true
+	scope.Declared = nil
+	scope.NumVarDecls = 0
+	l.ast.List = []js.IStmt{&js.ExprStmt{Value: callExpr(dotExpr(fn, "call"), thisExpr())}}
+}
+
+// unsupported records an error when the target does not support the syntax introduced in the given version, which cannot be lowered.
+func (l *lowerer) unsupported(syntax string, version Target) {
+	if l.target < version && l.err == nil {
+		l.err = fmt.Errorf("cannot lower %s to %v", syntax, l.target)
+	}
+}
+
+// scope returns the scope of the innermost block.
+func (l *lowerer) scope() *js.Scope {
+	return &l.blocks[len(l.blocks)-1].Scope
+}
+
+// funcScope returns the scope of the innermost function that is not a lowered arrow function, which binds this and arguments.
+func (l *lowerer) funcScope() *js.Scope {
+	for i := len(l.blocks) - 1; 0 < i; i-- {
+		if scope := &l.blocks[i].Scope; scope.Func == scope && !l.arrows[scope] {
+			return scope
+		}
+	}
+	return &l.blocks[0].Scope
+}
+
+// inLoop returns true if the innermost block is in a loop of the innermost function.
+func (l *lowerer) inLoop() bool {
+	fn := l.scope().Func
+	for i := len(l.blocks) - 1; 0 <= i && &l.blocks[i].Scope != fn; i-- {
+		if l.loops[&l.blocks[i].Scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueName returns a name based on the given name that is not used by any other variable.
+func (l *lowerer) uniqueName(name string) []byte {
+	unique := name
+	for i := 2; l.names[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	l.names[unique] = true
+	return []byte(unique)
+}
+
+// declareVar declares a new variable in the function scope fn, which is declared at the top of its body with an optional initial value.
+func (l *lowerer) declareVar(fn *js.Scope, name string, init js.IExpr) *js.Var {
+	v := &js.Var{Data: l.uniqueName(name), Uses: 1, Decl: js.VariableDecl}
+	l.temps[v] = true
+	fn.Declared = append(fn.Declared, v)
+	l.decls[fn] = append(l.decls[fn], js.BindingElement{Binding: v, Default: init})
+	return v
+}
+
+// use returns a new use of variable v that is declared in scope, and adds it to the undeclared variables of the scopes in between so that their variables are not renamed to the same name.
+func (l *lowerer) use(v *js.Var, scope *js.Scope) *js.Var {
+Scopes:
+	for s := l.scope(); s != nil && s != scope; s = s.Parent {
+		for _, u := range s.Undeclared {
+			if u == v {
+				continue Scopes
+			}
+		}
+		s.Undeclared = append(s.Undeclared, v)
+	}
+	v.Uses++
+	return v
+}
+
+// global returns a new use of a global variable such as Object.
+func (l *lowerer) global(name []byte) *js.Var {
+	for _, v := range l.ast.Undeclared {
+		if bytes.Equal(v.Data, name) {
+			v.Uses++
+			return v
+		}
+	}
+	v := &js.Var{Data: name, Uses: 1, Decl: js.NoDecl}
+	l.ast.Undeclared = append(l.ast.Undeclared, v)
+	return v
+}
+
+// capture returns a new use of the variable that holds this or arguments of the innermost function that is not a lowered arrow function, declaring it when needed.
+func (l *lowerer) capture(vars map[*js.Scope]*js.Var, name string, value js.IExpr) *js.Var {
+	fn := l.funcScope()
+	v, ok := vars[fn]
+	if !ok {
+		l.keep[value] = true
+		v = l.declareVar(fn, name, value)
+		vars[fn] = v
+	}
+	return l.use(v, fn)
+}
+
+// once returns an expression for the value of *p that may be evaluated again. When the expression is not a variable or literal, *p is replaced by an assignment to a new variable which is returned.
+func (l *lowerer) once(p *js.IExpr) js.IExpr {
+	switch expr := (*p).(type) {
+	case *js.Var:
+		expr.Uses++
+		return expr
+	case *js.LiteralExpr:
+		if expr.TokenType == js.SuperToken {
+			return thisExpr() // super.a() is called on this
+		}
+		return expr
+	}
+	fn := l.scope().Func
+	v := l.declareVar(fn, "_a", nil)
+	*p = &js.GroupExpr{X: binaryExpr(js.EqToken, l.use(v, fn), *p)}
+	return l.use(v, fn)
+}
+
+func (l *lowerer) enterBlock(block *js.BlockStmt) {
+	// set the parent and function scopes, as the scopes of function bodies have been copied by the parser and by lowering
+	scope := &block.Scope
+	if len(l.blocks) == 0 {
+		scope.Parent = nil
+		scope.Func = scope
+	} else {
+		scope.Parent = l.scope()
+		if l.funcs[scope] {
+			scope.Func = scope
+		} else {
+			scope.Func = scope.Parent.Func
+		}
+	}
+	l.blocks = append(l.blocks, block)
+	if l.target < ES2015 {
+		if scope.Func != scope {
+			l.hoistLexicalVars(scope)
+			return
+		}
+		for _, v := range scope.Undeclared {
+			if l.loopVars[resolveVar(v)] {
+				l.unsupported("let or const captured by a closure in a loop", ES2015)
+			}
+		}
+		for _, v := range scope.Declared {
+			if v.Decl == js.LexicalDecl {
+				v.Decl = js.VariableDecl
+			}
+		}
+	}
+}
+
+func (l *lowerer) exitBlock(block *js.BlockStmt) {
+	l.blocks = l.blocks[:len(l.blocks)-1]
+	if decls, ok := l.decls[&block.Scope]; ok {
+		// declare after the directives, such as "use strict"
+		i := 0
+		for i < len(block.List) {
+			if exprStmt, ok := block.List[i].(*js.ExprStmt); !ok || !isDirective(exprStmt) {
+				break
+			}
+			i++
+		}
+		decl := &js.VarDecl{TokenType: js.VarToken, List: decls}
+		block.List = append(block.List[:i], append([]js.IStmt{decl}, block.List[i:]...)...)
+		block.Scope.NumVarDecls++
+		delete(l.decls, &block.Scope)
+	}
+}
+
+// hoistLexicalVars moves the let, const, and class declarations of a block scope to its function scope, renaming them when their name is already used in the function.
+func (l *lowerer) hoistLexicalVars(scope *js.Scope) {
+	inLoop := l.inLoop()
+	declared := scope.Declared[:0]
+	numForInit := scope.NumForInit
+	for i, v := range scope.Declared {
+		if v.Decl != js.LexicalDecl {
+			declared = append(declared, v)
+			continue
+		} else if i < int(scope.NumForInit) {
+			numForInit--
+		}
+		if l.isVisible(scope, v) {
+			v.Data = l.uniqueName(string(v.Data))
+		}
+		v.Decl = js.VariableDecl
+		scope.Func.Declared = append(scope.Func.Declared, v)
+		for s := scope; s != scope.Func; s = s.Parent {
+			s.Undeclared = append(s.Undeclared, v)
+		}
+		if inLoop {
+			l.loopVars[v] = true
+		}
+	}
+	scope.Declared = declared
+	scope.NumForInit = numForInit
+}
+
+// isVisible returns true if another variable with the name of v is declared in or used by the function of the scope, or declared in one of the scopes in between.
+func (l *lowerer) isVisible(scope *js.Scope, v *js.Var) bool {
+	name := v.Data
+	for s := scope; ; s = s.Parent {
+		for _, u := range s.Declared {
+			if u != v && bytes.Equal(u.Data, name) {
+				return true
+			}
+		}
+		if s == scope.Func {
+			break
+		}
+	}
+	for _, v := range scope.Func.Undeclared {
+		if bytes.Equal(resolveVar(v).Data, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *lowerer) lowerStmt(istmt js.IStmt) js.IStmt {
+	switch stmt := istmt.(type) {
+	case *js.VarDecl:
+		l.lowerVarDecl(stmt)
+	case *js.DoWhileStmt:
+		if body, ok := stmt.Body.(*js.BlockStmt); ok {
+			if l.target < ES2015 {
+				l.wrapLoopBody(body)
+			}
+			l.loops[&body.Scope] = true
+		}
+	case *js.WhileStmt:
+		if body, ok := stmt.Body.(*js.BlockStmt); ok {
+			if l.target < ES2015 {
+				l.wrapLoopBody(body)
+			}
+			l.loops[&body.Scope] = true
+		}
+	case *js.ForStmt:
+		if l.target < ES2015 {
+			if vars := l.wrapLoopBody(&stmt.Body); vars != nil {
+				stmt.Init = replaceVars(stmt.Init, vars)
+				stmt.Cond = replaceVars(stmt.Cond, vars)
+				stmt.Post = replaceVars(stmt.Post, vars)
+			}
+		}
+		l.loops[&stmt.Body.Scope] = true
+	case *js.ForInStmt:
+		if l.target < ES2015 {
+			if vars := l.wrapLoopBody(&stmt.Body); vars != nil {
+				stmt.Init = replaceVars(stmt.Init, vars)
+			}
+		}
+		l.loops[&stmt.Body.Scope] = true
+	case *js.ForOfStmt:
+		if stmt.Await {
+			l.unsupported("for-await loops", ES2018)
+		} else if l.target < ES2015 {
+			return l.lowerStmt(l.lowerForOf(stmt))
+		}
+	case *js.TryStmt:
+		if stmt.Binding != nil {
+			l.checkBinding(stmt.Binding)
+		} else if stmt.Catch != nil && l.target < ES2019 {
+			v := &js.Var{Data: l.uniqueName("_e"), Uses: 1, Decl: js.ArgumentDecl}
+			stmt.Catch.Scope.Declared = append(stmt.Catch.Scope.Declared, v)
+			stmt.Binding = v
+		}
+	case *js.FuncDecl:
+		l.lowerFunc(stmt)
+	case *js.ClassDecl:
+		if l.target < ES2015 {
+			// the class name has been made function-scoped
+			l.scope().Func.NumVarDecls++
+			return &js.VarDecl{TokenType: js.VarToken, List: []js.BindingElement{{Binding: stmt.Name, Default: l.lowerClass(stmt)}}}
+		}
+		l.checkClass(stmt)
+	case *js.ImportStmt, *js.ExportStmt:
+		l.unsupported("modules", ES2015)
+	}
+	return istmt
+}
+
+// lowerForOf lowers a for-of loop to a for loop over the elements of an array, such as for(var a of b)f(a) to for(_i=0,_a=_toArray(b);_i<_a.length;_i++){var a=_a[_i];f(a)}. The lowering is loose: the elements of an array are those at the start of the loop.
+func (l *lowerer) lowerForOf(stmt *js.ForOfStmt) *js.ForStmt {
+	fn := l.scope().Func
+	i := l.declareVar(fn, "_i", nil)
+	array := l.declareVar(fn, "_a", nil)
+
+	// the variable of the loop is declared in the body, which uses the temporary variables
+	body := stmt.Body
+	body.Scope.NumForInit = 0
+	body.Scope.Undeclared = append(body.Scope.Undeclared, array, i)
+	element := &js.IndexExpr{X: l.use(array, fn), Index: l.use(i, fn), Prec: js.OpMember}
+	if decl, ok := stmt.Init.(*js.VarDecl); ok {
+		decl.List[0].Default = element
+		body.List = append([]js.IStmt{decl}, body.List...)
+	} else {
+		body.List = append([]js.IStmt{&js.ExprStmt{Value: binaryExpr(js.EqToken, stmt.Init, element)}}, body.List...)
+	}
+
+	zero := &js.LiteralExpr{TokenType: js.DecimalToken, Data: zeroBytes}
+	return &js.ForStmt{
+		Init: binaryExpr(js.CommaToken, binaryExpr(js.EqToken, l.use(i, fn), zero), binaryExpr(js.EqToken, l.use(array, fn), l.spreadElements(stmt.Value, true))),
+		Cond: binaryExpr(js.LtToken, l.use(i, fn), dotExpr(l.use(array, fn), "length")),
+		Post: &js.UnaryExpr{Op: js.PostIncrToken, X: l.use(i, fn)},
+		Body: body,
+	}
+}
+
+// wrapLoopBody wraps the body of a loop in a function that is called in every iteration with the variables declared in the header of the loop, when closures in the body capture its lexical variables, so that every iteration has its own variables, such as for(let i=0;i<n;i++)f(()=>i) to for(let i=0;i<n;i++)(function(i){f(()=>i)})(i). It returns the variables that replace those of the header outside of the function, or nil if the body is not wrapped. Bodies that cannot be moved into a function are not wrapped, see captureLoopVars.
+func (l *lowerer) wrapLoopBody(body *js.BlockStmt) map[*js.Var]*js.Var {
+	captured, wrappable, this := captureLoopVars(body)
+	if !captured || !wrappable {
+		return nil
+	}
+
+	fn := &js.FuncDecl{Body: js.BlockStmt{List: body.List, Scope: body.Scope}}
+	fn.Body.Scope.NumForInit = 0
+	scope := js.Scope{Undeclared: append(js.VarArray{}, body.Scope.Undeclared...)}
+	args := []js.IExpr{}
+	if this {
+		args = append(args, thisExpr())
+	}
+	vars := map[*js.Var]*js.Var{}
+	for _, v := range body.Scope.Declared[:body.Scope.NumForInit] {
+		u := &js.Var{Data: v.Data, Uses: 1, Decl: v.Decl}
+		v.Decl = js.ArgumentDecl
+		fn.Params.List = append(fn.Params.List, js.BindingElement{Binding: v})
+		scope.Declared = append(scope.Declared, u)
+		args = append(args, u)
+		vars[v] = u
+	}
+	scope.NumForInit = uint16(len(scope.Declared))
+
+	var call js.IExpr
+	if this {
+		call = callExpr(dotExpr(fn, "call"), args...)
+	} else {
+		call = callExpr(fn, args...)
+	}
+	body.List = []js.IStmt{&js.ExprStmt{Value: call}}
+	body.Scope = scope
+	return vars
+}
+
+// captureLoopVars returns whether closures in the body of a loop capture the lexical variables declared in its body or header, excluding those of nested loops. It also returns whether the body can be moved into a function, which is not the case when it breaks out of or continues the loop, returns, declares var variables or functions, yields, awaits, uses arguments or super, or assigns to the variables of the header, and whether it uses this.
+func captureLoopVars(body *js.BlockStmt) (captured, wrappable, this bool) {
+	head := map[*js.Var]bool{}
+	for _, v := range body.Scope.Declared[:body.Scope.NumForInit] {
+		head[v] = true
+	}
+	isHead := func(x js.IExpr) bool {
+		v, ok := x.(*js.Var)
+		return ok && head[resolveVar(v)]
+	}
+
+	wrappable = true
+	lexical := map[*js.Var]bool{}
+	funcs := map[*js.Scope]bool{}  // bodies of functions that bind this and arguments
+	arrows := map[*js.Scope]bool{} // bodies of arrow functions
+	labels := map[string]bool{}    // labels of statements in the body
+	funcDepth, closureDepth, loopDepth, switchDepth := 0, 0, 0, 0
+	methods := func(class *js.ClassDecl) {
+		for i := range class.Methods {
+			funcs[&class.Methods[i].Body.Scope] = true
+		}
+	}
+	isLoop := func(istmt js.IStmt) bool {
+		switch istmt.(type) {
+		case *js.ForStmt, *js.ForInStmt, *js.ForOfStmt, *js.WhileStmt, *js.DoWhileStmt:
+			return true
+		}
+		return false
+	}
+
+	w := &walker{}
+	w.stmt = func(istmt js.IStmt) js.IStmt {
+		switch stmt := istmt.(type) {
+		case *js.FuncDecl:
+			funcs[&stmt.Body.Scope] = true
+			wrappable = wrappable && 0 < closureDepth // function declarations in blocks may be hoisted to the function
+		case *js.ClassDecl:
+			methods(stmt)
+		case *js.ForInStmt:
+			wrappable = wrappable && !isHead(stmt.Init)
+		case *js.ForOfStmt:
+			wrappable = wrappable && !isHead(stmt.Init)
+		}
+		if 0 < closureDepth {
+			return istmt
+		}
+		switch stmt := istmt.(type) {
+		case *js.VarDecl:
+			wrappable = wrappable && stmt.TokenType != js.VarToken
+		case *js.ReturnStmt:
+			wrappable = false
+		case *js.BranchStmt:
+			if stmt.Label != nil {
+				wrappable = wrappable && labels[string(stmt.Label)]
+			} else if stmt.Type == js.ContinueToken {
+				wrappable = wrappable && 0 < loopDepth
+			} else {
+				wrappable = wrappable && 0 < loopDepth+switchDepth
+			}
+		case *js.LabelledStmt:
+			labels[string(stmt.Label)] = true
+		case *js.SwitchStmt:
+			switchDepth++
+		}
+		if isLoop(istmt) {
+			loopDepth++
+		}
+		return istmt
+	}
+	w.exitStmt = func(istmt js.IStmt) {
+		if 0 < closureDepth {
+			return
+		} else if _, ok := istmt.(*js.SwitchStmt); ok {
+			switchDepth--
+		} else if isLoop(istmt) {
+			loopDepth--
+		}
+	}
+	w.expr = func(iexpr js.IExpr) js.IExpr {
+		switch expr := iexpr.(type) {
+		case *js.FuncDecl:
+			funcs[&expr.Body.Scope] = true
+		case *js.MethodDecl:
+			funcs[&expr.Body.Scope] = true
+		case *js.ClassDecl:
+			methods(expr)
+		case *js.ArrowFunc:
+			arrows[&expr.Body.Scope] = true
+		case *js.BinaryExpr:
+			wrappable = wrappable && !(binaryLeftPrecMap[expr.Op] == js.OpLHS && isHead(expr.X))
+		case *js.UnaryExpr:
+			switch expr.Op {
+			case js.PreIncrToken, js.PreDecrToken, js.PostIncrToken, js.PostDecrToken:
+				wrappable = wrappable && !isHead(expr.X)
+			case js.AwaitToken:
+				wrappable = wrappable && 0 < closureDepth
+			}
+		case *js.YieldExpr:
+			wrappable = wrappable && 0 < closureDepth
+		case *js.Var:
+			if funcDepth == 0 && bytes.Equal(expr.Data, argumentsBytes) && resolveVar(expr).Decl == js.NoDecl {
+				wrappable = false
+			}
+		case *js.LiteralExpr:
+			if funcDepth == 0 && expr.TokenType == js.ThisToken {
+				this = true
+			} else if funcDepth == 0 && expr.TokenType == js.SuperToken {
+				wrappable = false
+			}
+		}
+		return iexpr
+	}
+	w.enterBlock = func(block *js.BlockStmt) {
+		scope := &block.Scope
+		if funcs[scope] {
+			funcDepth++
+			closureDepth++
+		} else if arrows[scope] {
+			closureDepth++
+		}
+		if 0 < closureDepth {
+			for _, v := range scope.Undeclared {
+				captured = captured || lexical[resolveVar(v)]
+			}
+		} else if loopDepth == 0 {
+			for _, v := range scope.Declared {
+				if v.Decl == js.LexicalDecl {
+					lexical[v] = true
+				}
+			}
+		}
+	}
+	w.exitBlock = func(block *js.BlockStmt) {
+		scope := &block.Scope
+		if funcs[scope] {
+			funcDepth--
+			closureDepth--
+		} else if arrows[scope] {
+			closureDepth--
+		}
+	}
+	w.walkBlockStmt(body)
+	return
+}
+
+// replaceVars replaces the variables of an expression in the header of a loop by those returned by wrapLoopBody.
+func replaceVars(iexpr js.IExpr, vars map[*js.Var]*js.Var) js.IExpr {
+	w := &walker{
+		expr: func(iexpr js.IExpr) js.IExpr {
+			switch expr := iexpr.(type) {
+			case *js.Var:
+				if u, ok := vars[resolveVar(expr)]; ok {
+					resolveVar(expr).Uses--
+					u.Uses++
+					return u
+				}
+			case *js.VarDecl:
+				for i, item := range expr.List {
+					if v, ok := item.Binding.(*js.Var); ok && vars[v] != nil {
+						expr.List[i].Binding = vars[v]
+					}
+				}
+			}
+			return iexpr
+		},
+	}
+	return w.walkExpr(iexpr)
+}
+
+func (l *lowerer) lowerVarDecl(decl *js.VarDecl) {
+	if decl.TokenType != js.VarToken && l.target < ES2015 {
+		if decl.TokenType == js.LetToken && l.inLoop() {
+			// let is initialized to undefined in every iteration
+			for i, item := range decl.List {
+				if _, ok := item.Binding.(*js.Var); ok && item.Default == nil {
+					decl.List[i].Default = voidZeroExpr()
+				}
+			}
+		}
+		decl.TokenType = js.VarToken
+		l.scope().Func.NumVarDecls++
+	}
+	for _, item := range decl.List {
+		l.checkBinding(item.Binding)
+	}
+}
+
+// checkBinding records an error for binding patterns that the target does not support.
+func (l *lowerer) checkBinding(ibinding js.IBinding) {
+	switch binding := ibinding.(type) {
+	case *js.BindingArray:
+		l.unsupported("destructuring", ES2015)
+	case *js.BindingObject:
+		l.unsupported("destructuring", ES2015)
+		if binding.Rest != nil {
+			l.unsupported("object rest properties", ES2018)
+		}
+	}
+}
+
+// checkFunc records an error for async functions and generators that the target does not support.
+func (l *lowerer) checkFunc(async, generator bool) {
+	if async && generator {
+		l.unsupported("async generators", ES2018)
+	} else if async {
+		l.unsupported("async functions", ES2017)
+	} else if generator {
+		l.unsupported("generators", ES2015)
+	}
+}
+
+// checkClass records an error for class methods that the target does not support, for targets that support classes.
+func (l *lowerer) checkClass(class *js.ClassDecl) {
+	for i, method := range class.Methods {
+		l.funcs[&class.Methods[i].Body.Scope] = true
+		l.checkFunc(method.Async, method.Generator)
+	}
+}
+
+// lowerFunc lowers the default values and the rest parameter of a function to statements at the top of its body.
+func (l *lowerer) lowerFunc(fn *js.FuncDecl) {
+	l.funcs[&fn.Body.Scope] = true
+	l.checkFunc(fn.Async, fn.Generator)
+	if ES2015 <= l.target {
+		return
+	}
+
+	list := []js.IStmt{}
+	for i, item := range fn.Params.List {
+		l.checkBinding(item.Binding)
+		if v, ok := item.Binding.(*js.Var); ok && item.Default != nil {
+			// if(a===void 0)a=b
+			v.Uses += 2
+			list = append(list, &js.IfStmt{
+				Cond: binaryExpr(js.EqEqEqToken, v, voidZeroExpr()),
+				Body: &js.ExprStmt{Value: binaryExpr(js.EqToken, v, item.Default)},
+			})
+			fn.Params.List[i].Default = nil
+		}
+	}
+	if fn.Params.Rest != nil {
+		l.checkBinding(fn.Params.Rest)
+		if v, ok := fn.Params.Rest.(*js.Var); ok {
+			// var a=[].slice.call(arguments,n)
+			args := &js.Var{Data: argumentsBytes, Uses: 1, Decl: js.NoDecl}
+			l.keep[args] = true
+			v.Decl = js.VariableDecl
+			list = append(list, &js.VarDecl{TokenType: js.VarToken, List: []js.BindingElement{{Binding: v, Default: sliceExpr(args, len(fn.Params.List))}}})
+			fn.Body.Scope.NumVarDecls++
+			fn.Params.Rest = nil
+		}
+	}
+	if 0 < len(list) {
+		fn.Body.List = append(list, fn.Body.List...)
+	}
+}
+
+// lowerMethod returns a function expression for a method, whose body is the body of the method.
+func (l *lowerer) lowerMethod(method *js.MethodDecl) *js.FuncDecl {
+	return &js.FuncDecl{Async: method.Async, Generator: method.Generator, Params: method.Params, Body: method.Body}
+}
+
+func (l *lowerer) lowerExpr(iexpr js.IExpr) js.IExpr {
+	if l.keep[iexpr] {
+		return iexpr
+	}
+
+	switch expr := iexpr.(type) {
+	case *js.Var:
+		if l.target < ES2015 && bytes.Equal(expr.Data, argumentsBytes) && resolveVar(expr).Decl == js.NoDecl && l.arrows[l.scope().Func] {
+			return l.capture(l.argumentsVars, "_arguments", expr)
+		}
+	case *js.LiteralExpr:
+		switch expr.TokenType {
+		case js.ThisToken:
+			if l.target < ES2015 && l.arrows[l.scope().Func] {
+				return l.capture(l.thisVars, "_this", expr)
+			}
+		case js.StringToken:
+			if l.target < ES2015 {
+				// "\u{1F600}"  =>  "\ud83d\ude00"
+				expr.Data = lowerCodePointEscapes(expr.Data)
+			}
+		case js.RegExpToken:
+			return l.lowerRegExp(expr)
+		case js.BigIntToken:
+			l.unsupported("BigInt literals", ES2020)
+		case js.ImportToken:
+			l.unsupported("dynamic imports", ES2020)
+		}
+	case *js.NewTargetExpr:
+		l.unsupported("new.target", ES2015)
+	case *js.ImportMetaExpr:
+		l.unsupported("import.meta", ES2020)
+	case *js.VarDecl:
+		l.lowerVarDecl(expr)
+	case *js.FuncDecl:
+		l.lowerFunc(expr)
+	case *js.MethodDecl:
+		l.funcs[&expr.Body.Scope] = true
+		l.checkFunc(expr.Async, expr.Generator)
+	case *js.ArrowFunc:
+		if l.target < ES2015 {
+			fn := &js.FuncDecl{Async: expr.Async, Params: expr.Params, Body: expr.Body}
+			l.arrows[&fn.Body.Scope] = true
+			l.lowerFunc(fn)
+			return fn
+		}
+		l.funcs[&expr.Body.Scope] = true
+		l.checkFunc(expr.Async, false)
+	case *js.ClassDecl:
+		if l.target < ES2015 {
+			return l.lowerClass(expr)
+		}
+		l.checkClass(expr)
+	case *js.TemplateExpr:
+		if expr.Tag != nil && l.target < ES2015 {
+			return l.lowerTaggedTemplate(expr)
+		} else if l.target < ES2015 {
+			return groupExpr(templateConcat(expr), js.OpPrimary)
+		}
+	case *js.ArrayExpr:
+		if l.target < ES2015 {
+			return l.lowerArray(expr)
+		}
+	case *js.ObjectExpr:
+		return l.lowerObject(expr)
+	case *js.NewExpr:
+		if l.target < ES2015 && expr.Args != nil && expr.Args.Rest != nil {
+			// new F(...a)  =>  new(Function.prototype.bind.apply(F,[null].concat(a)))
+			fn := dotExpr(dotExpr(l.global(functionVarBytes), "prototype"), "bind")
+			args := l.spreadArgs(append([]js.IExpr{nullExpr()}, expr.Args.List...), expr.Args.Rest)
+			return &js.NewExpr{X: groupExpr(callExpr(dotExpr(fn, "apply"), expr.X, args), js.OpNew)}
+		}
+	case *js.CallExpr:
+		if l.target < ES2015 {
+			if lit, ok := expr.X.(*js.LiteralExpr); ok && lit.TokenType == js.SuperToken {
+				// super(a)  =>  _super.call(this,a)
+				if super := l.superExpr(true); super != nil {
+					return groupExpr(l.callThis(super, thisExpr(), expr.Args), js.OpCall)
+				}
+			} else if fn, ok := l.superMember(expr.X); ok {
+				// super.f(a)  =>  _super.prototype.f.call(this,a)
+				return groupExpr(l.callThis(fn, thisExpr(), expr.Args), js.OpCall)
+			}
+		}
+		if l.target < ES2020 {
+			if group, ok := expr.X.(*js.GroupExpr); ok && hasOptChain(group.X) {
+				// (a?.b)()  =>  (a==null?void 0:a.b).call(a)
+				if this := l.memberThis(group.X); this != nil {
+					return groupExpr(l.callThis(group, this, expr.Args), js.OpCall)
+				}
+			} else if lowered := l.lowerOptChain(expr, false); lowered != nil {
+				return lowered
+			}
+		}
+		if l.target < ES2015 && expr.Args.Rest != nil {
+			// f(...a)  =>  f.apply(void 0,a)
+			var this js.IExpr = voidZeroExpr()
+			switch x := expr.X.(type) {
+			case *js.DotExpr:
+				this = l.once(&x.X)
+			case *js.IndexExpr:
+				this = l.once(&x.X)
+			}
+			return groupExpr(l.callThis(expr.X, this, expr.Args), js.OpCall)
+		}
+	case *js.DotExpr, *js.IndexExpr:
+		if l.target < ES2015 {
+			if member, ok := l.superMember(expr); ok {
+				return member
+			}
+		}
+		if l.target < ES2020 {
+			if lowered := l.lowerOptChain(expr, false); lowered != nil {
+				return lowered
+			}
+		}
+	case *js.OptChainExpr:
+		if l.target < ES2020 {
+			if lowered := l.lowerOptChain(expr, false); lowered != nil {
+				return lowered
+			}
+		}
+	case *js.UnaryExpr:
+		if expr.Op == js.DeleteToken && l.target < ES2020 {
+			if lowered := l.lowerOptChain(expr.X, true); lowered != nil {
+				return groupExpr(lowered, js.OpUnary)
+			}
+		}
+	case *js.BinaryExpr:
+		return l.lowerBinaryExpr(expr)
+	}
+	return iexpr
+}
+
+func (l *lowerer) lowerBinaryExpr(expr *js.BinaryExpr) js.IExpr {
+	switch expr.Op {
+	case js.EqToken:
+		switch expr.X.(type) {
+		case *js.ArrayExpr, *js.ObjectExpr:
+			l.unsupported("destructuring", ES2015)
+		}
+	case js.NullishToken:
+		if l.target < ES2020 {
+			// a??b  =>  a!=null?a:b
+			value := l.once(&expr.X)
+			return groupExpr(condExpr(binaryExpr(js.NotEqToken, expr.X, nullExpr()), value, expr.Y), js.OpCoalesce)
+		}
+	case js.ExpToken:
+		if l.target < ES2016 {
+			// a**b  =>  Math.pow(a,b)
+			return groupExpr(callExpr(dotExpr(l.global(mathBytes), "pow"), expr.X, expr.Y), js.OpExp)
+		}
+	case js.ExpEqToken:
+		if l.target < ES2016 {
+			// a**=b  =>  a=Math.pow(a,b)
+			var value js.IExpr
+			switch x := expr.X.(type) {
+			case *js.Var:
+				value = l.once(&expr.X)
+			case *js.DotExpr:
+				value = &js.DotExpr{X: l.once(&x.X), Y: x.Y, Prec: x.Prec}
+			case *js.IndexExpr:
+				value = &js.IndexExpr{X: l.once(&x.X), Index: l.once(&x.Index), Prec: x.Prec}
+			default:
+				l.unsupported("exponentiation assignments", ES2016)
+				return expr
+			}
+			return binaryExpr(js.EqToken, expr.X, callExpr(dotExpr(l.global(mathBytes), "pow"), value, expr.Y))
+		}
+	}
+	return expr
+}
+
+// callThis returns the call of fn with the given value of this and arguments, using call or apply.
+func (l *lowerer) callThis(fn, this js.IExpr, args js.Arguments) *js.CallExpr {
+	if args.Rest != nil && ES2015 <= l.target {
+		call := callExpr(dotExpr(fn, "call"), append([]js.IExpr{this}, args.List...)...)
+		call.Args.Rest = args.Rest
+		return call
+	} else if args.Rest != nil {
+		return callExpr(dotExpr(fn, "apply"), this, l.spreadArgs(args.List, args.Rest))
+	}
+	return callExpr(dotExpr(fn, "call"), append([]js.IExpr{this}, args.List...)...)
+}
+
+// superExpr returns the expression for super in the methods of a lowered class, which is the super class for calls and static methods, and its prototype otherwise. It returns nil if super is not in a lowered class.
+func (l *lowerer) superExpr(call bool) js.IExpr {
+	super, ok := l.supers[l.funcScope()]
+	if !ok {
+		l.unsupported("super outside of classes", ES2015)
+		return nil
+	}
+	var expr js.IExpr
+	if super.v != nil {
+		expr = l.use(super.v, super.scope)
+	} else {
+		expr = l.global(objectVarBytes)
+	}
+	if !call && !super.static {
+		expr = dotExpr(expr, "prototype")
+	}
+	return expr
+}
+
+// superMember returns the lowered member expression for super.a and super[a] in the methods of a lowered class.
+func (l *lowerer) superMember(iexpr js.IExpr) (js.IExpr, bool) {
+	switch expr := iexpr.(type) {
+	case *js.DotExpr:
+		if lit, ok := expr.X.(*js.LiteralExpr); ok && lit.TokenType == js.SuperToken {
+			if super := l.superExpr(false); super != nil {
+				return &js.DotExpr{X: super, Y: expr.Y, Prec: js.OpMember}, true
+			}
+		}
+	case *js.IndexExpr:
+		if lit, ok := expr.X.(*js.LiteralExpr); ok && lit.TokenType == js.SuperToken {
+			if super := l.superExpr(false); super != nil {
+				return &js.IndexExpr{X: super, Index: expr.Index, Prec: js.OpMember}, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// lowerOptChain lowers the innermost optional chain of a chain of member, call, and optional chain expressions, such as a?.b.c to a==null?void 0:a.b.c, so that it short-circuits the rest of the chain. The optional chains further along the chain are lowered when the result is walked, such as a?.b?.c to a==null?void 0:a.b==null?void 0:a.b.c. For delete it returns true instead of undefined. It returns nil if there is no optional chain.
+func (l *lowerer) lowerOptChain(expr js.IExpr, isDelete bool) js.IExpr {
+	var p *js.IExpr
+	var optChain *js.OptChainExpr
+	for q := &expr; ; {
+		switch x := (*q).(type) {
+		case *js.DotExpr:
+			q = &x.X
+			continue
+		case *js.IndexExpr:
+			q = &x.X
+			continue
+		case *js.CallExpr:
+			q = &x.X
+			continue
+		case *js.OptChainExpr:
+			p, optChain = q, x
+			q = &x.X
+			continue
+		}
+		break
+	}
+	if optChain == nil {
+		return nil
+	}
+
+	// keep this for calls of members, such as a.b?.()
+	var this js.IExpr
+	if _, ok := optChain.Y.(*js.CallExpr); ok {
+		this = l.memberThis(optChain.X)
+	}
+	value := l.once(&optChain.X)
+
+	switch y := optChain.Y.(type) {
+	case *js.LiteralExpr:
+		*p = &js.DotExpr{X: groupExpr(value, js.OpCall), Y: *y, Prec: js.OpCall}
+	case *js.IndexExpr:
+		*p = &js.IndexExpr{X: groupExpr(value, js.OpCall), Index: y.Index, Prec: js.OpCall}
+	case *js.CallExpr:
+		if this != nil {
+			*p = l.callThis(value, this, y.Args)
+		} else {
+			*p = &js.CallExpr{X: groupExpr(value, js.OpCall), Args: y.Args}
+		}
+	default:
+		l.unsupported("tagged templates in optional chains", ES2020)
+		return nil
+	}
+
+	var undefined js.IExpr = voidZeroExpr()
+	if isDelete {
+		expr = &js.UnaryExpr{Op: js.DeleteToken, X: groupExpr(expr, js.OpUnary)}
+		undefined = &js.LiteralExpr{TokenType: js.TrueToken, Data: trueBytes}
+	}
+	return groupExpr(condExpr(binaryExpr(js.EqEqToken, optChain.X, nullExpr()), undefined, expr), js.OpCall)
+}
+
+// memberThis returns an expression for the object of a member expression, which is the value of this when the member is called, and replaces the object by an assignment to a new variable if needed. It returns nil if x is not a member expression.
+func (l *lowerer) memberThis(x js.IExpr) js.IExpr {
+	switch y := x.(type) {
+	case *js.DotExpr:
+		return l.once(&y.X)
+	case *js.IndexExpr:
+		return l.once(&y.X)
+	case *js.OptChainExpr:
+		switch y.Y.(type) {
+		case *js.LiteralExpr, *js.IndexExpr:
+			return l.once(&y.X)
+		}
+	}
+	return nil
+}
+
+// hasOptChain returns true if the chain of member and call expressions contains an optional chain.
+func hasOptChain(x js.IExpr) bool {
+	for {
+		switch y := x.(type) {
+		case *js.DotExpr:
+			x = y.X
+		case *js.IndexExpr:
+			x = y.X
+		case *js.CallExpr:
+			x = y.X
+		case *js.OptChainExpr:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// lowerArray lowers spread elements to concatenation, such as [a,...arguments] to [a].concat([].slice.call(arguments)).
+func (l *lowerer) lowerArray(array *js.ArrayExpr) js.IExpr {
+	hasSpread := false
+	for _, item := range array.List {
+		hasSpread = hasSpread || item.Spread
+	}
+	if !hasSpread {
+		return array
+	} else if len(array.List) == 1 {
+		return l.spreadElements(array.List[0].Value, false)
+	}
+
+	args := []js.IExpr{}
+	elements := &js.ArrayExpr{}
+	for i, item := range array.List {
+		if item.Spread {
+			if 0 < len(elements.List) || i == 0 {
+				args = append(args, elements)
+				elements = &js.ArrayExpr{}
+			}
+			args = append(args, l.spreadElements(item.Value, false))
+		} else {
+			elements.List = append(elements.List, item)
+		}
+	}
+	if 0 < len(elements.List) {
+		args = append(args, elements)
+	}
+	return groupExpr(callExpr(dotExpr(args[0], "concat"), args[1:]...), js.OpPrimary)
+}
+
+// lowerObject lowers shorthand methods to function expressions for ES5, and spread properties to Object.assign, such as {a,...b} to Object.assign({a},b), or to a helper function for ES5, see assignHelper.
+func (l *lowerer) lowerObject(object *js.ObjectExpr) js.IExpr {
+	hasSpread := false
+	for i, item := range object.List {
+		hasSpread = hasSpread || item.Spread
+		if l.target < ES2015 {
+			if item.Name != nil && item.Name.IsComputed() {
+				l.unsupported("computed property names", ES2015)
+			} else if method, ok := item.Value.(*js.MethodDecl); ok {
+				if method.Name.IsComputed() {
+					l.unsupported("computed property names", ES2015)
+				} else if !method.Get && !method.Set {
+					name := method.Name
+					object.List[i].Name = &name
+					object.List[i].Value = l.lowerMethod(method)
+				}
+			}
+		}
+	}
+	if !hasSpread || ES2018 <= l.target {
+		return object
+	}
+
+	args := []js.IExpr{}
+	properties := &js.ObjectExpr{}
+	for i, item := range object.List {
+		if item.Spread {
+			if 0 < len(properties.List) || i == 0 {
+				args = append(args, properties)
+				properties = &js.ObjectExpr{}
+			}
+			args = append(args, item.Value)
+		} else {
+			properties.List = append(properties.List, item)
+		}
+	}
+	if 0 < len(properties.List) {
+		args = append(args, properties)
+	}
+	if l.target < ES2015 {
+		return callExpr(l.helper(&l.assign, "_assign", assignHelper), args...)
+	}
+	return groupExpr(callExpr(dotExpr(l.global(objectVarBytes), "assign"), args...), js.OpPrimary)
+}
+
+// lowerClass lowers a class to a constructor function whose methods are assigned to its prototype, in a function that is called with the super class. Getters and setters are defined with Object.defineProperty, and the super class is set with Object.create and __proto__.
+func (l *lowerer) lowerClass(class *js.ClassDecl) js.IExpr {
+	iife := &js.FuncDecl{}
+	scope := &iife.Body.Scope
+
+	// the constructor has the name of the class, which is declared outside the function for class declarations
+	name := class.Name
+	if name == nil {
+		name = &js.Var{Data: l.uniqueName("_class"), Decl: js.FunctionDecl}
+	}
+	if name.Decl == js.ExprDecl || name.Decl == js.FunctionDecl {
+		name.Decl = js.FunctionDecl
+		scope.Declared = append(scope.Declared, name)
+	} else {
+		scope.Undeclared = append(scope.Undeclared, name)
+	}
+
+	args := js.Arguments{}
+	var super *js.Var
+	if class.Extends != nil {
+		super = &js.Var{Data: l.uniqueName("_super"), Uses: 1, Decl: js.ArgumentDecl}
+		scope.Declared = append(scope.Declared, super)
+		iife.Params.List = []js.BindingElement{{Binding: super}}
+		args.List = []js.IExpr{groupExpr(class.Extends, js.OpAssign)}
+	}
+	ref := func(v *js.Var) *js.Var {
+		v.Uses++
+		return v
+	}
+
+	var ctor *js.FuncDecl
+	methods := []*js.FuncDecl{}
+	for i := range class.Methods {
+		method := &class.Methods[i]
+		fn := l.lowerMethod(method)
+		l.supers[&fn.Body.Scope] = &superClass{super, scope, method.Static}
+		methods = append(methods, fn)
+		if !method.Static && !method.Get && !method.Set && !method.Name.IsComputed() && bytes.Equal(propertyNameValue(method.Name), constructBytes) {
+			ctor = fn
+		}
+	}
+	if ctor == nil {
+		// the default constructor calls the super class with the same arguments
+		ctor = &js.FuncDecl{}
+		if super != nil {
+			args := &js.Var{Data: argumentsBytes, Uses: 1, Decl: js.NoDecl}
+			ctor.Body.Scope.Undeclared = append(ctor.Body.Scope.Undeclared, super, args)
+			ctor.Body.List = []js.IStmt{&js.ExprStmt{Value: callExpr(dotExpr(ref(super), "apply"), thisExpr(), args)}}
+		}
+	}
+	ctor.Name = ref(name)
+
+	list := []js.IStmt{ctor}
+	if super != nil {
+		// A.prototype=Object.create(_super.prototype);A.prototype.constructor=A;A.__proto__=_super
+		list = append(list,
+			&js.ExprStmt{Value: binaryExpr(js.EqToken, dotExpr(ref(name), "prototype"), callExpr(dotExpr(l.global(objectVarBytes), "create"), dotExpr(ref(super), "prototype")))},
+			&js.ExprStmt{Value: binaryExpr(js.EqToken, dotExpr(dotExpr(ref(name), "prototype"), "constructor"), ref(name))},
+			&js.ExprStmt{Value: binaryExpr(js.EqToken, dotExpr(ref(name), "__proto__"), ref(super))},
+		)
+	}
+
+	accessors := map[string]*js.ObjectExpr{}
+	for i, method := range class.Methods {
+		fn := methods[i]
+		if fn == ctor {
+			continue
+		} else if method.Name.IsComputed() {
+			l.unsupported("computed property names", ES2015)
+			continue
+		}
+
+		var object js.IExpr = ref(name)
+		if !method.Static {
+			object = dotExpr(object, "prototype")
+		}
+		if method.Get || method.Set {
+			// Object.defineProperty(A.prototype,"a",{get:function(){},configurable:!0})
+			key := string(propertyNameValue(method.Name))
+			if method.Static {
+				key = "static " + key
+			}
+			descriptor, ok := accessors[key]
+			if !ok {
+				descriptor = &js.ObjectExpr{}
+				accessors[key] = descriptor
+				list = append(list, &js.ExprStmt{Value: callExpr(dotExpr(l.global(objectVarBytes), "defineProperty"), object, propertyNameString(method.Name), descriptor)})
+			}
+			kind := getBytes
+			if method.Set {
+				kind = setBytes
+			}
+			descriptor.List = append(descriptor.List, js.Property{Name: &js.PropertyName{Literal: js.LiteralExpr{TokenType: js.IdentifierToken, Data: kind}}, Value: fn})
+		} else {
+			// A.prototype.a=function(){}
+			list = append(list, &js.ExprStmt{Value: binaryExpr(js.EqToken, memberExpr(object, method.Name.Literal), fn)})
+		}
+	}
+	for _, descriptor := range accessors {
+		descriptor.List = append(descriptor.List, js.Property{
+			Name:  &js.PropertyName{Literal: js.LiteralExpr{TokenType: js.IdentifierToken, Data: []byte("configurable")}},
+			Value: &js.LiteralExpr{TokenType: js.TrueToken, Data: trueBytes},
+		})
+	}
+	iife.Body.List = append(list, &js.ReturnStmt{Value: ref(name)})
+
+	// the function uses the variables that the methods use from outside the class
+	for _, fn := range append(methods, ctor) {
+	Undeclared:
+		for _, v := range fn.Body.Scope.Undeclared {
+			for _, u := range scope.Declared {
+				if resolveVar(v) == u {
+					continue Undeclared
+				}
+			}
+			for _, u := range scope.Undeclared {
+				if resolveVar(v) == resolveVar(u) {
+					continue Undeclared
+				}
+			}
+			scope.Undeclared = append(scope.Undeclared, v)
+		}
+	}
+	return groupExpr(&js.CallExpr{X: iife, Args: args}, js.OpPrimary)
+}
+
+// propertyNameValue returns the name of a non-computed property name, without quotes for strings.
+func propertyNameValue(name js.PropertyName) []byte {
+	if name.Literal.TokenType == js.StringToken {
+		return name.Literal.Data[1 : len(name.Literal.Data)-1]
+	}
+	return name.Literal.Data
+}
+
+// propertyNameString returns a non-computed property name as a string or numeric literal.
+func propertyNameString(name js.PropertyName) js.IExpr {
+	if name.Literal.TokenType == js.IdentifierToken || js.IsReservedWord(name.Literal.TokenType) {
+		return &js.LiteralExpr{TokenType: js.StringToken, Data: append(append([]byte{'"'}, name.Literal.Data...), '"')}
+	}
+	return &js.LiteralExpr{TokenType: name.Literal.TokenType, Data: name.Literal.Data}
+}
+
+// memberExpr returns the member of an object for a non-computed property name, such as a.b or a["b"].
+func memberExpr(object js.IExpr, name js.LiteralExpr) js.IExpr {
+	if name.TokenType == js.StringToken || name.TokenType == js.DecimalToken || js.IsNumeric(name.TokenType) {
+		return &js.IndexExpr{X: groupExpr(object, js.OpCall), Index: &js.LiteralExpr{TokenType: name.TokenType, Data: name.Data}, Prec: js.OpMember}
+	}
+	return dotExpr(object, string(name.Data))
+}
+
+// lowerTaggedTemplate lowers a tagged template to a call of its tag with the array of its strings, which has the array of its raw strings as property raw and is created once, such as f`a${b}` to f(_a||(_a=["a",""],_a.raw=["a",""],_a),b). The lowering is loose: the arrays are not frozen.
+func (l *lowerer) lowerTaggedTemplate(tmpl *js.TemplateExpr) js.IExpr {
+	cooked, raw := &js.ArrayExpr{}, &js.ArrayExpr{}
+	add := func(s []byte) {
+		var str js.IExpr = voidZeroExpr() // strings with invalid escapes are undefined
+		if validEscapes(s) {
+			str = &js.LiteralExpr{TokenType: js.StringToken, Data: templateString(s)}
+		}
+		cooked.List = append(cooked.List, js.Element{Value: str})
+		raw.List = append(raw.List, js.Element{Value: &js.LiteralExpr{TokenType: js.StringToken, Data: templateRawString(s)}})
+	}
+	args := []js.IExpr{nil}
+	for _, item := range tmpl.List {
+		add(item.Value[1 : len(item.Value)-2]) // remove ` or } and ${
+		args = append(args, item.Expr)
+	}
+	add(tmpl.Tail[1 : len(tmpl.Tail)-1]) // remove ` or } and `
+
+	top := &l.blocks[0].Scope
+	v := l.declareVar(top, "_templateObject", nil)
+	init := binaryExpr(js.CommaToken, binaryExpr(js.EqToken, l.use(v, top), cooked), binaryExpr(js.EqToken, dotExpr(l.use(v, top), "raw"), raw))
+	args[0] = binaryExpr(js.OrToken, l.use(v, top), binaryExpr(js.CommaToken, init, l.use(v, top)))
+	return callExpr(tmpl.Tag, args...)
+}
+
+// templateConcat lowers a template literal to the concatenation of strings, such as `a${b}` to "a"+b.
+func templateConcat(tmpl *js.TemplateExpr) js.IExpr {
+	var expr js.IExpr
+	for _, item := range tmpl.List {
+		raw := item.Value[1 : len(item.Value)-2] // remove ` or } and ${
+		if expr == nil {
+			expr = &js.LiteralExpr{TokenType: js.StringToken, Data: templateString(raw)}
+		} else if 0 < len(raw) {
+			expr = binaryExpr(js.AddToken, expr, &js.LiteralExpr{TokenType: js.StringToken, Data: templateString(raw)})
+		}
+		expr = binaryExpr(js.AddToken, expr, item.Expr)
+	}
+	raw := tmpl.Tail[1 : len(tmpl.Tail)-1] // remove ` or } and `
+	if expr == nil {
+		expr = &js.LiteralExpr{TokenType: js.StringToken, Data: templateString(raw)}
+	} else if 0 < len(raw) {
+		expr = binaryExpr(js.AddToken, expr, &js.LiteralExpr{TokenType: js.StringToken, Data: templateString(raw)})
+	}
+	return expr
+}
+
+// templateString returns a double-quoted string literal for the raw text of a template literal, escaping quotes and line terminators.
+func templateString(raw []byte) []byte {
+	b := make([]byte, 0, len(raw)+2)
+	b = append(b, '"')
+	for i := 0; i < len(raw); i++ {
+		switch c := raw[i]; c {
+		case '\\':
+			b = append(b, c)
+			if i+1 < len(raw) {
+				// keep escape sequences and line continuations
+				i++
+				b = append(b, raw[i])
+				if raw[i] == '\r' && i+1 < len(raw) && raw[i+1] == '\n' {
+					i++
+					b = append(b, '\n')
+				}
+			}
+		case '"':
+			b = append(b, '\\', '"')
+		case '\r':
+			if i+1 < len(raw) && raw[i+1] == '\n' {
+				i++
+			}
+			b = append(b, '\\', 'n')
+		case '\n':
+			b = append(b, '\\', 'n')
+		default:
+			if c == 0xE2 && i+2 < len(raw) && raw[i+1] == 0x80 && (raw[i+2] == 0xA8 || raw[i+2] == 0xA9) {
+				// line and paragraph separators are not allowed in ES5 strings
+				b = append(b, `\u202`...)
+				b = append(b, "89"[raw[i+2]-0xA8])
+				i += 2
+			} else {
+				b = append(b, c)
+			}
+		}
+	}
+	return lowerCodePointEscapes(append(b, '"'))
+}
+
+// templateRawString returns a double-quoted string literal whose value is the raw text of a template literal, as passed to the tags of tagged templates.
+func templateRawString(raw []byte) []byte {
+	b := make([]byte, 0, len(raw)+2)
+	b = append(b, '"')
+	for i := 0; i < len(raw); i++ {
+		switch c := raw[i]; c {
+		case '\\', '"':
+			b = append(b, '\\', c)
+		case '\r':
+			if i+1 < len(raw) && raw[i+1] == '\n' {
+				i++
+			}
+			b = append(b, '\\', 'n')
+		case '\n':
+			b = append(b, '\\', 'n')
+		default:
+			if c == 0xE2 && i+2 < len(raw) && raw[i+1] == 0x80 && (raw[i+2] == 0xA8 || raw[i+2] == 0xA9) {
+				b = append(b, `\u202`...)
+				b = append(b, "89"[raw[i+2]-0xA8])
+				i += 2
+			} else {
+				b = append(b, c)
+			}
+		}
+	}
+	return append(b, '"')
+}
+
+// validEscapes returns true if the escape sequences in the raw text of a template literal are valid. Tagged templates may contain invalid escape sequences, in which case their string is undefined.
+func validEscapes(raw []byte) bool {
+	isHex := func(s []byte) bool {
+		for _, c := range s {
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+				return false
+			}
+		}
+		return 0 < len(s)
+	}
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' || i+1 == len(raw) {
+			continue
+		}
+		i++
+		switch c := raw[i]; {
+		case c == 'x':
+			if len(raw) < i+3 || !isHex(raw[i+1:i+3]) {
+				return false
+			}
+		case c == 'u':
+			if i+1 < len(raw) && raw[i+1] == '{' {
+				end := bytes.IndexByte(raw[i+2:], '}')
+				if end == -1 || !isHex(raw[i+2:i+2+end]) {
+					return false
+				} else if r, err := strconv.ParseUint(string(raw[i+2:i+2+end]), 16, 32); err != nil || 0x10FFFF < r {
+					return false
+				}
+			} else if len(raw) < i+5 || !isHex(raw[i+1:i+5]) {
+				return false
+			}
+		case c == '0':
+			if i+1 < len(raw) && '0' <= raw[i+1] && raw[i+1] <= '9' {
+				return false
+			}
+		case '1' <= c && c <= '9':
+			return false
+		}
+	}
+	return true
+}
+
+// lowerCodePointEscapes replaces the \u{X} escapes of a string literal by \uXXXX escapes, using surrogate pairs for characters outside the BMP.
+func lowerCodePointEscapes(b []byte) []byte {
+	if !bytes.Contains(b, []byte(`\u{`)) {
+		return b
+	}
+	const hexDigits = "0123456789abcdef"
+	escape := func(dst []byte, r uint64) []byte {
+		return append(dst, '\\', 'u', hexDigits[r>>12&0xf], hexDigits[r>>8&0xf], hexDigits[r>>4&0xf], hexDigits[r&0xf])
+	}
+
+	esc := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] != '\\' {
+			esc = append(esc, b[i])
+			continue
+		} else if i+2 < len(b) && b[i+1] == 'u' && b[i+2] == '{' {
+			if end := bytes.IndexByte(b[i+3:], '}'); end != -1 {
+				if r, err := strconv.ParseUint(string(b[i+3:i+3+end]), 16, 32); err == nil && r <= 0x10FFFF {
+					if r <= 0xFFFF {
+						esc = escape(esc, r)
+					} else {
+						r -= 0x10000
+						esc = escape(esc, 0xD800+r>>10)
+						esc = escape(esc, 0xDC00+r&0x3FF)
+					}
+					i += 3 + end
+					continue
+				}
+			}
+		}
+		esc = append(esc, b[i])
+		if i+1 < len(b) {
+			i++
+			esc = append(esc, b[i]) // escaped character
+		}
+	}
+	return esc
+}
+
+// lowerRegExp lowers the dotAll flag s of a regular expression by replacing . outside of character classes by [^], and records an error for the flags that cannot be lowered, such as u and y.
+func (l *lowerer) lowerRegExp(expr *js.LiteralExpr) js.IExpr {
+	slash := bytes.LastIndexByte(expr.Data, '/')
+	flags := expr.Data[slash+1:]
+	for _, flag := range flags {
+		switch flag {
+		case 'u':
+			l.unsupported("regular expression flag u", ES2015)
+		case 'y':
+			l.unsupported("regular expression flag y", ES2015)
+		case 's':
+			if l.target < ES2018 {
+				return &js.LiteralExpr{TokenType: js.RegExpToken, Data: lowerDotAll(expr.Data[:slash+1], flags)}
+			}
+		case 'd':
+			l.unsupported("regular expression flag d", 2022)
+		}
+	}
+	return expr
+}
+
+// lowerDotAll returns the regular expression without the s flag, where . is replaced by [^] which matches any character including line terminators.
+func lowerDotAll(pattern, flags []byte) []byte {
+	b := make([]byte, 0, len(pattern)+len(flags)+8)
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			b = append(b, c, pattern[i+1])
+			i++
+		case c == '[':
+			inClass = true
+			b = append(b, c)
+		case c == ']':
+			inClass = false
+			b = append(b, c)
+		case c == '.' && !inClass:
+			b = append(b, "[^]"...)
+		default:
+			b = append(b, c)
+		}
+	}
+	for _, flag := range flags {
+		if flag != 's' {
+			b = append(b, flag)
+		}
+	}
+	return b
+}
+
+// spreadArgs returns the arguments array for apply of a call with spread arguments.
+func (l *lowerer) spreadArgs(list []js.IExpr, rest js.IExpr) js.IExpr {
+	if len(list) == 0 {
+		return l.spreadElements(rest, true)
+	}
+	elements := make([]js.Element, len(list))
+	for i, item := range list {
+		elements[i].Value = item
+	}
+	return callExpr(dotExpr(&js.ArrayExpr{List: elements}, "concat"), l.spreadElements(rest, false))
+}
+
+// spreadElements returns the array of the elements of a spread value, or the array-like object itself if allowed. Other values than array literals and arguments are converted to an array by a helper function, see toArrayHelper.
+func (l *lowerer) spreadElements(x js.IExpr, arrayLike bool) js.IExpr {
+	switch y := x.(type) {
+	case *js.ArrayExpr:
+		return l.lowerArray(y)
+	case *js.Var:
+		if bytes.Equal(y.Data, argumentsBytes) && resolveVar(y).Decl == js.NoDecl {
+			if arrayLike {
+				return y
+			}
+			return sliceExpr(y, 0)
+		}
+	}
+	return callExpr(l.helper(&l.toArray, "_toArray", toArrayHelper), x)
+}
+
+// helper returns a new use of the helper function *v, which is parsed from code and declared at the top level under the given name when it is first used.
+func (l *lowerer) helper(v **js.Var, name, code string) *js.Var {
+	if *v == nil {
+		helper, err := js.Parse(parse.NewInputString("(" + code + ")"))
+		if err != nil {
+			panic(err) // the helper is valid
+		}
+		fn := helper.List[0].(*js.ExprStmt).Value.(*js.GroupExpr).X.(*js.FuncDecl)
+		for _, u := range fn.Body.Scope.Undeclared {
+			l.global(u.Data)
+		}
+		l.funcs[&fn.Body.Scope] = true
+		*v = l.declareVar(&l.blocks[0].Scope, name, fn)
+	}
+	return l.use(*v, &l.blocks[0].Scope)
+}
+
+// toArrayHelper converts an iterable or array-like object to a new array, where iterables other than arrays, such as a Set or a string, are iterated when Symbol.iterator exists.
+const toArrayHelper = `function(a){if(typeof Symbol=="function"&&a!=null&&typeof a[Symbol.iterator]=="function"&&!Array.isArray(a)){for(var b=[],c=a[Symbol.iterator](),d;!(d=c.next()).done;)b.push(d.value);return b}return[].slice.call(a)}`
+
+// assignHelper copies the own enumerable properties of the objects that follow the first to the first, like Object.assign, skipping null and undefined.
+const assignHelper = `function(a){for(var b=1,c,d;b<arguments.length;b++)if((c=arguments[b])!=null)for(d in c)Object.prototype.hasOwnProperty.call(c,d)&&(a[d]=c[d]);return a}`
+
+// sliceExpr returns [].slice.call(x,n), which converts an array-like object to an array starting at index n.
+func sliceExpr(x js.IExpr, n int) js.IExpr {
+	args := []js.IExpr{x}
+	if n != 0 {
+		args = append(args, &js.LiteralExpr{TokenType: js.DecimalToken, Data: []byte(strconv.Itoa(n))})
+	}
+	return callExpr(dotExpr(dotExpr(&js.ArrayExpr{}, "slice"), "call"), args...)
+}
+
+func binaryExpr(op js.TokenType, x, y js.IExpr) *js.BinaryExpr {
+	return &js.BinaryExpr{Op: op, X: groupExpr(x, binaryLeftPrecMap[op]), Y: groupExpr(y, binaryRightPrecMap[op])}
+}
+
+func callExpr(x js.IExpr, args ...js.IExpr) *js.CallExpr {
+	for i := range args {
+		args[i] = groupExpr(args[i], js.OpAssign)
+	}
+	return &js.CallExpr{X: groupExpr(x, js.OpCall), Args: js.Arguments{List: args}}
+}
+
+func dotExpr(x js.IExpr, name string) *js.DotExpr {
+	x = groupExpr(x, js.OpCall)
+	prec := js.OpMember
+	if exprPrec(x) < js.OpMember {
+		prec = js.OpCall
+	}
+	return &js.DotExpr{X: x, Y: js.LiteralExpr{TokenType: js.IdentifierToken, Data: []byte(name)}, Prec: prec}
+}
+
+func thisExpr() *js.LiteralExpr {
+	return &js.LiteralExpr{TokenType: js.ThisToken, Data: []byte("this")}
+}
+
+func nullExpr() *js.LiteralExpr {
+	return &js.LiteralExpr{TokenType: js.NullToken, Data: nullBytes}
+}
+
+func voidZeroExpr() *js.UnaryExpr {
+	return &js.UnaryExpr{Op: js.VoidToken, X: &js.LiteralExpr{TokenType: js.DecimalToken, Data: zeroBytes}}
+}
@@ -1,12 +1,18 @@
 package js
 
 import (
+	"bytes"
+
 	"github.com/tdewolff/parse/v2/js"
 )
 
 func (m *jsMinifier) optimizeStmt(i js.IStmt) js.IStmt {
 	// convert if/else into expression statement, and optimize blocks
 	if ifStmt, ok := i.(*js.IfStmt); ok {
+		if list, ok := m.pruneIfStmt(ifStmt); ok {
+			return m.optimizeStmt(&js.BlockStmt{List: list})
+		}
+
 		hasIf := !m.isEmptyStmt(ifStmt.Body)
 		hasElse := !m.isEmptyStmt(ifStmt.Else)
 		if unaryExpr, ok := ifStmt.Cond.(*js.UnaryExpr); ok && unaryExpr.Op == js.NotToken && hasElse {
@@ -73,24 +79,29 @@ func (m *jsMinifier) optimizeStmt(i js.IStmt) js.IStmt {
 			}
 			return &js.EmptyStmt{}
 		}
-		// TODO: remove unused declarations
-		//for i := 0; i < len(decl.List); i++ {
-		//	if v, ok := decl.List[i].Binding.(*js.Var); ok && v.Uses < 2 {
-		//		decl.List = append(decl.List[:i], decl.List[i+1:]...)
-		//		i--
-		//	}
-		//}
-		//if len(decl.List) == 0 {
-		//	return &js.EmptyStmt{}
-		//}
+		if m.removeUnused && m.removeUnusedDecl(decl) == nil {
+			return &js.EmptyStmt{}
+		}
 		return decl
+	} else if m.removeUnused && m.removeUnusedDecl(i) == nil {
+		// unused function or class declaration
+		return &js.EmptyStmt{}
+	} else if _, ok := i.(*js.DebuggerStmt); ok && !m.o.KeepDebugger {
+		return &js.EmptyStmt{}
+	} else if exprStmt, ok := i.(*js.ExprStmt); ok && (0 < len(m.o.PureFuncs) || 0 < len(m.pureCalls)) {
+		// remove calls to pure functions whose result is unused
+		if exprStmt.Value = m.removePureCalls(exprStmt.Value); exprStmt.Value == nil {
+			return &js.EmptyStmt{}
+		}
+		return exprStmt
 	} else if blockStmt, ok := i.(*js.BlockStmt); ok {
 		// merge body and remove braces if it is not a lexical declaration
 		blockStmt.List = m.optimizeStmtList(blockStmt.List, defaultBlock)
 		if len(blockStmt.List) == 1 {
 			varDecl, isVarDecl := blockStmt.List[0].(*js.VarDecl)
 			_, isClassDecl := blockStmt.List[0].(*js.ClassDecl)
-			if !isClassDecl && (!isVarDecl || varDecl.TokenType == js.VarToken) {
+			_, isFuncDecl := blockStmt.List[0].(*js.FuncDecl)
+			if !isClassDecl && (!isVarDecl || varDecl.TokenType == js.VarToken) && (!isFuncDecl || !m.strict) {
 				return m.optimizeStmt(blockStmt.List[0])
 			}
 		} else if len(blockStmt.List) == 0 {
@@ -105,9 +116,31 @@ func (m *jsMinifier) optimizeStmtList(list []js.IStmt, blockType blockType) []js
 	// merge expression statements as well as if/else statements followed by flow control statements
 	if len(list) == 0 {
 		return list
+	} else if blockType == functionBlock {
+		list = optimizeModuleItems(list)
 	}
 	j := 0                           // write index
+	unreachable := false             // set after a return, throw, break or continue statement
+	hoisted := []js.IStmt{}          // declarations in unreachable code
+	lexical := []js.IStmt{}          // lexical declarations in unreachable code, which stay after the flow statement
 	for i := 0; i < len(list); i++ { // read index
+		if unreachable {
+			// remove unreachable code, but keep function declarations and variable declarations since they are hoisted
+			if _, ok := list[i].(*js.FuncDecl); ok {
+				hoisted = append(hoisted, list[i])
+			} else if decl := m.deadVarDecl(list[i]); decl != nil {
+				hoisted = append(hoisted, decl)
+			} else if decl := deadLexicalDecl(list[i]); decl != nil {
+				lexical = append(lexical, decl)
+			}
+			continue
+		} else if ifStmt, ok := list[i].(*js.IfStmt); ok {
+			if stmts, ok := m.pruneIfStmt(ifStmt); ok {
+				list = append(append(append(make([]js.IStmt, 0, len(list)+len(stmts)), list[:i]...), stmts...), list[i+1:]...)
+				i--
+				continue
+			}
+		}
 		list[i] = m.optimizeStmt(list[i])
 
 		if ifStmt, ok := list[i].(*js.IfStmt); ok && !m.isEmptyStmt(ifStmt.Else) && isFlowStmt(lastStmt(ifStmt.Body)) {
@@ -122,9 +155,9 @@ func (m *jsMinifier) optimizeStmtList(list []js.IStmt, blockType blockType) []js
 			continue
 		}
 
-		if 0 < i {
-			// merge expression statements with expression, return, and throw statements
-			if left, ok := list[i-1].(*js.ExprStmt); ok {
+		if 0 < j {
+			// merge expression statements with expression, return, and throw statements, but keep directives such as "use strict"
+			if left, ok := list[j-1].(*js.ExprStmt); ok && !isDirective(left) {
 				if right, ok := list[i].(*js.ExprStmt); ok {
 					right.Value = &js.BinaryExpr{js.CommaToken, left.Value, right.Value}
 					j--
@@ -158,7 +191,7 @@ func (m *jsMinifier) optimizeStmtList(list []js.IStmt, blockType blockType) []js
 					ifStmt.Cond = &js.BinaryExpr{js.CommaToken, left.Value, ifStmt.Cond}
 					j--
 				}
-			} else if left, ok := list[i-1].(*js.VarDecl); ok {
+			} else if left, ok := list[j-1].(*js.VarDecl); ok {
 				if right, ok := list[i].(*js.VarDecl); ok && left.TokenType == right.TokenType {
 					// merge const and let declarations
 					right.List = append(left.List, right.List...)
@@ -250,8 +283,19 @@ func (m *jsMinifier) optimizeStmtList(list []js.IStmt, blockType blockType) []js
 				}
 			}
 		}
+		unreachable = isFlowStmt(list[j])
 		j++
 	}
+	if 0 < len(hoisted) {
+		// put the declarations before the return, throw, break or continue statement
+		copy(list[j-1+len(hoisted):], list[j-1:j])
+		copy(list[j-1:], hoisted)
+		j += len(hoisted)
+	}
+	if 0 < len(lexical) {
+		list = append(list[:j], lexical...)
+		j += len(lexical)
+	}
 
 	// remove superfluous return or continue
 	if 0 < j {
@@ -272,3 +316,605 @@ func (m *jsMinifier) optimizeStmtList(list []js.IStmt, blockType blockType) []js
 	}
 	return list[:j]
 }
+
+// removePureCalls removes calls to pure functions from an expression whose result is unused, keeping arguments with side-effects. It returns nil if nothing remains.
+func (m *jsMinifier) removePureCalls(iexpr js.IExpr) js.IExpr {
+	switch expr := iexpr.(type) {
+	case *js.GroupExpr:
+		if expr.X = m.removePureCalls(expr.X); expr.X == nil {
+			return nil
+		}
+	case *js.BinaryExpr:
+		if expr.Op == js.CommaToken {
+			expr.X = m.removePureCalls(expr.X)
+			expr.Y = m.removePureCalls(expr.Y)
+			if expr.X == nil {
+				return expr.Y
+			} else if expr.Y == nil {
+				return expr.X
+			}
+		}
+	case *js.CallExpr, *js.NewExpr:
+		var arguments *js.Arguments
+		if call, ok := expr.(*js.CallExpr); ok && (m.pureCalls[call] || m.isPureFunc(call.X)) {
+			arguments = &call.Args
+		} else if newExpr, ok := expr.(*js.NewExpr); ok && m.pureCalls[newExpr] {
+			arguments = newExpr.Args
+			if arguments == nil {
+				return nil
+			}
+		} else {
+			break
+		}
+		var args js.IExpr
+		for _, arg := range arguments.List {
+			if m.hasSideEffects(arg) {
+				if args == nil {
+					args = groupExpr(arg, js.OpAssign)
+				} else {
+					args = &js.BinaryExpr{Op: js.CommaToken, X: args, Y: groupExpr(arg, js.OpAssign)}
+				}
+			}
+		}
+		if arguments.Rest != nil {
+			if args == nil {
+				args = &js.ArrayExpr{List: []js.Element{{Value: arguments.Rest, Spread: true}}}
+			} else {
+				args = &js.BinaryExpr{Op: js.CommaToken, X: args, Y: &js.ArrayExpr{List: []js.Element{{Value: arguments.Rest, Spread: true}}}}
+			}
+		}
+		return args
+	}
+	return iexpr
+}
+
+func (m *jsMinifier) isPureFunc(iexpr js.IExpr) bool {
+	name := memberName(iexpr)
+	if name == nil {
+		return false
+	}
+	for _, pureFunc := range m.o.PureFuncs {
+		if string(name) == pureFunc {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneIfStmt returns the statements of the branch that is taken when the condition is known and has no side-effects, including the variable declarations of the branch that is not taken. Branches that are not taken but declare functions are kept, as in sloppy mode the functions are also declared as variables of the function scope (Annex B).
+func (m *jsMinifier) pruneIfStmt(ifStmt *js.IfStmt) ([]js.IStmt, bool) {
+	truthy, ok := m.isTruthy(ifStmt.Cond)
+	if !ok || m.hasSideEffects(ifStmt.Cond) {
+		return nil, false
+	}
+	live, dead := ifStmt.Body, ifStmt.Else
+	if !truthy {
+		live, dead = dead, live
+	}
+	if 0 < len(funcDecls(dead)) {
+		return nil, false
+	}
+	list := []js.IStmt{}
+	if decl := m.deadVarDecl(dead); decl != nil {
+		list = append(list, decl)
+	}
+	if blockStmt, ok := live.(*js.BlockStmt); ok && !hasLexicalDecl(blockStmt.List) {
+		list = append(list, blockStmt.List...)
+	} else if live != nil {
+		list = append(list, live)
+	}
+	return list, true
+}
+
+func hasLexicalDecl(list []js.IStmt) bool {
+	for _, item := range list {
+		switch stmt := item.(type) {
+		case *js.VarDecl:
+			if stmt.TokenType != js.VarToken {
+				return true
+			}
+		case *js.FuncDecl, *js.ClassDecl:
+			return true
+		}
+	}
+	return false
+}
+
+// funcDecls returns the names of the functions declared by the statement, not counting nested functions.
+func funcDecls(istmt js.IStmt) []*js.Var {
+	names := []*js.Var{}
+	switch stmt := istmt.(type) {
+	case *js.FuncDecl:
+		if stmt.Name != nil {
+			names = append(names, stmt.Name)
+		}
+	case *js.BlockStmt:
+		for _, item := range stmt.List {
+			names = append(names, funcDecls(item)...)
+		}
+	case *js.IfStmt:
+		names = append(funcDecls(stmt.Body), funcDecls(stmt.Else)...)
+	case *js.LabelledStmt:
+		names = funcDecls(stmt.Value)
+	case *js.WithStmt:
+		names = funcDecls(stmt.Body)
+	case *js.DoWhileStmt:
+		names = funcDecls(stmt.Body)
+	case *js.WhileStmt:
+		names = funcDecls(stmt.Body)
+	case *js.ForStmt:
+		names = funcDecls(&stmt.Body)
+	case *js.ForInStmt:
+		names = funcDecls(&stmt.Body)
+	case *js.ForOfStmt:
+		names = funcDecls(&stmt.Body)
+	case *js.SwitchStmt:
+		for _, clause := range stmt.List {
+			for _, item := range clause.List {
+				names = append(names, funcDecls(item)...)
+			}
+		}
+	case *js.TryStmt:
+		names = funcDecls(&stmt.Body)
+		if stmt.Catch != nil {
+			names = append(names, funcDecls(stmt.Catch)...)
+		}
+		if stmt.Finally != nil {
+			names = append(names, funcDecls(stmt.Finally)...)
+		}
+	}
+	return names
+}
+
+// keepBlockFuncs keeps the names of the functions declared in blocks of the function body in strict mode code, since the parser declares them in the function scope as in sloppy mode (Annex B), while uses outside of the block refer to another variable.
+func (m *jsMinifier) keepBlockFuncs(body []js.IStmt) {
+	if !m.strict || !m.renamer.rename {
+		return
+	}
+	for _, item := range body {
+		if _, ok := item.(*js.FuncDecl); !ok {
+			for _, v := range funcDecls(item) {
+				m.renamer.keep[v] = true
+			}
+		}
+	}
+}
+
+// deadVarDecl returns a variable declaration without definitions for all variables declared with var in unreachable code, or nil if there are none. Variable declarations are hoisted to the function scope and thus must be kept.
+func (m *jsMinifier) deadVarDecl(stmt js.IStmt) *js.VarDecl {
+	if m.varsHoisted != nil {
+		// variables are already declared in the hoisted declaration
+		return nil
+	}
+	decl := &js.VarDecl{TokenType: js.VarToken}
+	addVarDecls(decl, stmt)
+	if len(decl.List) == 0 {
+		return nil
+	}
+	return decl
+}
+
+// deadLexicalDecl returns a let declaration without definitions for the variables declared with let, const or class in unreachable code that are referenced, or nil if there are none. Referencing them before their declaration throws a ReferenceError, so the declarations must be kept after the return, throw, break or continue statement.
+func deadLexicalDecl(istmt js.IStmt) *js.VarDecl {
+	decl := &js.VarDecl{TokenType: js.LetToken}
+	switch stmt := istmt.(type) {
+	case *js.VarDecl:
+		if stmt.TokenType != js.VarToken {
+			for _, item := range stmt.List {
+				for _, v := range bindingRefs(item.Binding) {
+					if 1 < v.Uses {
+						decl.List = append(decl.List, js.BindingElement{Binding: v})
+					}
+				}
+			}
+		}
+	case *js.ClassDecl:
+		if stmt.Name != nil && 1 < stmt.Name.Uses {
+			decl.List = append(decl.List, js.BindingElement{Binding: stmt.Name})
+		}
+	}
+	if len(decl.List) == 0 {
+		return nil
+	}
+	return decl
+}
+
+func addVarDecls(decl *js.VarDecl, istmt js.IStmt) {
+	switch stmt := istmt.(type) {
+	case *js.VarDecl:
+		if stmt.TokenType == js.VarToken {
+			for _, item := range stmt.List {
+				for _, v := range bindingRefs(item.Binding) {
+					decl.List = append(decl.List, js.BindingElement{Binding: v})
+				}
+			}
+		}
+	case *js.BlockStmt:
+		for _, item := range stmt.List {
+			addVarDecls(decl, item)
+		}
+	case *js.IfStmt:
+		addVarDecls(decl, stmt.Body)
+		addVarDecls(decl, stmt.Else)
+	case *js.LabelledStmt:
+		addVarDecls(decl, stmt.Value)
+	case *js.WithStmt:
+		addVarDecls(decl, stmt.Body)
+	case *js.DoWhileStmt:
+		addVarDecls(decl, stmt.Body)
+	case *js.WhileStmt:
+		addVarDecls(decl, stmt.Body)
+	case *js.ForStmt:
+		if varDecl, ok := stmt.Init.(*js.VarDecl); ok {
+			addVarDecls(decl, varDecl)
+		}
+		addVarDecls(decl, &stmt.Body)
+	case *js.ForInStmt:
+		if varDecl, ok := stmt.Init.(*js.VarDecl); ok {
+			addVarDecls(decl, varDecl)
+		}
+		addVarDecls(decl, &stmt.Body)
+	case *js.ForOfStmt:
+		if varDecl, ok := stmt.Init.(*js.VarDecl); ok {
+			addVarDecls(decl, varDecl)
+		}
+		addVarDecls(decl, &stmt.Body)
+	case *js.SwitchStmt:
+		for _, clause := range stmt.List {
+			for _, item := range clause.List {
+				addVarDecls(decl, item)
+			}
+		}
+	case *js.TryStmt:
+		addVarDecls(decl, &stmt.Body)
+		if stmt.Catch != nil {
+			addVarDecls(decl, stmt.Catch)
+		}
+		if stmt.Finally != nil {
+			addVarDecls(decl, stmt.Finally)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////
+
+// optimizeModuleItems merges the import declarations of the same module, merges the export clauses, and moves default exports of function and class declarations into the declaration. Only the module's statement list has import and export declarations.
+func optimizeModuleItems(list []js.IStmt) []js.IStmt {
+	j := 0
+	imports := map[string]*js.ImportStmt{}
+	reexports := map[string]*js.ExportStmt{}
+	var exports *js.ExportStmt // first export clause without module
+	isModule := false
+	for _, item := range list {
+		switch stmt := item.(type) {
+		case *js.ImportStmt:
+			isModule = true
+			stmt.List = shortenAliases(stmt.List)
+			if stmt.Default == nil && !isStarAlias(stmt.List) {
+				for k, alias := range stmt.List {
+					if bytes.Equal(alias.Name, defaultBytes) {
+						// import{default as a,b}from"x"  =>  import a,{b}from"x"
+						stmt.Default = alias.Binding
+						stmt.List = append(stmt.List[:k], stmt.List[k+1:]...)
+						break
+					}
+				}
+			}
+			module := string(stmt.Module[1 : len(stmt.Module)-1])
+			if first, ok := imports[module]; !ok {
+				imports[module] = stmt
+			} else if mergeImports(first, stmt) {
+				continue
+			}
+		case *js.ExportStmt:
+			isModule = true
+			if stmt.Decl == nil && !isStarAlias(stmt.List) {
+				stmt.List = shortenAliases(stmt.List)
+				if stmt.Module == nil {
+					if exports != nil {
+						exports.List = appendAliases(exports.List, stmt.List)
+						continue
+					}
+					exports = stmt
+				} else {
+					module := string(stmt.Module[1 : len(stmt.Module)-1])
+					if first, ok := reexports[module]; ok {
+						first.List = appendAliases(first.List, stmt.List)
+						continue
+					}
+					reexports[module] = stmt
+				}
+			}
+		}
+		list[j] = item
+		j++
+	}
+	list = list[:j]
+	if isModule {
+		list = moveDefaultExport(list, exports)
+	}
+	return list
+}
+
+// shortenAliases removes the empty alias of a trailing comma, and the names of aliases that equal their binding.
+func shortenAliases(list []js.Alias) []js.Alias {
+	aliases := list[:0]
+	for _, alias := range list {
+		if alias.Binding == nil {
+			continue
+		} else if bytes.Equal(alias.Name, alias.Binding) {
+			alias.Name = nil
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases
+}
+
+// appendAliases appends the aliases to the list of an import or export clause, skipping those that are in the list already.
+func appendAliases(list, aliases []js.Alias) []js.Alias {
+Next:
+	for _, alias := range aliases {
+		for _, item := range list {
+			if bytes.Equal(item.Name, alias.Name) && bytes.Equal(item.Binding, alias.Binding) {
+				continue Next
+			}
+		}
+		list = append(list, alias)
+	}
+	return list
+}
+
+// mergeImports merges an import declaration into an earlier one of the same module, and returns false if they cannot be combined, such as for two different default bindings or a namespace import and named imports. The module is evaluated at its first import, so moving the bindings to the earlier declaration keeps the order of evaluation.
+func mergeImports(first, stmt *js.ImportStmt) bool {
+	if first.Default != nil && stmt.Default != nil && !bytes.Equal(first.Default, stmt.Default) {
+		return false
+	} else if (isStarAlias(first.List) || isStarAlias(stmt.List)) && len(first.List) != 0 && len(stmt.List) != 0 {
+		return false
+	}
+	if first.Default == nil {
+		first.Default = stmt.Default
+	}
+	first.List = appendAliases(first.List, stmt.List)
+	return true
+}
+
+// moveDefaultExport moves the default export of a function or class declaration into the declaration, as in function f(){}export{f as default}  =>  export default function f(){}. The default export of a variable, as in export default f, is only moved when the variable is not used otherwise, since it exports the value instead of the binding.
+func moveDefaultExport(list []js.IStmt, exports *js.ExportStmt) []js.IStmt {
+	var name []byte
+	iExport, iAlias := -1, -1 // index of the export default statement or of the alias in the export clause
+	for i, item := range list {
+		if exportStmt, ok := item.(*js.ExportStmt); ok && exportStmt.Default {
+			if v, ok := exportStmt.Decl.(*js.Var); ok && v.Uses == 2 {
+				name, iExport = v.Data, i
+			}
+			break
+		}
+	}
+	if name == nil && exports != nil {
+		for i, alias := range exports.List {
+			if bytes.Equal(alias.Binding, defaultBytes) && alias.Name != nil {
+				if 1 < len(exports.List) && len(alias.Name) < 4 {
+					return list // removing ,f as default is longer than adding export default
+				}
+				name, iAlias = alias.Name, i
+				break
+			}
+		}
+	}
+	if name == nil {
+		return list
+	}
+
+	isExported := false // the name is exported by the export clause as well
+	if exports != nil {
+		for i, alias := range exports.List {
+			if i != iAlias && (alias.Name == nil && bytes.Equal(alias.Binding, name) || bytes.Equal(alias.Name, name)) {
+				isExported = true
+			}
+		}
+	}
+	for i, item := range list {
+		var v *js.Var
+		switch decl := item.(type) {
+		case *js.FuncDecl:
+			v = decl.Name
+		case *js.ClassDecl:
+			if iExport != -1 && iExport < i {
+				return list // the class is not initialized yet at export default
+			}
+			v = decl.Name
+		}
+		if v == nil || !bytes.Equal(v.Data, name) {
+			continue
+		}
+
+		list[i] = &js.ExportStmt{Default: true, Decl: item.(js.IExpr)}
+		if iExport != -1 {
+			list = append(list[:iExport], list[iExport+1:]...)
+			v.Uses--
+		} else if len(exports.List) == 1 {
+			for k, item := range list {
+				if item == exports {
+					list = append(list[:k], list[k+1:]...)
+					break
+				}
+			}
+		} else {
+			exports.List = append(exports.List[:iAlias], exports.List[iAlias+1:]...)
+		}
+		if isExported && v.Uses < 2 {
+			v.Uses = 2 // keep the name of the function expression for the export clause
+		}
+		return list
+	}
+	return list
+}
+
+// shortenNamespaceImports replaces namespace imports whose namespace is only used for a few members by named imports when shorter, as in import*as ns from"x";f(ns.a)  =>  import{a}from"x";f(a). This is only done for modules with known exports, since importing a name that is not exported is a SyntaxError while ns.a is undefined, and when the members are not called, since ns.a() is called with the namespace as this.
+func shortenNamespaceImports(ast *js.AST, moduleExports map[string][]string) {
+	if hasEval(ast) {
+		return
+	}
+
+	// collect all variable names in use, so that the imported members do not conflict with them
+	names := map[string]bool{}
+	scopes := []*js.Scope{}
+	hasWith := false
+	w := &walker{
+		enterBlock: func(block *js.BlockStmt) {
+			scope := &block.Scope
+			scopes = append(scopes, scope)
+			hasWith = hasWith || scope.HasWith
+			for _, v := range scope.Declared {
+				names[string(v.Data)] = true
+			}
+			for _, v := range scope.Undeclared {
+				names[string(v.Data)] = true
+			}
+		},
+	}
+	w.walkAST(ast)
+	if hasWith {
+		return // members may resolve to properties of the with object
+	}
+	exported := exportedNames(ast)
+	for name := range exported {
+		names[name] = true
+	}
+	for _, item := range ast.List {
+		if importStmt, ok := item.(*js.ImportStmt); ok {
+			if importStmt.Default != nil {
+				names[string(importStmt.Default)] = true
+			}
+			for _, alias := range importStmt.List {
+				names[string(alias.Binding)] = true
+			}
+		}
+	}
+
+	for _, item := range ast.List {
+		importStmt, ok := item.(*js.ImportStmt)
+		if !ok || !isStarAlias(importStmt.List) {
+			continue
+		}
+		moduleNames, ok := moduleExports[string(importStmt.Module[1:len(importStmt.Module)-1])]
+		if !ok {
+			continue // unknown exports
+		}
+		exports := map[string]bool{}
+		for _, name := range moduleNames {
+			exports[name] = true
+		}
+		ns := importStmt.List[0].Binding
+		var nsVar *js.Var
+		for _, v := range ast.Undeclared {
+			if bytes.Equal(v.Data, ns) {
+				nsVar = v
+				break
+			}
+		}
+		if nsVar == nil || exported[string(ns)] {
+			continue // unused or exported namespace
+		}
+
+		// the namespace must only be used for non-computed member expressions
+		uses := 0
+		members := []js.Alias{}
+		vars := map[string]*js.Var{}
+		valid := true
+		w := &walker{
+			expr: func(iexpr js.IExpr) js.IExpr {
+				switch expr := iexpr.(type) {
+				case *js.Var:
+					if resolveVar(expr) == nsVar {
+						uses++
+					}
+				case *js.UnaryExpr:
+					if dotExpr, ok := expr.X.(*js.DotExpr); ok && expr.Op == js.DeleteToken && isVarOf(dotExpr.X, nsVar) {
+						valid = false
+					}
+				case *js.CallExpr:
+					if dotExpr, ok := expr.X.(*js.DotExpr); ok && isVarOf(dotExpr.X, nsVar) {
+						valid = false // called with the namespace as this
+					}
+				case *js.OptChainExpr:
+					if dotExpr, ok := expr.X.(*js.DotExpr); ok && isVarOf(dotExpr.X, nsVar) {
+						if _, ok := expr.Y.(*js.CallExpr); ok {
+							valid = false
+						}
+					}
+				case *js.TemplateExpr:
+					if dotExpr, ok := expr.Tag.(*js.DotExpr); ok && isVarOf(dotExpr.X, nsVar) {
+						valid = false
+					}
+				case *js.DotExpr:
+					if isVarOf(expr.X, nsVar) {
+						name := expr.Y.Data
+						if _, ok := vars[string(name)]; !ok {
+							if _, ok := js.Keywords[string(name)]; ok || names[string(name)] || bytes.Equal(name, evalBytes) || bytes.Equal(name, argumentsBytes) || !exports[string(name)] {
+								valid = false
+							}
+							vars[string(name)] = &js.Var{Data: name, Decl: js.NoDecl}
+							members = append(members, js.Alias{Binding: name})
+						}
+						vars[string(name)].Uses++
+					}
+				}
+				return iexpr
+			},
+		}
+		w.walkAST(ast)
+		if !valid || uses != int(nsVar.Uses) {
+			continue
+		}
+		// every member expression drops ns. while the import clause changes from *as ns to {a,b}
+		n := 0 // number of member expressions
+		length := 1 + len(members)
+		for _, member := range members {
+			n += int(vars[string(member.Binding)].Uses)
+			length += len(member.Binding)
+		}
+		if n != uses || n*(len(ns)+1) <= length-len("*as  ")-len(ns) {
+			continue
+		}
+
+		// replace the member expressions and reserve the imported names in all scopes that use the namespace
+		w.expr = func(iexpr js.IExpr) js.IExpr {
+			if dotExpr, ok := iexpr.(*js.DotExpr); ok && isVarOf(dotExpr.X, nsVar) {
+				return vars[string(dotExpr.Y.Data)]
+			}
+			return iexpr
+		}
+		w.walkAST(ast)
+		for _, scope := range scopes {
+			for _, v := range scope.Undeclared {
+				if resolveVar(v) == nsVar {
+					for _, member := range members {
+						scope.Undeclared = append(scope.Undeclared, vars[string(member.Binding)])
+					}
+					break
+				}
+			}
+		}
+		for _, member := range members {
+			names[string(member.Binding)] = true
+		}
+		importStmt.List = members
+	}
+}
+
+// resolveVar returns the variable that a variable links to.
+func resolveVar(v *js.Var) *js.Var {
+	for v.Link != nil {
+		v = v.Link
+	}
+	return v
+}
+
+// isVarOf returns true if the expression is a use of the variable.
+func isVarOf(iexpr js.IExpr, v *js.Var) bool {
+	if w, ok := iexpr.(*js.Var); ok {
+		return resolveVar(w) == v
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package js
+
+import (
+	"bytes"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/js"
+)
+
+var (
+	pureAnnotations = [][]byte{[]byte("/*#__PURE__*/"), []byte("/*@__PURE__*/")}
+	pureMarker      = []byte("!~!~!~!~!~!~!") // unary operators of the same length as the annotation
+)
+
+// markPureAnnotations returns a copy of the source where /*#__PURE__*/ annotations in front of an expression are replaced by a marker of unary operators, so that the annotated calls can be found in the AST. It returns nil if there are no annotations.
+func markPureAnnotations(src []byte) []byte {
+	if !bytes.Contains(src, []byte("__PURE__")) {
+		return nil
+	}
+
+	var marked []byte
+	l := js.NewLexer(parse.NewInputBytes(src))
+	pos := 0
+	prev := js.ErrorToken // previous significant token
+	annotation := -1      // position of the annotation in front of the current token
+	for {
+		tt, data := l.Next()
+		if tt == js.ErrorToken {
+			break
+		} else if (tt == js.DivToken || tt == js.DivEqToken) && regExpAllowed(prev) {
+			if tt, data = l.RegExp(); tt == js.ErrorToken {
+				break
+			}
+		}
+		start := pos
+		pos += len(data)
+		if tt == js.CommentToken {
+			for _, pure := range pureAnnotations {
+				if bytes.Equal(data, pure) && regExpAllowed(prev) {
+					annotation = start
+				}
+			}
+			continue
+		} else if tt == js.WhitespaceToken || tt == js.LineTerminatorToken || tt == js.CommentLineTerminatorToken {
+			continue
+		}
+
+		if annotation != -1 && (tt == js.IdentifierToken || tt == js.NewToken || tt == js.OpenParenToken) {
+			if marked == nil {
+				marked = parse.Copy(src)
+			}
+			copy(marked[annotation:], pureMarker)
+		}
+		annotation = -1
+		prev = tt
+	}
+	return marked
+}
+
+// regExpAllowed returns true if an expression, such as a regular expression, can start after the token.
+func regExpAllowed(tt js.TokenType) bool {
+	switch tt {
+	case js.CloseParenToken, js.CloseBracketToken, js.CloseBraceToken, js.StringToken, js.RegExpToken, js.TemplateToken, js.TemplateEndToken, js.ThisToken, js.SuperToken, js.TrueToken, js.FalseToken, js.NullToken, js.IncrToken, js.DecrToken:
+		return false
+	}
+	return !js.IsNumeric(tt) && !js.IsIdentifier(tt)
+}
+
+// unwrapPureAnnotations removes the markers of /*#__PURE__*/ annotations and records the annotated call and new expressions.
+func (m *jsMinifier) unwrapPureAnnotations(ast *js.AST) {
+	w := &walker{
+		expr: func(iexpr js.IExpr) js.IExpr {
+			expr := iexpr
+			for _, c := range pureMarker {
+				unary, ok := expr.(*js.UnaryExpr)
+				if !ok || c == '!' && unary.Op != js.NotToken || c == '~' && unary.Op != js.BitNotToken {
+					return iexpr
+				}
+				expr = unary.X
+			}
+			switch expr.(type) {
+			case *js.CallExpr, *js.NewExpr:
+				m.pureCalls[expr] = true
+			}
+			return expr
+		},
+	}
+	w.walkAST(ast)
+}
+
+// isModule returns true if the AST has import or export statements.
+func isModule(ast *js.AST) bool {
+	for _, item := range ast.List {
+		switch item.(type) {
+		case *js.ImportStmt, *js.ExportStmt:
+			return true
+		}
+	}
+	return false
+}
+
+// exportedNames returns the local names exported by export clauses, which are not counted as uses of the variables.
+func exportedNames(ast *js.AST) map[string]bool {
+	names := map[string]bool{}
+	for _, item := range ast.List {
+		if exportStmt, ok := item.(*js.ExportStmt); ok && exportStmt.Decl == nil && exportStmt.Module == nil {
+			for _, alias := range exportStmt.List {
+				if alias.Name != nil {
+					names[string(alias.Name)] = true
+				} else if alias.Binding != nil {
+					names[string(alias.Binding)] = true
+				}
+			}
+		}
+	}
+	return names
+}
+
+// hasEval returns true if the global eval function is used, which can access any variable in scope.
+func hasEval(ast *js.AST) bool {
+	for _, v := range ast.Undeclared {
+		if bytes.Equal(v.Data, evalBytes) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnusedVar returns true if the variable is used only by its declaration.
+func (m *jsMinifier) isUnusedVar(v *js.Var) bool {
+	return v != nil && v.Uses < 2 && !m.exported[string(v.Data)]
+}
+
+// removeUnusedDecl removes the unused function and class declarations and the unused variables of a declaration, when their initializers have no side-effects. It returns nil when the statement is removed entirely.
+func (m *jsMinifier) removeUnusedDecl(istmt js.IStmt) js.IStmt {
+	switch stmt := istmt.(type) {
+	case *js.FuncDecl:
+		if m.isUnusedVar(stmt.Name) {
+			return nil
+		}
+	case *js.ClassDecl:
+		if m.isUnusedVar(stmt.Name) && (stmt.Extends == nil || !m.hasSideEffects(stmt.Extends)) {
+			for _, method := range stmt.Methods {
+				if method.Name.IsComputed() {
+					return stmt
+				}
+			}
+			return nil
+		}
+	case *js.VarDecl:
+		if stmt.TokenType == js.VarToken && m.varsHoisted != nil {
+			// hoisted variables may be declared more than once
+			return stmt
+		}
+		list := stmt.List[:0]
+		for _, item := range stmt.List {
+			if v, ok := item.Binding.(*js.Var); !ok || !m.isUnusedVar(v) || item.Default != nil && m.hasSideEffects(item.Default) {
+				list = append(list, item)
+			}
+		}
+		if stmt.List = list; len(list) == 0 {
+			return nil
+		}
+	}
+	return istmt
+}
@@ -3,8 +3,10 @@ package js
 import (
 	"bytes"
 	"encoding/hex"
+	"unicode/utf8"
 
 	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/parse/v2"
 	"github.com/tdewolff/parse/v2/js"
 	"github.com/tdewolff/parse/v2/strconv"
 )
@@ -12,6 +14,9 @@ import (
 var (
 	spaceBytes                 = []byte(" ")
 	newlineBytes               = []byte("\n")
+	indentBytes                = []byte("  ")
+	noSpaceBefore              = []byte(";,.:)]}") // tokens that follow keywords without a space in beautify mode
+	quoteBytes                 = []byte("\"'`}/")  // first bytes of strings, template literals, and regular expressions
 	starBytes                  = []byte("*")
 	colonBytes                 = []byte(":")
 	semicolonBytes             = []byte(";")
@@ -55,6 +60,7 @@ var (
 	ofBytes                    = []byte("of")
 	switchOpenBytes            = []byte("switch(")
 	throwBytes                 = []byte("throw")
+	debuggerBytes              = []byte("debugger")
 	tryBytes                   = []byte("try")
 	catchBytes                 = []byte("catch")
 	finallyBytes               = []byte("finally")
@@ -77,6 +83,9 @@ var (
 	nanBytes                   = []byte("NaN")
 	undefinedBytes             = []byte("undefined")
 	infinityBytes              = []byte("Infinity")
+	evalBytes                  = []byte("eval")
+	argumentsBytes             = []byte("arguments")
+	defaultBytes               = []byte("default")
 	voidZeroBytes              = []byte("void 0")
 	groupedVoidZeroBytes       = []byte("(void 0)")
 	oneDivZeroBytes            = []byte("1/0")
@@ -296,6 +305,8 @@ func (m *jsMinifier) isEmptyStmt(stmt js.IStmt) bool {
 		return true
 	} else if _, ok := stmt.(*js.EmptyStmt); ok {
 		return true
+	} else if _, ok := stmt.(*js.DebuggerStmt); ok && !m.o.KeepDebugger {
+		return true
 	} else if decl, ok := stmt.(*js.VarDecl); ok && m.varsHoisted != nil && decl != m.varsHoisted {
 		for _, item := range decl.List {
 			if item.Default != nil {
@@ -314,6 +325,30 @@ func (m *jsMinifier) isEmptyStmt(stmt js.IStmt) bool {
 	return false
 }
 
+// isStarAlias returns true for the namespace import or export of * or * as name, which is written without braces.
+func isStarAlias(list []js.Alias) bool {
+	return len(list) == 1 && (bytes.Equal(list[0].Name, starBytes) || bytes.Equal(list[0].Binding, starBytes))
+}
+
+// isDirective returns true for a string literal expression statement, such as "use strict".
+func isDirective(stmt *js.ExprStmt) bool {
+	lit, ok := stmt.Value.(*js.LiteralExpr)
+	return ok && lit.TokenType == js.StringToken
+}
+
+// isStrict returns true if the directives of the statement list of a script or function body include "use strict".
+func isStrict(list []js.IStmt) bool {
+	for _, item := range list {
+		exprStmt, ok := item.(*js.ExprStmt)
+		if !ok || !isDirective(exprStmt) {
+			break
+		} else if data := exprStmt.Value.(*js.LiteralExpr).Data; string(data[1:len(data)-1]) == "use strict" {
+			return true
+		}
+	}
+	return false
+}
+
 func isFlowStmt(stmt js.IStmt) bool {
 	if _, ok := stmt.(*js.ReturnStmt); ok {
 		return true
@@ -353,6 +388,9 @@ func (m *jsMinifier) isFalse(i js.IExpr) bool {
 }
 
 func (m *jsMinifier) toNullishExpr(condExpr *js.CondExpr) (js.IExpr, js.IExpr, bool) {
+	if !m.o.Target.supports(ES2020) {
+		return nil, nil, false
+	}
 	// convert conditional expression to nullish:  a!=null?a:b  =>  a??b
 	if binaryExpr, ok := condExpr.Cond.(*js.BinaryExpr); ok && (binaryExpr.Op == js.EqEqToken || binaryExpr.Op == js.NotEqToken) {
 		var left, right js.IExpr
@@ -372,9 +410,104 @@ func (m *jsMinifier) toNullishExpr(condExpr *js.CondExpr) (js.IExpr, js.IExpr, b
 	return nil, nil, false
 }
 
+func (m *jsMinifier) toOptChainExpr(condExpr *js.CondExpr) (js.IExpr, bool) {
+	// convert conditional expression to optional chain:  a==null?void 0:a.b  =>  a?.b
+	if !m.o.Target.supports(ES2020) {
+		return nil, false
+	}
+	if binaryExpr, ok := condExpr.Cond.(*js.BinaryExpr); ok && (binaryExpr.Op == js.EqEqToken || binaryExpr.Op == js.NotEqToken) {
+		undefined, chain := condExpr.X, condExpr.Y
+		if binaryExpr.Op == js.NotEqToken {
+			undefined, chain = chain, undefined
+		}
+		if unary, ok := undefined.(*js.UnaryExpr); !ok || unary.Op != js.VoidToken {
+			return nil, false
+		}
+
+		v, ok := binaryExpr.X.(*js.Var)
+		null := binaryExpr.Y
+		if !ok {
+			v, ok = binaryExpr.Y.(*js.Var)
+			null = binaryExpr.X
+		}
+		if lit, isLit := null.(*js.LiteralExpr); ok && (isLit && lit.TokenType == js.NullToken || m.isUndefined(null)) {
+			if chain = m.optChainOn(chain, v); chain != nil {
+				return chain, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// optChainOn returns the member and call chain with an optional chain on its innermost variable v, or nil if the chain does not start with v.
+func (m *jsMinifier) optChainOn(i js.IExpr, v *js.Var) js.IExpr {
+	switch expr := i.(type) {
+	case *js.DotExpr:
+		if m.isEqualExpr(expr.X, v) {
+			return &js.OptChainExpr{X: expr.X, Y: &js.LiteralExpr{TokenType: expr.Y.TokenType, Data: expr.Y.Data}}
+		} else if x := m.optChainOn(expr.X, v); x != nil {
+			return &js.DotExpr{X: x, Y: expr.Y, Prec: js.OpCall}
+		}
+	case *js.IndexExpr:
+		if m.isEqualExpr(expr.X, v) {
+			return &js.OptChainExpr{X: expr.X, Y: &js.IndexExpr{Index: expr.Index, Prec: js.OpCall}}
+		} else if x := m.optChainOn(expr.X, v); x != nil {
+			return &js.IndexExpr{X: x, Index: expr.Index, Prec: js.OpCall}
+		}
+	case *js.CallExpr:
+		if m.isEqualExpr(expr.X, v) {
+			return &js.OptChainExpr{X: expr.X, Y: &js.CallExpr{Args: expr.Args}}
+		} else if x := m.optChainOn(expr.X, v); x != nil {
+			return &js.CallExpr{X: x, Args: expr.Args}
+		}
+	}
+	return nil
+}
+
+// isArrowMethod returns true if an object literal method can be written as a shorter arrow function, which requires a single return statement (after expression statements) and no use of this, super, arguments, or new.target.
+func isArrowMethod(method *js.MethodDecl) bool {
+	if method.Get || method.Set || method.Generator || len(method.Body.List) == 0 {
+		return false
+	} else if !method.Name.IsComputed() && bytes.Equal(propertyNameValue(method.Name), []byte("__proto__")) {
+		return false // __proto__: sets the prototype
+	}
+	for i, item := range method.Body.List {
+		if i == len(method.Body.List)-1 {
+			if returnStmt, ok := item.(*js.ReturnStmt); !ok || returnStmt.Value == nil {
+				return false
+			}
+		} else if _, ok := item.(*js.ExprStmt); !ok {
+			return false
+		}
+	}
+
+	bound := false
+	w := &walker{
+		expr: func(iexpr js.IExpr) js.IExpr {
+			switch expr := iexpr.(type) {
+			case *js.LiteralExpr:
+				bound = bound || expr.TokenType == js.ThisToken || expr.TokenType == js.SuperToken
+			case *js.Var:
+				bound = bound || bytes.Equal(expr.Data, argumentsBytes)
+			case *js.NewTargetExpr:
+				bound = true
+			}
+			return iexpr
+		},
+	}
+	w.walkMethodDecl(method)
+	return !bound
+}
+
+// isGlobalVar returns true if the variable is the undeclared global of the given name, which is not shadowed by a declaration such as a parameter.
+func isGlobalVar(v *js.Var, name []byte) bool {
+	v = resolveVar(v)
+	return v.Decl == js.NoDecl && bytes.Equal(v.Data, name)
+}
+
 func (m *jsMinifier) isUndefined(i js.IExpr) bool {
 	if v, ok := i.(*js.Var); ok {
-		if bytes.Equal(v.Name(), undefinedBytes) { // TODO: only if not defined
+		if isGlobalVar(v, undefinedBytes) {
 			return true
 		}
 	} else if unary, ok := i.(*js.UnaryExpr); ok && unary.Op == js.VoidToken {
@@ -409,7 +542,7 @@ func (m *jsMinifier) isFalsy(i js.IExpr) (bool, bool) {
 	if lit, ok := i.(*js.LiteralExpr); ok {
 		tt := lit.TokenType
 		d := lit.Data
-		if tt == js.FalseToken || tt == js.NullToken || tt == js.StringToken && len(lit.Data) == 0 {
+		if tt == js.FalseToken || tt == js.NullToken || tt == js.StringToken && len(lit.Data) == 2 {
 			return !negated, true // falsy
 		} else if tt == js.TrueToken || tt == js.StringToken {
 			return negated, true // truthy
@@ -425,12 +558,148 @@ func (m *jsMinifier) isFalsy(i js.IExpr) (bool, bool) {
 		}
 	} else if m.isUndefined(i) {
 		return !negated, true // falsy
-	} else if v, ok := i.(*js.Var); ok && bytes.Equal(v.Name(), nanBytes) {
+	} else if v, ok := i.(*js.Var); ok && isGlobalVar(v, nanBytes) {
 		return !negated, true // falsy
 	}
 	return false, false // unknown
 }
 
+// hasSideEffects returns true when evaluating the expression may have side-effects, it assumes that conversions to primitives (such as valueOf and toString) have none.
+func (m *jsMinifier) hasSideEffects(i js.IExpr) bool {
+	switch expr := i.(type) {
+	case *js.Var:
+		// reading an undeclared variable throws a ReferenceError
+		return expr.Decl == js.NoDecl && !bytes.Equal(expr.Data, undefinedBytes) && !bytes.Equal(expr.Data, nanBytes) && !bytes.Equal(expr.Data, infinityBytes)
+	case *js.LiteralExpr:
+		return false
+	case *js.GroupExpr:
+		return m.hasSideEffects(expr.X)
+	case *js.UnaryExpr:
+		if expr.Op == js.TypeofToken {
+			if _, ok := expr.X.(*js.Var); ok {
+				return false
+			}
+		} else if expr.Op == js.DeleteToken || expr.Op == js.AwaitToken || unaryOpPrecMap[expr.Op] == js.OpUpdate {
+			return true
+		}
+		return m.hasSideEffects(expr.X)
+	case *js.BinaryExpr:
+		if binaryOpPrecMap[expr.Op] == js.OpAssign || expr.Op == js.InToken || expr.Op == js.InstanceofToken {
+			return true
+		}
+		return m.hasSideEffects(expr.X) || m.hasSideEffects(expr.Y)
+	case *js.CondExpr:
+		return m.hasSideEffects(expr.Cond) || m.hasSideEffects(expr.X) || m.hasSideEffects(expr.Y)
+	case *js.ArrayExpr:
+		for _, item := range expr.List {
+			if item.Spread || item.Value != nil && m.hasSideEffects(item.Value) {
+				return true
+			}
+		}
+		return false
+	case *js.ObjectExpr:
+		for _, item := range expr.List {
+			if item.Spread || item.Name != nil && item.Name.IsComputed() || m.hasSideEffects(item.Value) {
+				return true
+			}
+		}
+		return false
+	case *js.TemplateExpr:
+		if expr.Tag != nil {
+			return true
+		}
+		for _, item := range expr.List {
+			if m.hasSideEffects(item.Expr) {
+				return true
+			}
+		}
+		return false
+	case *js.FuncDecl, *js.ArrowFunc, *js.MethodDecl:
+		return false
+	case *js.CallExpr:
+		// calls annotated by /*#__PURE__*/ are without side-effects, but not their arguments
+		return !m.pureCalls[expr] || m.hasSideEffectsArgs(&expr.Args)
+	case *js.NewExpr:
+		return !m.pureCalls[expr] || expr.Args != nil && m.hasSideEffectsArgs(expr.Args)
+	}
+	return true
+}
+
+func (m *jsMinifier) hasSideEffectsArgs(args *js.Arguments) bool {
+	if args.Rest != nil {
+		return true
+	}
+	for _, arg := range args.List {
+		if m.hasSideEffects(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// regExpOffsets returns the offsets in the input of the regular expressions of the parsed AST, so that the input can be lexed without guessing whether a slash starts a regular expression.
+func regExpOffsets(src []byte, ast *js.AST) map[int]bool {
+	regExps := map[int]bool{}
+	w := &walker{
+		expr: func(iexpr js.IExpr) js.IExpr {
+			if expr, ok := iexpr.(*js.LiteralExpr); ok && expr.TokenType == js.RegExpToken {
+				if offset, ok := minify.SliceOffset(src, expr.Data); ok {
+					regExps[offset] = true
+				}
+			}
+			return iexpr
+		},
+	}
+	w.walkAST(ast)
+	return regExps
+}
+
+// sourceComments returns the comments of the source that are not in the leading comments of the AST, since the parser does not keep them. A slash is read as a regular expression only if the parser found one at its position.
+func sourceComments(src []byte, ast *js.AST) [][]byte {
+	skip := map[int]bool{}
+	for _, comment := range ast.Comments {
+		if offset, ok := minify.SliceOffset(src, comment); ok {
+			skip[offset] = true
+		}
+	}
+	regExps := regExpOffsets(src, ast)
+	comments := [][]byte{}
+	l := js.NewLexer(parse.NewInputBytes(src))
+	pos := 0
+	for {
+		tt, data := l.Next()
+		if tt == js.ErrorToken {
+			break
+		} else if (tt == js.DivToken || tt == js.DivEqToken) && regExps[pos] {
+			if tt, data = l.RegExp(); tt == js.ErrorToken {
+				break
+			}
+		}
+		if (tt == js.CommentToken || tt == js.CommentLineTerminatorToken) && !skip[pos] {
+			comments = append(comments, data)
+		}
+		pos += len(data)
+	}
+	return comments
+}
+
+// memberName returns the dotted name of an undeclared (global) variable or a member expression on it, such as console.log, or nil otherwise.
+func memberName(iexpr js.IExpr) []byte {
+	switch expr := iexpr.(type) {
+	case *js.Var:
+		if v := resolveVar(expr); v.Decl == js.NoDecl {
+			return v.Data
+		}
+	case *js.DotExpr:
+		if name := memberName(expr.X); name != nil {
+			return append(append(append([]byte{}, name...), '.'), expr.Y.Data...)
+		}
+	case *js.GroupExpr:
+		return memberName(expr.X)
+	}
+	return nil
+}
+
 func (m *jsMinifier) isEqualExpr(a, b js.IExpr) bool {
 	if group, ok := a.(*js.GroupExpr); ok {
 		a = group.X
@@ -518,6 +787,129 @@ func isHexDigit(b byte) bool {
 	return '0' <= b && b <= '9' || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F'
 }
 
+// unescapeString returns the value of the contents of a string literal or of an identifier name by resolving its escape sequences, or false if an escape sequence is invalid or encodes a lone surrogate.
+func unescapeString(b []byte) ([]byte, bool) {
+	if bytes.IndexByte(b, '\\') == -1 {
+		return b, true
+	}
+	s := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] != '\\' {
+			s = append(s, b[i])
+			continue
+		} else if i+1 == len(b) {
+			return nil, false
+		}
+		i++
+		switch c := b[i]; c {
+		case 'b':
+			s = append(s, '\b')
+		case 'f':
+			s = append(s, '\f')
+		case 'n':
+			s = append(s, '\n')
+		case 'r':
+			s = append(s, '\r')
+		case 't':
+			s = append(s, '\t')
+		case 'v':
+			s = append(s, '\v')
+		case '\r':
+			// line continuation
+			if i+1 < len(b) && b[i+1] == '\n' {
+				i++
+			}
+		case '\n':
+			// line continuation
+		case 'x':
+			if len(b) < i+3 || !isHexDigit(b[i+1]) || !isHexDigit(b[i+2]) {
+				return nil, false
+			}
+			s = appendRune(s, rune(hexValue(b[i+1])<<4|hexValue(b[i+2])))
+			i += 2
+		case 'u':
+			r, n := unescapeCodePoint(b[i+1:])
+			if n == 0 {
+				return nil, false
+			} else if 0xD800 <= r && r < 0xDC00 && i+n+2 < len(b) && b[i+n+1] == '\\' && b[i+n+2] == 'u' {
+				// surrogate pair
+				if r2, n2 := unescapeCodePoint(b[i+n+3:]); 0xDC00 <= r2 && r2 < 0xE000 {
+					r = 0x10000 + (r-0xD800)<<10 + (r2 - 0xDC00)
+					n += 2 + n2
+				}
+			}
+			if 0xD800 <= r && r < 0xE000 {
+				return nil, false
+			}
+			s = appendRune(s, r)
+			i += n
+		default:
+			if 0xE2 == c && i+2 < len(b) && b[i+1] == 0x80 && (b[i+2] == 0xA8 || b[i+2] == 0xA9) {
+				// line continuation
+				i += 2
+			} else if '0' <= c && c <= '7' {
+				// octal escapes (legacy)
+				num := rune(c - '0')
+				if i+1 < len(b) && '0' <= b[i+1] && b[i+1] <= '7' {
+					i++
+					num = num*8 + rune(b[i]-'0')
+					if c <= '3' && i+1 < len(b) && '0' <= b[i+1] && b[i+1] <= '7' {
+						i++
+						num = num*8 + rune(b[i]-'0')
+					}
+				}
+				s = appendRune(s, num)
+			} else {
+				s = append(s, c)
+			}
+		}
+	}
+	return s, true
+}
+
+// unescapeCodePoint parses the code point of a \u escape sequence after the u, such as 0061 or {61}, and returns the number of bytes read, or zero if invalid.
+func unescapeCodePoint(b []byte) (rune, int) {
+	if 0 < len(b) && b[0] == '{' {
+		end := bytes.IndexByte(b, '}')
+		if end < 2 {
+			return 0, 0
+		}
+		var r rune
+		for _, c := range b[1:end] {
+			if !isHexDigit(c) || 0x10FFFF < r {
+				return 0, 0
+			}
+			r = r*16 + rune(hexValue(c))
+		}
+		if 0x10FFFF < r {
+			return 0, 0
+		}
+		return r, end + 1
+	} else if len(b) < 4 || !isHexDigit(b[0]) || !isHexDigit(b[1]) || !isHexDigit(b[2]) || !isHexDigit(b[3]) {
+		return 0, 0
+	}
+	var r rune
+	for _, c := range b[:4] {
+		r = r*16 + rune(hexValue(c))
+	}
+	return r, 4
+}
+
+func appendRune(b []byte, r rune) []byte {
+	var buf [utf8.UTFMax]byte
+	n := utf8.EncodeRune(buf[:], r)
+	return append(b, buf[:n]...)
+}
+
+func hexValue(b byte) byte {
+	if b <= '9' {
+		return b - '0'
+	} else if b <= 'F' {
+		return b - 'A' + 10
+	}
+	return b - 'a' + 10
+}
+
 func minifyString(b []byte) []byte {
 	if len(b) < 3 {
 		return b
@@ -707,3 +1099,66 @@ func hexadecimalNumber(b []byte) []byte {
 	}
 	return minify.Number(b, 0)
 }
+
+// escapeNonASCII replaces non-ASCII characters by \uXXXX escapes. Characters outside the BMP are written as surrogate pairs in strings, template literals, and regular expressions, and as \u{X} in identifiers, which do not allow surrogate pairs. Escaped non-ASCII characters and line continuations are replaced as well.
+func escapeNonASCII(b []byte, isIdent bool) []byte {
+	i := 0
+	for i < len(b) && b[i] < utf8.RuneSelf {
+		i++
+	}
+	if i == len(b) {
+		return b
+	}
+
+	const hexDigits = "0123456789abcdef"
+	escape := func(dst []byte, r rune) []byte {
+		return append(dst, '\\', 'u', hexDigits[r>>12&0xf], hexDigits[r>>8&0xf], hexDigits[r>>4&0xf], hexDigits[r&0xf])
+	}
+
+	esc := make([]byte, 0, len(b)+16)
+	esc = append(esc, b[:i]...)
+	for i < len(b) {
+		if b[i] < utf8.RuneSelf {
+			esc = append(esc, b[i])
+			i++
+			continue
+		}
+		r, n := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && n == 1 {
+			esc = append(esc, b[i]) // invalid UTF-8 is kept as is
+			i++
+			continue
+		}
+		i += n
+
+		backslashes := 0
+		for backslashes < len(esc) && esc[len(esc)-1-backslashes] == '\\' {
+			backslashes++
+		}
+		if backslashes%2 == 1 {
+			// the character is escaped itself, such as \é which is é, or \ followed by a line separator which is removed
+			esc = esc[:len(esc)-1]
+			if r == '\u2028' || r == '\u2029' {
+				continue
+			}
+		}
+
+		if r <= 0xFFFF {
+			esc = escape(esc, r)
+		} else if isIdent {
+			esc = append(esc, '\\', 'u', '{')
+			for shift := 20; 0 <= shift; shift -= 4 {
+				if 16 < shift && r>>uint(shift) == 0 {
+					continue // at least five digits
+				}
+				esc = append(esc, hexDigits[r>>uint(shift)&0xf])
+			}
+			esc = append(esc, '}')
+		} else {
+			r -= 0x10000
+			esc = escape(esc, 0xD800+r>>10)
+			esc = escape(esc, 0xDC00+r&0x3FF)
+		}
+	}
+	return esc
+}
@@ -2,7 +2,13 @@ package js
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
 	"sort"
+	"sync"
 
 	"github.com/tdewolff/parse/v2"
 	"github.com/tdewolff/parse/v2/js"
@@ -12,6 +18,8 @@ type renamer struct {
 	ast      *js.AST
 	reserved map[string]struct{}
 	rename   bool
+	keep     map[*js.Var]bool   // variables that are not renamed
+	names    map[*js.Var][]byte // original names of renamed variables, only kept for source maps
 }
 
 func newRenamer(ast *js.AST, undeclared js.VarArray, rename bool) *renamer {
@@ -23,6 +31,7 @@ func newRenamer(ast *js.AST, undeclared js.VarArray, rename bool) *renamer {
 		ast:      ast,
 		reserved: reserved,
 		rename:   rename,
+		keep:     map[*js.Var]bool{},
 	}
 }
 
@@ -31,15 +40,136 @@ func (r *renamer) renameScope(scope js.Scope) {
 		return
 	}
 
+	kept := map[string]bool{}
+	for _, v := range scope.Declared {
+		if r.keep[v] {
+			kept[string(v.Data)] = true
+		}
+	}
+
 	rename := []byte("`") // so that the next is 'a'
 	sort.Sort(js.VarsByUses(scope.Declared))
 	for _, v := range scope.Declared {
+		if r.keep[v] {
+			continue
+		}
+		rename = r.next(rename)
+		for r.isReserved(rename, scope.Undeclared) || kept[string(rename)] {
+			rename = r.next(rename)
+		}
+		r.setName(v, parse.Copy(rename))
+	}
+}
+
+// setName renames a variable and keeps its original name for source maps.
+func (r *renamer) setName(v *js.Var, name []byte) {
+	if r.names != nil {
+		if _, ok := r.names[v]; !ok {
+			r.names[v] = v.Data
+		}
+	}
+	v.Data = name
+}
+
+// renameTopLevel renames the variables in the global scope using the name cache, which is updated with new names. Undeclared variables are only renamed when they are in the name cache, so that scripts minified separately agree on the names of the globals they share.
+func (r *renamer) renameTopLevel(cache *NameCache) error {
+	if !r.rename {
+		return nil
+	}
+	for _, v := range r.ast.Undeclared {
+		if bytes.Equal(v.Data, evalBytes) {
+			return nil // eval may reference any global by its name
+		}
+	}
+	if cache == nil {
+		cache = NewNameCache()
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	// names bound by import and export statements cannot be renamed
+	keep := map[string]bool{}
+	for _, istmt := range r.ast.List {
+		switch stmt := istmt.(type) {
+		case *js.ImportStmt:
+			if stmt.Default != nil {
+				keep[string(stmt.Default)] = true
+			}
+			for _, alias := range stmt.List {
+				keep[string(alias.Binding)] = true
+			}
+		case *js.ExportStmt:
+			if stmt.Module == nil {
+				for _, alias := range stmt.List {
+					if alias.Name != nil {
+						keep[string(alias.Name)] = true
+					} else {
+						keep[string(alias.Binding)] = true
+					}
+				}
+			}
+			switch decl := stmt.Decl.(type) {
+			case *js.FuncDecl:
+				if decl.Name != nil {
+					keep[string(decl.Name.Data)] = true
+				}
+			case *js.ClassDecl:
+				if decl.Name != nil {
+					keep[string(decl.Name.Data)] = true
+				}
+			case *js.VarDecl:
+				for _, item := range decl.List {
+					for _, v := range bindingRefs(item.Binding) {
+						keep[string(v.Data)] = true
+					}
+				}
+			}
+		}
+	}
+
+	// names of globals that are not renamed
+	taken := map[string]bool{}
+	for _, v := range r.ast.Undeclared {
+		if name, ok := cache.names[string(v.Data)]; ok && !keep[string(v.Data)] {
+			r.setName(v, []byte(name))
+		} else {
+			taken[string(v.Data)] = true
+		}
+	}
+
+	sort.Sort(js.VarsByUses(r.ast.Declared))
+	fresh := []*js.Var{}
+	for _, v := range r.ast.Declared {
+		if keep[string(v.Data)] {
+			taken[string(v.Data)] = true
+		} else if name, ok := cache.names[string(v.Data)]; ok {
+			r.setName(v, []byte(name))
+		} else {
+			fresh = append(fresh, v)
+		}
+	}
+	for name := range taken {
+		if cache.used[name] {
+			return fmt.Errorf("global %s is used but is also the new name of a global in the name cache", name)
+		}
+	}
+
+	rename := []byte("`") // so that the next is 'a'
+	for _, v := range fresh {
 		rename = r.next(rename)
-		for r.isReserved(rename, scope.Undeclared) {
+		for r.isKeyword(rename) || taken[string(rename)] || cache.used[string(rename)] {
 			rename = r.next(rename)
 		}
-		v.Data = parse.Copy(rename)
+		cache.names[string(v.Data)] = string(rename)
+		cache.used[string(rename)] = true
+		r.setName(v, parse.Copy(rename))
 	}
+	return nil
+}
+
+func (r *renamer) isKeyword(name []byte) bool {
+	_, ok := r.reserved[string(name)]
+	return ok
 }
 
 func (r *renamer) isReserved(name []byte, undeclared js.VarArray) bool {
@@ -93,6 +223,207 @@ func (r *renamer) next(name []byte) []byte {
 	return name
 }
 
+// renameProperties renames the property names that match the regular expression, except for the reserved names, consistently for member expressions, object literals, classes and destructuring.
+func (r *renamer) renameProperties(ast *js.AST, match *regexp.Regexp, reserved []string) {
+	uses := map[string]int{}
+	taken := map[string]bool{}
+	for _, name := range reserved {
+		taken[name] = true
+	}
+	count := func(name []byte) {
+		if len(name) == 0 || taken[string(name)] {
+			return
+		} else if !match.Match(name) {
+			taken[string(name)] = true
+			return
+		}
+		uses[string(name)]++
+	}
+	w := &walker{
+		expr: func(iexpr js.IExpr) js.IExpr {
+			count(propertyNameOfExpr(iexpr))
+			return iexpr
+		},
+		propertyName: func(name *js.PropertyName) {
+			count(propertyNameOfLiteral(name))
+		},
+	}
+	w.walkAST(ast)
+
+	names := make([]string, 0, len(uses))
+	for name := range uses {
+		if !taken[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return uses[names[i]] > uses[names[j]] || uses[names[i]] == uses[names[j]] && names[i] < names[j]
+	})
+	renames := make(map[string][]byte, len(names))
+	rename := []byte("`") // so that the next is 'a'
+	for _, name := range names {
+		rename = r.next(rename)
+		for r.isKeyword(rename) || taken[string(rename)] {
+			rename = r.next(rename)
+		}
+		renames[name] = parse.Copy(rename)
+	}
+
+	w = &walker{
+		expr: func(iexpr js.IExpr) js.IExpr {
+			switch expr := iexpr.(type) {
+			case *js.DotExpr:
+				if rename, ok := renames[string(propertyNameOfExpr(expr))]; ok {
+					expr.Y.Data = rename
+				}
+			case *js.OptChainExpr:
+				if lit, ok := expr.Y.(*js.LiteralExpr); ok && lit.TokenType != js.TemplateToken {
+					if rename, ok := renames[string(propertyNameOfExpr(expr))]; ok {
+						lit.Data = rename
+					}
+				} else if index, ok := expr.Y.(*js.IndexExpr); ok {
+					renameStringLiteral(index.Index, renames)
+				}
+			case *js.IndexExpr:
+				renameStringLiteral(expr.Index, renames)
+			case *js.BinaryExpr:
+				if expr.Op == js.InToken {
+					renameStringLiteral(expr.X, renames)
+				}
+			}
+			return iexpr
+		},
+		propertyName: func(name *js.PropertyName) {
+			if name.IsComputed() {
+				renameStringLiteral(name.Computed, renames)
+			} else if rename, ok := renames[string(propertyNameOfLiteral(name))]; ok {
+				name.Literal = js.LiteralExpr{TokenType: js.IdentifierToken, Data: rename}
+			}
+		},
+	}
+	w.walkAST(ast)
+}
+
+// propertyNameOfExpr returns the property name accessed by a member expression, or by the left-hand side of the in operator.
+func propertyNameOfExpr(iexpr js.IExpr) []byte {
+	switch expr := iexpr.(type) {
+	case *js.DotExpr:
+		return identifierNameValue(expr.Y.Data)
+	case *js.OptChainExpr:
+		if lit, ok := expr.Y.(*js.LiteralExpr); ok && lit.TokenType != js.TemplateToken {
+			return identifierNameValue(lit.Data)
+		} else if index, ok := expr.Y.(*js.IndexExpr); ok {
+			return stringLiteralValue(index.Index)
+		}
+	case *js.IndexExpr:
+		return stringLiteralValue(expr.Index)
+	case *js.BinaryExpr:
+		if expr.Op == js.InToken {
+			return stringLiteralValue(expr.X)
+		}
+	}
+	return nil
+}
+
+// propertyNameOfLiteral returns the name of a property name or of a computed property name that is a string literal, or nil for other computed and numeric property names.
+func propertyNameOfLiteral(name *js.PropertyName) []byte {
+	if name.IsComputed() {
+		return stringLiteralValue(name.Computed)
+	} else if name.Literal.TokenType == js.StringToken {
+		return stringLiteralValue(&name.Literal)
+	} else if js.IsIdentifierName(name.Literal.TokenType) {
+		return identifierNameValue(name.Literal.Data)
+	}
+	return nil
+}
+
+// stringLiteralValue returns the contents of a string literal or of an untagged template literal without substitutions with its escape sequences resolved, or nil otherwise.
+func stringLiteralValue(iexpr js.IExpr) []byte {
+	var data []byte
+	if lit, ok := iexpr.(*js.LiteralExpr); ok && lit.TokenType == js.StringToken {
+		data = lit.Data
+	} else if tmpl, ok := iexpr.(*js.TemplateExpr); ok && tmpl.Tag == nil && len(tmpl.List) == 0 {
+		data = tmpl.Tail
+	} else {
+		return nil
+	}
+	if val, ok := unescapeString(data[1 : len(data)-1]); ok {
+		return val
+	}
+	return nil
+}
+
+// identifierNameValue returns the identifier name with its unicode escape sequences resolved, such as \u0061 for a.
+func identifierNameValue(data []byte) []byte {
+	if val, ok := unescapeString(data); ok {
+		return val
+	}
+	return nil
+}
+
+func renameStringLiteral(iexpr js.IExpr, renames map[string][]byte) {
+	if rename, ok := renames[string(stringLiteralValue(iexpr))]; ok {
+		if lit, ok := iexpr.(*js.LiteralExpr); ok {
+			lit.Data = append(append([]byte{'"'}, rename...), '"')
+		} else {
+			tmpl := iexpr.(*js.TemplateExpr)
+			tmpl.Tail = append(append([]byte{'`'}, rename...), '`')
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////
+
+// NameCache maps the original names of renamed globals to their short names, so that scripts that are minified separately agree on the globals they share. It is safe for concurrent use.
+type NameCache struct {
+	mu    sync.Mutex
+	names map[string]string
+	used  map[string]bool
+}
+
+// NewNameCache returns a new empty name cache.
+func NewNameCache() *NameCache {
+	return &NameCache{
+		names: map[string]string{},
+		used:  map[string]bool{},
+	}
+}
+
+// ReadFrom reads a JSON object of original to short names and adds them to the name cache.
+func (c *NameCache) ReadFrom(r io.Reader) (int64, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return int64(len(b)), err
+	}
+	names := map[string]string{}
+	if err := json.Unmarshal(b, &names); err != nil {
+		return int64(len(b)), err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, rename := range names {
+		if !js.AsIdentifierName([]byte(rename)) {
+			return int64(len(b)), fmt.Errorf("invalid name %s for %s in name cache", rename, name)
+		}
+		c.names[name] = rename
+		c.used[rename] = true
+	}
+	return int64(len(b)), nil
+}
+
+// WriteTo writes the name cache as a JSON object of original to short names.
+func (c *NameCache) WriteTo(w io.Writer) (int64, error) {
+	c.mu.Lock()
+	b, err := json.MarshalIndent(c.names, "", "\t")
+	c.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
 ////////////////////////////////////////////////////////////////
 
 func bindingRefs(ibinding js.IBinding) (refs []*js.Var) {
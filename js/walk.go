@@ -0,0 +1,309 @@
+package js
+
+import (
+	"github.com/tdewolff/parse/v2/js"
+)
+
+// Visitor holds the callbacks of Walk. The callbacks are optional.
+type Visitor struct {
+	Expr       func(js.IExpr)      // called for every expression before its children
+	EnterBlock func(*js.BlockStmt) // called for the module and for every function body and block before its statements
+	ExitBlock  func(*js.BlockStmt) // called for the module and for every function body and block after its statements
+}
+
+// Walk traverses the AST in source order and calls the callbacks of the visitor, it does not modify the AST.
+func Walk(ast *js.AST, v Visitor) {
+	w := &walker{
+		enterBlock: v.EnterBlock,
+		exitBlock:  v.ExitBlock,
+	}
+	if v.Expr != nil {
+		w.expr = func(iexpr js.IExpr) js.IExpr {
+			v.Expr(iexpr)
+			return iexpr
+		}
+	}
+	w.walkAST(ast)
+}
+
+// walker traverses the AST in source order. The callbacks are optional.
+type walker struct {
+	stmt         func(js.IStmt) js.IStmt // called for every statement before its children, returns its replacement
+	expr         func(js.IExpr) js.IExpr // called for every expression before its children, returns its replacement
+	exitStmt     func(js.IStmt)          // called for every statement after its children
+	exitExpr     func(js.IExpr)          // called for every expression after its children
+	propertyName func(*js.PropertyName)  // called for every (non-computed and computed) property name
+	binding      func(*js.Var)           // called for every declared variable, including function and class names
+	enterBlock   func(*js.BlockStmt)     // called for the module and for every function body and block before its statements
+	exitBlock    func(*js.BlockStmt)     // called for the module and for every function body and block after its statements
+}
+
+func (w *walker) walkAST(ast *js.AST) {
+	w.walkBlockStmt(&ast.BlockStmt)
+}
+
+func (w *walker) walkBlockStmt(stmt *js.BlockStmt) {
+	if w.enterBlock != nil {
+		w.enterBlock(stmt)
+	}
+	for i := range stmt.List {
+		stmt.List[i] = w.walkStmt(stmt.List[i])
+	}
+	if w.exitBlock != nil {
+		w.exitBlock(stmt)
+	}
+}
+
+func (w *walker) walkStmt(istmt js.IStmt) js.IStmt {
+	if w.stmt != nil {
+		istmt = w.stmt(istmt)
+	}
+
+	switch stmt := istmt.(type) {
+	case *js.ExprStmt:
+		stmt.Value = w.walkExpr(stmt.Value)
+	case *js.VarDecl:
+		w.walkVarDecl(stmt)
+	case *js.IfStmt:
+		stmt.Cond = w.walkExpr(stmt.Cond)
+		stmt.Body = w.walkStmt(stmt.Body)
+		if stmt.Else != nil {
+			stmt.Else = w.walkStmt(stmt.Else)
+		}
+	case *js.BlockStmt:
+		w.walkBlockStmt(stmt)
+	case *js.ReturnStmt:
+		if stmt.Value != nil {
+			stmt.Value = w.walkExpr(stmt.Value)
+		}
+	case *js.LabelledStmt:
+		stmt.Value = w.walkStmt(stmt.Value)
+	case *js.WithStmt:
+		stmt.Cond = w.walkExpr(stmt.Cond)
+		stmt.Body = w.walkStmt(stmt.Body)
+	case *js.DoWhileStmt:
+		stmt.Body = w.walkStmt(stmt.Body)
+		stmt.Cond = w.walkExpr(stmt.Cond)
+	case *js.WhileStmt:
+		stmt.Cond = w.walkExpr(stmt.Cond)
+		stmt.Body = w.walkStmt(stmt.Body)
+	case *js.ForStmt:
+		if stmt.Init != nil {
+			stmt.Init = w.walkExpr(stmt.Init)
+		}
+		if stmt.Cond != nil {
+			stmt.Cond = w.walkExpr(stmt.Cond)
+		}
+		if stmt.Post != nil {
+			stmt.Post = w.walkExpr(stmt.Post)
+		}
+		w.walkBlockStmt(&stmt.Body)
+	case *js.ForInStmt:
+		stmt.Init = w.walkExpr(stmt.Init)
+		stmt.Value = w.walkExpr(stmt.Value)
+		w.walkBlockStmt(&stmt.Body)
+	case *js.ForOfStmt:
+		stmt.Init = w.walkExpr(stmt.Init)
+		stmt.Value = w.walkExpr(stmt.Value)
+		w.walkBlockStmt(&stmt.Body)
+	case *js.SwitchStmt:
+		stmt.Init = w.walkExpr(stmt.Init)
+		for i := range stmt.List {
+			if stmt.List[i].Cond != nil {
+				stmt.List[i].Cond = w.walkExpr(stmt.List[i].Cond)
+			}
+			for j := range stmt.List[i].List {
+				stmt.List[i].List[j] = w.walkStmt(stmt.List[i].List[j])
+			}
+		}
+	case *js.ThrowStmt:
+		stmt.Value = w.walkExpr(stmt.Value)
+	case *js.TryStmt:
+		w.walkBlockStmt(&stmt.Body)
+		if stmt.Binding != nil {
+			w.walkBinding(stmt.Binding)
+		}
+		if stmt.Catch != nil {
+			w.walkBlockStmt(stmt.Catch)
+		}
+		if stmt.Finally != nil {
+			w.walkBlockStmt(stmt.Finally)
+		}
+	case *js.FuncDecl:
+		w.walkFuncDecl(stmt)
+	case *js.ClassDecl:
+		w.walkClassDecl(stmt)
+	case *js.ExportStmt:
+		if stmt.Decl != nil {
+			stmt.Decl = w.walkExpr(stmt.Decl)
+		}
+	}
+	if w.exitStmt != nil {
+		w.exitStmt(istmt)
+	}
+	return istmt
+}
+
+func (w *walker) walkVarDecl(decl *js.VarDecl) {
+	for i := range decl.List {
+		w.walkBindingElement(&decl.List[i])
+	}
+}
+
+func (w *walker) walkFuncDecl(decl *js.FuncDecl) {
+	if decl.Name != nil && w.binding != nil {
+		w.binding(decl.Name)
+	}
+	w.walkParams(&decl.Params)
+	w.walkBlockStmt(&decl.Body)
+}
+
+func (w *walker) walkMethodDecl(decl *js.MethodDecl) {
+	w.walkPropertyName(&decl.Name)
+	w.walkParams(&decl.Params)
+	w.walkBlockStmt(&decl.Body)
+}
+
+func (w *walker) walkClassDecl(decl *js.ClassDecl) {
+	if decl.Name != nil && w.binding != nil {
+		w.binding(decl.Name)
+	}
+	if decl.Extends != nil {
+		decl.Extends = w.walkExpr(decl.Extends)
+	}
+	for i := range decl.Methods {
+		w.walkMethodDecl(&decl.Methods[i])
+	}
+}
+
+func (w *walker) walkParams(params *js.Params) {
+	for i := range params.List {
+		w.walkBindingElement(&params.List[i])
+	}
+	if params.Rest != nil {
+		w.walkBinding(params.Rest)
+	}
+}
+
+func (w *walker) walkArguments(args *js.Arguments) {
+	for i := range args.List {
+		args.List[i] = w.walkExpr(args.List[i])
+	}
+	if args.Rest != nil {
+		args.Rest = w.walkExpr(args.Rest)
+	}
+}
+
+func (w *walker) walkPropertyName(name *js.PropertyName) {
+	if w.propertyName != nil {
+		w.propertyName(name)
+	}
+	if name.IsComputed() {
+		name.Computed = w.walkExpr(name.Computed)
+	}
+}
+
+func (w *walker) walkBindingElement(element *js.BindingElement) {
+	if element.Binding != nil {
+		w.walkBinding(element.Binding)
+	}
+	if element.Default != nil {
+		element.Default = w.walkExpr(element.Default)
+	}
+}
+
+func (w *walker) walkBinding(ibinding js.IBinding) {
+	switch binding := ibinding.(type) {
+	case *js.Var:
+		if w.binding != nil {
+			w.binding(binding)
+		}
+	case *js.BindingArray:
+		for i := range binding.List {
+			w.walkBindingElement(&binding.List[i])
+		}
+		if binding.Rest != nil {
+			w.walkBinding(binding.Rest)
+		}
+	case *js.BindingObject:
+		for i := range binding.List {
+			if binding.List[i].Key != nil {
+				w.walkPropertyName(binding.List[i].Key)
+			}
+			w.walkBindingElement(&binding.List[i].Value)
+		}
+	}
+}
+
+func (w *walker) walkExpr(iexpr js.IExpr) js.IExpr {
+	if iexpr == nil {
+		return nil
+	}
+	if w.expr != nil {
+		iexpr = w.expr(iexpr)
+	}
+
+	switch expr := iexpr.(type) {
+	case *js.GroupExpr:
+		expr.X = w.walkExpr(expr.X)
+	case *js.ArrayExpr:
+		for i := range expr.List {
+			expr.List[i].Value = w.walkExpr(expr.List[i].Value)
+		}
+	case *js.ObjectExpr:
+		for i := range expr.List {
+			if expr.List[i].Name != nil {
+				w.walkPropertyName(expr.List[i].Name)
+			}
+			expr.List[i].Value = w.walkExpr(expr.List[i].Value)
+			expr.List[i].Init = w.walkExpr(expr.List[i].Init)
+		}
+	case *js.TemplateExpr:
+		expr.Tag = w.walkExpr(expr.Tag)
+		for i := range expr.List {
+			expr.List[i].Expr = w.walkExpr(expr.List[i].Expr)
+		}
+	case *js.NewExpr:
+		expr.X = w.walkExpr(expr.X)
+		if expr.Args != nil {
+			w.walkArguments(expr.Args)
+		}
+	case *js.YieldExpr:
+		expr.X = w.walkExpr(expr.X)
+	case *js.CondExpr:
+		expr.Cond = w.walkExpr(expr.Cond)
+		expr.X = w.walkExpr(expr.X)
+		expr.Y = w.walkExpr(expr.Y)
+	case *js.DotExpr:
+		expr.X = w.walkExpr(expr.X)
+	case *js.CallExpr:
+		expr.X = w.walkExpr(expr.X)
+		w.walkArguments(&expr.Args)
+	case *js.IndexExpr:
+		expr.X = w.walkExpr(expr.X)
+		expr.Index = w.walkExpr(expr.Index)
+	case *js.OptChainExpr:
+		expr.X = w.walkExpr(expr.X)
+		expr.Y = w.walkExpr(expr.Y)
+	case *js.UnaryExpr:
+		expr.X = w.walkExpr(expr.X)
+	case *js.BinaryExpr:
+		expr.X = w.walkExpr(expr.X)
+		expr.Y = w.walkExpr(expr.Y)
+	case *js.VarDecl:
+		w.walkVarDecl(expr)
+	case *js.FuncDecl:
+		w.walkFuncDecl(expr)
+	case *js.MethodDecl:
+		w.walkMethodDecl(expr) // only happens in object literal
+	case *js.ClassDecl:
+		w.walkClassDecl(expr)
+	case *js.ArrowFunc:
+		w.walkParams(&expr.Params)
+		w.walkBlockStmt(&expr.Body)
+	}
+	if w.exitExpr != nil {
+		w.exitExpr(iexpr)
+	}
+	return iexpr
+}
@@ -0,0 +1,177 @@
+package minify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// RenameMap maps class names, IDs, and custom property names to short names, so that the stylesheets and HTML documents that share them agree on the names when minified separately. The map can be written to a JSON manifest so that scripts can look up the renamed names. It is safe for concurrent use.
+type RenameMap struct {
+	mu         sync.Mutex
+	namespaces [3]renameNamespace
+}
+
+// renameNamespace holds the names of one kind, such as classes, which are renamed independently from other kinds.
+type renameNamespace struct {
+	names map[string]string
+	used  map[string]bool
+	n     int // number of generated names
+}
+
+const (
+	renameClasses = iota
+	renameIDs
+	renameCustomProperties
+)
+
+// renameManifest is the JSON manifest of a RenameMap.
+type renameManifest struct {
+	Classes          map[string]string `json:"classes"`
+	IDs              map[string]string `json:"ids"`
+	CustomProperties map[string]string `json:"customProperties"`
+}
+
+// NewRenameMap returns a new empty rename map.
+func NewRenameMap() *RenameMap {
+	m := &RenameMap{}
+	for i := range m.namespaces {
+		m.namespaces[i] = renameNamespace{
+			names: map[string]string{},
+			used:  map[string]bool{},
+		}
+	}
+	return m
+}
+
+// Class returns the short name of a class name.
+func (m *RenameMap) Class(name []byte) []byte {
+	return m.rename(renameClasses, "", name)
+}
+
+// ID returns the short name of an ID.
+func (m *RenameMap) ID(name []byte) []byte {
+	return m.rename(renameIDs, "", name)
+}
+
+// ReserveClass reserves a class name that is kept as is, such as a class that is used only in HTML, so that no other class is renamed to it.
+func (m *RenameMap) ReserveClass(name []byte) {
+	m.reserve(renameClasses, name)
+}
+
+// ReserveID reserves an ID that is kept as is, such as an ID that is not used by any stylesheet, so that no other ID is renamed to it.
+func (m *RenameMap) ReserveID(name []byte) {
+	m.reserve(renameIDs, name)
+}
+
+func (m *RenameMap) reserve(kind int, name []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.namespaces[kind].used[string(name)] = true
+}
+
+// HasClass returns true if the class name has been renamed before, such as by a stylesheet that defines it, which is used to rename only the classes of HTML documents that are defined in CSS.
+func (m *RenameMap) HasClass(name []byte) bool {
+	return m.has(renameClasses, name)
+}
+
+// HasID returns true if the ID has been renamed before, which is used for references to IDs that may not be IDs, such as URL fragments.
+func (m *RenameMap) HasID(name []byte) bool {
+	return m.has(renameIDs, name)
+}
+
+func (m *RenameMap) has(kind int, name []byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.namespaces[kind].names[string(name)]
+	return ok
+}
+
+// CustomProperty returns the short name of a custom property name, including the leading --.
+func (m *RenameMap) CustomProperty(name []byte) []byte {
+	if len(name) < 2 || name[0] != '-' || name[1] != '-' {
+		return name
+	}
+	return m.rename(renameCustomProperties, "--", name)
+}
+
+func (m *RenameMap) rename(kind int, prefix string, name []byte) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ns := &m.namespaces[kind]
+	if rename, ok := ns.names[string(name)]; ok {
+		return []byte(rename)
+	}
+	rename := string(name)
+	if len(prefix)+len(shortName(ns.n)) < len(name) || ns.used[rename] {
+		// keep names that are short already if they are not taken
+		rename = prefix + shortName(ns.n)
+		ns.n++
+		for ns.used[rename] {
+			rename = prefix + shortName(ns.n)
+			ns.n++
+		}
+	}
+	ns.names[string(name)] = rename
+	ns.used[rename] = true
+	return []byte(rename)
+}
+
+// shortName returns the n-th short identifier, which starts with a letter followed by letters, digits, hyphens, and underscores.
+func shortName(n int) string {
+	const first = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	const rest = first + "0123456789-_"
+	b := []byte{first[n%len(first)]}
+	for n /= len(first); 0 < n; n /= len(rest) {
+		n--
+		b = append(b, rest[n%len(rest)])
+	}
+	return string(b)
+}
+
+// ReadFrom reads a JSON manifest of the original to short names and adds them to the rename map.
+func (m *RenameMap) ReadFrom(r io.Reader) (int64, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return int64(len(b)), err
+	}
+	manifest := renameManifest{}
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return int64(len(b)), err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for kind, names := range []map[string]string{manifest.Classes, manifest.IDs, manifest.CustomProperties} {
+		ns := &m.namespaces[kind]
+		for name, rename := range names {
+			if rename == "" {
+				return int64(len(b)), fmt.Errorf("empty name for %s in rename manifest", name)
+			} else if ns.names[name] != rename && ns.used[rename] {
+				return int64(len(b)), fmt.Errorf("duplicate name %s for %s in rename manifest", rename, name)
+			}
+			ns.names[name] = rename
+			ns.used[rename] = true
+		}
+	}
+	return int64(len(b)), nil
+}
+
+// WriteTo writes the rename map as a JSON manifest with the original to short names of the classes, IDs, and custom properties.
+func (m *RenameMap) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	b, err := json.MarshalIndent(renameManifest{
+		Classes:          m.namespaces[renameClasses].names,
+		IDs:              m.namespaces[renameIDs].names,
+		CustomProperties: m.namespaces[renameCustomProperties].names,
+	}, "", "\t")
+	m.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
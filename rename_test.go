@@ -0,0 +1,68 @@
+package minify
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func TestShortName(t *testing.T) {
+	shortNameTests := []struct {
+		n        int
+		expected string
+	}{
+		{0, "a"},
+		{25, "z"},
+		{26, "A"},
+		{51, "Z"},
+		{52, "aa"},
+		{53, "ba"},
+		{52 + 52*64, "aaa"},
+	}
+	for _, tt := range shortNameTests {
+		t.Run(tt.expected, func(t *testing.T) {
+			test.String(t, shortName(tt.n), tt.expected)
+		})
+	}
+}
+
+func TestRenameMap(t *testing.T) {
+	m := NewRenameMap()
+	test.String(t, string(m.Class([]byte("button--primary"))), "a")
+	test.String(t, string(m.Class([]byte("b"))), "b")
+	test.String(t, string(m.Class([]byte("a"))), "c")
+	test.String(t, string(m.Class([]byte("card__title"))), "d")
+	test.String(t, string(m.Class([]byte("button--primary"))), "a")
+	test.T(t, m.HasClass([]byte("card__title")), true)
+	test.T(t, m.HasClass([]byte("other")), false)
+	test.String(t, string(m.ID([]byte("main-content"))), "a")
+	test.T(t, m.HasID([]byte("main-content")), true)
+	test.T(t, m.HasID([]byte("other")), false)
+	test.String(t, string(m.CustomProperty([]byte("--brand-color"))), "--a")
+	test.String(t, string(m.CustomProperty([]byte("brand-color"))), "brand-color")
+
+	m3 := NewRenameMap()
+	m3.ReserveClass([]byte("a"))
+	m3.ReserveID([]byte("b"))
+	test.String(t, string(m3.Class([]byte("very-long-name"))), "b")
+	test.String(t, string(m3.ID([]byte("very-long-id"))), "a")
+	test.String(t, string(m3.ID([]byte("other-long-id"))), "c")
+	test.T(t, m3.HasClass([]byte("a")), false)
+
+	buf := &bytes.Buffer{}
+	_, err := m.WriteTo(buf)
+	test.Error(t, err)
+
+	m2 := NewRenameMap()
+	_, err = m2.ReadFrom(buf)
+	test.Error(t, err)
+	test.String(t, string(m2.Class([]byte("card__title"))), "d")
+	test.String(t, string(m2.Class([]byte("long-name"))), "e")
+	test.String(t, string(m2.CustomProperty([]byte("--brand-color"))), "--a")
+
+	_, err = NewRenameMap().ReadFrom(bytes.NewBufferString(`{"classes":{"x":"a","y":"a"}}`))
+	test.That(t, err != nil, "duplicate names must fail")
+	_, err = NewRenameMap().ReadFrom(bytes.NewBufferString(`{"ids":{"x":""}}`))
+	test.That(t, err != nil, "empty names must fail")
+}
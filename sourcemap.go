@@ -0,0 +1,458 @@
+package minify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// ErrSourceMap is returned when a source map cannot be decoded.
+var ErrSourceMap = errors.New("invalid source map")
+
+// SourceMap is a source map (revision 3) that maps positions in the minified output back to positions in the original sources. See https://sourcemaps.info/spec.html.
+type SourceMap struct {
+	File       string
+	SourceRoot string
+	Sources    []string
+	Names      []string
+	Mappings   []Mapping
+
+	names map[string]int
+}
+
+// Mapping maps a position in the generated output to a position in a source. Lines and columns are zero-based, and columns are counted in UTF-16 code units.
+type Mapping struct {
+	GenLine, GenCol int
+	Source          int // index into Sources, or -1 when the position is unmapped
+	Line, Col       int
+	Name            int // index into Names, or -1 when there is no name
+}
+
+// AddSource adds a source file and returns its index.
+func (sm *SourceMap) AddSource(source string) int {
+	for i, s := range sm.Sources {
+		if s == source {
+			return i
+		}
+	}
+	sm.Sources = append(sm.Sources, source)
+	return len(sm.Sources) - 1
+}
+
+// AddName adds a symbol name and returns its index.
+func (sm *SourceMap) AddName(name string) int {
+	if sm.names == nil {
+		sm.names = make(map[string]int, len(sm.Names))
+		for i, s := range sm.Names {
+			sm.names[s] = i
+		}
+	}
+	if i, ok := sm.names[name]; ok {
+		return i
+	}
+	sm.Names = append(sm.Names, name)
+	sm.names[name] = len(sm.Names) - 1
+	return len(sm.Names) - 1
+}
+
+// AddMapping adds a mapping. A mapping for the same generated position as the previous mapping replaces it.
+func (sm *SourceMap) AddMapping(m Mapping) {
+	if n := len(sm.Mappings); 0 < n && sm.Mappings[n-1].GenLine == m.GenLine && sm.Mappings[n-1].GenCol == m.GenCol {
+		sm.Mappings[n-1] = m
+		return
+	}
+	sm.Mappings = append(sm.Mappings, m)
+}
+
+// Chain remaps all mappings into the source with the given index through in, which is the source map of that source, so that they map to the original sources of in. Mappings that cannot be remapped are removed.
+func (sm *SourceMap) Chain(source int, in *SourceMap) {
+	inMappings := make([]Mapping, len(in.Mappings))
+	copy(inMappings, in.Mappings)
+	sortMappings(inMappings)
+
+	sources := make([]int, len(in.Sources))
+	for i, s := range in.Sources {
+		sources[i] = sm.AddSource(s)
+	}
+
+	mappings := sm.Mappings[:0]
+	for _, m := range sm.Mappings {
+		if m.Source == source {
+			// find the last mapping in the input before or at the position
+			i := sort.Search(len(inMappings), func(i int) bool {
+				return m.Line < inMappings[i].GenLine || m.Line == inMappings[i].GenLine && m.Col < inMappings[i].GenCol
+			}) - 1
+			if i < 0 || inMappings[i].GenLine != m.Line || inMappings[i].Source < 0 || len(sources) <= inMappings[i].Source {
+				continue
+			}
+			orig := inMappings[i]
+			m.Source, m.Line, m.Col = sources[orig.Source], orig.Line, orig.Col
+			if 0 <= orig.Name && orig.Name < len(in.Names) {
+				m.Name = sm.AddName(in.Names[orig.Name])
+			}
+		}
+		mappings = append(mappings, m)
+	}
+	sm.Mappings = mappings
+
+	// remove the chained source if it is no longer used
+	for _, m := range sm.Mappings {
+		if m.Source == source {
+			return
+		}
+	}
+	sm.Sources = append(sm.Sources[:source], sm.Sources[source+1:]...)
+	for i := range sm.Mappings {
+		if source < sm.Mappings[i].Source {
+			sm.Mappings[i].Source--
+		}
+	}
+}
+
+// Rebase resolves relative source paths against dir, which is relative to the location of the source map, and removes the source root.
+func (sm *SourceMap) Rebase(dir string) {
+	for i, s := range sm.Sources {
+		if !isAbsoluteURL(s) {
+			if !isAbsoluteURL(sm.SourceRoot) {
+				s = path.Join(dir, sm.SourceRoot, s)
+			} else {
+				s = strings.TrimSuffix(sm.SourceRoot, "/") + "/" + s
+			}
+		}
+		sm.Sources[i] = s
+	}
+	sm.SourceRoot = ""
+}
+
+func isAbsoluteURL(s string) bool {
+	if strings.HasPrefix(s, "/") {
+		return true
+	}
+	for i, c := range s {
+		if c == ':' {
+			return 0 < i
+		} else if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || 0 < i && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.')) {
+			return false
+		}
+	}
+	return false
+}
+
+type sourceMapJSON struct {
+	Version    int      `json:"version"`
+	File       string   `json:"file,omitempty"`
+	SourceRoot string   `json:"sourceRoot,omitempty"`
+	Sources    []string `json:"sources"`
+	Names      []string `json:"names"`
+	Mappings   string   `json:"mappings"`
+}
+
+// ParseSourceMap parses a source map (revision 3) from its JSON representation. Index maps with sections are not supported.
+func ParseSourceMap(b []byte) (*SourceMap, error) {
+	v := sourceMapJSON{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	} else if v.Version != 3 {
+		return nil, ErrSourceMap
+	}
+
+	sm := &SourceMap{
+		File:       v.File,
+		SourceRoot: v.SourceRoot,
+		Sources:    v.Sources,
+		Names:      v.Names,
+	}
+	genLine, genCol, source, line, col, name := 0, 0, 0, 0, 0, 0
+	fields := [5]int{}
+	for i := 0; i < len(v.Mappings); {
+		if c := v.Mappings[i]; c == ';' {
+			genLine++
+			genCol = 0
+			i++
+			continue
+		} else if c == ',' {
+			i++
+			continue
+		}
+
+		n := 0
+		for ; i < len(v.Mappings) && v.Mappings[i] != ',' && v.Mappings[i] != ';'; n++ {
+			if n == len(fields) {
+				return nil, ErrSourceMap
+			}
+			var ok bool
+			if fields[n], i, ok = decodeVLQ(v.Mappings, i); !ok {
+				return nil, ErrSourceMap
+			}
+		}
+		if n != 1 && n != 4 && n != 5 {
+			return nil, ErrSourceMap
+		}
+
+		genCol += fields[0]
+		m := Mapping{GenLine: genLine, GenCol: genCol, Source: -1, Name: -1}
+		if 1 < n {
+			source += fields[1]
+			line += fields[2]
+			col += fields[3]
+			m.Source, m.Line, m.Col = source, line, col
+			if n == 5 {
+				name += fields[4]
+				m.Name = name
+			}
+		}
+		sm.Mappings = append(sm.Mappings, m)
+	}
+	return sm, nil
+}
+
+// WriteTo writes the source map in its JSON representation.
+func (sm *SourceMap) WriteTo(w io.Writer) (int64, error) {
+	v := sourceMapJSON{
+		Version:    3,
+		File:       sm.File,
+		SourceRoot: sm.SourceRoot,
+		Sources:    sm.Sources,
+		Names:      sm.Names,
+		Mappings:   string(sm.encodeMappings()),
+	}
+	if v.Sources == nil {
+		v.Sources = []string{}
+	}
+	if v.Names == nil {
+		v.Names = []string{}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+func (sm *SourceMap) encodeMappings() []byte {
+	mappings := make([]Mapping, len(sm.Mappings))
+	copy(mappings, sm.Mappings)
+	sortMappings(mappings)
+
+	b := []byte{}
+	genLine, genCol, source, line, col, name := 0, 0, 0, 0, 0, 0
+	for i, m := range mappings {
+		if genLine < m.GenLine {
+			for genLine < m.GenLine {
+				b = append(b, ';')
+				genLine++
+			}
+			genCol = 0
+		} else if 0 < i {
+			b = append(b, ',')
+		}
+		b = appendVLQ(b, m.GenCol-genCol)
+		genCol = m.GenCol
+		if m.Source == -1 {
+			continue
+		}
+		b = appendVLQ(b, m.Source-source)
+		b = appendVLQ(b, m.Line-line)
+		b = appendVLQ(b, m.Col-col)
+		if m.Name != -1 {
+			b = appendVLQ(b, m.Name-name)
+			name = m.Name
+		}
+		source, line, col = m.Source, m.Line, m.Col
+	}
+	return b
+}
+
+func sortMappings(mappings []Mapping) {
+	sort.SliceStable(mappings, func(i, j int) bool {
+		return mappings[i].GenLine < mappings[j].GenLine || mappings[i].GenLine == mappings[j].GenLine && mappings[i].GenCol < mappings[j].GenCol
+	})
+}
+
+const base64VLQ = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+func decodeVLQ(s string, i int) (int, int, bool) {
+	v, shift := 0, uint(0)
+	for i < len(s) {
+		digit := strings.IndexByte(base64VLQ, s[i])
+		if digit == -1 {
+			return 0, i, false
+		}
+		i++
+		v |= (digit & 0x1F) << shift
+		if digit&0x20 == 0 {
+			if v&1 == 1 {
+				return -(v >> 1), i, true
+			}
+			return v >> 1, i, true
+		}
+		shift += 5
+	}
+	return 0, i, false
+}
+
+func appendVLQ(b []byte, n int) []byte {
+	v := n << 1
+	if n < 0 {
+		v = (-n << 1) | 1
+	}
+	for {
+		digit := v & 0x1F
+		v >>= 5
+		if v != 0 {
+			digit |= 0x20
+		}
+		b = append(b, base64VLQ[digit])
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////
+
+// SourceMapWriter wraps a writer and keeps track of the position in the output, so that mappings can be added for what is written next. It accounts for the newlines inserted by a wrapped LineWriter.
+type SourceMapWriter struct {
+	io.Writer
+	*SourceMap
+
+	src    []byte
+	source int
+	lines  []int // offsets of the line starts in src
+
+	line, col int
+	prevCR    bool
+}
+
+// NewSourceMapWriter returns a new SourceMapWriter that writes to w and adds mappings to sm for slices of src, which is the source with the given name.
+func NewSourceMapWriter(w io.Writer, sm *SourceMap, source string, src []byte) *SourceMapWriter {
+	return &SourceMapWriter{
+		Writer:    w,
+		SourceMap: sm,
+		src:       src,
+		source:    sm.AddSource(source),
+	}
+}
+
+// Write writes to the underlying writer and updates the output position.
+func (w *SourceMapWriter) Write(b []byte) (int, error) {
+	if 0 < len(b) && w.pendingNewline() {
+		w.line++
+		w.col = 0
+		w.prevCR = false
+	}
+	for _, c := range b {
+		if c == '\n' {
+			if !w.prevCR {
+				w.line++
+			}
+			w.col = 0
+		} else if c == '\r' {
+			w.line++
+			w.col = 0
+		} else if c < 0x80 || 0xC0 <= c {
+			w.col++ // count UTF-16 code units
+			if 0xF0 <= c {
+				w.col++
+			}
+		}
+		w.prevCR = c == '\r'
+	}
+	return w.Writer.Write(b)
+}
+
+// Map adds a mapping from the current output position to the position of b in the source. It does nothing when b is not a slice of the source. When name is not nil, it is added as the original name of the symbol.
+func (w *SourceMapWriter) Map(b, name []byte) {
+	offset, ok := SliceOffset(w.src, b)
+	if !ok {
+		return
+	}
+	w.MapOffset(offset, name)
+}
+
+// MapOffset adds a mapping from the current output position to the given offset in the source. When name is not nil, it is added as the original name of the symbol.
+func (w *SourceMapWriter) MapOffset(offset int, name []byte) {
+	if w.lines == nil {
+		w.lines = append(w.lines, 0)
+		for i := 0; i < len(w.src); i++ {
+			if w.src[i] == '\n' || w.src[i] == '\r' {
+				if w.src[i] == '\r' && i+1 < len(w.src) && w.src[i+1] == '\n' {
+					i++
+				}
+				w.lines = append(w.lines, i+1)
+			}
+		}
+	}
+
+	line := sort.SearchInts(w.lines, offset+1) - 1
+	col := 0
+	for _, c := range w.src[w.lines[line]:offset] {
+		if c < 0x80 || 0xC0 <= c {
+			col++
+			if 0xF0 <= c {
+				col++
+			}
+		}
+	}
+
+	iName := -1
+	if name != nil {
+		iName = w.AddName(string(name))
+	}
+	genLine, genCol := w.Position()
+	w.AddMapping(Mapping{
+		GenLine: genLine,
+		GenCol:  genCol,
+		Source:  w.source,
+		Line:    line,
+		Col:     col,
+		Name:    iName,
+	})
+}
+
+// Position returns the current zero-based line and column of the output, which is where the next write starts.
+func (w *SourceMapWriter) Position() (int, int) {
+	if w.pendingNewline() {
+		return w.line + 1, 0
+	}
+	return w.line, w.col
+}
+
+// pendingNewline returns true if the underlying writer is a LineWriter that starts a new line before writing more.
+func (w *SourceMapWriter) pendingNewline() bool {
+	lw, ok := w.Writer.(*LineWriter)
+	return ok && lw.newline
+}
+
+// SliceOffset returns the offset of b in src if b is a slice of src, such as the byte slices of an AST parsed from src.
+func SliceOffset(src, b []byte) (int, bool) {
+	if len(b) == 0 || len(src) == 0 {
+		return 0, false
+	}
+	start := uintptr(unsafe.Pointer(&src[0]))
+	p := uintptr(unsafe.Pointer(&b[0]))
+	if p < start || start+uintptr(len(src)) <= p {
+		return 0, false
+	}
+	return int(p - start), true
+}
+
+// SourceMappingURL returns the comment that links the output to its source map for the given mimetype, using either a line comment for JS or a block comment for CSS.
+func SourceMappingURL(mimetype, url string) []byte {
+	b := bytes.Buffer{}
+	if mimetype == "text/css" {
+		b.WriteString("/*# sourceMappingURL=")
+		b.WriteString(url)
+		b.WriteString(" */")
+	} else {
+		b.WriteString("//# sourceMappingURL=")
+		b.WriteString(url)
+	}
+	return b.Bytes()
+}
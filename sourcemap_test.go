@@ -0,0 +1,93 @@
+package minify
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tdewolff/test"
+)
+
+func TestVLQ(t *testing.T) {
+	vlqTests := []struct {
+		n        int
+		expected string
+	}{
+		{0, "A"},
+		{1, "C"},
+		{-1, "D"},
+		{15, "e"},
+		{16, "gB"},
+		{-16, "hB"},
+		{123456, "gkxH"},
+	}
+	for _, tt := range vlqTests {
+		t.Run(tt.expected, func(t *testing.T) {
+			test.String(t, string(appendVLQ(nil, tt.n)), tt.expected)
+		})
+	}
+}
+
+func TestSourceMapWriter(t *testing.T) {
+	src := []byte("ab\ncd\r\néf")
+	sm := &SourceMap{File: "out"}
+	w := &bytes.Buffer{}
+	smw := NewSourceMapWriter(w, sm, "in", src)
+	smw.Map(src[0:2], []byte("ab"))
+	smw.Write([]byte("x\n"))
+	smw.Map(src[3:5], nil)
+	smw.Write([]byte("é\U0001F600"))
+	smw.Map(src[9:10], []byte("ab"))
+	smw.Map([]byte("f"), nil) // not in source
+	test.String(t, w.String(), "x\né\U0001F600")
+
+	line, col := smw.Position()
+	test.T(t, line, 1)
+	test.T(t, col, 3)
+
+	buf := &bytes.Buffer{}
+	_, err := sm.WriteTo(buf)
+	test.Error(t, err)
+	test.String(t, buf.String(), `{"version":3,"file":"out","sources":["in"],"names":["ab"],"mappings":"AAAAA;AACA,GACCA"}`)
+}
+
+func TestParseSourceMap(t *testing.T) {
+	sm, err := ParseSourceMap([]byte(`{"version":3,"file":"out","sources":["in"],"names":["ab"],"mappings":"AAAAA;AACA,GACCA,C"}`))
+	test.Error(t, err)
+	test.T(t, len(sm.Mappings), 4)
+	test.T(t, sm.Mappings[2], Mapping{1, 3, 0, 2, 1, 0})
+	test.T(t, sm.Mappings[3], Mapping{1, 4, -1, 0, 0, -1})
+
+	buf := &bytes.Buffer{}
+	_, err = sm.WriteTo(buf)
+	test.Error(t, err)
+	test.String(t, buf.String(), `{"version":3,"file":"out","sources":["in"],"names":["ab"],"mappings":"AAAAA;AACA,GACCA,C"}`)
+
+	_, err = ParseSourceMap([]byte(`{"version":3,"mappings":"AAAAAA"}`))
+	test.T(t, err, ErrSourceMap)
+	_, err = ParseSourceMap([]byte(`{"version":2,"mappings":""}`))
+	test.T(t, err, ErrSourceMap)
+}
+
+func TestSourceMapChain(t *testing.T) {
+	sm := &SourceMap{}
+	sm.AddSource("tmp.css")
+	sm.AddMapping(Mapping{0, 0, 0, 0, 0, -1})
+	sm.AddMapping(Mapping{0, 5, 0, 0, 7, -1})
+	sm.AddMapping(Mapping{0, 9, 0, 2, 0, -1})
+
+	in := &SourceMap{SourceRoot: "../src/", Sources: []string{"a.scss", "http://host/b.scss"}, Names: []string{"x"}}
+	in.AddMapping(Mapping{0, 0, 0, 3, 0, -1})
+	in.AddMapping(Mapping{0, 6, 1, 8, 2, 0})
+	in.Rebase("maps")
+	test.T(t, in.Sources, []string{"src/a.scss", "http://host/b.scss"})
+
+	sm.Chain(0, in)
+	test.T(t, sm.Sources, []string{"src/a.scss", "http://host/b.scss"})
+	test.T(t, sm.Names, []string{"x"})
+	test.T(t, sm.Mappings, []Mapping{{0, 0, 0, 3, 0, -1}, {0, 5, 1, 8, 2, 0}})
+}
+
+func TestSourceMappingURL(t *testing.T) {
+	test.String(t, string(SourceMappingURL("application/javascript", "a.js.map")), "//# sourceMappingURL=a.js.map")
+	test.String(t, string(SourceMappingURL("text/css", "a.css.map")), "/*# sourceMappingURL=a.css.map */")
+}